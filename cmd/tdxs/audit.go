@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/Hyodar/tdxs/pkg/audit"
+	"github.com/Hyodar/tdxs/pkg/manager"
+	"github.com/spf13/cobra"
+)
+
+var (
+	auditFile        string
+	auditAllowPruned bool
+)
+
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Inspect the attestation audit log",
+}
+
+var auditVerifyCmd = &cobra.Command{
+	Use:   "verify [files...]",
+	Short: "Verify the hash chain of the audit log",
+	Long: `Verify the hash chain of the audit log.
+
+Files are given oldest first. Without arguments, the audit log configured in
+--audit-file or the config file is verified together with its rotated files.
+
+The chain must start at entry 1 unless --allow-pruned is given, or the
+configured log sets max_files and so prunes its oldest rotated files.`,
+	RunE: runAuditVerify,
+}
+
+func init() {
+	auditVerifyCmd.Flags().StringVar(&auditFile, "audit-file", "", "audit log path (defaults to audit.file_path from the config file)")
+	auditVerifyCmd.Flags().BoolVar(&auditAllowPruned, "allow-pruned", false, "accept a chain whose oldest entries were pruned")
+	auditCmd.AddCommand(auditVerifyCmd)
+	rootCmd.AddCommand(auditCmd)
+}
+
+func runAuditVerify(cmd *cobra.Command, args []string) error {
+	files := args
+	allowPruned := auditAllowPruned
+	if len(files) == 0 {
+		path := auditFile
+		if path == "" {
+			config, err := manager.LoadManagerConfig(cfgFile)
+			if err != nil {
+				return err
+			}
+			if config.Audit == nil {
+				return fmt.Errorf("audit is not configured in %s", cfgFile)
+			}
+			path = config.Audit.FilePath
+			allowPruned = allowPruned || config.Audit.MaxFiles > 0
+		}
+		files = audit.Files(path)
+		if len(files) == 0 {
+			return fmt.Errorf("no audit log found at %s", path)
+		}
+	}
+
+	result, err := audit.Verify(files, allowPruned)
+	if err != nil {
+		return fmt.Errorf("audit chain verification failed: %w", err)
+	}
+
+	out := cmd.OutOrStdout()
+	fmt.Fprintf(out, "Audit chain OK: %d entries", result.Entries)
+	if result.Entries > 0 {
+		fmt.Fprintf(out, " (seq %d-%d)", result.FirstSeq, result.LastSeq)
+	}
+	fmt.Fprintln(out)
+	if result.Entries > 0 {
+		if result.FirstPrev != audit.GenesisHash {
+			fmt.Fprintf(out, "Chain starts at seq %d after pruned entries, anchored at %s\n", result.FirstSeq, result.FirstPrev)
+		}
+		fmt.Fprintf(out, "Head: %s\n", result.LastHash)
+	}
+	return nil
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"context"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Hyodar/tdxs/pkg/client"
+	"github.com/Hyodar/tdxs/pkg/manager"
+	"github.com/Hyodar/tdxs/pkg/transport"
+	sockettransport "github.com/Hyodar/tdxs/pkg/transport/socket"
+	"github.com/spf13/cobra"
+)
+
+const (
+	exitCodeError   = 1
+	exitCodeInvalid = 2
+)
+
+var (
+	clientSocket  string
+	clientProfile string
+	clientOutput  string
+	clientTimeout time.Duration
+)
+
+var (
+	issueUserData   = &inputFlag{name: "user-data"}
+	issueNonce      = &inputFlag{name: "nonce"}
+	issueDocOut     string
+	validateDoc     = &inputFlag{name: "document"}
+	validateNonce   = &inputFlag{name: "nonce"}
+	validateDataOut string
+	metadataData    = &inputFlag{name: "user-data"}
+	metadataNonce   = &inputFlag{name: "nonce"}
+	metadataDocOut  string
+	extendRTMR      int
+	extendDigest    = &inputFlag{name: "digest"}
+	extendMeasure   string
+	extendDesc      string
+)
+
+var issueCmd = &cobra.Command{
+	Use:   "issue",
+	Short: "Request an attestation document from a running daemon",
+	Args:  cobra.NoArgs,
+	RunE:  runIssue,
+}
+
+var validateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Validate an attestation document with a running daemon",
+	Long: `Validate an attestation document with a running daemon.
+
+Exits with status 0 if the document is valid, 2 if it is invalid and 1 on any
+other error.`,
+	Args: cobra.NoArgs,
+	RunE: runValidate,
+}
+
+var metadataCmd = &cobra.Command{
+	Use:   "metadata",
+	Short: "Fetch issuer metadata from a running daemon",
+	Long: `Fetch issuer metadata from a running daemon.
+
+The metadata is read from an attestation over fixed user data and nonce,
+unless --user-data or --nonce is given. With --document-out, that attestation
+document is written to a file, so it can be validated elsewhere.`,
+	Args: cobra.NoArgs,
+	RunE: runMetadata,
+}
+
+var extendCmd = &cobra.Command{
+	Use:   "extend",
+	Short: "Extend an RTMR through a running daemon",
+	Long: `Extend an RTMR through a running daemon.
+
+The digest is either given directly (48 bytes, SHA-384 sized) or computed as
+the SHA-384 of the file passed with --measure. The daemon records the
+extension in its runtime event log and prints the new RTMR value.`,
+	Args: cobra.NoArgs,
+	RunE: runExtend,
+}
+
+func init() {
+	for _, cmd := range []*cobra.Command{issueCmd, validateCmd, metadataCmd, extendCmd} {
+		cmd.Flags().StringVarP(&clientSocket, "socket", "s", "", "daemon socket path (defaults to the socket in the config file)")
+		cmd.Flags().StringVarP(&clientProfile, "profile", "p", "", "issuer/validator profile (defaults to the daemon's default profile)")
+		cmd.Flags().StringVarP(&clientOutput, "output", "o", "text", "output format (text, json)")
+		cmd.Flags().DurationVar(&clientTimeout, "timeout", time.Minute, "request timeout")
+		rootCmd.AddCommand(cmd)
+	}
+
+	addInputFlags(issueCmd, issueUserData, "user data to embed in the document")
+	addInputFlags(issueCmd, issueNonce, "nonce to embed in the document")
+	issueCmd.Flags().StringVar(&issueDocOut, "document-out", "", "write the raw document to this file")
+
+	addInputFlags(validateCmd, validateDoc, "attestation document to validate")
+	addInputFlags(validateCmd, validateNonce, "expected nonce")
+	validateCmd.Flags().StringVar(&validateDataOut, "user-data-out", "", "write the raw validated user data to this file")
+
+	addInputFlags(metadataCmd, metadataData, "user data to read the metadata with")
+	addInputFlags(metadataCmd, metadataNonce, "nonce to read the metadata with")
+	metadataCmd.Flags().StringVar(&metadataDocOut, "document-out", "", "write the raw attestation document to this file")
+
+	extendCmd.Flags().IntVar(&extendRTMR, "rtmr", 2, "index of the RTMR to extend")
+	addInputFlags(extendCmd, extendDigest, "SHA-384 digest to extend the RTMR with")
+	extendCmd.Flags().StringVar(&extendMeasure, "measure", "", "extend with the SHA-384 of this file instead of a digest")
+	extendCmd.Flags().StringVar(&extendDesc, "description", "", "description recorded in the event log")
+}
+
+func runIssue(cmd *cobra.Command, _ []string) error {
+	userData, err := issueUserData.read(cmd.InOrStdin())
+	if err != nil {
+		return err
+	}
+	nonce, err := issueNonce.read(cmd.InOrStdin())
+	if err != nil {
+		return err
+	}
+
+	c, err := newDaemonClient()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	ctx, cancel := requestContext(cmd)
+	defer cancel()
+
+	document, err := c.Issue(ctx, userData, nonce)
+	if err != nil {
+		return err
+	}
+
+	if issueDocOut != "" {
+		if err := os.WriteFile(issueDocOut, document, 0o644); err != nil {
+			return fmt.Errorf("failed to write document: %w", err)
+		}
+	}
+
+	data := &sockettransport.SocketTransportIssueResponseData{Document: hex.EncodeToString(document)}
+	return printResult(cmd.OutOrStdout(), data, [][2]string{
+		{"Document", data.Document},
+	})
+}
+
+func runValidate(cmd *cobra.Command, _ []string) error {
+	if !validateDoc.isSet() {
+		return fmt.Errorf("--document or --document-file is required")
+	}
+	document, err := validateDoc.read(cmd.InOrStdin())
+	if err != nil {
+		return err
+	}
+	nonce, err := validateNonce.read(cmd.InOrStdin())
+	if err != nil {
+		return err
+	}
+
+	c, err := newDaemonClient()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	ctx, cancel := requestContext(cmd)
+	defer cancel()
+
+	result, err := c.Validate(ctx, document, nonce)
+	if err != nil {
+		return err
+	}
+
+	if validateDataOut != "" && result.Valid {
+		if err := os.WriteFile(validateDataOut, result.UserData, 0o644); err != nil {
+			return fmt.Errorf("failed to write user data: %w", err)
+		}
+	}
+
+	data := &sockettransport.SocketTransportValidateResponseData{
+		UserData: hex.EncodeToString(result.UserData),
+		Valid:    result.Valid,
+		Verdict:  result.Verdict,
+	}
+	fields := [][2]string{
+		{"Valid", strconv.FormatBool(result.Valid)},
+		{"User data", data.UserData},
+	}
+	for _, check := range result.FailedChecks {
+		data.FailedChecks = append(data.FailedChecks, sockettransport.SocketTransportFailedCheck{Check: check.Check, Code: check.Code, Reason: check.Reason})
+		fields = append(fields, [2]string{"Failed check", fmt.Sprintf("%s: %s [%s]", check.Check, check.Reason, check.Code)})
+	}
+	if len(result.Claims) > 0 {
+		data.Claims = result.Claims
+		fields = append(fields, [2]string{"Claims", string(result.Claims)})
+	}
+	err = printResult(cmd.OutOrStdout(), data, fields)
+	if err != nil {
+		return err
+	}
+	if !result.Valid {
+		return &exitError{code: exitCodeInvalid}
+	}
+	return nil
+}
+
+func runExtend(cmd *cobra.Command, _ []string) error {
+	var digest []byte
+	switch {
+	case extendMeasure != "" && extendDigest.isSet():
+		return fmt.Errorf("--measure and --digest are mutually exclusive")
+	case extendMeasure != "":
+		data, err := os.ReadFile(extendMeasure)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", extendMeasure, err)
+		}
+		sum := sha512.Sum384(data)
+		digest = sum[:]
+	case extendDigest.isSet():
+		var err error
+		digest, err = extendDigest.read(cmd.InOrStdin())
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("--digest, --digest-file or --measure is required")
+	}
+
+	c, err := newDaemonClient()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	ctx, cancel := requestContext(cmd)
+	defer cancel()
+
+	result, err := c.Extend(ctx, extendRTMR, digest, extendDesc)
+	if err != nil {
+		return err
+	}
+
+	data := &sockettransport.SocketTransportExtendResponseData{
+		RTMR:     result.RTMR,
+		Value:    hex.EncodeToString(result.Value),
+		Sequence: result.Sequence,
+	}
+	return printResult(cmd.OutOrStdout(), data, [][2]string{
+		{"RTMR", strconv.Itoa(data.RTMR)},
+		{"Value", data.Value},
+		{"Sequence", strconv.FormatUint(data.Sequence, 10)},
+	})
+}
+
+func runMetadata(cmd *cobra.Command, _ []string) error {
+	userData, err := metadataData.read(cmd.InOrStdin())
+	if err != nil {
+		return err
+	}
+	nonce, err := metadataNonce.read(cmd.InOrStdin())
+	if err != nil {
+		return err
+	}
+
+	c, err := newDaemonClient()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	ctx, cancel := requestContext(cmd)
+	defer cancel()
+
+	result, err := c.MetadataWithOptions(ctx, &client.MetadataOptions{
+		UserData:        userData,
+		Nonce:           nonce,
+		IncludeDocument: metadataDocOut != "",
+	})
+	if err != nil {
+		return err
+	}
+
+	if metadataDocOut != "" {
+		if err := os.WriteFile(metadataDocOut, result.Document, 0o644); err != nil {
+			return fmt.Errorf("failed to write document: %w", err)
+		}
+	}
+
+	data := metadataResponseData(result)
+	fields := [][2]string{
+		{"Issuer type", data.IssuerType},
+		{"User data", data.UserData},
+		{"Nonce", data.Nonce},
+	}
+	var metadata any
+	if len(result.Metadata) > 0 {
+		if err := json.Unmarshal(result.Metadata, &metadata); err != nil {
+			return fmt.Errorf("failed to decode metadata: %w", err)
+		}
+	}
+	fields = append(fields, metadataFields(metadata)...)
+	return printResult(cmd.OutOrStdout(), data, fields)
+}
+
+// metadataResponseData converts a metadata result back into its wire format for JSON
+// output.
+func metadataResponseData(result *client.MetadataResult) *sockettransport.SocketTransportMetadataResponseData {
+	data := &sockettransport.SocketTransportMetadataResponseData{
+		IssuerType: result.IssuerType,
+		UserData:   hex.EncodeToString(result.UserData),
+		Nonce:      hex.EncodeToString(result.Nonce),
+		Document:   hex.EncodeToString(result.Document),
+	}
+	if len(result.Metadata) > 0 {
+		data.Metadata = result.Metadata
+	}
+	return data
+}
+
+// metadataFields flattens the issuer-specific metadata object for text output.
+func metadataFields(metadata any) [][2]string {
+	obj, ok := metadata.(map[string]any)
+	if !ok {
+		if metadata == nil {
+			return nil
+		}
+		return [][2]string{{"Metadata", fmt.Sprint(metadata)}}
+	}
+
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var fields [][2]string
+	for _, key := range keys {
+		if nested, ok := obj[key].(map[string]any); ok {
+			for _, field := range metadataFields(nested) {
+				fields = append(fields, [2]string{key + "." + field[0], field[1]})
+			}
+			continue
+		}
+		fields = append(fields, [2]string{key, fmt.Sprint(obj[key])})
+	}
+	return fields
+}
+
+func printResult(out io.Writer, data any, fields [][2]string) error {
+	switch clientOutput {
+	case "json":
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(data)
+	case "text":
+		width := 0
+		for _, field := range fields {
+			width = max(width, len(field[0]))
+		}
+		for _, field := range fields {
+			fmt.Fprintf(out, "%-*s  %s\n", width+1, field[0]+":", field[1])
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown output format: %s", clientOutput)
+	}
+}
+
+// newDaemonClient connects to the socket given with --socket or configured
+// in the config file.
+func newDaemonClient() (*client.Client, error) {
+	socketPath, err := resolveSocketPath()
+	if err != nil {
+		return nil, err
+	}
+	return newSocketClient(socketPath)
+}
+
+func newSocketClient(socketPath string) (*client.Client, error) {
+	return client.NewClient(&client.ClientConfig{
+		SocketPath:  socketPath,
+		Profile:     clientProfile,
+		DialTimeout: clientTimeout,
+	})
+}
+
+// requestContext bounds a request by --timeout.
+func requestContext(cmd *cobra.Command) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(cmd.Context(), clientTimeout)
+}
+
+func resolveSocketPath() (string, error) {
+	if clientSocket != "" {
+		return clientSocket, nil
+	}
+
+	config, err := manager.LoadManagerConfig(cfgFile)
+	if err != nil {
+		return "", fmt.Errorf("no --socket given and %w", err)
+	}
+	if config.Transport == nil || config.Transport.Type != transport.TransportTypeSocket {
+		return "", fmt.Errorf("no --socket given and %s has no socket transport", cfgFile)
+	}
+	socketCfg, ok := config.Transport.Config.(sockettransport.SocketTransportConfig)
+	if !ok || socketCfg.FilePath == "" {
+		return "", fmt.Errorf("no --socket given and %s has no socket file_path", cfgFile)
+	}
+	return socketCfg.FilePath, nil
+}
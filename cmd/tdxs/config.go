@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	manager "github.com/Hyodar/tdxs/pkg/manager"
+	"github.com/spf13/cobra"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Work with the daemon config file",
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate [file]",
+	Short: "Check a config file without starting the daemon",
+	Long: `Parse a config file and run every check the daemon runs at startup,
+including socket owner/group resolution and validator reference-value formats.
+Nothing is created or started.
+
+Checks the file given by --config when no file is given.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runConfigValidate,
+}
+
+var configSchemaCmd = &cobra.Command{
+	Use:   "schema",
+	Short: "Print a JSON Schema for the config file",
+	Args:  cobra.NoArgs,
+	RunE:  runConfigSchema,
+}
+
+func init() {
+	configCmd.AddCommand(configValidateCmd, configSchemaCmd)
+	rootCmd.AddCommand(configCmd)
+}
+
+func runConfigValidate(cmd *cobra.Command, args []string) error {
+	path := cfgFile
+	if len(args) > 0 {
+		path = args[0]
+	}
+
+	config, err := manager.LoadManagerConfig(path)
+	if err != nil {
+		return err
+	}
+
+	if err := config.Validate(); err != nil {
+		var problems []string
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			for _, e := range joined.Unwrap() {
+				problems = append(problems, e.Error())
+			}
+		} else {
+			problems = []string{err.Error()}
+		}
+		return errors.New(path + " is invalid:\n  " + strings.Join(problems, "\n  "))
+	}
+
+	fmt.Fprintf(cmd.OutOrStdout(), "%s is valid\n", path)
+	return nil
+}
+
+func runConfigSchema(cmd *cobra.Command, _ []string) error {
+	encoder := json.NewEncoder(cmd.OutOrStdout())
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(manager.ConfigSchema()); err != nil {
+		return fmt.Errorf("failed to encode schema: %w", err)
+	}
+	return nil
+}
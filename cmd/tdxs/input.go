@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// inputFlag is a byte value read from a flag, a file or stdin, in one of
+// several encodings.
+type inputFlag struct {
+	name   string
+	value  string
+	file   string
+	format string
+}
+
+func addInputFlags(cmd *cobra.Command, in *inputFlag, usage string) {
+	cmd.Flags().StringVar(&in.value, in.name, "", usage)
+	cmd.Flags().StringVar(&in.file, in.name+"-file", "", "read "+in.name+" from file (- for stdin)")
+	cmd.Flags().StringVar(&in.format, in.name+"-format", "hex", "encoding of "+in.name+" (hex, base64, raw)")
+}
+
+func (in *inputFlag) isSet() bool {
+	return in.value != "" || in.file != ""
+}
+
+func (in *inputFlag) read(stdin io.Reader) ([]byte, error) {
+	if in.value != "" && in.file != "" {
+		return nil, fmt.Errorf("--%s and --%s-file are mutually exclusive", in.name, in.name)
+	}
+
+	data := []byte(in.value)
+	if in.file != "" {
+		var err error
+		if in.file == "-" {
+			data, err = io.ReadAll(stdin)
+		} else {
+			data, err = os.ReadFile(in.file)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", in.name, err)
+		}
+	}
+
+	decoded, err := decodeInput(data, in.format)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", in.name, err)
+	}
+	return decoded, nil
+}
+
+func decodeInput(data []byte, format string) ([]byte, error) {
+	switch format {
+	case "raw":
+		return data, nil
+	case "hex":
+		text := strings.TrimPrefix(string(bytes.TrimSpace(data)), "0x")
+		return hex.DecodeString(text)
+	case "base64":
+		text := string(bytes.TrimSpace(data))
+		decoded, err := base64.StdEncoding.DecodeString(text)
+		if err != nil {
+			decoded, err = base64.RawURLEncoding.DecodeString(strings.TrimRight(text, "="))
+		}
+		return decoded, err
+	default:
+		return nil, fmt.Errorf("unknown format: %s", format)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Hyodar/tdxs/pkg/inspect"
+	"github.com/spf13/cobra"
+)
+
+var (
+	inspectFormat string
+	inspectOutput string
+)
+
+var inspectCmd = &cobra.Command{
+	Use:   "inspect [file]",
+	Short: "Decode an attestation document without verifying it",
+	Long: `Decode an Azure, GCP or SEV-SNP attestation document, raw TDX quote or
+simulator document and print its contents. Reads from stdin when no file or
+"-" is given.
+
+Nothing is verified; use "tdxs validate" for that.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runInspect,
+}
+
+func init() {
+	inspectCmd.Flags().StringVar(&inspectFormat, "format", "raw", "encoding of the input (raw, hex, base64)")
+	inspectCmd.Flags().StringVarP(&inspectOutput, "output", "o", "text", "output format (text, json)")
+	rootCmd.AddCommand(inspectCmd)
+}
+
+func runInspect(cmd *cobra.Command, args []string) error {
+	var data []byte
+	var err error
+	if len(args) == 0 || args[0] == "-" {
+		data, err = io.ReadAll(cmd.InOrStdin())
+	} else {
+		data, err = os.ReadFile(args[0])
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read document: %w", err)
+	}
+
+	doc, err := decodeInput(data, inspectFormat)
+	if err != nil {
+		return fmt.Errorf("failed to decode document: %w", err)
+	}
+
+	report, err := inspect.Inspect(doc)
+	if err != nil {
+		return err
+	}
+
+	out := cmd.OutOrStdout()
+	switch inspectOutput {
+	case "json":
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	case "text":
+		printInspectReport(out, report)
+		return nil
+	default:
+		return fmt.Errorf("unknown output format: %s", inspectOutput)
+	}
+}
+
+func printInspectReport(out io.Writer, report *inspect.Report) {
+	fmt.Fprintf(out, "Format:    %s\n", report.Format)
+	if report.UserData != "" {
+		fmt.Fprintf(out, "User data: %s\n", report.UserData)
+	}
+	if report.Nonce != "" {
+		fmt.Fprintf(out, "Nonce:     %s\n", report.Nonce)
+	}
+	if instance := report.Instance; instance != nil {
+		fmt.Fprintf(out, "\nInstance:\n")
+		fmt.Fprintf(out, "  Project: %s (%d)\n", instance.ProjectID, instance.ProjectNumber)
+		fmt.Fprintf(out, "  Zone:    %s\n", instance.Zone)
+		fmt.Fprintf(out, "  Name:    %s (%d)\n", instance.InstanceName, instance.InstanceID)
+	}
+	if batch := report.Batch; batch != nil {
+		fmt.Fprintf(out, "\nBatch:\n")
+		fmt.Fprintf(out, "  Index: %d (batch of %d)\n", batch.Index, batch.Size)
+		fmt.Fprintf(out, "  Root:  %s\n", batch.Root)
+		for _, hash := range batch.Path {
+			fmt.Fprintf(out, "  Path:  %s\n", hash)
+		}
+	}
+
+	if quote := report.Quote; quote != nil {
+		fmt.Fprintln(out, "\nQuote header:")
+		fmt.Fprintf(out, "  Version:              %d\n", quote.Header.Version)
+		fmt.Fprintf(out, "  Attestation key type: %d\n", quote.Header.AttestationKeyType)
+		fmt.Fprintf(out, "  TEE type:             0x%x\n", quote.Header.TeeType)
+		fmt.Fprintf(out, "  QE SVN:               %s\n", quote.Header.QeSvn)
+		fmt.Fprintf(out, "  PCE SVN:              %s\n", quote.Header.PceSvn)
+		fmt.Fprintf(out, "  QE vendor ID:         %s\n", quote.Header.QeVendorID)
+		fmt.Fprintf(out, "  User data:            %s\n", quote.Header.UserData)
+
+		body := quote.Body
+		fmt.Fprintln(out, "\nTD body:")
+		fmt.Fprintf(out, "  TEE TCB SVN:     %s\n", body.TeeTcbSvn)
+		fmt.Fprintf(out, "  MRSEAM:          %s\n", body.MrSeam)
+		fmt.Fprintf(out, "  MRSIGNERSEAM:    %s\n", body.MrSignerSeam)
+		fmt.Fprintf(out, "  SEAM attributes: %s\n", body.SeamAttributes)
+		fmt.Fprintf(out, "  TD attributes:   %s [%s]\n", body.TdAttributes, strings.Join(body.TdAttributeFlags, " "))
+		fmt.Fprintf(out, "  XFAM:            %s\n", body.Xfam)
+		fmt.Fprintf(out, "  MRTD:            %s\n", body.MrTd)
+		fmt.Fprintf(out, "  MRCONFIGID:      %s\n", body.MrConfigID)
+		fmt.Fprintf(out, "  MROWNER:         %s\n", body.MrOwner)
+		fmt.Fprintf(out, "  MROWNERCONFIG:   %s\n", body.MrOwnerConfig)
+		for i, rtmr := range body.Rtmrs {
+			fmt.Fprintf(out, "  RTMR%d:           %s\n", i, rtmr)
+		}
+		fmt.Fprintf(out, "  REPORTDATA:      %s\n", body.ReportData)
+	}
+
+	if snp := report.SNPReport; snp != nil {
+		fmt.Fprintln(out, "\nSEV-SNP report:")
+		fmt.Fprintf(out, "  Version:           %d\n", snp.Version)
+		fmt.Fprintf(out, "  Product:           %s\n", snp.Product)
+		fmt.Fprintf(out, "  Signing key:       %s\n", snp.SigningKey)
+		fmt.Fprintf(out, "  MEASUREMENT:       %s\n", snp.Measurement)
+		fmt.Fprintf(out, "  HOST_DATA:         %s\n", snp.HostData)
+		fmt.Fprintf(out, "  Policy:            0x%x [%s]\n", snp.Policy, strings.Join(snp.PolicyFlags, " "))
+		fmt.Fprintf(out, "  VMPL:              %d\n", snp.VMPL)
+		fmt.Fprintf(out, "  Guest SVN:         %d\n", snp.GuestSVN)
+		fmt.Fprintf(out, "  ID key digest:     %s\n", snp.IDKeyDigest)
+		fmt.Fprintf(out, "  Author key digest: %s\n", snp.AuthorKeyDigest)
+		for _, tcb := range []struct {
+			name  string
+			value inspect.TCB
+		}{
+			{"Reported", snp.ReportedTCB},
+			{"Current", snp.CurrentTCB},
+			{"Committed", snp.CommittedTCB},
+			{"Launch", snp.LaunchTCB},
+		} {
+			fmt.Fprintf(out, "  %-18s bootloader %d, tee %d, snp %d, microcode %d\n", tcb.name+" TCB:", tcb.value.Bootloader, tcb.value.TEE, tcb.value.SNP, tcb.value.Microcode)
+		}
+		fmt.Fprintf(out, "  Chip ID:           %s\n", snp.ChipID)
+		fmt.Fprintf(out, "  Report ID:         %s\n", snp.ReportID)
+		fmt.Fprintf(out, "  REPORT_DATA:       %s\n", snp.ReportData)
+	}
+
+	if len(report.PCRs) > 0 {
+		indices := make([]int, 0, len(report.PCRs))
+		for index := range report.PCRs {
+			indices = append(indices, int(index))
+		}
+		sort.Ints(indices)
+
+		fmt.Fprintln(out, "\nTPM PCRs (SHA-256):")
+		for _, index := range indices {
+			fmt.Fprintf(out, "  %2d: %s\n", index, report.PCRs[uint32(index)])
+		}
+	}
+
+	if len(report.Events) > 0 {
+		fmt.Fprintln(out, "\nEvent log:")
+		for _, event := range report.Events {
+			label := event.Type
+			if event.Source == "runtime" {
+				label = event.Description
+			}
+			fmt.Fprintf(out, "  [%s] %s %s %s\n", event.Source, event.Register, event.Digest, label)
+		}
+	}
+
+	if len(report.Certificates) > 0 {
+		fmt.Fprintln(out, "\nCertificates:")
+		for _, cert := range report.Certificates {
+			fmt.Fprintf(out, "  [%s] %s\n", cert.Chain, cert.Subject)
+			if cert.Issuer != "" {
+				fmt.Fprintf(out, "    Issuer:   %s\n", cert.Issuer)
+				fmt.Fprintf(out, "    Serial:   %s\n", cert.Serial)
+				fmt.Fprintf(out, "    Validity: %s to %s\n", cert.NotBefore.Format(time.RFC3339), cert.NotAfter.Format(time.RFC3339))
+			}
+		}
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
@@ -11,7 +12,6 @@ import (
 	"github.com/Hyodar/tdxs/pkg/logger"
 	manager "github.com/Hyodar/tdxs/pkg/manager"
 	"github.com/spf13/cobra"
-	"gopkg.in/yaml.v3"
 )
 
 var (
@@ -24,6 +24,10 @@ var rootCmd = &cobra.Command{
 	Short: "TDX attestation service",
 	Long:  `TDX attestation service that manages attestation issuance and validation`,
 	RunE:  run,
+
+	// Errors are printed by main.
+	SilenceErrors: true,
+	SilenceUsage:  true,
 }
 
 func init() {
@@ -31,10 +35,31 @@ func init() {
 	rootCmd.PersistentFlags().StringVarP(&logLevel, "log-level", "l", "info", "log level (debug, info, warn, error)")
 }
 
+// exitError makes the process exit with a specific status code. A nil err
+// exits silently.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	if e.err == nil {
+		return fmt.Sprintf("exit status %d", e.code)
+	}
+	return e.err.Error()
+}
+
 func main() {
 	if err := rootCmd.Execute(); err != nil {
+		var exitErr *exitError
+		if errors.As(err, &exitErr) {
+			if exitErr.err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", exitErr.err)
+			}
+			os.Exit(exitErr.code)
+		}
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		os.Exit(exitCodeError)
 	}
 }
 
@@ -56,26 +81,24 @@ func run(cmd *cobra.Command, args []string) error {
 	slogger := slog.New(logHandler)
 	log := logger.Logger(slogger)
 
-	configData, err := os.ReadFile(cfgFile)
+	config, err := manager.LoadManagerConfig(cfgFile)
 	if err != nil {
-		return fmt.Errorf("failed to read config file: %w", err)
-	}
-
-	var config manager.ManagerConfig
-	if err := yaml.Unmarshal(configData, &config); err != nil {
-		return fmt.Errorf("failed to parse config file: %w", err)
+		return err
 	}
 
-	mgr, err := manager.NewManager(&config, log)
+	mgr, err := manager.NewManager(config, log)
 	if err != nil {
 		return fmt.Errorf("failed to create manager: %w", err)
 	}
+	mgr.SetConfigLoader(func() (*manager.ManagerConfig, error) {
+		return manager.LoadManagerConfig(cfgFile)
+	})
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 
 	errChan := make(chan error, 1)
 	go func() {
@@ -85,12 +108,21 @@ func run(cmd *cobra.Command, args []string) error {
 		}
 	}()
 
-	select {
-	case sig := <-sigChan:
-		log.Info("Received signal, shutting down", "signal", sig)
-		cancel()
-		return nil
-	case err := <-errChan:
-		return fmt.Errorf("service error: %w", err)
+	for {
+		select {
+		case sig := <-sigChan:
+			if sig == syscall.SIGHUP {
+				log.Info("Received SIGHUP, reloading config", "config", cfgFile)
+				if err := mgr.ReloadConfig(); err != nil {
+					log.Error("Failed to reload config, keeping current config", "error", err)
+				}
+				continue
+			}
+			log.Info("Received signal, shutting down", "signal", sig)
+			cancel()
+			return nil
+		case err := <-errChan:
+			return fmt.Errorf("service error: %w", err)
+		}
 	}
 }
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/Hyodar/tdxs/pkg/measure"
+	"github.com/spf13/cobra"
+)
+
+var (
+	measureFirmware    string
+	measureKernel      string
+	measureInitrd      string
+	measureCmdline     string
+	measureMemory      string
+	measureFirmwareLog string
+	measureOutput      string
+)
+
+var measureCmd = &cobra.Command{
+	Use:   "measure",
+	Short: "Compute expected TD measurements from an image build",
+	Long: `Compute the MRTD and RTMRs of a TD that QEMU boots from a TDVF firmware
+with direct kernel boot (-kernel, -initrd, -append), and print them as
+validator reference values. No TDX hardware is needed.
+
+MRTD is computed from the firmware. RTMR1 and RTMR2 are computed from the
+kernel, initrd, command line and memory size. RTMR0 measures the TD HOB, ACPI
+tables and UEFI variables, which depend on the VMM configuration; it is only
+computed when --firmware-log gives a CCEL captured from a TD with the same
+firmware and configuration (/sys/firmware/acpi/tables/data/CCEL). RTMR3 is
+reported at its initial value.`,
+	Args: cobra.NoArgs,
+	RunE: runMeasure,
+}
+
+func init() {
+	measureCmd.Flags().StringVar(&measureFirmware, "firmware", "", "TDVF (OVMF) firmware image")
+	measureCmd.Flags().StringVar(&measureKernel, "kernel", "", "kernel image (bzImage)")
+	measureCmd.Flags().StringVar(&measureInitrd, "initrd", "", "initrd image")
+	measureCmd.Flags().StringVar(&measureCmdline, "cmdline", "", "kernel command line")
+	measureCmd.Flags().StringVar(&measureMemory, "memory", "2G", "guest memory size (as passed to QEMU -m, in M or G)")
+	measureCmd.Flags().StringVar(&measureFirmwareLog, "firmware-log", "", "CCEL captured from a TD with the same firmware and VM configuration")
+	measureCmd.Flags().StringVarP(&measureOutput, "output", "o", "yaml", "output format (yaml, json)")
+	measureCmd.MarkFlagRequired("firmware")
+	measureCmd.MarkFlagRequired("kernel")
+	rootCmd.AddCommand(measureCmd)
+}
+
+func runMeasure(cmd *cobra.Command, _ []string) error {
+	memory, err := parseMemorySize(measureMemory)
+	if err != nil {
+		return err
+	}
+	in := &measure.Input{Cmdline: measureCmdline, Memory: memory}
+	sources := []string{}
+	for _, file := range []struct {
+		path string
+		data *[]byte
+	}{
+		{measureFirmware, &in.Firmware},
+		{measureKernel, &in.Kernel},
+		{measureInitrd, &in.Initrd},
+		{measureFirmwareLog, &in.FirmwareLog},
+	} {
+		if file.path == "" {
+			continue
+		}
+		*file.data, err = os.ReadFile(file.path)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", file.path, err)
+		}
+		sources = append(sources, filepath.Base(file.path))
+	}
+
+	result, err := measure.Measure(in)
+	if err != nil {
+		return err
+	}
+	result.Sources = sources
+
+	out := cmd.OutOrStdout()
+	switch measureOutput {
+	case "json":
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(result)
+	case "yaml":
+		data, err := result.ValidatorYAML()
+		if err != nil {
+			return err
+		}
+		_, err = out.Write(data)
+		return err
+	default:
+		return fmt.Errorf("unknown output format: %s", measureOutput)
+	}
+}
+
+// parseMemorySize parses a size the way QEMU's -m does: a number of MiB, or
+// a number with an M or G suffix.
+func parseMemorySize(s string) (uint64, error) {
+	number, shift := s, uint64(20)
+	switch {
+	case strings.HasSuffix(s, "G"):
+		number, shift = strings.TrimSuffix(s, "G"), 30
+	case strings.HasSuffix(s, "M"):
+		number = strings.TrimSuffix(s, "M")
+	}
+	value, err := strconv.ParseUint(number, 10, 64)
+	if err != nil || value == 0 || value > 1<<(64-shift)-1 {
+		return 0, fmt.Errorf("invalid memory size %q", s)
+	}
+	return value << shift, nil
+}
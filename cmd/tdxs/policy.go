@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Hyodar/tdxs/pkg/policy"
+	"github.com/spf13/cobra"
+)
+
+var (
+	policySockets []string
+	policyPCRs    []uint
+	policyOutput  string
+)
+
+var policyCmd = &cobra.Command{
+	Use:   "policy",
+	Short: "Work with validator reference values",
+}
+
+var policyGenerateCmd = &cobra.Command{
+	Use:   "generate [metadata.json...]",
+	Short: "Generate Azure validator reference values from issuer metadata",
+	Long: `Collect metadata from running daemons (--socket, repeatable) and saved
+metadata files (the output of "tdxs metadata -o json"), keep the values shared
+by all samples and print an Azure validator config section.
+
+Fields that differ between samples are left out and listed in a comment.
+Exits with status 2 if any field differs.`,
+	RunE: runPolicyGenerate,
+}
+
+func init() {
+	policyGenerateCmd.Flags().StringArrayVarP(&policySockets, "socket", "s", nil, "daemon socket to fetch metadata from (repeatable)")
+	policyGenerateCmd.Flags().StringVarP(&clientProfile, "profile", "p", "", "issuer profile to fetch metadata from")
+	policyGenerateCmd.Flags().DurationVar(&clientTimeout, "timeout", time.Minute, "request timeout")
+	policyGenerateCmd.Flags().UintSliceVar(&policyPCRs, "pcrs", nil, "only include these PCRs (default: all PCRs reported)")
+	policyGenerateCmd.Flags().StringVarP(&policyOutput, "output", "o", "yaml", "output format (yaml, json)")
+	policyCmd.AddCommand(policyGenerateCmd)
+	rootCmd.AddCommand(policyCmd)
+}
+
+func runPolicyGenerate(cmd *cobra.Command, args []string) error {
+	if len(policySockets) == 0 && len(args) == 0 {
+		return fmt.Errorf("at least one --socket or metadata file is required")
+	}
+
+	var samples []policy.Sample
+	for _, socketPath := range policySockets {
+		sample, err := fetchMetadataSample(cmd.Context(), socketPath)
+		if err != nil {
+			return err
+		}
+		samples = append(samples, *sample)
+	}
+	for _, path := range args {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read metadata: %w", err)
+		}
+		sample, err := policy.ParseSample(path, data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		samples = append(samples, *sample)
+	}
+
+	p, err := policy.Generate(samples)
+	if err != nil {
+		return err
+	}
+	if len(policyPCRs) > 0 {
+		restrictPCRs(p, policyPCRs)
+	}
+
+	out := cmd.OutOrStdout()
+	switch policyOutput {
+	case "json":
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(p); err != nil {
+			return err
+		}
+	case "yaml":
+		data, err := p.ValidatorYAML()
+		if err != nil {
+			return err
+		}
+		if _, err := out.Write(data); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown output format: %s", policyOutput)
+	}
+
+	if len(p.Differences) > 0 {
+		fields := make([]string, 0, len(p.Differences))
+		for _, diff := range p.Differences {
+			fields = append(fields, diff.Field)
+		}
+		return &exitError{
+			code: exitCodeInvalid,
+			err:  fmt.Errorf("fields differ between samples: %s", strings.Join(fields, ", ")),
+		}
+	}
+	return nil
+}
+
+func fetchMetadataSample(ctx context.Context, socketPath string) (*policy.Sample, error) {
+	c, err := newSocketClient(socketPath)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(ctx, clientTimeout)
+	defer cancel()
+
+	result, err := c.Metadata(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", socketPath, err)
+	}
+
+	data, err := json.Marshal(metadataResponseData(result))
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+	sample, err := policy.ParseSample(socketPath, data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", socketPath, err)
+	}
+	return sample, nil
+}
+
+// restrictPCRs drops the PCRs that were not asked for, including from the
+// differences.
+func restrictPCRs(p *policy.Policy, indices []uint) {
+	keep := make(map[string]bool, len(indices))
+	for _, index := range indices {
+		keep["pcrs."+strconv.FormatUint(uint64(index), 10)] = true
+	}
+
+	for index := range p.PCRs {
+		if !keep["pcrs."+strconv.FormatUint(uint64(index), 10)] {
+			delete(p.PCRs, index)
+		}
+	}
+
+	differences := p.Differences[:0]
+	for _, diff := range p.Differences {
+		if !diff.IsPCR() || keep[diff.Field] {
+			differences = append(differences, diff)
+		}
+	}
+	p.Differences = differences
+}
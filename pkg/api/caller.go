@@ -0,0 +1,9 @@
+package api
+
+// Caller identifies the peer that submitted a request, as reported by the
+// transport. Transports that cannot determine the peer leave it nil.
+type Caller struct {
+	PID int32  `json:"pid"`
+	UID uint32 `json:"uid"`
+	GID uint32 `json:"gid"`
+}
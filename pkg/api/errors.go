@@ -0,0 +1,78 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrorCode is a stable, machine-readable category for a failed request.
+type ErrorCode string
+
+const (
+	// ErrorCodeBadRequest means the request was malformed or named something
+	// that does not exist, such as an unknown method or profile.
+	ErrorCodeBadRequest ErrorCode = "bad_request"
+	// ErrorCodeUnauthorized means the caller is not allowed to make the request.
+	ErrorCodeUnauthorized ErrorCode = "unauthorized"
+	// ErrorCodeNotEnabled means the method is not configured, e.g. validate on
+	// a profile without a validator.
+	ErrorCodeNotEnabled ErrorCode = "not_enabled"
+	// ErrorCodeBackendUnavailable means the issuer or validator backend could
+	// not serve the request, e.g. the TPM or a collateral service failed.
+	ErrorCodeBackendUnavailable ErrorCode = "backend_unavailable"
+	// ErrorCodeAttestationInvalid means the document could not be parsed or
+	// its signatures and certificates did not verify.
+	ErrorCodeAttestationInvalid ErrorCode = "attestation_invalid"
+	// ErrorCodePolicyRejected means the document verified but did not match
+	// the configured reference values.
+	ErrorCodePolicyRejected ErrorCode = "policy_rejected"
+	// ErrorCodeTimeout means the request did not finish in time.
+	ErrorCodeTimeout ErrorCode = "timeout"
+	// ErrorCodeInternal is used for errors that have no category.
+	ErrorCodeInternal ErrorCode = "internal"
+)
+
+// Retryable reports whether the same request may succeed if sent again.
+func (c ErrorCode) Retryable() bool {
+	return c == ErrorCodeBackendUnavailable || c == ErrorCodeTimeout
+}
+
+// Error attaches an ErrorCode to an error.
+type Error struct {
+	Code ErrorCode
+	Err  error
+}
+
+func NewError(code ErrorCode, err error) *Error {
+	return &Error{Code: code, Err: err}
+}
+
+func Errorf(code ErrorCode, format string, args ...any) *Error {
+	return &Error{Code: code, Err: fmt.Errorf(format, args...)}
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// CodeOf returns the category of err: the code of the outermost *Error in
+// its chain, ErrorCodeTimeout for context deadlines, and ErrorCodeInternal
+// otherwise.
+func CodeOf(err error) ErrorCode {
+	if err == nil {
+		return ""
+	}
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrorCodeTimeout
+	}
+	return ErrorCodeInternal
+}
@@ -1,13 +1,22 @@
 package api
 
+import "time"
+
 type IssueRequest struct {
 	UserData []byte
 	Nonce    []byte
 	Options  any
 }
 
+// MetadataRequest asks for the issuer's metadata. UserData and Nonce bind
+// the attestation the metadata is read from to the caller's values; when
+// both are empty, issuer.MetadataUserData and issuer.MetadataNonce are used.
+// IncludeDocument returns that attestation document with the metadata.
 type MetadataRequest struct {
-	Options any
+	UserData        []byte
+	Nonce           []byte
+	IncludeDocument bool
+	Options         any
 }
 
 type ValidateRequest struct {
@@ -15,3 +24,45 @@ type ValidateRequest struct {
 	Nonce    []byte
 	Options  any
 }
+
+type HealthRequest struct{}
+
+type ReloadRequest struct{}
+
+// FaultsRequest changes the injected faults of a profile's simulator
+// backends. Issuer and Validator replace the faults of the respective
+// backend; Enabled then switches both on or off. Nil fields leave the
+// current settings in place.
+type FaultsRequest struct {
+	Issuer    *Faults
+	Validator *Faults
+	Enabled   *bool
+}
+
+// Faults describes the faults injected by a simulator backend. It mirrors
+// simulator.Faults, which the manager converts it to and from.
+type Faults struct {
+	Enabled           bool
+	Seed              uint64
+	Latency           Latency
+	ErrorRates        map[string]float64
+	CorruptSignature  float64
+	WrongNonce        float64
+	StaleTCB          float64
+	ExpiredCollateral float64
+}
+
+type Latency struct {
+	Distribution string
+	Min          time.Duration
+	Max          time.Duration
+	Mean         time.Duration
+	StdDev       time.Duration
+}
+
+// ExtendRequest extends an RTMR with a SHA-384 digest.
+type ExtendRequest struct {
+	RTMR        int
+	Digest      []byte
+	Description string
+}
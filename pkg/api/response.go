@@ -1,20 +1,81 @@
 package api
 
+import "time"
+
 type IssueResponse struct {
 	Document []byte
 	Error    error
 }
 
+// ValidateResponse holds exactly one verdict (see Verdict): valid with
+// UserData, invalid with FailedChecks, or could-not-evaluate with Error.
+// Claims are validator specific, e.g. eventlog.Claims for documents with
+// attached event logs, and only set on valid verdicts.
 type ValidateResponse struct {
-	UserData []byte
-	Valid    bool
-	Error    error
+	UserData     []byte
+	Valid        bool
+	FailedChecks []FailedCheck
+	Claims       any
+	Error        error
 }
 
+// MetadataResponse holds the metadata and the user data and nonce of the
+// attestation it was read from. Document is that attestation, if requested.
 type MetadataResponse struct {
 	IssuerType string
 	UserData   []byte
 	Nonce      []byte
 	Metadata   any
+	Document   []byte
+	Error      error
+}
+
+// HealthResponse reports the service status. IssueCache holds the counters
+// of the issue cache of each profile that has one.
+type HealthResponse struct {
+	Live       bool
+	Ready      bool
+	SelfTest   SelfTestStatus
+	IssueCache map[string]CacheStats
 	Error      error
 }
+
+type SelfTestStatus struct {
+	Enabled     bool
+	Running     bool
+	LastRun     time.Time
+	LastSuccess time.Time
+	LastError   string
+}
+
+// CacheStats counts Issue requests served from the cache (Hits), by calling
+// the issuer (Misses) and by joining an identical request in progress
+// (Shared), and documents evicted to stay within the size limit.
+type CacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Shared    uint64
+	Evictions uint64
+	Entries   int
+}
+
+type ReloadResponse struct {
+	Error error
+}
+
+// FaultsResponse holds the faults in effect after a FaultsRequest. A nil
+// field means the backend is absent or does not support fault injection.
+type FaultsResponse struct {
+	Issuer    *Faults
+	Validator *Faults
+	Error     error
+}
+
+// ExtendResponse holds the RTMR value after the extension and the sequence
+// number of its runtime event log entry.
+type ExtendResponse struct {
+	RTMR     int
+	Value    []byte
+	Sequence uint64
+	Error    error
+}
@@ -0,0 +1,77 @@
+package api
+
+import "fmt"
+
+// Verdict is the outcome of a validation.
+type Verdict string
+
+const (
+	// VerdictValid means every check passed.
+	VerdictValid Verdict = "valid"
+	// VerdictInvalid means the document was evaluated and at least one check
+	// failed. The failed checks say which.
+	VerdictInvalid Verdict = "invalid"
+	// VerdictError means the document could not be evaluated, e.g. because
+	// the request was malformed or the backend failed. Error says why.
+	VerdictError Verdict = "error"
+)
+
+// Names of the checks validators report in FailedCheck.
+const (
+	CheckFormat       = "format"       // the document could not be parsed
+	CheckSignature    = "signature"    // signatures or certificate chains did not verify
+	CheckNonce        = "nonce"        // the document is not bound to the expected nonce
+	CheckTCB          = "tcb"          // the platform TCB is out of date or collateral is stale
+	CheckMeasurements = "measurements" // PCRs or TD measurements differ from the reference values
+	CheckAttributes   = "attributes"   // TD attributes, XFAM or SVNs differ from the reference values
+	CheckEventLog     = "eventlog"     // the attached event log is malformed or does not replay to the measurements
+	CheckIdentity     = "identity"     // the platform identity, such as the cloud instance, is not allowed
+)
+
+// FailedCheck is one reason a document was found invalid. Code is
+// ErrorCodeAttestationInvalid when the document itself is bad and
+// ErrorCodePolicyRejected when it is genuine but does not match the
+// reference values.
+type FailedCheck struct {
+	Check  string
+	Code   ErrorCode
+	Reason string
+}
+
+func (c FailedCheck) String() string {
+	return fmt.Sprintf("%s: %s", c.Check, c.Reason)
+}
+
+// NewFailedCheck returns a check failure with the code implied by the check.
+func NewFailedCheck(check string, reason string) FailedCheck {
+	code := ErrorCodeAttestationInvalid
+	if check == CheckMeasurements || check == CheckAttributes || check == CheckIdentity {
+		code = ErrorCodePolicyRejected
+	}
+	return FailedCheck{Check: check, Code: code, Reason: reason}
+}
+
+func NewValidResponse(userData []byte) *ValidateResponse {
+	return &ValidateResponse{UserData: userData, Valid: true}
+}
+
+func NewInvalidResponse(checks ...FailedCheck) *ValidateResponse {
+	return &ValidateResponse{FailedChecks: checks}
+}
+
+// NewValidateErrorResponse reports that the document could not be evaluated.
+func NewValidateErrorResponse(err error) *ValidateResponse {
+	return &ValidateResponse{Error: err}
+}
+
+// Verdict derives the verdict from the response fields.
+func (r *ValidateResponse) Verdict() Verdict {
+	switch {
+	case r.Error != nil:
+		return VerdictError
+	case r.Valid:
+		return VerdictValid
+	default:
+		return VerdictInvalid
+	}
+}
@@ -1,16 +1,47 @@
 package api
 
 type IssueRequestWrapper struct {
+	Caller   *Caller
+	Profile  string
 	Request  *IssueRequest
 	Response chan *IssueResponse
 }
 
 type MetadataRequestWrapper struct {
+	Caller   *Caller
+	Profile  string
 	Request  *MetadataRequest
 	Response chan *MetadataResponse
 }
 
 type ValidateRequestWrapper struct {
+	Caller   *Caller
+	Profile  string
 	Request  *ValidateRequest
 	Response chan *ValidateResponse
 }
+
+type HealthRequestWrapper struct {
+	Caller   *Caller
+	Request  *HealthRequest
+	Response chan *HealthResponse
+}
+
+type ReloadRequestWrapper struct {
+	Caller   *Caller
+	Request  *ReloadRequest
+	Response chan *ReloadResponse
+}
+
+type FaultsRequestWrapper struct {
+	Caller   *Caller
+	Profile  string
+	Request  *FaultsRequest
+	Response chan *FaultsResponse
+}
+
+type ExtendRequestWrapper struct {
+	Caller   *Caller
+	Request  *ExtendRequest
+	Response chan *ExtendResponse
+}
@@ -0,0 +1,358 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Hyodar/tdxs/pkg/api"
+	"github.com/Hyodar/tdxs/pkg/logger"
+)
+
+// GenesisHash is the previous hash of the very first entry of a chain.
+var GenesisHash = strings.Repeat("0", sha256.Size*2)
+
+type Result string
+
+const (
+	ResultSuccess Result = "success"
+	ResultValid   Result = "valid"
+	ResultInvalid Result = "invalid"
+	ResultError   Result = "error"
+)
+
+type Entry struct {
+	Seq             uint64      `json:"seq"`
+	Time            time.Time   `json:"time"`
+	Method          string      `json:"method"`
+	Caller          *api.Caller `json:"caller,omitempty"`
+	Profile         string      `json:"profile,omitempty"`
+	UserDataHash    string      `json:"userDataHash,omitempty"`
+	NonceHash       string      `json:"nonceHash,omitempty"`
+	Result          Result      `json:"result"`
+	Error           string      `json:"error,omitempty"`
+	ReferenceValues string      `json:"referenceValues,omitempty"`
+	RTMR            *int        `json:"rtmr,omitempty"`
+	Digest          string      `json:"digest,omitempty"`
+	PrevHash        string      `json:"prevHash"`
+	Hash            string      `json:"hash"`
+}
+
+// ComputeHash returns the chain hash of the entry, covering every field
+// except Hash itself. PrevHash is included, which links the entry to its
+// predecessor.
+func (e *Entry) ComputeHash() (string, error) {
+	unhashed := *e
+	unhashed.Hash = ""
+	data, err := json.Marshal(&unhashed)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal entry: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+type AuditConfig struct {
+	FilePath string `yaml:"file_path"`
+	MaxSize  int64  `yaml:"max_size"`
+	MaxFiles int    `yaml:"max_files"`
+}
+
+func (c *AuditConfig) Validate() error {
+	if c.FilePath == "" {
+		return fmt.Errorf("file_path is required")
+	}
+	if c.MaxSize < 0 {
+		return fmt.Errorf("max_size must not be negative")
+	}
+	if c.MaxFiles < 0 {
+		return fmt.Errorf("max_files must not be negative")
+	}
+	return nil
+}
+
+type AuditLog struct {
+	cfg    *AuditConfig
+	logger logger.Logger
+
+	mu       sync.Mutex
+	file     logFile
+	size     int64
+	seq      uint64
+	prevHash string
+	// failed is set when a failed write could not be undone; the log
+	// refuses entries until it is reopened and the torn entry recovered.
+	failed error
+}
+
+// logFile is the part of *os.File the log writes through.
+type logFile interface {
+	io.Writer
+	Sync() error
+	Truncate(size int64) error
+	Close() error
+}
+
+func NewAuditLog(cfg *AuditConfig, logger logger.Logger) (*AuditLog, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	l := &AuditLog{
+		cfg:      cfg,
+		logger:   logger,
+		prevHash: GenesisHash,
+	}
+
+	if err := l.recoverChain(); err != nil {
+		return nil, fmt.Errorf("failed to recover audit chain: %w", err)
+	}
+
+	if err := l.openFile(); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// recoverChain resumes the hash chain from the newest entry on disk, looking
+// at the most recently rotated file if the current one is empty. A torn last
+// line left by a crash during a write is truncated; that entry's result was
+// never returned to its caller.
+func (l *AuditLog) recoverChain() error {
+	last, torn, err := lastEntry(l.cfg.FilePath)
+	if err != nil {
+		return err
+	}
+	if torn >= 0 {
+		l.logger.Warn("Truncating torn entry at the end of the audit log", "path", l.cfg.FilePath, "offset", torn)
+		if err := os.Truncate(l.cfg.FilePath, torn); err != nil {
+			return fmt.Errorf("failed to truncate torn entry: %w", err)
+		}
+	}
+
+	if last == nil {
+		rotated := rotatedPath(l.cfg.FilePath, 1)
+		last, torn, err = lastEntry(rotated)
+		if err != nil {
+			return err
+		}
+		if torn >= 0 {
+			return fmt.Errorf("%s ends in an incomplete entry", rotated)
+		}
+	}
+
+	if last != nil {
+		l.seq = last.Seq
+		l.prevHash = last.Hash
+	}
+	return nil
+}
+
+func (l *AuditLog) openFile() error {
+	file, err := os.OpenFile(l.cfg.FilePath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat audit log: %w", err)
+	}
+
+	l.file = file
+	l.size = info.Size()
+	return nil
+}
+
+// Record fills in the sequence number, time and chain hashes of the entry and
+// appends it to the log.
+func (l *AuditLog) Record(entry *Entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return fmt.Errorf("audit log is closed")
+	}
+	if l.failed != nil {
+		return fmt.Errorf("audit log failed: %w", l.failed)
+	}
+
+	entry.Seq = l.seq + 1
+	entry.Time = time.Now().UTC()
+	entry.PrevHash = l.prevHash
+
+	hash, err := entry.ComputeHash()
+	if err != nil {
+		return err
+	}
+	entry.Hash = hash
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal entry: %w", err)
+	}
+	line = append(line, '\n')
+
+	if l.cfg.MaxSize > 0 && l.size > 0 && l.size+int64(len(line)) > l.cfg.MaxSize {
+		if err := l.rotate(); err != nil {
+			return fmt.Errorf("failed to rotate audit log: %w", err)
+		}
+	}
+
+	if _, err := l.file.Write(line); err != nil {
+		return l.undo(fmt.Errorf("failed to write audit entry: %w", err))
+	}
+	if err := l.file.Sync(); err != nil {
+		return l.undo(fmt.Errorf("failed to sync audit log: %w", err))
+	}
+
+	l.size += int64(len(line))
+	l.seq = entry.Seq
+	l.prevHash = entry.Hash
+	return nil
+}
+
+// undo truncates what a failed write left at the end of the file, so the
+// next entry does not follow a torn one. If that fails too, the log is
+// marked failed.
+func (l *AuditLog) undo(err error) error {
+	if truncErr := l.file.Truncate(l.size); truncErr != nil {
+		l.logger.Error("Failed to truncate audit log after a failed write", "path", l.cfg.FilePath, "error", truncErr)
+		l.failed = errors.Join(err, truncErr)
+	}
+	return err
+}
+
+func (l *AuditLog) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	l.file = nil
+
+	oldest := highestRotation(l.cfg.FilePath)
+	if l.cfg.MaxFiles > 0 {
+		for i := oldest; i >= l.cfg.MaxFiles; i-- {
+			if err := os.Remove(rotatedPath(l.cfg.FilePath, i)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		oldest = min(oldest, l.cfg.MaxFiles-1)
+	}
+
+	for i := oldest; i >= 1; i-- {
+		if err := os.Rename(rotatedPath(l.cfg.FilePath, i), rotatedPath(l.cfg.FilePath, i+1)); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Rename(l.cfg.FilePath, rotatedPath(l.cfg.FilePath, 1)); err != nil {
+		return err
+	}
+
+	l.logger.Info("Rotated audit log", "path", l.cfg.FilePath, "seq", l.seq)
+	return l.openFile()
+}
+
+func (l *AuditLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+func rotatedPath(path string, index int) string {
+	return fmt.Sprintf("%s.%d", path, index)
+}
+
+func highestRotation(path string) int {
+	i := 0
+	for {
+		if _, err := os.Stat(rotatedPath(path, i+1)); err != nil {
+			return i
+		}
+		i++
+	}
+}
+
+// Files returns the audit log at path and its rotated predecessors, ordered
+// from oldest to newest.
+func Files(path string) []string {
+	var files []string
+	for i := highestRotation(path); i >= 1; i-- {
+		files = append(files, rotatedPath(path, i))
+	}
+	if _, err := os.Stat(path); err == nil {
+		files = append(files, path)
+	}
+	return files
+}
+
+// lastEntry returns the last entry of the file at path. If the file ends in
+// a line without a newline, which only an interrupted write leaves, that line
+// is skipped and torn is the offset it starts at; otherwise torn is -1.
+func lastEntry(path string) (last *Entry, torn int64, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, -1, nil
+		}
+		return nil, -1, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				return last, offset, nil
+			}
+			return last, -1, nil
+		}
+		if err != nil {
+			return nil, -1, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		offset += int64(len(line))
+		if len(line) > maxLineSize {
+			return nil, -1, fmt.Errorf("failed to read %s: line exceeds %d bytes", path, maxLineSize)
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		var entry Entry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return nil, -1, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		last = &entry
+	}
+}
+
+const maxLineSize = 1024 * 1024
+
+// HashData returns the hex-encoded SHA-256 digest of data, or an empty string
+// if data is empty.
+func HashData(data []byte) string {
+	if len(data) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,254 @@
+package audit
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestLog(t *testing.T, cfg *AuditConfig) *AuditLog {
+	t.Helper()
+	l, err := NewAuditLog(cfg, slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatalf("failed to open audit log: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func record(t *testing.T, l *AuditLog, n int) {
+	t.Helper()
+	for i := range n {
+		entry := &Entry{Method: MethodIssue, Result: ResultSuccess, UserDataHash: HashData(fmt.Appendf(nil, "user data %d", i))}
+		if err := l.Record(entry); err != nil {
+			t.Fatalf("failed to record entry: %v", err)
+		}
+	}
+}
+
+func readLines(t *testing.T, path string) [][]byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	return lines[:len(lines)-1]
+}
+
+func writeLines(t *testing.T, path string, lines [][]byte) {
+	t.Helper()
+	data := bytes.Join(lines, nil)
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		data = append(data, '\n')
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	l := newTestLog(t, &AuditConfig{FilePath: path})
+	record(t, l, 3)
+	l.Close()
+
+	// The chain resumes from the last entry after a restart.
+	l = newTestLog(t, &AuditConfig{FilePath: path})
+	record(t, l, 2)
+
+	result, err := Verify([]string{path}, false)
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if result.Entries != 5 || result.FirstSeq != 1 || result.LastSeq != 5 || result.FirstPrev != GenesisHash {
+		t.Errorf("result = %+v, want entries 1-5 from the genesis hash", result)
+	}
+}
+
+func TestRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	cfg := &AuditConfig{FilePath: path, MaxSize: 600, MaxFiles: 2}
+	l := newTestLog(t, cfg)
+	record(t, l, 20)
+	l.Close()
+
+	files := Files(path)
+	if len(files) != 3 || files[0] != path+".2" || files[2] != path {
+		t.Fatalf("files = %v, want two rotated files and the current one", files)
+	}
+
+	// Rotated files were pruned, so the chain only verifies if that is
+	// allowed.
+	if _, err := Verify(files, false); err == nil {
+		t.Error("pruned chain verified without allowing pruning")
+	}
+	result, err := Verify(files, true)
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if result.LastSeq != 20 || result.FirstSeq == 1 || result.FirstPrev == GenesisHash {
+		t.Errorf("result = %+v, want a chain ending at entry 20 after pruned entries", result)
+	}
+
+	// A crash right after rotating leaves an empty current file, and the
+	// chain resumes from the newest rotated one.
+	for _, rename := range [][2]string{{path + ".1", path + ".2"}, {path, path + ".1"}} {
+		if err := os.Rename(rename[0], rename[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	l = newTestLog(t, cfg)
+	record(t, l, 1)
+	l.Close()
+	if result, err := Verify(Files(path), true); err != nil || result.LastSeq != 21 {
+		t.Errorf("Verify = %+v, %v; want a chain ending at entry 21", result, err)
+	}
+}
+
+func TestTornEntry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	l := newTestLog(t, &AuditConfig{FilePath: path})
+	record(t, l, 3)
+	l.Close()
+
+	lines := readLines(t, path)
+	torn := append(bytes.Join(lines, nil), lines[2][:40]...)
+	if err := os.WriteFile(path, torn, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	l = newTestLog(t, &AuditConfig{FilePath: path})
+	record(t, l, 1)
+	l.Close()
+
+	result, err := Verify([]string{path}, false)
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if result.Entries != 4 || result.LastSeq != 4 {
+		t.Errorf("result = %+v, want the torn entry replaced", result)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	l := newTestLog(t, &AuditConfig{FilePath: path})
+	record(t, l, 4)
+	l.Close()
+	lines := readLines(t, path)
+
+	for _, tt := range []struct {
+		name   string
+		modify func(lines [][]byte) [][]byte
+		err    string
+	}{
+		{
+			name: "Tampered",
+			modify: func(lines [][]byte) [][]byte {
+				lines[1] = bytes.Replace(lines[1], []byte(`"success"`), []byte(`"error"`), 1)
+				return lines
+			},
+			err: "hash mismatch",
+		},
+		{
+			name: "Reordered",
+			modify: func(lines [][]byte) [][]byte {
+				lines[1], lines[2] = lines[2], lines[1]
+				return lines
+			},
+			err: "expected sequence",
+		},
+		{
+			name:   "Deleted",
+			modify: func(lines [][]byte) [][]byte { return append(lines[:1], lines[2:]...) },
+			err:    "expected sequence",
+		},
+		{
+			name:   "HeadDeleted",
+			modify: func(lines [][]byte) [][]byte { return lines[2:] },
+			err:    "does not start at entry 1",
+		},
+		{
+			name:   "Truncated",
+			modify: func(lines [][]byte) [][]byte { return append(lines[:3], lines[3][:40]) },
+			err:    "failed to parse entry",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			modified := filepath.Join(t.TempDir(), "audit.jsonl")
+			writeLines(t, modified, tt.modify(append([][]byte{}, lines...)))
+			_, err := Verify([]string{modified}, false)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Verify error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+// tearingFile writes half of each entry and fails while tear is set, and
+// fails truncation while stuck is set.
+type tearingFile struct {
+	logFile
+	tear, stuck bool
+}
+
+func (f *tearingFile) Write(p []byte) (int, error) {
+	if !f.tear {
+		return f.logFile.Write(p)
+	}
+	n, _ := f.logFile.Write(p[:len(p)/2])
+	return n, errors.New("disk full")
+}
+
+func (f *tearingFile) Truncate(size int64) error {
+	if f.stuck {
+		return errors.New("read-only file system")
+	}
+	return f.logFile.Truncate(size)
+}
+
+func TestFailedWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	l := newTestLog(t, &AuditConfig{FilePath: path})
+	record(t, l, 2)
+
+	file := &tearingFile{logFile: l.file, tear: true}
+	l.file = file
+	if err := l.Record(&Entry{Method: MethodIssue, Result: ResultSuccess}); err == nil {
+		t.Fatal("torn write succeeded")
+	}
+
+	// The torn entry was truncated, so the next one continues the chain.
+	file.tear = false
+	record(t, l, 1)
+	if result, err := Verify([]string{path}, false); err != nil || result.Entries != 3 {
+		t.Fatalf("Verify = %+v, %v; want 3 entries", result, err)
+	}
+
+	// If the torn entry cannot be truncated, the log refuses entries until
+	// it is reopened.
+	file.tear, file.stuck = true, true
+	if err := l.Record(&Entry{Method: MethodIssue, Result: ResultSuccess}); err == nil {
+		t.Fatal("torn write succeeded")
+	}
+	file.tear = false
+	if err := l.Record(&Entry{Method: MethodIssue, Result: ResultSuccess}); err == nil {
+		t.Fatal("failed log accepted an entry")
+	}
+	l.Close()
+
+	l = newTestLog(t, &AuditConfig{FilePath: path})
+	record(t, l, 1)
+	if result, err := Verify([]string{path}, false); err != nil || result.Entries != 4 {
+		t.Errorf("Verify = %+v, %v; want 4 entries after reopening", result, err)
+	}
+}
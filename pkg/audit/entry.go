@@ -0,0 +1,78 @@
+package audit
+
+import (
+	"encoding/hex"
+
+	"github.com/Hyodar/tdxs/pkg/api"
+)
+
+const (
+	MethodIssue    = "issue"
+	MethodMetadata = "metadata"
+	MethodValidate = "validate"
+	MethodExtend   = "extend"
+)
+
+func NewIssueEntry(caller *api.Caller, req *api.IssueRequest, resp *api.IssueResponse) *Entry {
+	entry := &Entry{
+		Method:       MethodIssue,
+		Caller:       caller,
+		UserDataHash: HashData(req.UserData),
+		NonceHash:    HashData(req.Nonce),
+		Result:       ResultSuccess,
+	}
+	if resp.Error != nil {
+		entry.Result = ResultError
+		entry.Error = resp.Error.Error()
+	}
+	return entry
+}
+
+func NewMetadataEntry(caller *api.Caller, req *api.MetadataRequest, resp *api.MetadataResponse) *Entry {
+	entry := &Entry{
+		Method:       MethodMetadata,
+		Caller:       caller,
+		UserDataHash: HashData(req.UserData),
+		NonceHash:    HashData(req.Nonce),
+		Result:       ResultSuccess,
+	}
+	if resp.Error != nil {
+		entry.Result = ResultError
+		entry.Error = resp.Error.Error()
+	}
+	return entry
+}
+
+func NewValidateEntry(caller *api.Caller, req *api.ValidateRequest, resp *api.ValidateResponse, referenceValues string) *Entry {
+	entry := &Entry{
+		Method:          MethodValidate,
+		Caller:          caller,
+		UserDataHash:    HashData(resp.UserData),
+		NonceHash:       HashData(req.Nonce),
+		Result:          ResultInvalid,
+		ReferenceValues: referenceValues,
+	}
+	switch {
+	case resp.Error != nil:
+		entry.Result = ResultError
+		entry.Error = resp.Error.Error()
+	case resp.Valid:
+		entry.Result = ResultValid
+	}
+	return entry
+}
+
+func NewExtendEntry(caller *api.Caller, req *api.ExtendRequest, resp *api.ExtendResponse) *Entry {
+	entry := &Entry{
+		Method: MethodExtend,
+		Caller: caller,
+		RTMR:   &req.RTMR,
+		Digest: hex.EncodeToString(req.Digest),
+		Result: ResultSuccess,
+	}
+	if resp.Error != nil {
+		entry.Result = ResultError
+		entry.Error = resp.Error.Error()
+	}
+	return entry
+}
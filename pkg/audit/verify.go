@@ -0,0 +1,102 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+type VerifyResult struct {
+	Entries   uint64
+	FirstSeq  uint64
+	LastSeq   uint64
+	FirstPrev string
+	LastHash  string
+}
+
+// Verify walks the given files, ordered from oldest to newest, and checks that
+// every entry hashes correctly and links to its predecessor. The chain must
+// start at entry 1 unless allowPruned is set, in which case the first entry
+// may link to a hash that is not present because older rotated files were
+// pruned; FirstSeq and FirstPrev then tell where the chain is anchored.
+func Verify(paths []string, allowPruned bool) (*VerifyResult, error) {
+	result := &VerifyResult{}
+	var prev *Entry
+
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open %s: %w", path, err)
+		}
+
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+		line := 0
+		for scanner.Scan() {
+			line++
+			if len(scanner.Bytes()) == 0 {
+				continue
+			}
+
+			var entry Entry
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+				file.Close()
+				return nil, fmt.Errorf("%s:%d: failed to parse entry: %w", path, line, err)
+			}
+
+			if err := verifyEntry(&entry, prev, allowPruned); err != nil {
+				file.Close()
+				return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+			}
+
+			if prev == nil {
+				result.FirstSeq = entry.Seq
+				result.FirstPrev = entry.PrevHash
+			}
+			result.Entries++
+			result.LastSeq = entry.Seq
+			result.LastHash = entry.Hash
+			prev = &entry
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+	}
+
+	return result, nil
+}
+
+func verifyEntry(entry *Entry, prev *Entry, allowPruned bool) error {
+	hash, err := entry.ComputeHash()
+	if err != nil {
+		return err
+	}
+	if hash != entry.Hash {
+		return fmt.Errorf("entry %d: hash mismatch: recorded %s, computed %s", entry.Seq, entry.Hash, hash)
+	}
+
+	if prev == nil {
+		switch {
+		case entry.Seq == 0:
+			return fmt.Errorf("entry 0: sequence numbers start at 1")
+		case entry.Seq == 1 && entry.PrevHash != GenesisHash:
+			return fmt.Errorf("entry 1: previous hash is not the genesis hash")
+		case entry.Seq > 1 && !allowPruned:
+			return fmt.Errorf("entry %d: chain does not start at entry 1, entries before it are missing", entry.Seq)
+		case entry.Seq > 1 && entry.PrevHash == GenesisHash:
+			return fmt.Errorf("entry %d: previous hash is the genesis hash", entry.Seq)
+		}
+		return nil
+	}
+
+	if entry.Seq != prev.Seq+1 {
+		return fmt.Errorf("entry %d: expected sequence %d", entry.Seq, prev.Seq+1)
+	}
+	if entry.PrevHash != prev.Hash {
+		return fmt.Errorf("entry %d: previous hash %s does not match entry %d hash %s", entry.Seq, entry.PrevHash, prev.Seq, prev.Hash)
+	}
+	return nil
+}
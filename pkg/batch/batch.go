@@ -0,0 +1,197 @@
+// Package batch lets one attestation document cover many (userData, nonce)
+// pairs. The pairs are the leaves of a Merkle tree, the document attests to
+// the tree's root, and each caller receives the document together with an
+// inclusion proof for its own leaf.
+package batch
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// Domain separation prefixes, as in RFC 6962, so that a leaf can never be
+// mistaken for an interior node.
+const (
+	leafPrefix = 0x00
+	nodePrefix = 0x01
+)
+
+// Leaf returns the leaf hash of a (userData, nonce) pair. Both values are
+// length prefixed, so different pairs never encode to the same leaf.
+func Leaf(userData, nonce []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{leafPrefix})
+	h.Write(binary.BigEndian.AppendUint32(nil, uint32(len(userData))))
+	h.Write(userData)
+	h.Write(binary.BigEndian.AppendUint32(nil, uint32(len(nonce))))
+	h.Write(nonce)
+	return h.Sum(nil)
+}
+
+func node(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{nodePrefix})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// Tree is a Merkle tree over leaf hashes. A node without a sibling is
+// promoted to the next level unchanged, which gives the same root and proofs
+// as the RFC 6962 tree over the same leaves.
+type Tree struct {
+	levels [][][]byte
+}
+
+func NewTree(leaves [][]byte) (*Tree, error) {
+	if len(leaves) == 0 {
+		return nil, fmt.Errorf("tree has no leaves")
+	}
+	t := &Tree{levels: [][][]byte{leaves}}
+	for level := leaves; len(level) > 1; {
+		next := make([][]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 < len(level) {
+				next = append(next, node(level[i], level[i+1]))
+			} else {
+				next = append(next, level[i])
+			}
+		}
+		t.levels = append(t.levels, next)
+		level = next
+	}
+	return t, nil
+}
+
+func (t *Tree) Size() int {
+	return len(t.levels[0])
+}
+
+func (t *Tree) Root() []byte {
+	return t.levels[len(t.levels)-1][0]
+}
+
+// Proof returns the inclusion proof of the leaf at index, from the bottom of
+// the tree up.
+func (t *Tree) Proof(index int) [][]byte {
+	var proof [][]byte
+	for _, level := range t.levels[:len(t.levels)-1] {
+		if sibling := index ^ 1; sibling < len(level) {
+			proof = append(proof, level[sibling])
+		}
+		index /= 2
+	}
+	return proof
+}
+
+// VerifyProof checks that leaf is at index in a tree of size leaves with the
+// given root, following RFC 9162, section 2.1.3.2.
+func VerifyProof(leaf []byte, index, size uint64, proof [][]byte, root []byte) bool {
+	if index >= size {
+		return false
+	}
+	fn, sn := index, size-1
+	hash := leaf
+	for _, sibling := range proof {
+		if sn == 0 {
+			return false
+		}
+		if fn&1 == 1 || fn == sn {
+			hash = node(sibling, hash)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			hash = node(hash, sibling)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	return sn == 0 && bytes.Equal(hash, root)
+}
+
+// Document is a batched attestation document: the document issued over the
+// Merkle root, and the proof that binds one caller's user data and nonce to
+// that root. The nonce is not included; validators take it from the request.
+type Document struct {
+	Batch    Proof  `json:"batch"`
+	Document []byte `json:"document"`
+}
+
+// Proof is the position of a leaf in its batch and its inclusion proof. Byte
+// values are hex encoded.
+type Proof struct {
+	UserData string   `json:"userData"`
+	Index    uint64   `json:"index"`
+	Size     uint64   `json:"size"`
+	Path     []string `json:"path"`
+}
+
+// NewDocument builds the document for the leaf at index of tree.
+func NewDocument(document []byte, tree *Tree, index int, userData []byte) *Document {
+	proof := tree.Proof(index)
+	path := make([]string, len(proof))
+	for i, hash := range proof {
+		path[i] = hex.EncodeToString(hash)
+	}
+	return &Document{
+		Batch: Proof{
+			UserData: hex.EncodeToString(userData),
+			Index:    uint64(index),
+			Size:     uint64(tree.Size()),
+			Path:     path,
+		},
+		Document: document,
+	}
+}
+
+// IsDocument reports whether doc is a batched document rather than a
+// document of the issuer itself.
+func IsDocument(doc []byte) bool {
+	trimmed := bytes.TrimSpace(doc)
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return false
+	}
+	var probe map[string]json.RawMessage
+	if err := json.Unmarshal(trimmed, &probe); err != nil {
+		return false
+	}
+	_, ok := probe["batch"]
+	return ok
+}
+
+func ParseDocument(doc []byte) (*Document, error) {
+	var parsed Document
+	if err := json.Unmarshal(doc, &parsed); err != nil {
+		return nil, fmt.Errorf("failed to parse batched document: %w", err)
+	}
+	if len(parsed.Document) == 0 {
+		return nil, fmt.Errorf("batched document has no document")
+	}
+	return &parsed, nil
+}
+
+// Verify checks that the proof binds userData and nonce to root, and returns
+// the user data.
+func (p *Proof) Verify(nonce []byte, root []byte) ([]byte, error) {
+	userData, err := hex.DecodeString(p.UserData)
+	if err != nil {
+		return nil, fmt.Errorf("invalid user data: %w", err)
+	}
+	path := make([][]byte, len(p.Path))
+	for i, hash := range p.Path {
+		path[i], err = hex.DecodeString(hash)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proof hash %d: %w", i, err)
+		}
+	}
+	if !VerifyProof(Leaf(userData, nonce), p.Index, p.Size, path, root) {
+		return nil, fmt.Errorf("inclusion proof does not bind the user data and nonce to the attested root")
+	}
+	return userData, nil
+}
@@ -0,0 +1,77 @@
+package batch
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+// rfc6962Root is the Merkle tree hash as defined in RFC 6962, section 2.1.
+func rfc6962Root(leaves [][]byte) []byte {
+	if len(leaves) == 1 {
+		return leaves[0]
+	}
+	k := 1
+	for k*2 < len(leaves) {
+		k *= 2
+	}
+	return node(rfc6962Root(leaves[:k]), rfc6962Root(leaves[k:]))
+}
+
+func TestTree(t *testing.T) {
+	for size := 1; size <= 33; size++ {
+		leaves := make([][]byte, size)
+		for i := range leaves {
+			leaves[i] = Leaf(fmt.Appendf(nil, "user data %d", i), []byte("nonce"))
+		}
+		tree, err := NewTree(leaves)
+		if err != nil {
+			t.Fatalf("size %d: failed to build tree: %v", size, err)
+		}
+		if !bytes.Equal(tree.Root(), rfc6962Root(leaves)) {
+			t.Fatalf("size %d: root differs from RFC 6962", size)
+		}
+
+		for index := range leaves {
+			proof := tree.Proof(index)
+			if !VerifyProof(leaves[index], uint64(index), uint64(size), proof, tree.Root()) {
+				t.Fatalf("size %d: proof of leaf %d does not verify", size, index)
+			}
+			other := (index + 1) % size
+			if size > 1 && VerifyProof(leaves[other], uint64(index), uint64(size), proof, tree.Root()) {
+				t.Fatalf("size %d: proof of leaf %d verifies leaf %d", size, index, other)
+			}
+			if VerifyProof(leaves[index], uint64(size), uint64(size), proof, tree.Root()) {
+				t.Fatalf("size %d: proof verifies for an index outside the tree", size)
+			}
+		}
+	}
+
+	if _, err := NewTree(nil); err == nil {
+		t.Error("empty tree built without error")
+	}
+}
+
+func TestLeafEncoding(t *testing.T) {
+	if bytes.Equal(Leaf([]byte("ab"), []byte("c")), Leaf([]byte("a"), []byte("bc"))) {
+		t.Error("leaves of different pairs collide")
+	}
+}
+
+func TestDocument(t *testing.T) {
+	userData := [][]byte{[]byte("a"), []byte("b"), []byte("c")}
+	leaves := make([][]byte, len(userData))
+	for i := range userData {
+		leaves[i] = Leaf(userData[i], []byte("nonce"))
+	}
+	tree, _ := NewTree(leaves)
+	doc := NewDocument([]byte("quote"), tree, 2, userData[2])
+
+	got, err := doc.Batch.Verify([]byte("nonce"), tree.Root())
+	if err != nil || !bytes.Equal(got, userData[2]) {
+		t.Fatalf("Verify = %q, %v; want %q", got, err, userData[2])
+	}
+	if _, err := doc.Batch.Verify([]byte("other nonce"), tree.Root()); err == nil {
+		t.Error("proof verified with a different nonce")
+	}
+}
@@ -0,0 +1,393 @@
+// Package client is a Go client for the tdxs socket transport.
+package client
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Hyodar/tdxs/pkg/api"
+	sockettransport "github.com/Hyodar/tdxs/pkg/transport/socket"
+)
+
+const (
+	defaultMaxIdleConns = 2
+	defaultDialTimeout  = 5 * time.Second
+)
+
+type ClientConfig struct {
+	SocketPath string `yaml:"socket_path"`
+	// Profile is sent with every request; empty uses the daemon's default.
+	Profile string `yaml:"profile"`
+	// MaxIdleConns is the number of connections kept open between calls.
+	MaxIdleConns int           `yaml:"max_idle_conns"`
+	DialTimeout  time.Duration `yaml:"dial_timeout"`
+}
+
+// Client sends requests to a tdxs daemon. It is safe for concurrent use;
+// each call in flight uses its own connection, and connections are reused
+// across calls.
+type Client struct {
+	cfg     ClientConfig
+	profile string
+	pool    *pool
+}
+
+type ValidateResult struct {
+	Valid        bool
+	Verdict      api.Verdict
+	UserData     []byte
+	FailedChecks []api.FailedCheck
+	// Claims are validator specific, e.g. eventlog.Claims for documents
+	// with attached event logs.
+	Claims json.RawMessage
+}
+
+type MetadataResult struct {
+	IssuerType string
+	UserData   []byte
+	Nonce      []byte
+	// Metadata is issuer specific, e.g. azure.TDXMetadata for Azure issuers.
+	Metadata json.RawMessage
+	// Document is the attestation the metadata was read from, if requested
+	// with MetadataOptions.IncludeDocument.
+	Document []byte
+}
+
+// MetadataOptions binds the attestation the metadata is read from to the
+// caller's user data and nonce, so a remote party can check its freshness.
+// If both are empty, the daemon uses fixed values.
+type MetadataOptions struct {
+	UserData        []byte
+	Nonce           []byte
+	IncludeDocument bool
+}
+
+type ExtendResult struct {
+	RTMR int
+	// Value is the RTMR value after the extension.
+	Value    []byte
+	Sequence uint64
+}
+
+func NewClient(cfg *ClientConfig) (*Client, error) {
+	if cfg.SocketPath == "" {
+		return nil, fmt.Errorf("socket_path is required")
+	}
+
+	c := &Client{
+		cfg:     *cfg,
+		profile: cfg.Profile,
+		pool:    &pool{},
+	}
+	if c.cfg.MaxIdleConns <= 0 {
+		c.cfg.MaxIdleConns = defaultMaxIdleConns
+	}
+	if c.cfg.DialTimeout <= 0 {
+		c.cfg.DialTimeout = defaultDialTimeout
+	}
+	return c, nil
+}
+
+// WithProfile returns a client that sends requests to the given profile. It
+// shares connections with c.
+func (c *Client) WithProfile(profile string) *Client {
+	clone := *c
+	clone.profile = profile
+	return &clone
+}
+
+// Close closes idle connections. Calls in flight finish, but their
+// connections are not reused.
+func (c *Client) Close() error {
+	return c.pool.close()
+}
+
+func (c *Client) Issue(ctx context.Context, userData []byte, nonce []byte) ([]byte, error) {
+	var resp sockettransport.SocketTransportIssueResponse
+	err := c.call(ctx, sockettransport.SocketTransportRequestMethodIssue, &sockettransport.SocketTransportIssueRequest{
+		UserData: hex.EncodeToString(userData),
+		Nonce:    hex.EncodeToString(nonce),
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkResponse(sockettransport.SocketTransportRequestMethodIssue, resp.Error, resp.Code, resp.Data == nil); err != nil {
+		return nil, err
+	}
+
+	document, err := hex.DecodeString(resp.Data.Document)
+	if err != nil {
+		return nil, decodeError(sockettransport.SocketTransportRequestMethodIssue, "document", err)
+	}
+	return document, nil
+}
+
+// Validate checks document against the daemon's reference values. A document
+// that fails validation is reported with Valid set to false and the failed
+// checks, not an error; errors mean the document could not be evaluated.
+func (c *Client) Validate(ctx context.Context, document []byte, nonce []byte) (*ValidateResult, error) {
+	var resp struct {
+		Data *struct {
+			sockettransport.SocketTransportValidateResponseData
+			Claims json.RawMessage `json:"claims"`
+		} `json:"data"`
+		Error *string       `json:"error"`
+		Code  api.ErrorCode `json:"code"`
+	}
+	err := c.call(ctx, sockettransport.SocketTransportRequestMethodValidate, &sockettransport.SocketTransportValidateRequest{
+		Document: hex.EncodeToString(document),
+		Nonce:    hex.EncodeToString(nonce),
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkResponse(sockettransport.SocketTransportRequestMethodValidate, resp.Error, resp.Code, resp.Data == nil); err != nil {
+		return nil, err
+	}
+
+	userData, err := hex.DecodeString(resp.Data.UserData)
+	if err != nil {
+		return nil, decodeError(sockettransport.SocketTransportRequestMethodValidate, "user data", err)
+	}
+	result := &ValidateResult{
+		Valid:    resp.Data.Valid,
+		Verdict:  resp.Data.Verdict,
+		UserData: userData,
+		Claims:   resp.Data.Claims,
+	}
+	for _, check := range resp.Data.FailedChecks {
+		result.FailedChecks = append(result.FailedChecks, api.FailedCheck{
+			Check:  check.Check,
+			Code:   check.Code,
+			Reason: check.Reason,
+		})
+	}
+	return result, nil
+}
+
+func (c *Client) Metadata(ctx context.Context) (*MetadataResult, error) {
+	return c.MetadataWithOptions(ctx, &MetadataOptions{})
+}
+
+func (c *Client) MetadataWithOptions(ctx context.Context, opts *MetadataOptions) (*MetadataResult, error) {
+	var resp struct {
+		Data *struct {
+			IssuerType string          `json:"issuerType"`
+			UserData   string          `json:"userData"`
+			Nonce      string          `json:"nonce"`
+			Metadata   json.RawMessage `json:"metadata"`
+			Document   string          `json:"document"`
+		} `json:"data"`
+		Error *string       `json:"error"`
+		Code  api.ErrorCode `json:"code"`
+	}
+	err := c.call(ctx, sockettransport.SocketTransportRequestMethodMetadata, &sockettransport.SocketTransportMetadataRequest{
+		UserData:        hex.EncodeToString(opts.UserData),
+		Nonce:           hex.EncodeToString(opts.Nonce),
+		IncludeDocument: opts.IncludeDocument,
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkResponse(sockettransport.SocketTransportRequestMethodMetadata, resp.Error, resp.Code, resp.Data == nil); err != nil {
+		return nil, err
+	}
+
+	userData, err := hex.DecodeString(resp.Data.UserData)
+	if err != nil {
+		return nil, decodeError(sockettransport.SocketTransportRequestMethodMetadata, "user data", err)
+	}
+	nonce, err := hex.DecodeString(resp.Data.Nonce)
+	if err != nil {
+		return nil, decodeError(sockettransport.SocketTransportRequestMethodMetadata, "nonce", err)
+	}
+	document, err := hex.DecodeString(resp.Data.Document)
+	if err != nil {
+		return nil, decodeError(sockettransport.SocketTransportRequestMethodMetadata, "document", err)
+	}
+	return &MetadataResult{
+		IssuerType: resp.Data.IssuerType,
+		UserData:   userData,
+		Nonce:      nonce,
+		Metadata:   resp.Data.Metadata,
+		Document:   document,
+	}, nil
+}
+
+// Extend extends an RTMR with a SHA-384 digest. The daemon only accepts
+// extensions from callers allowed by its rtmr config.
+func (c *Client) Extend(ctx context.Context, rtmr int, digest []byte, description string) (*ExtendResult, error) {
+	var resp sockettransport.SocketTransportExtendResponse
+	err := c.call(ctx, sockettransport.SocketTransportRequestMethodExtend, &sockettransport.SocketTransportExtendRequest{
+		RTMR:        rtmr,
+		Digest:      hex.EncodeToString(digest),
+		Description: description,
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkResponse(sockettransport.SocketTransportRequestMethodExtend, resp.Error, resp.Code, resp.Data == nil); err != nil {
+		return nil, err
+	}
+
+	value, err := hex.DecodeString(resp.Data.Value)
+	if err != nil {
+		return nil, decodeError(sockettransport.SocketTransportRequestMethodExtend, "value", err)
+	}
+	return &ExtendResult{RTMR: resp.Data.RTMR, Value: value, Sequence: resp.Data.Sequence}, nil
+}
+
+func checkResponse(method sockettransport.SocketTransportRequestMethod, errMsg *string, code api.ErrorCode, noData bool) error {
+	if errMsg != nil {
+		return &ServerError{Method: string(method), Code: code, Message: *errMsg}
+	}
+	if noData {
+		return &TransportError{Op: string(method), Err: fmt.Errorf("empty response")}
+	}
+	return nil
+}
+
+func decodeError(method sockettransport.SocketTransportRequestMethod, field string, err error) error {
+	return &TransportError{Op: string(method), Err: fmt.Errorf("failed to decode %s: %w", field, err)}
+}
+
+// call sends one request and decodes the response into resp. A connection
+// taken from the pool may have been closed by the daemon in the meantime
+// (e.g. on restart), so a failure on one is retried once on a new
+// connection. The other idle connections are likely stale as well, so they
+// are dropped. Extensions are not idempotent, so they always use a new
+// connection and are never retried.
+func (c *Client) call(ctx context.Context, method sockettransport.SocketTransportRequestMethod, data any, resp any) error {
+	rawData, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+	req := &sockettransport.SocketTransportRequest{
+		Method:  method,
+		Profile: c.profile,
+		Data:    rawData,
+	}
+
+	retry := method != sockettransport.SocketTransportRequestMethodExtend
+	for attempt := 0; ; attempt++ {
+		cn, err := c.pool.get(ctx, c.cfg.SocketPath, c.cfg.DialTimeout, retry && attempt == 0)
+		if err != nil {
+			if err == ErrClosed {
+				return err
+			}
+			return &TransportError{Op: string(method), Err: err}
+		}
+
+		err = cn.roundTrip(ctx, req, resp)
+		if err == nil {
+			c.pool.put(cn, c.cfg.MaxIdleConns)
+			return nil
+		}
+		cn.Close()
+
+		if ctx.Err() != nil {
+			return &TransportError{Op: string(method), Err: ctx.Err()}
+		}
+		if !retry || !cn.reused || attempt > 0 {
+			return &TransportError{Op: string(method), Err: err}
+		}
+		c.pool.closeIdle()
+	}
+}
+
+type conn struct {
+	net.Conn
+	encoder *json.Encoder
+	decoder *json.Decoder
+	reused  bool
+}
+
+func (cn *conn) roundTrip(ctx context.Context, req any, resp any) error {
+	deadline, _ := ctx.Deadline()
+	if err := cn.SetDeadline(deadline); err != nil {
+		return err
+	}
+	// Unblock reads and writes as soon as ctx is cancelled.
+	stop := context.AfterFunc(ctx, func() {
+		cn.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	if err := cn.encoder.Encode(req); err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	if err := cn.decoder.Decode(resp); err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	if !stop() {
+		// The deadline was moved by cancellation; the connection may be
+		// mid-response and cannot be reused.
+		return ctx.Err()
+	}
+	return cn.SetDeadline(time.Time{})
+}
+
+type pool struct {
+	mu     sync.Mutex
+	idle   []*conn
+	closed bool
+}
+
+func (p *pool) get(ctx context.Context, socketPath string, dialTimeout time.Duration, allowIdle bool) (*conn, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, ErrClosed
+	}
+	if n := len(p.idle); allowIdle && n > 0 {
+		cn := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+		cn.reused = true
+		return cn, nil
+	}
+	p.mu.Unlock()
+
+	dialer := net.Dialer{Timeout: dialTimeout}
+	netConn, err := dialer.DialContext(ctx, "unix", socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", socketPath, err)
+	}
+	return &conn{
+		Conn:    netConn,
+		encoder: json.NewEncoder(netConn),
+		decoder: json.NewDecoder(netConn),
+	}, nil
+}
+
+func (p *pool) put(cn *conn, maxIdle int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed || len(p.idle) >= maxIdle {
+		cn.Close()
+		return
+	}
+	p.idle = append(p.idle, cn)
+}
+
+func (p *pool) closeIdle() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, cn := range p.idle {
+		cn.Close()
+	}
+	p.idle = nil
+}
+
+func (p *pool) close() error {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+	p.closeIdle()
+	return nil
+}
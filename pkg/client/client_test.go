@@ -0,0 +1,241 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Hyodar/tdxs/pkg/api"
+)
+
+// fakeDaemon answers each request line with the line returned by handle. An
+// empty answer closes the connection without responding, as a daemon that
+// restarted would.
+type fakeDaemon struct {
+	socket string
+	handle func(request string) string
+
+	mu          sync.Mutex
+	connections int
+	requests    int
+}
+
+func startFakeDaemon(t *testing.T, handle func(request string) string) *fakeDaemon {
+	t.Helper()
+
+	d := &fakeDaemon{socket: filepath.Join(t.TempDir(), "tdxs.sock"), handle: handle}
+	listener, err := net.Listen("unix", d.socket)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			d.mu.Lock()
+			d.connections++
+			d.mu.Unlock()
+			go d.serve(conn)
+		}
+	}()
+	return d
+}
+
+func (d *fakeDaemon) serve(conn net.Conn) {
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		d.mu.Lock()
+		d.requests++
+		d.mu.Unlock()
+		answer := d.handle(scanner.Text())
+		if answer == "" {
+			return
+		}
+		if _, err := conn.Write([]byte(answer + "\n")); err != nil {
+			return
+		}
+	}
+}
+
+func (d *fakeDaemon) counts() (connections, requests int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.connections, d.requests
+}
+
+func newTestClient(t *testing.T, socket string) *Client {
+	t.Helper()
+	c, err := NewClient(&ClientConfig{SocketPath: socket, DialTimeout: time.Second})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+const issueAnswer = `{"data":{"document":"00ff"},"error":null}`
+
+func TestStaleConnection(t *testing.T) {
+	// The first connection answers once and is then closed, as by a daemon
+	// restart; later connections keep answering.
+	var mu sync.Mutex
+	answered := 0
+	d := startFakeDaemon(t, func(string) string {
+		mu.Lock()
+		defer mu.Unlock()
+		answered++
+		if answered == 2 {
+			return ""
+		}
+		return issueAnswer
+	})
+	c := newTestClient(t, d.socket)
+	ctx := context.Background()
+
+	for i := range 2 {
+		if _, err := c.Issue(ctx, nil, nil); err != nil {
+			t.Fatalf("issue %d failed: %v", i, err)
+		}
+	}
+	if connections, requests := d.counts(); connections != 2 || requests != 3 {
+		t.Errorf("daemon saw %d connections and %d requests, want the stale connection retried once on a new one", connections, requests)
+	}
+}
+
+func TestNoRetryOnNewConnection(t *testing.T) {
+	d := startFakeDaemon(t, func(string) string { return "" })
+	c := newTestClient(t, d.socket)
+
+	_, err := c.Issue(context.Background(), nil, nil)
+	var transportErr *TransportError
+	if !errors.As(err, &transportErr) {
+		t.Fatalf("error = %v, want a TransportError", err)
+	}
+	if connections, requests := d.counts(); connections != 1 || requests != 1 {
+		t.Errorf("daemon saw %d connections and %d requests, want no retry", connections, requests)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		answer    string
+		code      api.ErrorCode // set for server errors
+		retryable bool
+	}{
+		{
+			name:      "BackendUnavailable",
+			answer:    `{"data":null,"error":"quote provider unavailable","code":"backend_unavailable"}`,
+			code:      api.ErrorCodeBackendUnavailable,
+			retryable: true,
+		},
+		{
+			name:      "Timeout",
+			answer:    `{"data":null,"error":"deadline exceeded","code":"timeout"}`,
+			code:      api.ErrorCodeTimeout,
+			retryable: true,
+		},
+		{
+			name:   "BadRequest",
+			answer: `{"data":null,"error":"unknown profile: staging","code":"bad_request"}`,
+			code:   api.ErrorCodeBadRequest,
+		},
+		{
+			name:   "NoCode",
+			answer: `{"data":null,"error":"failed"}`,
+		},
+		{name: "EmptyResponse", answer: `{"data":null,"error":null}`},
+		{name: "InvalidDocument", answer: `{"data":{"document":"not hex"},"error":null}`},
+		{name: "InvalidJSON", answer: `{"data":}`},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			d := startFakeDaemon(t, func(string) string { return tt.answer })
+			_, err := newTestClient(t, d.socket).Issue(context.Background(), nil, nil)
+
+			var serverErr *ServerError
+			var transportErr *TransportError
+			switch {
+			case tt.code != "" || tt.name == "NoCode":
+				if !errors.As(err, &serverErr) || serverErr.Code != tt.code || serverErr.Method != "issue" {
+					t.Fatalf("error = %v, want a ServerError with code %q", err, tt.code)
+				}
+				if serverErr.Retryable() != tt.retryable {
+					t.Errorf("Retryable() = %t, want %t", serverErr.Retryable(), tt.retryable)
+				}
+			case !errors.As(err, &transportErr):
+				t.Errorf("error = %v, want a TransportError", err)
+			}
+		})
+	}
+
+	t.Run("NoDaemon", func(t *testing.T) {
+		_, err := newTestClient(t, filepath.Join(t.TempDir(), "missing.sock")).Issue(context.Background(), nil, nil)
+		var transportErr *TransportError
+		if !errors.As(err, &transportErr) {
+			t.Errorf("error = %v, want a TransportError", err)
+		}
+	})
+
+	t.Run("Cancelled", func(t *testing.T) {
+		block := make(chan struct{})
+		t.Cleanup(func() { close(block) })
+		d := startFakeDaemon(t, func(string) string {
+			<-block
+			return issueAnswer
+		})
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+		_, err := newTestClient(t, d.socket).Issue(ctx, nil, nil)
+		var transportErr *TransportError
+		if !errors.As(err, &transportErr) || !errors.Is(err, context.Canceled) {
+			t.Errorf("error = %v, want a TransportError for the cancellation", err)
+		}
+	})
+
+	t.Run("Closed", func(t *testing.T) {
+		d := startFakeDaemon(t, func(string) string { return issueAnswer })
+		c := newTestClient(t, d.socket)
+		c.Close()
+		if _, err := c.Issue(context.Background(), nil, nil); !errors.Is(err, ErrClosed) {
+			t.Errorf("error = %v, want %v", err, ErrClosed)
+		}
+	})
+}
+
+func TestExtendNotRetried(t *testing.T) {
+	// The daemon drops connections on extensions, as it would if it crashed
+	// after extending.
+	d := startFakeDaemon(t, func(request string) string {
+		if strings.Contains(request, `"method":"extend"`) {
+			return ""
+		}
+		return issueAnswer
+	})
+	c := newTestClient(t, d.socket)
+	ctx := context.Background()
+
+	if _, err := c.Issue(ctx, nil, nil); err != nil {
+		t.Fatalf("issue failed: %v", err)
+	}
+	_, err := c.Extend(ctx, 3, make([]byte, 48), "")
+	var transportErr *TransportError
+	if !errors.As(err, &transportErr) {
+		t.Fatalf("error = %v, want a TransportError", err)
+	}
+	// The extension used a new connection instead of the idle one, and was
+	// sent only once.
+	if connections, requests := d.counts(); connections != 2 || requests != 2 {
+		t.Errorf("daemon saw %d connections and %d requests, want the extension sent once on a new connection", connections, requests)
+	}
+}
@@ -0,0 +1,47 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Hyodar/tdxs/pkg/api"
+)
+
+// ErrClosed is returned for calls made after Close.
+var ErrClosed = errors.New("client is closed")
+
+// TransportError means the request did not get an answer from the daemon:
+// the socket could not be reached, the connection broke, the context ended
+// or the response could not be decoded. The request may be retried.
+type TransportError struct {
+	Op  string
+	Err error
+}
+
+func (e *TransportError) Error() string {
+	return fmt.Sprintf("tdxs transport error: %s: %v", e.Op, e.Err)
+}
+
+func (e *TransportError) Unwrap() error {
+	return e.Err
+}
+
+// ServerError means the daemon answered, but with an error from the issuer,
+// the validator or its own request handling. Code tells them apart.
+type ServerError struct {
+	Method  string
+	Code    api.ErrorCode
+	Message string
+}
+
+func (e *ServerError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("tdxs %s failed: %s", e.Method, e.Message)
+	}
+	return fmt.Sprintf("tdxs %s failed (%s): %s", e.Method, e.Code, e.Message)
+}
+
+// Retryable reports whether the same request may succeed if sent again.
+func (e *ServerError) Retryable() bool {
+	return e.Code.Retryable()
+}
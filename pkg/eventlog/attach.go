@@ -0,0 +1,232 @@
+package eventlog
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/google/go-tdx-guest/abi"
+	"gopkg.in/yaml.v3"
+
+	"github.com/Hyodar/tdxs/pkg/rtmr"
+	"github.com/Hyodar/tdxs/pkg/schema"
+)
+
+// Hex is a byte string written in JSON as hex, and in YAML as hex with or
+// without a 0x prefix.
+type Hex []byte
+
+func (h Hex) MarshalJSON() ([]byte, error) {
+	return json.Marshal(hex.EncodeToString(h))
+}
+
+func (h *Hex) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	decoded, err := hex.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	*h = decoded
+	return nil
+}
+
+func (h *Hex) UnmarshalYAML(node *yaml.Node) error {
+	var s string
+	if err := node.Decode(&s); err != nil {
+		return err
+	}
+	decoded, err := hex.DecodeString(strings.TrimPrefix(s, "0x"))
+	if err != nil {
+		return fmt.Errorf("invalid hex value %q: %w", s, err)
+	}
+	*h = decoded
+	return nil
+}
+
+func (h Hex) MarshalYAML() (any, error) {
+	return "0x" + hex.EncodeToString(h), nil
+}
+
+func (Hex) JSONSchema() schema.Schema {
+	return schema.Schema{"type": "string", "pattern": "^(0x)?([0-9a-fA-F]{2})*$"}
+}
+
+// RuntimeEvent is an RTMR extension made through tdxs, as attached to
+// documents.
+type RuntimeEvent struct {
+	Seq         uint64 `json:"seq"`
+	RTMR        int    `json:"rtmr"`
+	Digest      Hex    `json:"digest"`
+	Description string `json:"description,omitempty"`
+}
+
+// Attachment holds the event logs an issuer attaches to a document.
+type Attachment struct {
+	CCEL    Hex            `json:"ccel,omitempty"`
+	Runtime []RuntimeEvent `json:"runtime,omitempty"`
+}
+
+// AttachConfig selects the event logs an issuer attaches to its documents.
+type AttachConfig struct {
+	// CCEL attaches the firmware event log.
+	CCEL     bool   `yaml:"ccel"`
+	CCELPath string `yaml:"ccel_path"`
+	// RuntimeLog is the event_log file of the daemon's rtmr config.
+	RuntimeLog string `yaml:"runtime_log"`
+}
+
+func (c *AttachConfig) Enabled() bool {
+	return c.CCEL || c.RuntimeLog != ""
+}
+
+func (c *AttachConfig) Validate() error {
+	if c.CCELPath != "" && !c.CCEL {
+		return fmt.Errorf("ccel_path requires ccel: true")
+	}
+	return nil
+}
+
+// Collect reads the configured event logs. rtmrs, if not nil, are the RTMRs
+// of the quote the logs go with: runtime events extended after the quote was
+// taken are dropped, so the logs replay to the quoted values.
+func (c *AttachConfig) Collect(rtmrs [][]byte) (*Attachment, error) {
+	attachment := &Attachment{}
+	if c.CCEL {
+		path := c.CCELPath
+		if path == "" {
+			path = DefaultCCELPath
+		}
+		ccel, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CCEL: %w", err)
+		}
+		attachment.CCEL = ccel
+	}
+	if c.RuntimeLog != "" {
+		events, err := rtmr.ReadEventLog(c.RuntimeLog)
+		if err != nil {
+			return nil, err
+		}
+		for _, event := range events {
+			if event.RTMR < 0 || event.RTMR >= rtmr.Count {
+				return nil, fmt.Errorf("runtime event %d: invalid RTMR %d", event.Seq, event.RTMR)
+			}
+			digest, err := hex.DecodeString(event.Digest)
+			if err != nil {
+				return nil, fmt.Errorf("runtime event %d: invalid digest: %w", event.Seq, err)
+			}
+			attachment.Runtime = append(attachment.Runtime, RuntimeEvent{
+				Seq:         event.Seq,
+				RTMR:        event.RTMR,
+				Digest:      digest,
+				Description: event.Description,
+			})
+		}
+	}
+	if rtmrs != nil && len(attachment.Runtime) > 0 {
+		if err := attachment.trim(rtmrs); err != nil {
+			return nil, err
+		}
+	}
+	return attachment, nil
+}
+
+// trim drops trailing runtime events until the logs replay to rtmrs. If no
+// prefix does, the events are left alone for the validator to reject.
+func (a *Attachment) trim(rtmrs [][]byte) error {
+	events, err := a.firmwareEvents()
+	if err != nil {
+		return err
+	}
+	values, _ := Replay(events, nil)
+
+	var touched [rtmr.Count]bool
+	for _, event := range a.Runtime {
+		touched[event.RTMR] = true
+	}
+	matches := func() bool {
+		for i, t := range touched {
+			if t && (i >= len(rtmrs) || !bytes.Equal(values[i], rtmrs[i])) {
+				return false
+			}
+		}
+		return true
+	}
+
+	keep := -1
+	for k := 0; ; k++ {
+		if matches() {
+			keep = k
+		}
+		if k == len(a.Runtime) {
+			break
+		}
+		event := a.Runtime[k]
+		values[event.RTMR] = rtmr.Extended(values[event.RTMR], event.Digest)
+	}
+	if keep >= 0 {
+		a.Runtime = a.Runtime[:keep]
+	}
+	return nil
+}
+
+func (a *Attachment) firmwareEvents() ([]Event, error) {
+	if len(a.CCEL) == 0 {
+		return nil, nil
+	}
+	events, err := ParseCCEL(a.CCEL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CCEL: %w", err)
+	}
+	return events, nil
+}
+
+// Verify parses the attached logs, replays them and compares every RTMR an
+// event extended with its quoted value. Each problem found is returned; the
+// claims are only meaningful if there are none.
+func (a *Attachment) Verify(rtmrs [][]byte) (*Claims, []error) {
+	events, err := a.firmwareEvents()
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	var errs []error
+	var seq uint64
+	for _, event := range a.Runtime {
+		if event.RTMR < 0 || event.RTMR >= rtmr.Count {
+			errs = append(errs, fmt.Errorf("runtime event %d: invalid RTMR %d", event.Seq, event.RTMR))
+		} else if len(event.Digest) != abi.RtmrSize {
+			errs = append(errs, fmt.Errorf("runtime event %d: digest must be %d bytes", event.Seq, abi.RtmrSize))
+		} else if event.Seq <= seq {
+			errs = append(errs, fmt.Errorf("runtime event %d: out of order", event.Seq))
+		}
+		seq = event.Seq
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	values, covered := Replay(events, a.Runtime)
+	for i := range rtmr.Count {
+		if !covered[i] {
+			continue
+		}
+		if i >= len(rtmrs) || !bytes.Equal(values[i], rtmrs[i]) {
+			var quoted []byte
+			if i < len(rtmrs) {
+				quoted = rtmrs[i]
+			}
+			errs = append(errs, fmt.Errorf("RTMR%d replays to %x, quote has %x", i, values[i], quoted))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return NewClaims(events, a.Runtime), nil
+}
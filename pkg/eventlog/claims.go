@@ -0,0 +1,188 @@
+package eventlog
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+)
+
+// Claims describe what the event logs say was measured. Claims derived from
+// event data are only made when the data hashes to the logged digest, so
+// they are as trustworthy as the RTMRs the log replays to. Descriptions of
+// runtime events are informational.
+type Claims struct {
+	Events        []EventClaim        `json:"events,omitempty"`
+	UEFIVariables []UEFIVariableClaim `json:"uefiVariables,omitempty"`
+	Images        []ImageClaim        `json:"images,omitempty"`
+	KernelCmdline string              `json:"kernelCmdline,omitempty"`
+	Runtime       []RuntimeEvent      `json:"runtime,omitempty"`
+}
+
+type EventClaim struct {
+	RTMR   int    `json:"rtmr"`
+	Type   string `json:"type"`
+	Digest string `json:"digest"`
+	// DataVerified is set when the event data hashes to Digest.
+	DataVerified bool `json:"dataVerified"`
+}
+
+type UEFIVariableClaim struct {
+	RTMR int    `json:"rtmr"`
+	GUID string `json:"guid"`
+	Name string `json:"name"`
+	// Value is the variable data, hex encoded, for variables of at most
+	// maxVariableValue bytes such as SecureBoot. Larger variables (key
+	// databases) are identified by Digest.
+	Value  string `json:"value,omitempty"`
+	Digest string `json:"digest"`
+}
+
+// ImageClaim is an EFI application loaded by the firmware, identified by its
+// Authenticode digest.
+type ImageClaim struct {
+	RTMR   int    `json:"rtmr"`
+	Digest string `json:"digest"`
+}
+
+const maxVariableValue = 32
+
+// kernelCmdlinePrefixes mark EV_IPL events in which GRUB measures the kernel
+// command line, in the current and in the older Fedora style.
+var kernelCmdlinePrefixes = []string{"kernel_cmdline: ", "grub_kernel_cmdline "}
+
+// NewClaims derives claims from firmware and runtime events.
+func NewClaims(events []Event, runtime []RuntimeEvent) *Claims {
+	claims := &Claims{Runtime: runtime}
+	for _, event := range events {
+		index, ok := event.RTMR()
+		if !ok {
+			continue
+		}
+		verified := hashes(event.Data, event.Digest)
+		claims.Events = append(claims.Events, EventClaim{
+			RTMR:         index,
+			Type:         EventTypeName(event.Type),
+			Digest:       hex.EncodeToString(event.Digest),
+			DataVerified: verified,
+		})
+
+		switch event.Type {
+		case EventTypeEFIVariableDriverConfig, EventTypeEFIVariableBoot, EventTypeEFIVariableBoot2, EventTypeEFIVariableAuthority:
+			variable, ok := verifiedVariable(event)
+			if !ok {
+				continue
+			}
+			claim := UEFIVariableClaim{
+				RTMR:   index,
+				GUID:   variable.guid,
+				Name:   variable.name,
+				Digest: hex.EncodeToString(event.Digest),
+			}
+			if len(variable.data) <= maxVariableValue {
+				claim.Value = hex.EncodeToString(variable.data)
+			}
+			claims.UEFIVariables = append(claims.UEFIVariables, claim)
+		case EventTypeEFIBootServicesApplication:
+			claims.Images = append(claims.Images, ImageClaim{RTMR: index, Digest: hex.EncodeToString(event.Digest)})
+		case EventTypeIPL:
+			if cmdline, ok := kernelCmdline(event); ok {
+				claims.KernelCmdline = cmdline
+			}
+		}
+	}
+	return claims
+}
+
+// verifiedVariable decodes the UEFI variable of an event if the event digest
+// covers it. Firmware hashes either the whole UEFI_VARIABLE_DATA or, for boot
+// variables, only the variable data.
+func verifiedVariable(event Event) (*uefiVariable, bool) {
+	variable, err := parseUEFIVariable(event.Data)
+	if err != nil {
+		return nil, false
+	}
+	if !hashes(event.Data, event.Digest) && !hashes(variable.data, event.Digest) {
+		return nil, false
+	}
+	return variable, true
+}
+
+// kernelCmdline returns the command line of a GRUB EV_IPL event. GRUB
+// measures the command line without the prefix and, depending on the
+// version, without the NUL terminator.
+func kernelCmdline(event Event) (string, bool) {
+	return grubText(event, kernelCmdlinePrefixes)
+}
+
+// grubText returns the text of a GRUB EV_IPL event starting with one of
+// prefixes, if the digest covers it.
+func grubText(event Event, prefixes []string) (string, bool) {
+	for _, prefix := range prefixes {
+		rest, ok := bytes.CutPrefix(event.Data, []byte(prefix))
+		if !ok {
+			continue
+		}
+		text := bytes.TrimSuffix(rest, []byte{0})
+		if hashes(rest, event.Digest) || hashes(text, event.Digest) {
+			return string(text), true
+		}
+	}
+	return "", false
+}
+
+// hashes reports whether data hashes to digest, with SHA-256 or SHA-384
+// depending on the digest size.
+func hashes(data []byte, digest []byte) bool {
+	if len(digest) == sha256.Size {
+		sum := sha256.Sum256(data)
+		return bytes.Equal(sum[:], digest)
+	}
+	sum := sha512.Sum384(data)
+	return bytes.Equal(sum[:], digest)
+}
+
+type uefiVariable struct {
+	guid string
+	name string
+	data []byte
+}
+
+// parseUEFIVariable decodes UEFI_VARIABLE_DATA: the vendor GUID, the name and
+// data lengths, the UTF-16 name and the data.
+func parseUEFIVariable(raw []byte) (*uefiVariable, error) {
+	r := &reader{data: raw}
+	guid := r.bytes(16)
+	nameLength := r.u64()
+	dataLength := r.u64()
+	if r.err != nil || nameLength > uint64(len(r.data))/2 || dataLength > uint64(len(r.data)) {
+		return nil, fmt.Errorf("truncated UEFI variable")
+	}
+	name := r.bytes(int(nameLength) * 2)
+	data := r.bytes(int(dataLength))
+	if r.err != nil {
+		return nil, fmt.Errorf("truncated UEFI variable")
+	}
+	return &uefiVariable{guid: formatGUID(guid), name: decodeUTF16(name), data: data}, nil
+}
+
+// formatGUID formats an EFI_GUID, whose first three fields are little-endian.
+func formatGUID(b []byte) string {
+	return fmt.Sprintf("%08x-%04x-%04x-%x-%x",
+		binary.LittleEndian.Uint32(b[0:4]),
+		binary.LittleEndian.Uint16(b[4:6]),
+		binary.LittleEndian.Uint16(b[6:8]),
+		b[8:10], b[10:16])
+}
+
+func decodeUTF16(b []byte) string {
+	units := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		units = append(units, binary.LittleEndian.Uint16(b[i:]))
+	}
+	return strings.TrimRight(string(utf16.Decode(units)), "\x00")
+}
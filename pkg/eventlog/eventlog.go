@@ -0,0 +1,294 @@
+// Package eventlog parses TCG event logs (the TDX firmware log, CCEL, and
+// the vTPM log), replays them to recompute RTMRs and PCRs, and derives claims
+// about what was measured.
+package eventlog
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/google/go-tdx-guest/abi"
+
+	"github.com/Hyodar/tdxs/pkg/rtmr"
+)
+
+// DefaultCCELPath is where Linux exposes the CCEL ACPI table data.
+const DefaultCCELPath = "/sys/firmware/acpi/tables/data/CCEL"
+
+// Event types of the TCG PC Client Platform Firmware Profile used by TDVF.
+const (
+	EventTypeNoAction                   uint32 = 0x00000003
+	EventTypeSeparator                  uint32 = 0x00000004
+	EventTypeEventTag                   uint32 = 0x00000006
+	EventTypeIPL                        uint32 = 0x0000000d
+	EventTypeEFIVariableDriverConfig    uint32 = 0x80000001
+	EventTypeEFIVariableBoot            uint32 = 0x80000002
+	EventTypeEFIBootServicesApplication uint32 = 0x80000003
+	EventTypeEFIBootServicesDriver      uint32 = 0x80000004
+	EventTypeEFIAction                  uint32 = 0x80000007
+	EventTypeEFIPlatformFirmwareBlob2   uint32 = 0x8000000a
+	EventTypeEFIHandoffTables2          uint32 = 0x8000000b
+	EventTypeEFIVariableBoot2           uint32 = 0x8000000c
+	EventTypeEFIVariableAuthority       uint32 = 0x800000e0
+	algSHA256                           uint16 = 0x000b
+	algSHA384                           uint16 = 0x000c
+	specIDSignature                            = "Spec ID Event03\x00"
+)
+
+var eventTypeNames = map[uint32]string{
+	EventTypeNoAction:                   "EV_NO_ACTION",
+	EventTypeSeparator:                  "EV_SEPARATOR",
+	EventTypeEventTag:                   "EV_EVENT_TAG",
+	EventTypeIPL:                        "EV_IPL",
+	EventTypeEFIVariableDriverConfig:    "EV_EFI_VARIABLE_DRIVER_CONFIG",
+	EventTypeEFIVariableBoot:            "EV_EFI_VARIABLE_BOOT",
+	EventTypeEFIBootServicesApplication: "EV_EFI_BOOT_SERVICES_APPLICATION",
+	EventTypeEFIBootServicesDriver:      "EV_EFI_BOOT_SERVICES_DRIVER",
+	EventTypeEFIAction:                  "EV_EFI_ACTION",
+	EventTypeEFIPlatformFirmwareBlob2:   "EV_EFI_PLATFORM_FIRMWARE_BLOB2",
+	EventTypeEFIHandoffTables2:          "EV_EFI_HANDOFF_TABLES2",
+	EventTypeEFIVariableBoot2:           "EV_EFI_VARIABLE_BOOT2",
+	EventTypeEFIVariableAuthority:       "EV_EFI_VARIABLE_AUTHORITY",
+}
+
+// EventTypeName returns the TCG name of an event type, or its hex value.
+func EventTypeName(eventType uint32) string {
+	if name, ok := eventTypeNames[eventType]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%08x", eventType)
+}
+
+// Event is one event log entry. In a CCEL, MRIndex 0 is MRTD and 1-4 are
+// RTMR0-3 and Digest is SHA-384; in a TPM log, MRIndex is the PCR and Digest
+// is SHA-256.
+type Event struct {
+	MRIndex uint32
+	Type    uint32
+	Digest  []byte
+	Data    []byte
+}
+
+// RTMR returns the RTMR the event was extended into, if any.
+func (e *Event) RTMR() (int, bool) {
+	if e.Type == EventTypeNoAction || e.MRIndex < 1 || e.MRIndex > rtmr.Count {
+		return 0, false
+	}
+	return int(e.MRIndex) - 1, true
+}
+
+// ParseCCEL decodes a crypto-agile CCEL: a TCG_PCR_EVENT header carrying the
+// Spec ID event, followed by TCG_PCR_EVENT2 entries. The ACPI table area is
+// larger than the log, so parsing stops at the first all-0xFF or empty
+// entry.
+func ParseCCEL(raw []byte) ([]Event, error) {
+	return parse(raw, algSHA384)
+}
+
+// ParseTPMLog decodes a crypto-agile TPM event log and keeps the SHA-256
+// digests.
+func ParseTPMLog(raw []byte) ([]Event, error) {
+	return parse(raw, algSHA256)
+}
+
+func parse(raw []byte, digestAlg uint16) ([]Event, error) {
+	r := &reader{data: raw}
+
+	// The header entry has a fixed SHA-1 sized digest.
+	r.u32() // MR index
+	if eventType := r.u32(); r.err == nil && eventType != EventTypeNoAction {
+		return nil, fmt.Errorf("first event has type %s, expected the Spec ID event", EventTypeName(eventType))
+	}
+	r.bytes(20)
+	spec := r.bytes(int(r.u32()))
+	if r.err != nil {
+		return nil, fmt.Errorf("truncated header event: %w", r.err)
+	}
+	digestSizes, err := parseSpecID(spec, digestAlg)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []Event
+	for len(r.data) >= 8 {
+		if end(r.data[:8]) {
+			break
+		}
+		index := r.u32()
+		eventType := r.u32()
+		count := r.u32()
+		if count == 0 {
+			break
+		}
+
+		var digest []byte
+		for range count {
+			alg := r.u16()
+			if r.err != nil {
+				break
+			}
+			size, ok := digestSizes[alg]
+			if !ok {
+				return nil, fmt.Errorf("event %d: digest algorithm 0x%04x not declared in the Spec ID event", len(events), alg)
+			}
+			value := r.bytes(int(size))
+			if alg == digestAlg {
+				digest = value
+			}
+		}
+		data := r.bytes(int(r.u32()))
+		if r.err != nil {
+			return nil, fmt.Errorf("event %d: %w", len(events), r.err)
+		}
+		if digest == nil {
+			return nil, fmt.Errorf("event %d has no %s digest", len(events), algName(digestAlg))
+		}
+		events = append(events, Event{MRIndex: index, Type: eventType, Digest: digest, Data: data})
+	}
+	return events, nil
+}
+
+func parseSpecID(spec []byte, digestAlg uint16) (map[uint16]uint16, error) {
+	r := &reader{data: spec}
+	if string(r.bytes(len(specIDSignature))) != specIDSignature {
+		return nil, fmt.Errorf("header event is not a Spec ID Event03 event")
+	}
+	r.bytes(8) // platform class, spec version, errata, uintn size
+	count := r.u32()
+	sizes := make(map[uint16]uint16)
+	for range count {
+		alg := r.u16()
+		sizes[alg] = r.u16()
+		if r.err != nil {
+			break
+		}
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("truncated Spec ID event: %w", r.err)
+	}
+	if want := algSizes[digestAlg]; sizes[digestAlg] != want {
+		return nil, fmt.Errorf("event log has no %s digests", algName(digestAlg))
+	}
+	return sizes, nil
+}
+
+var algSizes = map[uint16]uint16{
+	algSHA256: sha256.Size,
+	algSHA384: abi.RtmrSize,
+}
+
+func algName(alg uint16) string {
+	if alg == algSHA256 {
+		return "SHA-256"
+	}
+	return "SHA-384"
+}
+
+func end(header []byte) bool {
+	return bytes.Equal(header, bytes.Repeat([]byte{0xff}, 8)) || bytes.Equal(header, make([]byte, 8))
+}
+
+// MarshalCCEL encodes events as a CCEL with SHA-384 digests only.
+func MarshalCCEL(events []Event) []byte {
+	return marshal(events, algSHA384)
+}
+
+// MarshalTPMLog encodes events as a TPM event log with SHA-256 digests only.
+func MarshalTPMLog(events []Event) []byte {
+	return marshal(events, algSHA256)
+}
+
+func marshal(events []Event, digestAlg uint16) []byte {
+	var buf bytes.Buffer
+	le := func(v any) { binary.Write(&buf, binary.LittleEndian, v) }
+
+	var spec bytes.Buffer
+	spec.WriteString(specIDSignature)
+	binary.Write(&spec, binary.LittleEndian, struct {
+		PlatformClass uint32
+		Minor, Major  uint8
+		Errata        uint8
+		UintnSize     uint8
+		Count         uint32
+		Alg, Size     uint16
+		VendorInfo    uint8
+	}{0, 0, 2, 0, 2, 1, digestAlg, algSizes[digestAlg], 0})
+
+	le(uint32(0))
+	le(EventTypeNoAction)
+	buf.Write(make([]byte, 20))
+	le(uint32(spec.Len()))
+	buf.Write(spec.Bytes())
+
+	for _, event := range events {
+		le(event.MRIndex)
+		le(event.Type)
+		le(uint32(1))
+		le(digestAlg)
+		buf.Write(event.Digest)
+		le(uint32(len(event.Data)))
+		buf.Write(event.Data)
+	}
+	return buf.Bytes()
+}
+
+// Replay recomputes the RTMRs from zero with the firmware events followed by
+// the runtime events. covered reports which RTMRs any event extended.
+func Replay(events []Event, runtime []RuntimeEvent) (values [rtmr.Count][]byte, covered [rtmr.Count]bool) {
+	for i := range values {
+		values[i] = make([]byte, abi.RtmrSize)
+	}
+	for _, event := range events {
+		if index, ok := event.RTMR(); ok {
+			values[index] = rtmr.Extended(values[index], event.Digest)
+			covered[index] = true
+		}
+	}
+	for _, event := range runtime {
+		values[event.RTMR] = rtmr.Extended(values[event.RTMR], event.Digest)
+		covered[event.RTMR] = true
+	}
+	return values, covered
+}
+
+// reader decodes little-endian fields, remembering the first overrun.
+type reader struct {
+	data []byte
+	err  error
+}
+
+func (r *reader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(r.data) {
+		r.err = fmt.Errorf("need %d bytes, %d left", n, len(r.data))
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *reader) u16() uint16 {
+	if b := r.bytes(2); b != nil {
+		return binary.LittleEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *reader) u32() uint32 {
+	if b := r.bytes(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *reader) u64() uint64 {
+	if b := r.bytes(8); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+	return 0
+}
@@ -0,0 +1,184 @@
+package eventlog_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/Hyodar/tdxs/pkg/eventlog"
+)
+
+func TestParseCCEL(t *testing.T) {
+	digest := sha512.Sum384([]byte("kernel"))
+	events := []eventlog.Event{
+		{MRIndex: 1, Type: eventlog.EventTypeSeparator, Digest: digest[:], Data: []byte{0, 0, 0, 0}},
+		{MRIndex: 3, Type: eventlog.EventTypeIPL, Digest: digest[:], Data: []byte("kernel_cmdline: quiet")},
+	}
+	raw := eventlog.MarshalCCEL(events)
+
+	// The CCEL table area is padded after the last event.
+	parsed, err := eventlog.ParseCCEL(append(raw, bytes.Repeat([]byte{0xff}, 64)...))
+	if err != nil {
+		t.Fatalf("failed to parse CCEL: %v", err)
+	}
+	if len(parsed) != len(events) {
+		t.Fatalf("parsed %d events, want %d", len(parsed), len(events))
+	}
+	for i := range events {
+		if parsed[i].MRIndex != events[i].MRIndex || parsed[i].Type != events[i].Type ||
+			!bytes.Equal(parsed[i].Digest, events[i].Digest) || !bytes.Equal(parsed[i].Data, events[i].Data) {
+			t.Errorf("event %d = %+v, want %+v", i, parsed[i], events[i])
+		}
+	}
+
+	if _, err := eventlog.ParseCCEL(raw[:len(raw)-4]); err == nil {
+		t.Error("truncated CCEL parsed without error")
+	}
+	if _, err := eventlog.ParseCCEL([]byte("not an event log")); err == nil {
+		t.Error("garbage parsed without error")
+	}
+}
+
+func tpmEvent(pcr uint32, eventType uint32, data []byte) eventlog.Event {
+	sum := sha256.Sum256(data)
+	return eventlog.Event{MRIndex: pcr, Type: eventType, Digest: sum[:], Data: data}
+}
+
+// grubEvent is an EV_IPL event measured by GRUB, which hashes the text
+// after the prefix.
+func grubEvent(pcr uint32, prefix string, text string) eventlog.Event {
+	event := tpmEvent(pcr, eventlog.EventTypeIPL, []byte(prefix+text+"\x00"))
+	sum := sha256.Sum256([]byte(text))
+	event.Digest = sum[:]
+	return event
+}
+
+func secureBootVariable(value byte) []byte {
+	var buf bytes.Buffer
+	buf.Write([]byte{0x61, 0xdf, 0xe4, 0x8b, 0xca, 0x93, 0xd2, 0x11, 0xaa, 0x0d, 0x00, 0xe0, 0x98, 0x03, 0x2b, 0x8c})
+	name := utf16.Encode([]rune("SecureBoot"))
+	binary.Write(&buf, binary.LittleEndian, uint64(len(name)))
+	binary.Write(&buf, binary.LittleEndian, uint64(1))
+	binary.Write(&buf, binary.LittleEndian, name)
+	buf.WriteByte(value)
+	return buf.Bytes()
+}
+
+func replay(events []eventlog.Event) map[uint32][]byte {
+	pcrs := make(map[uint32][]byte)
+	for i := range uint32(10) {
+		pcrs[i] = make([]byte, sha256.Size)
+	}
+	for _, event := range events {
+		sum := sha256.Sum256(append(pcrs[event.MRIndex], event.Digest...))
+		pcrs[event.MRIndex] = sum[:]
+	}
+	return pcrs
+}
+
+func TestVerifyTPMLog(t *testing.T) {
+	shim := sha256.Sum256([]byte("shim"))
+	grub := sha256.Sum256([]byte("grub"))
+	separator := []byte{0, 0, 0, 0}
+	events := []eventlog.Event{
+		tpmEvent(7, eventlog.EventTypeEFIVariableDriverConfig, secureBootVariable(1)),
+		tpmEvent(7, eventlog.EventTypeSeparator, separator),
+		{MRIndex: 4, Type: eventlog.EventTypeEFIBootServicesApplication, Digest: shim[:]},
+		{MRIndex: 4, Type: eventlog.EventTypeEFIBootServicesApplication, Digest: grub[:]},
+		grubEvent(8, "grub_cmd: ", "linux /vmlinuz-6.8 root=/dev/sda1"),
+		tpmEvent(9, eventlog.EventTypeIPL, []byte("(hd0,gpt1)/vmlinuz-6.8\x00")),
+		grubEvent(8, "kernel_cmdline: ", "/vmlinuz-6.8 root=/dev/sda1"),
+		grubEvent(8, "grub_cmd: ", "initrd /initrd.img-6.8"),
+		tpmEvent(9, eventlog.EventTypeIPL, []byte("(hd0,gpt1)/initrd.img-6.8\x00")),
+	}
+	// GRUB measures file contents, not names.
+	kernel := sha256.Sum256([]byte("kernel image"))
+	events[5].Digest = kernel[:]
+	raw := eventlog.MarshalTPMLog(events)
+	pcrs := replay(events)
+
+	claims, errs := eventlog.VerifyTPMLog(raw, pcrs)
+	if len(errs) > 0 {
+		t.Fatalf("verify failed: %v", errs)
+	}
+	if !claims.SecureBoot {
+		t.Error("secure boot not claimed")
+	}
+	if len(claims.BootApplications) != 2 || claims.BootApplications[0] != hex.EncodeToString(shim[:]) {
+		t.Errorf("boot applications = %v, want shim and grub", claims.BootApplications)
+	}
+	if len(claims.Files) != 2 || claims.Files[0].Digest != hex.EncodeToString(kernel[:]) || claims.Files[1].Path != "(hd0,gpt1)/initrd.img-6.8" {
+		t.Errorf("files = %+v, want the kernel and initrd", claims.Files)
+	}
+	if claims.KernelCmdline != "/vmlinuz-6.8 root=/dev/sda1" {
+		t.Errorf("kernel cmdline = %q", claims.KernelCmdline)
+	}
+
+	policy := &eventlog.BootPolicy{
+		SecureBoot:       true,
+		BootApplications: []eventlog.Hex{shim[:], grub[:]},
+		Files:            []eventlog.Hex{kernel[:], events[8].Digest},
+		KernelCmdlines:   []string{"/vmlinuz-6.8 root=/dev/sda1"},
+	}
+	if err := policy.Validate(); err != nil {
+		t.Fatalf("invalid policy: %v", err)
+	}
+	if errs := policy.Check(claims); len(errs) > 0 {
+		t.Errorf("policy rejected the claims: %v", errs)
+	}
+	policy.BootApplications = policy.BootApplications[:1]
+	policy.KernelCmdlines = []string{"quiet"}
+	if errs := policy.Check(claims); len(errs) != 2 {
+		t.Errorf("policy check = %v, want grub and the cmdline rejected", errs)
+	}
+
+	t.Run("RelabeledPath", func(t *testing.T) {
+		// GRUB booted another kernel after reading the allowed one, and the
+		// log labels the allowed file with the booted path. Labels are not
+		// covered by the digests, so the other kernel is still checked.
+		other := sha256.Sum256([]byte("other kernel image"))
+		events := append([]eventlog.Event{}, events[:4]...)
+		events = append(events,
+			grubEvent(8, "grub_cmd: ", "cat /vmlinuz-6.8"),
+			eventlog.Event{MRIndex: 9, Type: eventlog.EventTypeIPL, Digest: kernel[:], Data: []byte("(hd0,gpt1)/vmlinuz-other\x00")},
+			grubEvent(8, "grub_cmd: ", "linux /vmlinuz-other root=/dev/sda1"),
+			eventlog.Event{MRIndex: 9, Type: eventlog.EventTypeIPL, Digest: other[:], Data: []byte("(hd0,gpt1)/vmlinuz-6.8\x00")},
+		)
+		claims, errs := eventlog.VerifyTPMLog(eventlog.MarshalTPMLog(events), replay(events))
+		if len(errs) > 0 {
+			t.Fatalf("verify failed: %v", errs)
+		}
+		policy := &eventlog.BootPolicy{Files: []eventlog.Hex{kernel[:]}}
+		if errs := policy.Check(claims); len(errs) != 1 {
+			t.Errorf("policy check = %v, want the other kernel rejected", errs)
+		}
+	})
+
+	t.Run("Mismatch", func(t *testing.T) {
+		// A log with an event left out no longer replays to the PCRs.
+		if _, errs := eventlog.VerifyTPMLog(eventlog.MarshalTPMLog(events[1:]), pcrs); len(errs) != 1 {
+			t.Errorf("verify = %v, want a PCR7 mismatch", errs)
+		}
+	})
+
+	t.Run("SecureBootDisabled", func(t *testing.T) {
+		events := append([]eventlog.Event{tpmEvent(7, eventlog.EventTypeEFIVariableDriverConfig, secureBootVariable(0))}, events[1:]...)
+		claims, errs := eventlog.VerifyTPMLog(eventlog.MarshalTPMLog(events), replay(events))
+		if len(errs) > 0 || claims.SecureBoot {
+			t.Errorf("verify = %+v, %v; want secure boot disabled", claims, errs)
+		}
+	})
+
+	t.Run("UnquotedPCR", func(t *testing.T) {
+		// Events of PCRs the quote does not cover are not claimed.
+		delete(pcrs, 7)
+		claims, errs := eventlog.VerifyTPMLog(raw, pcrs)
+		if len(errs) > 0 || claims.SecureBoot {
+			t.Errorf("verify = %+v, %v; want secure boot unclaimed", claims, errs)
+		}
+	})
+}
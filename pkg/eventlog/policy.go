@@ -0,0 +1,74 @@
+package eventlog
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+)
+
+// BootPolicy constrains the measured boot described by BootClaims, so that
+// validators can check what booted instead of raw PCR values. Unset fields
+// are not checked.
+type BootPolicy struct {
+	SecureBoot bool `yaml:"secure_boot"`
+	// Allowed SHA-256 digests. Every boot application and every file
+	// measured into PCR9 must be allowed.
+	BootApplications []Hex    `yaml:"boot_applications"`
+	Files            []Hex    `yaml:"files"`
+	KernelCmdlines   []string `yaml:"kernel_cmdlines"`
+}
+
+func (p *BootPolicy) Validate() error {
+	for _, field := range []struct {
+		name    string
+		digests []Hex
+	}{
+		{"boot_applications", p.BootApplications},
+		{"files", p.Files},
+	} {
+		for _, digest := range field.digests {
+			if len(digest) != sha256.Size {
+				return fmt.Errorf("%s: expected %d byte digests, got %d bytes", field.name, sha256.Size, len(digest))
+			}
+		}
+	}
+	return nil
+}
+
+// Check returns a reason for each way the claims violate the policy.
+func (p *BootPolicy) Check(claims *BootClaims) []error {
+	var errs []error
+	if p.SecureBoot && !claims.SecureBoot {
+		errs = append(errs, fmt.Errorf("Secure Boot is not enabled"))
+	}
+	checkAll := func(name string, allowedDigests []Hex, digests []string) {
+		if len(allowedDigests) == 0 {
+			return
+		}
+		if len(digests) == 0 {
+			errs = append(errs, fmt.Errorf("no %ss were measured", name))
+		}
+		for _, digest := range digests {
+			if !allowed(allowedDigests, digest) {
+				errs = append(errs, fmt.Errorf("%s %s is not allowed", name, digest))
+			}
+		}
+	}
+	checkAll("boot application", p.BootApplications, claims.BootApplications)
+	files := make([]string, len(claims.Files))
+	for i, file := range claims.Files {
+		files[i] = file.Digest
+	}
+	checkAll("file", p.Files, files)
+	if len(p.KernelCmdlines) > 0 && !slices.Contains(p.KernelCmdlines, claims.KernelCmdline) {
+		errs = append(errs, fmt.Errorf("kernel command line %q is not allowed", claims.KernelCmdline))
+	}
+	return errs
+}
+
+func allowed(digests []Hex, digest string) bool {
+	return slices.ContainsFunc(digests, func(d Hex) bool {
+		return hex.EncodeToString(d) == digest
+	})
+}
@@ -0,0 +1,150 @@
+package eventlog
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// DefaultTPMLogPath is where Linux exposes the TPM event log.
+const DefaultTPMLogPath = "/sys/kernel/security/tpm0/binary_bios_measurements"
+
+// PCRs of the boot chain, as assigned by the TCG PC Client Platform Firmware
+// Profile and GRUB.
+const (
+	pcrBootApplications = 4
+	pcrSecureBoot       = 7
+	pcrGrubCommands     = 8
+	pcrGrubFiles        = 9
+)
+
+const startupLocalitySignature = "StartupLocality\x00"
+
+// ReplayPCRs recomputes the SHA-256 PCRs from the events and compares each
+// PCR in pcrs that an event extended. Events of PCRs that are not in pcrs are
+// dropped, as nothing vouches for them; the events that replayed are
+// returned.
+func ReplayPCRs(events []Event, pcrs map[uint32][]byte) ([]Event, []error) {
+	values := make(map[uint32][]byte)
+	var replayed []Event
+	for _, event := range events {
+		if event.Type == EventTypeNoAction {
+			// The Startup Locality event sets the initial value of PCR0.
+			if locality, ok := bytes.CutPrefix(event.Data, []byte(startupLocalitySignature)); ok && event.MRIndex == 0 && len(locality) == 1 {
+				values[0] = make([]byte, sha256.Size)
+				values[0][sha256.Size-1] = locality[0]
+			}
+			continue
+		}
+		if _, ok := pcrs[event.MRIndex]; !ok {
+			continue
+		}
+		value, ok := values[event.MRIndex]
+		if !ok {
+			value = make([]byte, sha256.Size)
+		}
+		sum := sha256.Sum256(append(value, event.Digest...))
+		values[event.MRIndex] = sum[:]
+		replayed = append(replayed, event)
+	}
+
+	var errs []error
+	for _, index := range slices.Sorted(maps.Keys(values)) {
+		quoted, ok := pcrs[index]
+		if ok && !bytes.Equal(values[index], quoted) {
+			errs = append(errs, fmt.Errorf("PCR%d replays to %x, quote has %x", index, values[index], quoted))
+		}
+	}
+	return replayed, errs
+}
+
+// VerifyTPMLog parses a TPM event log, replays it against the quoted PCRs
+// and derives boot claims from the events that replayed.
+func VerifyTPMLog(raw []byte, pcrs map[uint32][]byte) (*BootClaims, []error) {
+	events, err := ParseTPMLog(raw)
+	if err != nil {
+		return nil, []error{fmt.Errorf("invalid TPM event log: %w", err)}
+	}
+	replayed, errs := ReplayPCRs(events, pcrs)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return NewBootClaims(replayed), nil
+}
+
+// BootClaims describe the measured boot recorded in a TPM event log. Like
+// Claims, they are only derived from event data the digests cover.
+type BootClaims struct {
+	Events []PCREventClaim `json:"events,omitempty"`
+	// SecureBoot is set when the SecureBoot variable measured into PCR7 is 1.
+	SecureBoot bool `json:"secureBoot"`
+	// BootApplications are the Authenticode digests of the EFI applications
+	// the firmware loaded, in order: usually shim or the boot loader, then
+	// the kernel or unified kernel image if started through LoadImage.
+	BootApplications []string `json:"bootApplications,omitempty"`
+	// Files are the events measured into PCR9, in order: the files GRUB
+	// read, including the kernel and initrd, and the initrd and load options
+	// the Linux EFI stub measured.
+	Files         []FileClaim `json:"files,omitempty"`
+	KernelCmdline string      `json:"kernelCmdline,omitempty"`
+}
+
+type PCREventClaim struct {
+	PCR          uint32 `json:"pcr"`
+	Type         string `json:"type"`
+	Digest       string `json:"digest"`
+	DataVerified bool   `json:"dataVerified"`
+}
+
+// FileClaim is a file measured by the boot chain. Path comes from event data
+// that the digest does not cover, so it is informational: a forged log can
+// label any file as the kernel. Which file GRUB booted cannot be told from
+// the log, so policies allow files by digest only.
+type FileClaim struct {
+	Path   string `json:"path,omitempty"`
+	Digest string `json:"digest"`
+}
+
+// NewBootClaims derives boot claims from PCR events.
+func NewBootClaims(events []Event) *BootClaims {
+	claims := &BootClaims{}
+	for _, event := range events {
+		claims.Events = append(claims.Events, PCREventClaim{
+			PCR:          event.MRIndex,
+			Type:         EventTypeName(event.Type),
+			Digest:       hex.EncodeToString(event.Digest),
+			DataVerified: hashes(event.Data, event.Digest),
+		})
+
+		switch {
+		case event.MRIndex == pcrSecureBoot && event.Type == EventTypeEFIVariableDriverConfig:
+			variable, ok := verifiedVariable(event)
+			if ok && variable.guid == efiGlobalVariableGUID && variable.name == "SecureBoot" {
+				claims.SecureBoot = bytes.Equal(variable.data, []byte{1})
+			}
+		case event.MRIndex == pcrBootApplications && event.Type == EventTypeEFIBootServicesApplication:
+			claims.BootApplications = append(claims.BootApplications, hex.EncodeToString(event.Digest))
+		case event.MRIndex == pcrGrubCommands && event.Type == EventTypeIPL:
+			if cmdline, ok := kernelCmdline(event); ok {
+				claims.KernelCmdline = cmdline
+			}
+		case event.MRIndex == pcrGrubFiles:
+			// Event types are not covered by the digests either, so every
+			// PCR9 event counts as a file.
+			file := FileClaim{Digest: hex.EncodeToString(event.Digest)}
+			if event.Type == EventTypeIPL {
+				file.Path = strings.TrimRight(string(event.Data), "\x00")
+			}
+			claims.Files = append(claims.Files, file)
+		}
+	}
+	return claims
+}
+
+// efiGlobalVariableGUID is EFI_GLOBAL_VARIABLE, the vendor GUID of
+// SecureBoot and the other variables defined by the UEFI specification.
+const efiGlobalVariableGUID = "8be4df61-93ca-11d2-aa0d-00e098032b8c"
@@ -0,0 +1,290 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Hyodar/tdxs/pkg/api"
+	"github.com/Hyodar/tdxs/pkg/logger"
+)
+
+const (
+	DefaultInterval = time.Minute
+	DefaultTimeout  = 30 * time.Second
+)
+
+type HealthConfig struct {
+	Interval   time.Duration `yaml:"interval"`
+	Timeout    time.Duration `yaml:"timeout"`
+	ListenAddr string        `yaml:"listen_addr"`
+}
+
+func (c *HealthConfig) Validate() error {
+	if c.Interval < 0 {
+		return fmt.Errorf("interval must not be negative")
+	}
+	if c.Timeout < 0 {
+		return fmt.Errorf("timeout must not be negative")
+	}
+	return nil
+}
+
+// SelfTestFunc exercises the attestation backends end to end and reports
+// whether they are able to serve requests.
+type SelfTestFunc func(ctx context.Context) error
+
+// Monitor tracks liveness and readiness of the service and periodically runs
+// a self-test, optionally exposing the result over HTTP.
+type Monitor struct {
+	cfg      *HealthConfig
+	selfTest SelfTestFunc
+	logger   logger.Logger
+
+	cacheStats func() map[string]api.CacheStats
+
+	mu     sync.RWMutex
+	live   bool
+	ready  bool
+	status api.SelfTestStatus
+}
+
+// NewMonitor creates a monitor. A nil selfTest disables the self-test, in which
+// case readiness depends only on SetReady.
+func NewMonitor(cfg *HealthConfig, selfTest SelfTestFunc, logger logger.Logger) (*Monitor, error) {
+	if cfg == nil {
+		cfg = &HealthConfig{}
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &Monitor{
+		cfg:      cfg,
+		selfTest: selfTest,
+		logger:   logger,
+		status:   api.SelfTestStatus{Enabled: selfTest != nil},
+	}, nil
+}
+
+func (m *Monitor) Start(ctx context.Context) error {
+	m.mu.Lock()
+	m.live = true
+	m.mu.Unlock()
+
+	if m.cfg.ListenAddr != "" {
+		if err := m.serveHTTP(ctx); err != nil {
+			return err
+		}
+	}
+
+	if m.selfTest != nil {
+		go m.runSelfTests(ctx)
+	}
+
+	return nil
+}
+
+// SetCacheStats sets the source of the issue cache counters included in the
+// status.
+func (m *Monitor) SetCacheStats(fn func() map[string]api.CacheStats) {
+	m.cacheStats = fn
+}
+
+// SetReady records whether the service is accepting requests.
+func (m *Monitor) SetReady(ready bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ready = ready
+}
+
+func (m *Monitor) Status() *api.HealthResponse {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	ready := m.live && m.ready
+	if m.status.Enabled && (m.status.LastSuccess.IsZero() || m.status.LastError != "") {
+		ready = false
+	}
+
+	status := &api.HealthResponse{
+		Live:     m.live,
+		Ready:    ready,
+		SelfTest: m.status,
+	}
+	if m.cacheStats != nil {
+		status.IssueCache = m.cacheStats()
+	}
+	return status
+}
+
+func (m *Monitor) runSelfTests(ctx context.Context) {
+	interval := m.cfg.Interval
+	if interval == 0 {
+		interval = DefaultInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		m.RunSelfTest(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunSelfTest runs the self-test once and records its outcome.
+func (m *Monitor) RunSelfTest(ctx context.Context) error {
+	if m.selfTest == nil {
+		return nil
+	}
+
+	timeout := m.cfg.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+
+	m.mu.Lock()
+	m.status.Running = true
+	m.mu.Unlock()
+
+	testCtx, cancel := context.WithTimeout(ctx, timeout)
+	err := m.selfTest(testCtx)
+	cancel()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.status.Running = false
+	m.status.LastRun = time.Now()
+	if err != nil {
+		if m.status.LastError == "" {
+			m.logger.Warn("Self-test failed", "error", err)
+		}
+		m.status.LastError = err.Error()
+		return err
+	}
+
+	if m.status.LastError != "" {
+		m.logger.Info("Self-test recovered")
+	}
+	m.status.LastSuccess = m.status.LastRun
+	m.status.LastError = ""
+	return nil
+}
+
+func (m *Monitor) serveHTTP(ctx context.Context) error {
+	listener, err := net.Listen("tcp", m.cfg.ListenAddr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", m.cfg.ListenAddr, err)
+	}
+
+	server := &http.Server{
+		Handler:           m.handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			m.logger.Error("Health server stopped", "error", err)
+		}
+	}()
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	m.logger.Info("Health endpoints listening", "addr", listener.Addr().String())
+	return nil
+}
+
+// handler serves /healthz, which fails unless the service is live, and
+// /readyz, which fails unless it is ready.
+func (m *Monitor) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		status := m.Status()
+		writeStatus(w, status, status.Live)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, _ *http.Request) {
+		status := m.Status()
+		writeStatus(w, status, status.Ready)
+	})
+	return mux
+}
+
+func writeStatus(w http.ResponseWriter, status *api.HealthResponse, ok bool) {
+	w.Header().Set("Content-Type", "application/json")
+	if ok {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(NewStatusJSON(status))
+}
+
+type SelfTestJSON struct {
+	Enabled     bool       `json:"enabled"`
+	Running     bool       `json:"running"`
+	LastRun     *time.Time `json:"lastRun"`
+	LastSuccess *time.Time `json:"lastSuccess"`
+	LastError   *string    `json:"lastError"`
+}
+
+type CacheStatsJSON struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Shared    uint64 `json:"shared"`
+	Evictions uint64 `json:"evictions"`
+	Entries   int    `json:"entries"`
+}
+
+type StatusJSON struct {
+	Live       bool                      `json:"live"`
+	Ready      bool                      `json:"ready"`
+	SelfTest   SelfTestJSON              `json:"selfTest"`
+	IssueCache map[string]CacheStatsJSON `json:"issueCache,omitempty"`
+}
+
+// NewStatusJSON converts a health response into the wire format shared by the
+// HTTP endpoints and transports.
+func NewStatusJSON(status *api.HealthResponse) *StatusJSON {
+	out := &StatusJSON{
+		Live:  status.Live,
+		Ready: status.Ready,
+		SelfTest: SelfTestJSON{
+			Enabled: status.SelfTest.Enabled,
+			Running: status.SelfTest.Running,
+		},
+	}
+	if !status.SelfTest.LastRun.IsZero() {
+		lastRun := status.SelfTest.LastRun
+		out.SelfTest.LastRun = &lastRun
+	}
+	if !status.SelfTest.LastSuccess.IsZero() {
+		lastSuccess := status.SelfTest.LastSuccess
+		out.SelfTest.LastSuccess = &lastSuccess
+	}
+	if status.SelfTest.LastError != "" {
+		lastError := status.SelfTest.LastError
+		out.SelfTest.LastError = &lastError
+	}
+	for profile, stats := range status.IssueCache {
+		if out.IssueCache == nil {
+			out.IssueCache = make(map[string]CacheStatsJSON, len(status.IssueCache))
+		}
+		out.IssueCache[profile] = CacheStatsJSON(stats)
+	}
+	return out
+}
@@ -0,0 +1,148 @@
+package health
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// stubSelfTest fails with err until it is cleared.
+type stubSelfTest struct {
+	err error
+}
+
+func (s *stubSelfTest) run(context.Context) error {
+	return s.err
+}
+
+func newTestMonitor(t *testing.T, selfTest SelfTestFunc, logs *bytes.Buffer) *Monitor {
+	t.Helper()
+	handler := slog.DiscardHandler
+	if logs != nil {
+		handler = slog.NewTextHandler(logs, nil)
+	}
+	m, err := NewMonitor(&HealthConfig{}, selfTest, slog.New(handler))
+	if err != nil {
+		t.Fatalf("failed to create monitor: %v", err)
+	}
+	m.mu.Lock()
+	m.live = true
+	m.mu.Unlock()
+	m.SetReady(true)
+	return m
+}
+
+func TestStatus(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("NoSelfTest", func(t *testing.T) {
+		m := newTestMonitor(t, nil, nil)
+		if status := m.Status(); !status.Live || !status.Ready || status.SelfTest.Enabled {
+			t.Errorf("status = %+v, want live and ready without a self-test", status)
+		}
+		m.SetReady(false)
+		if status := m.Status(); status.Ready {
+			t.Errorf("status = %+v, want not ready", status)
+		}
+	})
+
+	t.Run("SelfTest", func(t *testing.T) {
+		selfTest := &stubSelfTest{err: errors.New("quote provider unavailable")}
+		m := newTestMonitor(t, selfTest.run, nil)
+		if status := m.Status(); status.Ready {
+			t.Errorf("status = %+v, want not ready before the first self-test", status)
+		}
+
+		if err := m.RunSelfTest(ctx); err == nil {
+			t.Fatal("failing self-test succeeded")
+		}
+		if status := m.Status(); status.Ready || status.SelfTest.LastError != "quote provider unavailable" || !status.SelfTest.LastSuccess.IsZero() {
+			t.Errorf("status = %+v, want not ready with the self-test error", status)
+		}
+
+		selfTest.err = nil
+		if err := m.RunSelfTest(ctx); err != nil {
+			t.Fatalf("self-test failed: %v", err)
+		}
+		if status := m.Status(); !status.Ready || status.SelfTest.LastError != "" || status.SelfTest.LastSuccess.IsZero() {
+			t.Errorf("status = %+v, want ready after a successful self-test", status)
+		}
+
+		// A failure after a success makes the service unready again.
+		selfTest.err = errors.New("TPM unavailable")
+		m.RunSelfTest(ctx)
+		if status := m.Status(); status.Ready || status.SelfTest.LastSuccess.IsZero() {
+			t.Errorf("status = %+v, want not ready after a failed self-test", status)
+		}
+	})
+}
+
+func TestRecoveryLogging(t *testing.T) {
+	var logs bytes.Buffer
+	selfTest := &stubSelfTest{err: errors.New("quote provider unavailable")}
+	m := newTestMonitor(t, selfTest.run, &logs)
+	ctx := context.Background()
+
+	// Repeated failures are logged once, and so is the recovery.
+	m.RunSelfTest(ctx)
+	m.RunSelfTest(ctx)
+	selfTest.err = nil
+	m.RunSelfTest(ctx)
+	m.RunSelfTest(ctx)
+
+	if n := strings.Count(logs.String(), "Self-test failed"); n != 1 {
+		t.Errorf("failure logged %d times, want once:\n%s", n, logs.String())
+	}
+	if n := strings.Count(logs.String(), "Self-test recovered"); n != 1 {
+		t.Errorf("recovery logged %d times, want once:\n%s", n, logs.String())
+	}
+}
+
+func TestEndpoints(t *testing.T) {
+	selfTest := &stubSelfTest{err: errors.New("quote provider unavailable")}
+	m := newTestMonitor(t, selfTest.run, nil)
+	server := httptest.NewServer(m.handler())
+	defer server.Close()
+
+	get := func(path string) (int, *StatusJSON) {
+		t.Helper()
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", path, err)
+		}
+		defer resp.Body.Close()
+		var status StatusJSON
+		if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+			t.Fatalf("GET %s returned invalid JSON: %v", path, err)
+		}
+		return resp.StatusCode, &status
+	}
+
+	m.RunSelfTest(context.Background())
+	if code, _ := get("/healthz"); code != http.StatusOK {
+		t.Errorf("/healthz = %d while live, want %d", code, http.StatusOK)
+	}
+	code, status := get("/readyz")
+	if code != http.StatusServiceUnavailable || status.SelfTest.LastError == nil {
+		t.Errorf("/readyz = %d %+v after a failed self-test, want %d with the error", code, status, http.StatusServiceUnavailable)
+	}
+
+	selfTest.err = nil
+	m.RunSelfTest(context.Background())
+	if code, status := get("/readyz"); code != http.StatusOK || !status.Ready {
+		t.Errorf("/readyz = %d %+v after a successful self-test, want %d", code, status, http.StatusOK)
+	}
+
+	m.mu.Lock()
+	m.live = false
+	m.mu.Unlock()
+	if code, _ := get("/healthz"); code != http.StatusServiceUnavailable {
+		t.Errorf("/healthz = %d while not live, want %d", code, http.StatusServiceUnavailable)
+	}
+}
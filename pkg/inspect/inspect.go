@@ -0,0 +1,557 @@
+package inspect
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"time"
+
+	"github.com/google/go-sev-guest/abi"
+	"github.com/google/go-sev-guest/kds"
+	spb "github.com/google/go-sev-guest/proto/sevsnp"
+	"github.com/google/go-tdx-guest/proto/tdx"
+	"github.com/google/go-tpm-tools/proto/attest"
+
+	"github.com/Hyodar/tdxs/pkg/batch"
+	"github.com/Hyodar/tdxs/pkg/eventlog"
+	azureissuer "github.com/Hyodar/tdxs/pkg/issuer/azure"
+	gcpissuer "github.com/Hyodar/tdxs/pkg/issuer/gcp"
+	simulatorissuer "github.com/Hyodar/tdxs/pkg/issuer/simulator"
+	snpissuer "github.com/Hyodar/tdxs/pkg/issuer/snp"
+)
+
+type Format string
+
+const (
+	FormatAzure     Format = "azure"
+	FormatGCP       Format = "gcp"
+	FormatSNP       Format = "snp"
+	FormatTDXQuote  Format = "tdx-quote"
+	FormatSimulator Format = "simulator"
+)
+
+// Report is a structured breakdown of an attestation document. Nothing in it
+// has been verified.
+type Report struct {
+	Format       Format              `json:"format"`
+	UserData     string              `json:"userData,omitempty"`
+	Nonce        string              `json:"nonce,omitempty"`
+	Quote        *QuoteReport        `json:"quote,omitempty"`
+	SNPReport    *SNPReport          `json:"snpReport,omitempty"`
+	PCRs         map[uint32]string   `json:"pcrs,omitempty"`
+	Instance     *gcpissuer.Instance `json:"instance,omitempty"`
+	Certificates []Certificate       `json:"certificates,omitempty"`
+	Events       []LogEvent          `json:"events,omitempty"`
+	Batch        *BatchReport        `json:"batch,omitempty"`
+}
+
+// BatchReport describes a batched document. Root is the user data of the
+// inner document, which its inclusion path should lead to.
+type BatchReport struct {
+	Index uint64   `json:"index"`
+	Size  uint64   `json:"size"`
+	Root  string   `json:"root"`
+	Path  []string `json:"path"`
+}
+
+type QuoteReport struct {
+	Header QuoteHeader `json:"header"`
+	Body   TDBody      `json:"body"`
+}
+
+type QuoteHeader struct {
+	Version            uint32 `json:"version"`
+	AttestationKeyType uint32 `json:"attestationKeyType"`
+	TeeType            uint32 `json:"teeType"`
+	QeSvn              string `json:"qeSvn"`
+	PceSvn             string `json:"pceSvn"`
+	QeVendorID         string `json:"qeVendorId"`
+	UserData           string `json:"userData"`
+}
+
+type TDBody struct {
+	TeeTcbSvn        string   `json:"teeTcbSvn"`
+	MrSeam           string   `json:"mrSeam"`
+	MrSignerSeam     string   `json:"mrSignerSeam"`
+	SeamAttributes   string   `json:"seamAttributes"`
+	TdAttributes     string   `json:"tdAttributes"`
+	TdAttributeFlags []string `json:"tdAttributeFlags"`
+	Xfam             string   `json:"xfam"`
+	MrTd             string   `json:"mrTd"`
+	MrConfigID       string   `json:"mrConfigId"`
+	MrOwner          string   `json:"mrOwner"`
+	MrOwnerConfig    string   `json:"mrOwnerConfig"`
+	Rtmrs            []string `json:"rtmrs"`
+	ReportData       string   `json:"reportData"`
+}
+
+// SNPReport is the body of an SEV-SNP attestation report.
+type SNPReport struct {
+	Version         uint32   `json:"version"`
+	Product         string   `json:"product,omitempty"` // product line, e.g. Milan
+	SigningKey      string   `json:"signingKey"`        // VCEK or VLEK
+	Measurement     string   `json:"measurement"`
+	HostData        string   `json:"hostData"`
+	Policy          uint64   `json:"policy"`
+	PolicyFlags     []string `json:"policyFlags"`
+	VMPL            uint32   `json:"vmpl"`
+	GuestSVN        uint32   `json:"guestSvn"`
+	IDKeyDigest     string   `json:"idKeyDigest"`
+	AuthorKeyDigest string   `json:"authorKeyDigest"`
+	ReportedTCB     TCB      `json:"reportedTcb"`
+	CurrentTCB      TCB      `json:"currentTcb"`
+	CommittedTCB    TCB      `json:"committedTcb"`
+	LaunchTCB       TCB      `json:"launchTcb"`
+	ChipID          string   `json:"chipId"`
+	ReportID        string   `json:"reportId"`
+	ReportData      string   `json:"reportData"`
+}
+
+// TCB holds the security patch levels of the SEV-SNP firmware components.
+type TCB struct {
+	Bootloader uint8 `json:"bootloader"`
+	TEE        uint8 `json:"tee"`
+	SNP        uint8 `json:"snp"`
+	Microcode  uint8 `json:"microcode"`
+}
+
+// LogEvent is an entry of an attached event log. Source is "ccel" for TDX
+// firmware events, "runtime" for RTMR extensions made through tdxs and "tpm"
+// for vTPM events. Register is the RTMR or PCR extended, as in "RTMR2".
+type LogEvent struct {
+	Source      string `json:"source"`
+	Register    string `json:"register"`
+	Type        string `json:"type,omitempty"`
+	Digest      string `json:"digest"`
+	Description string `json:"description,omitempty"`
+}
+
+type Certificate struct {
+	Chain     string    `json:"chain"`
+	Subject   string    `json:"subject"`
+	Issuer    string    `json:"issuer"`
+	Serial    string    `json:"serial"`
+	NotBefore time.Time `json:"notBefore"`
+	NotAfter  time.Time `json:"notAfter"`
+}
+
+// Inspect detects the format of doc and decodes it. Azure, GCP, SNP and
+// simulator documents are JSON; anything else is parsed as a raw TDX quote.
+func Inspect(doc []byte) (*Report, error) {
+	trimmed := bytes.TrimSpace(doc)
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		var probe map[string]json.RawMessage
+		if err := json.Unmarshal(trimmed, &probe); err != nil {
+			return nil, fmt.Errorf("failed to parse JSON document: %w", err)
+		}
+		if _, ok := probe["batch"]; ok {
+			return inspectBatch(trimmed)
+		}
+		if _, ok := probe["Attestation"]; ok {
+			// GCP documents carry the TDX quote next to the vTPM
+			// attestation, Azure ones in the instance info.
+			if _, ok := probe["Quote"]; ok {
+				return inspectGCP(trimmed)
+			}
+			return inspectAzure(trimmed)
+		}
+		if _, ok := probe["Report"]; ok {
+			return inspectSNP(trimmed)
+		}
+		if _, ok := probe["quote"]; ok {
+			return inspectSignedSimulator(trimmed)
+		}
+		if _, ok := probe["userData"]; ok {
+			return inspectSimulator(trimmed)
+		}
+		return nil, fmt.Errorf("unrecognized JSON document")
+	}
+
+	quote, err := azureissuer.ParseQuote(doc)
+	if err != nil {
+		return nil, fmt.Errorf("document is neither JSON nor a TDX quote: %w", err)
+	}
+	return &Report{
+		Format:       FormatTDXQuote,
+		Quote:        quoteReport(quote),
+		Certificates: quoteCertificates(quote),
+	}, nil
+}
+
+func inspectAzure(doc []byte) (*Report, error) {
+	parsed, err := azureissuer.ParseDocument(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{
+		Format:       FormatAzure,
+		UserData:     hexEncode(parsed.Document.UserData),
+		Quote:        quoteReport(parsed.Quote),
+		PCRs:         make(map[uint32]string, len(parsed.PCRs.Pcrs)),
+		Certificates: quoteCertificates(parsed.Quote),
+	}
+
+	for index, value := range parsed.PCRs.Pcrs {
+		report.PCRs[index] = hexEncode(value)
+	}
+
+	if err := addAttestation(report, parsed.Document.Attestation); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+func inspectGCP(doc []byte) (*Report, error) {
+	parsed, err := gcpissuer.ParseDocument(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{
+		Format:       FormatGCP,
+		UserData:     hexEncode(parsed.Document.UserData),
+		Quote:        quoteReport(parsed.Quote),
+		PCRs:         make(map[uint32]string, len(parsed.PCRs.Pcrs)),
+		Instance:     parsed.Instance,
+		Certificates: quoteCertificates(parsed.Quote),
+	}
+
+	for index, value := range parsed.PCRs.Pcrs {
+		report.PCRs[index] = hexEncode(value)
+	}
+
+	if err := addAttestation(report, parsed.Document.Attestation); err != nil {
+		return nil, err
+	}
+	if parsed.Document.EventLog != nil {
+		events, err := logEvents(parsed.Document.EventLog)
+		if err != nil {
+			return nil, err
+		}
+		report.Events = append(report.Events, events...)
+	}
+	return report, nil
+}
+
+func inspectSNP(doc []byte) (*Report, error) {
+	parsed, err := snpissuer.ParseDocument(doc)
+	if err != nil {
+		return nil, err
+	}
+	report := parsed.Attestation.GetReport()
+
+	snpReport := &SNPReport{
+		Version:         report.GetVersion(),
+		Measurement:     hexEncode(report.GetMeasurement()),
+		HostData:        hexEncode(report.GetHostData()),
+		Policy:          report.GetPolicy(),
+		PolicyFlags:     snpPolicyFlags(report.GetPolicy()),
+		VMPL:            report.GetVmpl(),
+		GuestSVN:        report.GetGuestSvn(),
+		IDKeyDigest:     hexEncode(report.GetIdKeyDigest()),
+		AuthorKeyDigest: hexEncode(report.GetAuthorKeyDigest()),
+		ReportedTCB:     tcb(report.GetReportedTcb()),
+		CurrentTCB:      tcb(report.GetCurrentTcb()),
+		CommittedTCB:    tcb(report.GetCommittedTcb()),
+		LaunchTCB:       tcb(report.GetLaunchTcb()),
+		ChipID:          hexEncode(report.GetChipId()),
+		ReportID:        hexEncode(report.GetReportId()),
+		ReportData:      hexEncode(report.GetReportData()),
+	}
+	if info, err := abi.ParseSignerInfo(report.GetSignerInfo()); err == nil {
+		snpReport.SigningKey = info.SigningKey.String()
+	}
+	if fms := report.GetCpuid1EaxFms(); fms != 0 {
+		snpReport.Product = kds.ProductLineFromFms(fms)
+	}
+
+	return &Report{
+		Format:       FormatSNP,
+		UserData:     hexEncode(parsed.Document.UserData),
+		SNPReport:    snpReport,
+		Certificates: snpCertificates(parsed.Attestation.GetCertificateChain()),
+	}, nil
+}
+
+// snpPolicyFlags names the capabilities the guest policy allows.
+func snpPolicyFlags(policy uint64) []string {
+	parsed, err := abi.ParseSnpPolicy(policy)
+	if err != nil {
+		return nil
+	}
+
+	known := []struct {
+		set  bool
+		name string
+	}{
+		{parsed.SMT, "SMT"},
+		{parsed.MigrateMA, "MIGRATE_MA"},
+		{parsed.Debug, "DEBUG"},
+		{parsed.SingleSocket, "SINGLE_SOCKET"},
+	}
+
+	flags := []string{}
+	for _, flag := range known {
+		if flag.set {
+			flags = append(flags, flag.name)
+		}
+	}
+	return flags
+}
+
+func tcb(version uint64) TCB {
+	parts := kds.DecomposeTCBVersion(kds.TCBVersion(version))
+	return TCB{Bootloader: parts.BlSpl, TEE: parts.TeeSpl, SNP: parts.SnpSpl, Microcode: parts.UcodeSpl}
+}
+
+// snpCertificates lists the certificate table of an SEV-SNP document, from
+// the VCEK or VLEK up to the ARK.
+func snpCertificates(chain *spb.CertificateChain) []Certificate {
+	var ders [][]byte
+	for _, der := range [][]byte{chain.GetVcekCert(), chain.GetVlekCert(), chain.GetAskCert(), chain.GetArkCert()} {
+		if len(der) > 0 {
+			ders = append(ders, der)
+		}
+	}
+	return derCertificates("amd", ders)
+}
+
+// addAttestation adds the AK certificates and vTPM event log of a vTPM
+// attestation to report.
+func addAttestation(report *Report, att *attest.Attestation) error {
+	if len(att.AkCert) > 0 {
+		report.Certificates = append(report.Certificates, derCertificates("vtpm-ak", append([][]byte{att.AkCert}, att.IntermediateCerts...))...)
+	}
+
+	if raw := att.GetEventLog(); len(raw) > 0 {
+		events, err := eventlog.ParseTPMLog(raw)
+		if err != nil {
+			return fmt.Errorf("failed to parse TPM event log: %w", err)
+		}
+		for _, event := range events {
+			if event.Type == eventlog.EventTypeNoAction {
+				continue
+			}
+			report.Events = append(report.Events, LogEvent{
+				Source:   "tpm",
+				Register: fmt.Sprintf("PCR%d", event.MRIndex),
+				Type:     eventlog.EventTypeName(event.Type),
+				Digest:   hexEncode(event.Digest),
+			})
+		}
+	}
+	return nil
+}
+
+// inspectBatch decodes the document inside a batched document and reports
+// the batch user data in place of the Merkle root.
+func inspectBatch(doc []byte) (*Report, error) {
+	parsed, err := batch.ParseDocument(doc)
+	if err != nil {
+		return nil, err
+	}
+	if batch.IsDocument(parsed.Document) {
+		return nil, fmt.Errorf("batched document contains another batched document")
+	}
+	report, err := Inspect(parsed.Document)
+	if err != nil {
+		return nil, err
+	}
+	report.Batch = &BatchReport{
+		Index: parsed.Batch.Index,
+		Size:  parsed.Batch.Size,
+		Root:  report.UserData,
+		Path:  make([]string, len(parsed.Batch.Path)),
+	}
+	for i, hash := range parsed.Batch.Path {
+		report.Batch.Path[i] = "0x" + hash
+	}
+	report.UserData = "0x" + parsed.Batch.UserData
+	return report, nil
+}
+
+func inspectSimulator(doc []byte) (*Report, error) {
+	var simDoc simulatorissuer.Document
+	if err := json.Unmarshal(doc, &simDoc); err != nil {
+		return nil, fmt.Errorf("failed to parse simulator document: %w", err)
+	}
+	return &Report{
+		Format:   FormatSimulator,
+		UserData: "0x" + simDoc.UserData,
+		Nonce:    "0x" + simDoc.Nonce,
+	}, nil
+}
+
+func inspectSignedSimulator(doc []byte) (*Report, error) {
+	var simDoc simulatorissuer.SignedDocument
+	if err := json.Unmarshal(doc, &simDoc); err != nil {
+		return nil, fmt.Errorf("failed to parse simulator document: %w", err)
+	}
+	rawQuote, err := hex.DecodeString(simDoc.Quote)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode simulator quote: %w", err)
+	}
+	quote, err := azureissuer.ParseQuote(rawQuote)
+	if err != nil {
+		return nil, err
+	}
+	report := &Report{
+		Format:       FormatSimulator,
+		UserData:     "0x" + simDoc.UserData,
+		Quote:        quoteReport(quote),
+		Certificates: quoteCertificates(quote),
+	}
+	if simDoc.EventLog != nil {
+		report.Events, err = logEvents(simDoc.EventLog)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+func logEvents(attachment *eventlog.Attachment) ([]LogEvent, error) {
+	var events []LogEvent
+	if len(attachment.CCEL) > 0 {
+		parsed, err := eventlog.ParseCCEL(attachment.CCEL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse CCEL: %w", err)
+		}
+		for _, event := range parsed {
+			index, ok := event.RTMR()
+			if !ok {
+				continue
+			}
+			events = append(events, LogEvent{
+				Source:   "ccel",
+				Register: fmt.Sprintf("RTMR%d", index),
+				Type:     eventlog.EventTypeName(event.Type),
+				Digest:   hexEncode(event.Digest),
+			})
+		}
+	}
+	for _, event := range attachment.Runtime {
+		events = append(events, LogEvent{
+			Source:      "runtime",
+			Register:    fmt.Sprintf("RTMR%d", event.RTMR),
+			Digest:      hexEncode(event.Digest),
+			Description: event.Description,
+		})
+	}
+	return events, nil
+}
+
+func quoteReport(quote *tdx.QuoteV4) *QuoteReport {
+	report := &QuoteReport{}
+
+	if header := quote.Header; header != nil {
+		report.Header = QuoteHeader{
+			Version:            header.Version,
+			AttestationKeyType: header.AttestationKeyType,
+			TeeType:            header.TeeType,
+			QeSvn:              hexEncode(header.QeSvn),
+			PceSvn:             hexEncode(header.PceSvn),
+			QeVendorID:         hexEncode(header.QeVendorId),
+			UserData:           hexEncode(header.UserData),
+		}
+	}
+
+	if body := quote.TdQuoteBody; body != nil {
+		report.Body = TDBody{
+			TeeTcbSvn:        hexEncode(body.TeeTcbSvn),
+			MrSeam:           hexEncode(body.MrSeam),
+			MrSignerSeam:     hexEncode(body.MrSignerSeam),
+			SeamAttributes:   hexEncode(body.SeamAttributes),
+			TdAttributes:     hexEncode(body.TdAttributes),
+			TdAttributeFlags: tdAttributeFlags(body.TdAttributes),
+			Xfam:             hexEncode(body.Xfam),
+			MrTd:             hexEncode(body.MrTd),
+			MrConfigID:       hexEncode(body.MrConfigId),
+			MrOwner:          hexEncode(body.MrOwner),
+			MrOwnerConfig:    hexEncode(body.MrOwnerConfig),
+			ReportData:       hexEncode(body.ReportData),
+		}
+		for _, rtmr := range body.Rtmrs {
+			report.Body.Rtmrs = append(report.Body.Rtmrs, hexEncode(rtmr))
+		}
+	}
+
+	return report
+}
+
+// tdAttributeFlags names the set bits of the TD attributes that are defined
+// by the TDX module specification.
+func tdAttributeFlags(attributes []byte) []string {
+	if len(attributes) != 8 {
+		return nil
+	}
+	value := binary.LittleEndian.Uint64(attributes)
+
+	known := []struct {
+		bit  uint
+		name string
+	}{
+		{0, "DEBUG"},
+		{28, "SEPT_VE_DISABLE"},
+		{29, "MIGRATABLE"},
+		{30, "PKS"},
+		{31, "KL"},
+		{63, "PERFMON"},
+	}
+
+	flags := []string{}
+	for _, flag := range known {
+		if value&(1<<flag.bit) != 0 {
+			flags = append(flags, flag.name)
+		}
+	}
+	return flags
+}
+
+func quoteCertificates(quote *tdx.QuoteV4) []Certificate {
+	chain := quote.GetSignedData().GetCertificationData().GetQeReportCertificationData().GetPckCertificateChainData().GetPckCertChain()
+	if len(chain) == 0 {
+		return nil
+	}
+
+	var ders [][]byte
+	rest := chain
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		ders = append(ders, block.Bytes)
+	}
+	return derCertificates("pck", ders)
+}
+
+func derCertificates(chain string, ders [][]byte) []Certificate {
+	var certs []Certificate
+	for _, der := range ders {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			certs = append(certs, Certificate{Chain: chain, Subject: fmt.Sprintf("unparseable certificate: %v", err)})
+			continue
+		}
+		certs = append(certs, Certificate{
+			Chain:     chain,
+			Subject:   cert.Subject.String(),
+			Issuer:    cert.Issuer.String(),
+			Serial:    cert.SerialNumber.Text(16),
+			NotBefore: cert.NotBefore,
+			NotAfter:  cert.NotAfter,
+		})
+	}
+	return certs
+}
+
+func hexEncode(data []byte) string {
+	return "0x" + hex.EncodeToString(data)
+}
@@ -0,0 +1,279 @@
+package inspect
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-tpm-tools/proto/attest"
+	tpmproto "github.com/google/go-tpm-tools/proto/tpm"
+
+	"github.com/Hyodar/tdxs/pkg/batch"
+	"github.com/Hyodar/tdxs/pkg/eventlog"
+	azureissuer "github.com/Hyodar/tdxs/pkg/issuer/azure"
+	simulatorissuer "github.com/Hyodar/tdxs/pkg/issuer/simulator"
+	"github.com/Hyodar/tdxs/pkg/simulator"
+)
+
+var testUserData = []byte("tdxs inspect user data")
+
+// testQuote returns a TDX quote of the simulator authority over the default
+// TD.
+func testQuote(t *testing.T) []byte {
+	t.Helper()
+	authority, err := simulator.NewAuthority()
+	if err != nil {
+		t.Fatal(err)
+	}
+	td := simulator.DefaultTD()
+	quote, err := authority.Quote(&td, make([]byte, 64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return quote
+}
+
+// azureDocument wraps quote in an Azure document whose vTPM quote reports
+// PCR 4.
+func azureDocument(t *testing.T, quote []byte) []byte {
+	t.Helper()
+	instanceInfo, err := json.Marshal(&azureissuer.InstanceInfo{AttestationReport: quote})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// TPMS_ATTEST up to its extraData: magic, type, qualifiedSigner and
+	// extraData, the latter two as TPM2B.
+	binding := sha256.Sum256(testUserData)
+	var attested bytes.Buffer
+	binary.Write(&attested, binary.BigEndian, uint32(0xff544347))
+	binary.Write(&attested, binary.BigEndian, uint16(0x8018))
+	binary.Write(&attested, binary.BigEndian, uint16(0))
+	binary.Write(&attested, binary.BigEndian, uint16(len(binding)))
+	attested.Write(binding[:])
+
+	doc, err := json.Marshal(&azureissuer.Document{
+		Attestation: &attest.Attestation{Quotes: []*tpmproto.Quote{{
+			Quote: attested.Bytes(),
+			Pcrs:  &tpmproto.PCRs{Hash: tpmproto.HashAlgo_SHA256, Pcrs: map[uint32][]byte{4: bytes.Repeat([]byte{4}, 32)}},
+		}}},
+		InstanceInfo: instanceInfo,
+		UserData:     testUserData,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+// validatorFixture reads a document recorded for the tests of a validator.
+func validatorFixture(t *testing.T, validator string) []byte {
+	t.Helper()
+	doc, err := os.ReadFile(filepath.Join("..", "validator", validator, "testdata", "document.json"))
+	if err != nil {
+		t.Fatalf("failed to read fixture: %v", err)
+	}
+	return doc
+}
+
+func mustJSON(t *testing.T, v any) []byte {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestInspect(t *testing.T) {
+	quote := testQuote(t)
+	mrTd := hexEncode(simulator.DefaultTD().MrTd)
+	unsigned := mustJSON(t, &simulatorissuer.Document{UserData: hex.EncodeToString(testUserData), Nonce: "0102"})
+	tree, err := batch.NewTree([][]byte{batch.Leaf(testUserData, nil), batch.Leaf([]byte("other"), nil)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name     string
+		doc      []byte
+		format   Format
+		userData []byte // nil if the document has none
+		check    func(t *testing.T, report *Report)
+	}{
+		{
+			name:     "Azure",
+			doc:      azureDocument(t, quote),
+			format:   FormatAzure,
+			userData: testUserData,
+			check: func(t *testing.T, report *Report) {
+				if report.Quote == nil || report.Quote.Body.MrTd != mrTd {
+					t.Errorf("quote = %+v, want MRTD %s", report.Quote, mrTd)
+				}
+				if report.PCRs[4] != hexEncode(bytes.Repeat([]byte{4}, 32)) {
+					t.Errorf("PCRs = %v, want PCR 4", report.PCRs)
+				}
+			},
+		},
+		{
+			name:     "GCP",
+			doc:      validatorFixture(t, "gcp"),
+			format:   FormatGCP,
+			userData: []byte("tdxs gcp fixture user data"),
+			check: func(t *testing.T, report *Report) {
+				if report.Instance == nil || report.Instance.ProjectID != "tdxs-fixtures" {
+					t.Errorf("instance = %+v, want the fixture project", report.Instance)
+				}
+				if report.Quote == nil || len(report.PCRs) == 0 || !hasEvent(report, "tpm") || !hasCertificate(report, "vtpm-ak") {
+					t.Errorf("report = %+v, want the TDX quote, PCRs, vTPM events and AK certificate", report)
+				}
+			},
+		},
+		{
+			name:     "SNP",
+			doc:      validatorFixture(t, "snp"),
+			format:   FormatSNP,
+			userData: []byte("tdxs snp fixture user data"),
+			check: func(t *testing.T, report *Report) {
+				snp := report.SNPReport
+				if snp == nil || snp.Product != "Milan" || snp.SigningKey != "VCEK" || snp.GuestSVN != 2 {
+					t.Fatalf("SNP report = %+v, want the fixture report", snp)
+				}
+				if snp.ReportedTCB != (TCB{Bootloader: 3, SNP: 8, Microcode: 115}) {
+					t.Errorf("reported TCB = %+v, want the fixture TCB", snp.ReportedTCB)
+				}
+				if len(snp.PolicyFlags) != 1 || snp.PolicyFlags[0] != "SMT" {
+					t.Errorf("policy flags = %v, want SMT", snp.PolicyFlags)
+				}
+				if !hasCertificate(report, "amd") {
+					t.Errorf("certificates = %+v, want the AMD certificate table", report.Certificates)
+				}
+			},
+		},
+		{
+			name:   "TDXQuote",
+			doc:    quote,
+			format: FormatTDXQuote,
+			check: func(t *testing.T, report *Report) {
+				if report.Quote == nil || report.Quote.Body.MrTd != mrTd || len(report.Quote.Body.Rtmrs) != 4 {
+					t.Errorf("quote = %+v, want MRTD %s and four RTMRs", report.Quote, mrTd)
+				}
+				if !hasCertificate(report, "pck") {
+					t.Errorf("certificates = %+v, want the PCK chain", report.Certificates)
+				}
+			},
+		},
+		{
+			name:     "Simulator",
+			doc:      unsigned,
+			format:   FormatSimulator,
+			userData: testUserData,
+			check: func(t *testing.T, report *Report) {
+				if report.Nonce != "0x0102" || report.Quote != nil {
+					t.Errorf("report = %+v, want the nonce and no quote", report)
+				}
+			},
+		},
+		{
+			name: "SignedSimulator",
+			doc: mustJSON(t, &simulatorissuer.SignedDocument{
+				Quote:    hex.EncodeToString(quote),
+				UserData: hex.EncodeToString(testUserData),
+				EventLog: &eventlog.Attachment{Runtime: []eventlog.RuntimeEvent{
+					{Seq: 1, RTMR: 3, Digest: bytes.Repeat([]byte{3}, 48), Description: "app config"},
+				}},
+			}),
+			format:   FormatSimulator,
+			userData: testUserData,
+			check: func(t *testing.T, report *Report) {
+				if report.Quote == nil || report.Quote.Body.MrTd != mrTd {
+					t.Errorf("quote = %+v, want MRTD %s", report.Quote, mrTd)
+				}
+				if len(report.Events) != 1 || report.Events[0].Register != "RTMR3" || report.Events[0].Description != "app config" {
+					t.Errorf("events = %+v, want the runtime event", report.Events)
+				}
+			},
+		},
+		{
+			name:     "Batch",
+			doc:      mustJSON(t, batch.NewDocument(unsigned, tree, 1, []byte("other"))),
+			format:   FormatSimulator,
+			userData: []byte("other"),
+			check: func(t *testing.T, report *Report) {
+				if report.Batch == nil || report.Batch.Index != 1 || report.Batch.Size != 2 || len(report.Batch.Path) != 1 || report.Batch.Root != hexEncode(testUserData) {
+					t.Errorf("batch = %+v, want leaf 1 of 2 under the inner user data", report.Batch)
+				}
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			report, err := Inspect(tt.doc)
+			if err != nil {
+				t.Fatalf("Inspect failed: %v", err)
+			}
+			if report.Format != tt.format {
+				t.Errorf("format = %s, want %s", report.Format, tt.format)
+			}
+			want := ""
+			if tt.userData != nil {
+				want = hexEncode(tt.userData)
+			}
+			if report.UserData != want {
+				t.Errorf("user data = %q, want %q", report.UserData, want)
+			}
+			tt.check(t, report)
+		})
+	}
+}
+
+func TestInspectGarbage(t *testing.T) {
+	quote := testQuote(t)
+	nested := mustJSON(t, &batch.Document{
+		Batch:    batch.Proof{UserData: "00"},
+		Document: mustJSON(t, &batch.Document{Batch: batch.Proof{UserData: "00"}, Document: []byte(`{"userData":"00","nonce":"00"}`)}),
+	})
+
+	for _, tt := range []struct {
+		name string
+		doc  []byte
+	}{
+		{name: "Empty", doc: nil},
+		{name: "Text", doc: []byte("not an attestation document")},
+		{name: "TruncatedQuote", doc: quote[:len(quote)/2]},
+		{name: "InvalidJSON", doc: []byte(`{"userData":`)},
+		{name: "UnknownJSON", doc: []byte(`{"foo":"bar"}`)},
+		{name: "InvalidAzure", doc: []byte(`{"Attestation":null,"InstanceInfo":null}`)},
+		{name: "InvalidSNP", doc: []byte(`{"Report":"AAAA"}`)},
+		{name: "InvalidSignedSimulator", doc: []byte(`{"quote":"not hex","userData":"00"}`)},
+		{name: "NestedBatch", doc: nested},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if report, err := Inspect(tt.doc); err == nil {
+				t.Errorf("Inspect = %+v, want an error", report)
+			}
+		})
+	}
+}
+
+func hasEvent(report *Report, source string) bool {
+	for _, event := range report.Events {
+		if event.Source == source {
+			return true
+		}
+	}
+	return false
+}
+
+func hasCertificate(report *Report, chain string) bool {
+	for _, cert := range report.Certificates {
+		if cert.Chain == chain {
+			return true
+		}
+	}
+	return false
+}
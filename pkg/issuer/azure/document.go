@@ -0,0 +1,130 @@
+package azure
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/go-tdx-guest/abi"
+	"github.com/google/go-tdx-guest/proto/tdx"
+	"github.com/google/go-tpm-tools/proto/attest"
+	tpmproto "github.com/google/go-tpm-tools/proto/tpm"
+)
+
+// Document is an Azure TDX attestation document as produced by the issuer:
+// a vTPM attestation whose instance info carries the TDX quote.
+type Document struct {
+	Attestation  *attest.Attestation
+	InstanceInfo []byte
+	UserData     []byte
+}
+
+type InstanceInfo struct {
+	AttestationReport []byte
+	RuntimeData       []byte
+}
+
+// ParsedDocument holds the decoded parts of a Document.
+type ParsedDocument struct {
+	Document     *Document
+	InstanceInfo *InstanceInfo
+	Quote        *tdx.QuoteV4
+	PCRs         *tpmproto.PCRs // SHA-256 PCR bank of the vTPM quote
+	ExtraData    []byte         // qualifying data of the vTPM quote
+}
+
+// ParseDocument decodes an Azure TDX attestation document without verifying
+// it.
+func ParseDocument(doc []byte) (*ParsedDocument, error) {
+	var attDoc Document
+	if err := json.Unmarshal(doc, &attDoc); err != nil {
+		return nil, fmt.Errorf("unmarshal attestation document: %w", err)
+	}
+
+	if attDoc.Attestation == nil {
+		return nil, fmt.Errorf("attestation is nil")
+	}
+
+	var sha256Quote *tpmproto.Quote
+	for _, quote := range attDoc.Attestation.Quotes {
+		if quote.Pcrs == nil {
+			continue
+		}
+
+		if quote.Pcrs.Hash == tpmproto.HashAlgo_SHA256 {
+			sha256Quote = quote
+			break
+		}
+	}
+
+	if sha256Quote == nil {
+		return nil, fmt.Errorf("no SHA256 quote found")
+	}
+
+	var instanceInfo InstanceInfo
+	if err := json.Unmarshal(attDoc.InstanceInfo, &instanceInfo); err != nil {
+		return nil, fmt.Errorf("unmarshal instance info: %w", err)
+	}
+
+	quote, err := ParseQuote(instanceInfo.AttestationReport)
+	if err != nil {
+		return nil, err
+	}
+
+	extraData, err := parseTPMQuoteExtraData(sha256Quote.Quote)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ParsedDocument{
+		Document:     &attDoc,
+		InstanceInfo: &instanceInfo,
+		Quote:        quote,
+		PCRs:         sha256Quote.Pcrs,
+		ExtraData:    extraData,
+	}, nil
+}
+
+// parseTPMQuoteExtraData reads the extraData field of a TPMS_ATTEST
+// structure: magic, type, qualifiedSigner (TPM2B) and extraData (TPM2B).
+func parseTPMQuoteExtraData(attest []byte) ([]byte, error) {
+	const tpmGeneratedValue = 0xff544347
+
+	if len(attest) < 6 || binary.BigEndian.Uint32(attest) != tpmGeneratedValue {
+		return nil, fmt.Errorf("vTPM quote is not a TPM generated structure")
+	}
+	rest := attest[6:]
+
+	readTPM2B := func() ([]byte, error) {
+		if len(rest) < 2 {
+			return nil, fmt.Errorf("vTPM quote is truncated")
+		}
+		size := int(binary.BigEndian.Uint16(rest))
+		if len(rest) < 2+size {
+			return nil, fmt.Errorf("vTPM quote is truncated")
+		}
+		value := rest[2 : 2+size]
+		rest = rest[2+size:]
+		return value, nil
+	}
+
+	if _, err := readTPM2B(); err != nil { // qualifiedSigner
+		return nil, err
+	}
+	return readTPM2B()
+}
+
+// ParseQuote decodes a raw TDX v4 quote.
+func ParseQuote(raw []byte) (*tdx.QuoteV4, error) {
+	quotePb, err := abi.QuoteToProto(raw)
+	if err != nil {
+		return nil, fmt.Errorf("parse TDX quote: %w", err)
+	}
+
+	quote, ok := quotePb.(*tdx.QuoteV4)
+	if !ok {
+		return nil, fmt.Errorf("unexpected quote type: %T", quotePb)
+	}
+
+	return quote, nil
+}
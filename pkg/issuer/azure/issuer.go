@@ -5,23 +5,45 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"os"
+	"time"
 
 	azuretdx "github.com/Hyodar/tdxs/internal/constellation/attestation/azure/tdx"
-	"github.com/google/go-tdx-guest/abi"
-	"github.com/google/go-tdx-guest/proto/tdx"
-	"github.com/google/go-tpm-tools/proto/attest"
-	tpmproto "github.com/google/go-tpm-tools/proto/tpm"
 
 	"github.com/Hyodar/tdxs/pkg/api"
+	"github.com/Hyodar/tdxs/pkg/eventlog"
 	"github.com/Hyodar/tdxs/pkg/issuer"
 	"github.com/Hyodar/tdxs/pkg/logger"
+	"github.com/Hyodar/tdxs/pkg/registry"
 )
 
 type AzureIssuer struct {
 	issuer.Issuer
 
-	logger  logger.Logger
-	backend *azuretdx.Issuer
+	cfg      *AzureIssuerConfig
+	logger   logger.Logger
+	backend  *azuretdx.Issuer
+	metadata *metadataCache
+}
+
+type AzureIssuerConfig struct {
+	// EventLog attaches the vTPM event log to documents that do not carry
+	// one, so validators can replay it against the quoted PCRs.
+	EventLog     bool   `yaml:"event_log"`
+	EventLogPath string `yaml:"event_log_path"`
+	// MetadataRefresh is how long metadata is served from the cache before
+	// it is read again from a new attestation (default 5m).
+	MetadataRefresh time.Duration `yaml:"metadata_refresh"`
+}
+
+func (c *AzureIssuerConfig) Validate() error {
+	if c.EventLogPath != "" && !c.EventLog {
+		return fmt.Errorf("event_log_path requires event_log: true")
+	}
+	if c.MetadataRefresh < 0 {
+		return fmt.Errorf("metadata_refresh must not be negative")
+	}
+	return nil
 }
 
 type TDXMetadata struct {
@@ -37,127 +59,176 @@ type TDXMetadata struct {
 	PCRs map[uint32]string `json:"pcrs"` // Map of PCR index to hex value
 }
 
-func NewAzureIssuer(logger logger.Logger) *AzureIssuer {
-	return &AzureIssuer{
+func init() {
+	issuer.Register(issuer.IssuerTypeAzure, registry.WithConfig(func(cfg *AzureIssuerConfig, logger logger.Logger) (issuer.Issuer, error) {
+		return NewAzureIssuer(cfg, logger), nil
+	}))
+}
+
+func NewAzureIssuer(cfg *AzureIssuerConfig, logger logger.Logger) *AzureIssuer {
+	i := &AzureIssuer{
+		cfg:     cfg,
 		backend: azuretdx.NewIssuer(logger),
 		logger:  logger,
 	}
+	i.metadata = newMetadataCache(i.fetchMetadata, cfg.MetadataRefresh, logger)
+	return i
 }
 
-func (i *AzureIssuer) Start(_ context.Context) error {
+// Start refreshes the cached metadata in the background until ctx is done.
+func (i *AzureIssuer) Start(ctx context.Context) error {
+	go i.metadata.run(ctx)
 	return nil
 }
 
+// RTMRExtended marks the cached metadata stale after an RTMR extension.
+func (i *AzureIssuer) RTMRExtended(index int, value []byte) {
+	i.metadata.rtmrExtended(index, value)
+}
+
 func (i *AzureIssuer) Issue(ctx context.Context, req *api.IssueRequest) *api.IssueResponse {
 	doc, err := i.backend.Issue(ctx, req.UserData, req.Nonce)
 	if err != nil {
-		return &api.IssueResponse{Error: err}
+		return &api.IssueResponse{Error: backendError(ctx, err)}
+	}
+	if i.cfg.EventLog {
+		doc, err = i.attachEventLog(doc)
+		if err != nil {
+			return &api.IssueResponse{Error: api.NewError(api.ErrorCodeBackendUnavailable, err)}
+		}
 	}
 	return &api.IssueResponse{Document: doc}
 }
 
-func (i *AzureIssuer) Metadata(ctx context.Context, req *api.MetadataRequest) *api.MetadataResponse {
-	userData := []byte(issuer.MetadataUserData)
-	nonce := []byte(issuer.MetadataNonce)
-
-	doc, err := i.backend.Issue(ctx, userData, nonce)
-	if err != nil {
-		return &api.MetadataResponse{Error: err}
+// attachEventLog adds the TCG event log to a document that has none. The log
+// is not covered by the vTPM quote, so it can be added after the fact.
+func (i *AzureIssuer) attachEventLog(doc []byte) ([]byte, error) {
+	var attDoc Document
+	if err := json.Unmarshal(doc, &attDoc); err != nil {
+		return nil, fmt.Errorf("unmarshal attestation document: %w", err)
+	}
+	if attDoc.Attestation == nil || len(attDoc.Attestation.EventLog) > 0 {
+		return doc, nil
 	}
 
-	metadata, err := i.extractMetadata(doc)
+	path := i.cfg.EventLogPath
+	if path == "" {
+		path = eventlog.DefaultTPMLogPath
+	}
+	log, err := os.ReadFile(path)
 	if err != nil {
-		return &api.MetadataResponse{Error: fmt.Errorf("extract metadata: %w", err)}
+		return nil, fmt.Errorf("failed to read TPM event log: %w", err)
 	}
+	attDoc.Attestation.EventLog = log
 
-	return &api.MetadataResponse{
-		IssuerType: string(issuer.IssuerTypeAzure),
-		UserData:   userData,
-		Nonce:      nonce,
-		Metadata:   metadata,
+	doc, err = json.Marshal(&attDoc)
+	if err != nil {
+		return nil, fmt.Errorf("marshal attestation document: %w", err)
 	}
+	return doc, nil
 }
 
-func (i *AzureIssuer) extractMetadata(doc []byte) (*TDXMetadata, error) {
-	var attDoc struct {
-		Attestation  *attest.Attestation
-		InstanceInfo []byte
-		UserData     string
-	}
+// Metadata reads the metadata from an attestation. Requests with the default
+// user data and nonce are served from the cache; requests with the caller's
+// own values always get a new attestation.
+func (i *AzureIssuer) Metadata(ctx context.Context, req *api.MetadataRequest) *api.MetadataResponse {
+	userData, nonce, custom := issuer.MetadataBinding(req)
 
-	if err := json.Unmarshal(doc, &attDoc); err != nil {
-		return nil, fmt.Errorf("unmarshal attestation document: %w", err)
+	var metadata *TDXMetadata
+	var doc []byte
+	var err error
+	if custom {
+		metadata, doc, err = i.readMetadata(ctx, userData, nonce)
+	} else {
+		metadata, doc, err = i.metadata.get(ctx)
 	}
-
-	if attDoc.Attestation == nil {
-		return nil, fmt.Errorf("attestation is nil")
+	if err != nil {
+		return &api.MetadataResponse{Error: err}
 	}
 
-	var sha256Quote *tpmproto.Quote
-	for _, quote := range attDoc.Attestation.Quotes {
-		if quote.Pcrs == nil {
-			continue
-		}
-
-		if quote.Pcrs.Hash == tpmproto.HashAlgo_SHA256 {
-			sha256Quote = quote
-			break
+	response := &api.MetadataResponse{
+		IssuerType: string(issuer.IssuerTypeAzure),
+		UserData:   userData,
+		Nonce:      nonce,
+		Metadata:   metadata,
+	}
+	if req.IncludeDocument {
+		if i.cfg.EventLog {
+			doc, err = i.attachEventLog(doc)
+			if err != nil {
+				return &api.MetadataResponse{Error: api.NewError(api.ErrorCodeBackendUnavailable, err)}
+			}
 		}
+		response.Document = doc
 	}
+	return response
+}
 
-	if sha256Quote == nil {
-		return nil, fmt.Errorf("no SHA256 quote found")
-	}
+// fetchMetadata reads the metadata from a new attestation with the default
+// user data and nonce.
+func (i *AzureIssuer) fetchMetadata(ctx context.Context) (*TDXMetadata, []byte, error) {
+	return i.readMetadata(ctx, []byte(issuer.MetadataUserData), []byte(issuer.MetadataNonce))
+}
 
-	var instanceInfo struct {
-		AttestationReport []byte
-		RuntimeData       []byte
-	}
-	if err := json.Unmarshal(attDoc.InstanceInfo, &instanceInfo); err != nil {
-		return nil, err
+func (i *AzureIssuer) readMetadata(ctx context.Context, userData, nonce []byte) (*TDXMetadata, []byte, error) {
+	doc, err := i.backend.Issue(ctx, userData, nonce)
+	if err != nil {
+		return nil, nil, backendError(ctx, err)
 	}
 
-	quotePb, err := abi.QuoteToProto(instanceInfo.AttestationReport)
+	metadata, err := i.extractMetadata(doc)
 	if err != nil {
-		return nil, fmt.Errorf("parse TDX quote: %w", err)
+		return nil, nil, fmt.Errorf("extract metadata: %w", err)
 	}
+	return metadata, doc, nil
+}
 
-	quote, ok := quotePb.(*tdx.QuoteV4)
-	if !ok {
-		return nil, fmt.Errorf("unexpected quote type: %T", quotePb)
+func (i *AzureIssuer) extractMetadata(doc []byte) (*TDXMetadata, error) {
+	parsed, err := ParseDocument(doc)
+	if err != nil {
+		return nil, err
 	}
 
 	metadata := &TDXMetadata{
 		PCRs: make(map[uint32]string),
 	}
 
-	if quote.TdQuoteBody != nil {
-		metadata.XFAM = prefixedHexEncode(quote.TdQuoteBody.Xfam)
-		metadata.MrTd = prefixedHexEncode(quote.TdQuoteBody.MrTd)
-		metadata.MrOwner = prefixedHexEncode(quote.TdQuoteBody.MrOwner)
-		metadata.MrSeam = prefixedHexEncode(quote.TdQuoteBody.MrSeam)
+	if body := parsed.Quote.TdQuoteBody; body != nil {
+		metadata.XFAM = prefixedHexEncode(body.Xfam)
+		metadata.MrTd = prefixedHexEncode(body.MrTd)
+		metadata.MrOwner = prefixedHexEncode(body.MrOwner)
+		metadata.MrSeam = prefixedHexEncode(body.MrSeam)
 
-		if len(quote.TdQuoteBody.Rtmrs) > 0 {
-			metadata.Rtmr0 = prefixedHexEncode(quote.TdQuoteBody.Rtmrs[0])
+		if len(body.Rtmrs) > 0 {
+			metadata.Rtmr0 = prefixedHexEncode(body.Rtmrs[0])
 		}
-		if len(quote.TdQuoteBody.Rtmrs) > 1 {
-			metadata.Rtmr1 = prefixedHexEncode(quote.TdQuoteBody.Rtmrs[1])
+		if len(body.Rtmrs) > 1 {
+			metadata.Rtmr1 = prefixedHexEncode(body.Rtmrs[1])
 		}
-		if len(quote.TdQuoteBody.Rtmrs) > 2 {
-			metadata.Rtmr2 = prefixedHexEncode(quote.TdQuoteBody.Rtmrs[2])
+		if len(body.Rtmrs) > 2 {
+			metadata.Rtmr2 = prefixedHexEncode(body.Rtmrs[2])
 		}
-		if len(quote.TdQuoteBody.Rtmrs) > 3 {
-			metadata.Rtmr3 = prefixedHexEncode(quote.TdQuoteBody.Rtmrs[3])
+		if len(body.Rtmrs) > 3 {
+			metadata.Rtmr3 = prefixedHexEncode(body.Rtmrs[3])
 		}
 	}
 
-	for pcrIndex, pcrValue := range sha256Quote.Pcrs.Pcrs {
+	for pcrIndex, pcrValue := range parsed.PCRs.Pcrs {
 		metadata.PCRs[pcrIndex] = prefixedHexEncode(pcrValue)
 	}
 
 	return metadata, nil
 }
 
+// backendError categorizes a failure of the attestation backend, which
+// usually means the vTPM or the quote provider could not be reached.
+func backendError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return api.NewError(api.ErrorCodeTimeout, err)
+	}
+	return api.NewError(api.ErrorCodeBackendUnavailable, err)
+}
+
 func prefixedHexEncode(data []byte) string {
 	return "0x" + hex.EncodeToString(data)
 }
@@ -0,0 +1,193 @@
+package azure
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Hyodar/tdxs/pkg/logger"
+)
+
+const (
+	DefaultMetadataRefresh = 5 * time.Minute
+	metadataRefreshTimeout = 30 * time.Second
+)
+
+// metadataCache keeps the last metadata read from a full attestation, and
+// the attestation document, so metadata requests do not each need a quote.
+// It is refreshed when older than the refresh interval and after an RTMR is
+// extended through the daemon.
+// Measured values that change between refreshes without such an extension
+// are logged as unexpected.
+type metadataCache struct {
+	fetch    func(ctx context.Context) (*TDXMetadata, []byte, error)
+	interval time.Duration
+	logger   logger.Logger
+	now      func() time.Time
+
+	// refreshMu serializes refreshes, so concurrent requests for stale
+	// metadata share one attestation.
+	refreshMu sync.Mutex
+
+	mu                sync.Mutex
+	metadata          *TDXMetadata
+	document          []byte
+	fetched           time.Time
+	generation        uint64
+	fetchedGeneration uint64
+	extended          map[int]map[string]bool
+}
+
+func newMetadataCache(fetch func(ctx context.Context) (*TDXMetadata, []byte, error), interval time.Duration, logger logger.Logger) *metadataCache {
+	if interval == 0 {
+		interval = DefaultMetadataRefresh
+	}
+	return &metadataCache{
+		fetch:    fetch,
+		interval: interval,
+		logger:   logger,
+		now:      time.Now,
+	}
+}
+
+// get returns the cached metadata and document, refreshing them first if
+// they are stale.
+func (c *metadataCache) get(ctx context.Context) (*TDXMetadata, []byte, error) {
+	if metadata, document := c.current(); metadata != nil {
+		return metadata, document, nil
+	}
+
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+	if metadata, document := c.current(); metadata != nil {
+		return metadata, document, nil
+	}
+	return c.refresh(ctx)
+}
+
+// current returns the cached metadata and document if they are fresh.
+func (c *metadataCache) current() (*TDXMetadata, []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.metadata == nil || c.fetchedGeneration != c.generation || c.now().Sub(c.fetched) >= c.interval {
+		return nil, nil
+	}
+	return c.metadata, c.document
+}
+
+// refresh reads the metadata and compares it with the cached metadata. It
+// must be called with refreshMu held.
+func (c *metadataCache) refresh(ctx context.Context) (*TDXMetadata, []byte, error) {
+	c.mu.Lock()
+	generation := c.generation
+	c.mu.Unlock()
+
+	metadata, document, err := c.fetch(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.metadata != nil {
+		c.compare(c.metadata, metadata)
+	}
+	c.metadata = metadata
+	c.document = document
+	c.fetched = c.now()
+	c.fetchedGeneration = generation
+	// Extensions made while fetching may not be reflected yet, so their
+	// values stay expected until the next refresh.
+	if generation == c.generation {
+		c.extended = nil
+	}
+	return metadata, document, nil
+}
+
+// rtmrExtended records that an RTMR was extended to value, which marks the
+// cache stale and makes the new value expected.
+func (c *metadataCache) rtmrExtended(index int, value []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	if c.extended == nil {
+		c.extended = make(map[int]map[string]bool)
+	}
+	if c.extended[index] == nil {
+		c.extended[index] = make(map[string]bool)
+	}
+	c.extended[index][prefixedHexEncode(value)] = true
+}
+
+// compare logs every measured value that differs between old and current,
+// except RTMRs that now hold a value they were extended to.
+func (c *metadataCache) compare(old, current *TDXMetadata) {
+	type field struct {
+		name     string
+		old, new string
+		rtmr     int
+	}
+	fields := []field{
+		{"XFAM", old.XFAM, current.XFAM, -1},
+		{"MRTD", old.MrTd, current.MrTd, -1},
+		{"MROWNER", old.MrOwner, current.MrOwner, -1},
+		{"MRSEAM", old.MrSeam, current.MrSeam, -1},
+		{"RTMR0", old.Rtmr0, current.Rtmr0, 0},
+		{"RTMR1", old.Rtmr1, current.Rtmr1, 1},
+		{"RTMR2", old.Rtmr2, current.Rtmr2, 2},
+		{"RTMR3", old.Rtmr3, current.Rtmr3, 3},
+	}
+
+	indices := make(map[uint32]bool, len(current.PCRs))
+	for index := range old.PCRs {
+		indices[index] = true
+	}
+	for index := range current.PCRs {
+		indices[index] = true
+	}
+	sorted := make([]uint32, 0, len(indices))
+	for index := range indices {
+		sorted = append(sorted, index)
+	}
+	sort.Slice(sorted, func(a, b int) bool { return sorted[a] < sorted[b] })
+	for _, index := range sorted {
+		fields = append(fields, field{fmt.Sprintf("PCR%d", index), old.PCRs[index], current.PCRs[index], -1})
+	}
+
+	for _, f := range fields {
+		if f.old == f.new {
+			continue
+		}
+		if f.rtmr >= 0 && c.extended[f.rtmr][f.new] {
+			c.logger.Debug("RTMR changed by an extension", "register", f.name, "value", f.new)
+			continue
+		}
+		c.logger.Warn("Measured value changed unexpectedly", "register", f.name, "old", f.old, "new", f.new)
+	}
+}
+
+// run refreshes the metadata every interval until ctx is done, so unexpected
+// changes are noticed even when no metadata is requested.
+func (c *metadataCache) run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		c.refreshMu.Lock()
+		refreshCtx, cancel := context.WithTimeout(ctx, metadataRefreshTimeout)
+		_, _, err := c.refresh(refreshCtx)
+		cancel()
+		c.refreshMu.Unlock()
+		if err != nil && ctx.Err() == nil {
+			c.logger.Warn("Failed to refresh metadata", "error", err)
+		}
+	}
+}
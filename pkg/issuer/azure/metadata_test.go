@@ -0,0 +1,69 @@
+package azure
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetadataCache(t *testing.T) {
+	current := &TDXMetadata{
+		MrTd:  "0x01",
+		Rtmr2: "0x02",
+		PCRs:  map[uint32]string{4: "0x04"},
+	}
+	fetches := 0
+	fetch := func(context.Context) (*TDXMetadata, []byte, error) {
+		fetches++
+		copied := *current
+		return &copied, []byte("document"), nil
+	}
+	var logs bytes.Buffer
+	cache := newMetadataCache(fetch, time.Minute, slog.New(slog.NewTextHandler(&logs, nil)))
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+	get := func() *TDXMetadata {
+		t.Helper()
+		metadata, document, err := cache.get(context.Background())
+		if err != nil || string(document) != "document" {
+			t.Fatalf("failed to get metadata: %q, %v", document, err)
+		}
+		return metadata
+	}
+
+	get()
+	get()
+	if fetches != 1 {
+		t.Fatalf("fetched %d times, want 1", fetches)
+	}
+
+	// An extension makes the cache stale, and its value is not reported.
+	current.Rtmr2 = "0xaa"
+	cache.rtmrExtended(2, []byte{0xaa})
+	if metadata := get(); fetches != 2 || metadata.Rtmr2 != "0xaa" {
+		t.Fatalf("after extension: fetched %d times, RTMR2 = %s", fetches, metadata.Rtmr2)
+	}
+	if logs.Len() > 0 {
+		t.Errorf("extension logged as unexpected: %s", logs.String())
+	}
+
+	// Changes found by a refresh after the interval are reported.
+	current.Rtmr2 = "0xbb"
+	current.PCRs = map[uint32]string{4: "0x05"}
+	now = now.Add(time.Minute)
+	get()
+	if fetches != 3 {
+		t.Fatalf("fetched %d times after the interval, want 3", fetches)
+	}
+	for _, register := range []string{"RTMR2", "PCR4"} {
+		if !strings.Contains(logs.String(), "register="+register) {
+			t.Errorf("unexpected change of %s not logged: %s", register, logs.String())
+		}
+	}
+	if strings.Contains(logs.String(), "MRTD") {
+		t.Errorf("unchanged MRTD logged: %s", logs.String())
+	}
+}
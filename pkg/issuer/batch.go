@@ -0,0 +1,203 @@
+package issuer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Hyodar/tdxs/pkg/api"
+	"github.com/Hyodar/tdxs/pkg/batch"
+	"github.com/Hyodar/tdxs/pkg/logger"
+)
+
+const (
+	DefaultBatchWindow  = 10 * time.Millisecond
+	DefaultBatchMaxSize = 1024
+	DefaultBatchTimeout = 30 * time.Second
+)
+
+// BatchConfig enables batched issuance. Issue requests are collected for up
+// to Window, or until MaxSize requests are pending, and answered with a
+// single document over the Merkle root of their (userData, nonce) pairs.
+// Timeout bounds the call to the wrapped issuer, which is shared by the whole
+// batch and so outlives any one request.
+type BatchConfig struct {
+	Window  time.Duration `yaml:"window"`
+	MaxSize int           `yaml:"max_size"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+func (c *BatchConfig) Validate() error {
+	if c.Window < 0 {
+		return fmt.Errorf("window must not be negative")
+	}
+	if c.MaxSize < 0 {
+		return fmt.Errorf("max_size must not be negative")
+	}
+	if c.Timeout < 0 {
+		return fmt.Errorf("timeout must not be negative")
+	}
+	return nil
+}
+
+// Batcher is an Issuer that answers many Issue requests with one document of
+// the wrapped issuer. The document is issued with the Merkle root of the
+// batch as user data and no nonce, and returned to each caller as a
+// batch.Document with the inclusion proof of its own user data and nonce.
+// Metadata requests are passed through.
+type Batcher struct {
+	issuer  Issuer
+	window  time.Duration
+	maxSize int
+	timeout time.Duration
+	logger  logger.Logger
+
+	mu      sync.Mutex
+	pending *pendingBatch
+}
+
+type pendingBatch struct {
+	requests []*batchRequest
+	timer    *time.Timer
+}
+
+type batchRequest struct {
+	req      *api.IssueRequest
+	done     chan struct{}
+	response *api.IssueResponse
+}
+
+func NewBatcher(cfg *BatchConfig, issuer Issuer, logger logger.Logger) *Batcher {
+	b := &Batcher{
+		issuer:  issuer,
+		window:  cfg.Window,
+		maxSize: cfg.MaxSize,
+		timeout: cfg.Timeout,
+		logger:  logger,
+	}
+	if b.window == 0 {
+		b.window = DefaultBatchWindow
+	}
+	if b.maxSize == 0 {
+		b.maxSize = DefaultBatchMaxSize
+	}
+	if b.timeout == 0 {
+		b.timeout = DefaultBatchTimeout
+	}
+	return b
+}
+
+// Unwrap returns the batched issuer.
+func (b *Batcher) Unwrap() Issuer {
+	return b.issuer
+}
+
+// RTMRExtended passes the notification on to the batched issuer.
+func (b *Batcher) RTMRExtended(index int, value []byte) {
+	if observer, ok := b.issuer.(RTMRObserver); ok {
+		observer.RTMRExtended(index, value)
+	}
+}
+
+func (b *Batcher) Start(ctx context.Context) error {
+	return b.issuer.Start(ctx)
+}
+
+func (b *Batcher) Metadata(ctx context.Context, req *api.MetadataRequest) *api.MetadataResponse {
+	return b.issuer.Metadata(ctx, req)
+}
+
+func (b *Batcher) Issue(ctx context.Context, req *api.IssueRequest) *api.IssueResponse {
+	r := &batchRequest{req: req, done: make(chan struct{})}
+
+	b.mu.Lock()
+	pending := b.pending
+	if pending == nil {
+		pending = &pendingBatch{}
+		pending.timer = time.AfterFunc(b.window, func() { b.flush(ctx, pending) })
+		b.pending = pending
+	}
+	pending.requests = append(pending.requests, r)
+	full := len(pending.requests) >= b.maxSize
+	b.mu.Unlock()
+
+	if full {
+		pending.timer.Stop()
+		b.flush(ctx, pending)
+	}
+
+	select {
+	case <-r.done:
+		return r.response
+	case <-ctx.Done():
+		return &api.IssueResponse{Error: api.NewError(api.ErrorCodeTimeout, ctx.Err())}
+	}
+}
+
+// flush issues pending, unless it was already flushed. The document is
+// shared, so one caller going away must not cancel it for the others; issue
+// bounds it with its own timeout instead.
+func (b *Batcher) flush(ctx context.Context, pending *pendingBatch) {
+	b.mu.Lock()
+	if b.pending != pending {
+		b.mu.Unlock()
+		return
+	}
+	b.pending = nil
+	b.mu.Unlock()
+
+	go b.issue(context.WithoutCancel(ctx), pending.requests)
+}
+
+func (b *Batcher) issue(ctx context.Context, requests []*batchRequest) {
+	defer func() {
+		for _, r := range requests {
+			close(r.done)
+		}
+	}()
+	fail := func(err error) {
+		for _, r := range requests {
+			r.response = &api.IssueResponse{Error: err}
+		}
+	}
+
+	leaves := make([][]byte, len(requests))
+	for i, r := range requests {
+		leaves[i] = batch.Leaf(r.req.UserData, r.req.Nonce)
+	}
+	tree, err := batch.NewTree(leaves)
+	if err != nil {
+		fail(api.NewError(api.ErrorCodeInternal, err))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, b.timeout)
+	defer cancel()
+
+	// The batch is answered when the timeout expires, even if the wrapped
+	// issuer does not return.
+	result := make(chan *api.IssueResponse, 1)
+	go func() { result <- b.issuer.Issue(ctx, &api.IssueRequest{UserData: tree.Root()}) }()
+	var resp *api.IssueResponse
+	select {
+	case resp = <-result:
+	case <-ctx.Done():
+		resp = &api.IssueResponse{Error: api.NewError(api.ErrorCodeTimeout, fmt.Errorf("issuer did not respond in time: %w", ctx.Err()))}
+	}
+	if resp.Error != nil {
+		fail(resp.Error)
+		return
+	}
+	b.logger.Debug("Issued batched document", "size", len(requests))
+
+	for i, r := range requests {
+		document, err := json.Marshal(batch.NewDocument(resp.Document, tree, i, r.req.UserData))
+		if err != nil {
+			r.response = &api.IssueResponse{Error: api.NewError(api.ErrorCodeInternal, fmt.Errorf("failed to marshal batched document: %w", err))}
+			continue
+		}
+		r.response = &api.IssueResponse{Document: document}
+	}
+}
@@ -0,0 +1,223 @@
+package issuer
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Hyodar/tdxs/pkg/api"
+	"github.com/Hyodar/tdxs/pkg/logger"
+)
+
+const (
+	DefaultCacheTTL        = 30 * time.Second
+	DefaultCacheMaxEntries = 128
+	DefaultCacheTimeout    = 30 * time.Second
+)
+
+// CacheConfig enables caching of issued documents. Documents are cached per
+// (userData, nonce) for TTL; once MaxEntries are cached, the least recently
+// used one is evicted. Timeout bounds the call to the wrapped issuer, which
+// is shared by every waiting request and so outlives any one of them.
+type CacheConfig struct {
+	TTL        time.Duration `yaml:"ttl"`
+	MaxEntries int           `yaml:"max_entries"`
+	Timeout    time.Duration `yaml:"timeout"`
+}
+
+func (c *CacheConfig) Validate() error {
+	if c.TTL < 0 {
+		return fmt.Errorf("ttl must not be negative")
+	}
+	if c.MaxEntries < 0 {
+		return fmt.Errorf("max_entries must not be negative")
+	}
+	if c.Timeout < 0 {
+		return fmt.Errorf("timeout must not be negative")
+	}
+	return nil
+}
+
+// Cache is an Issuer that serves repeated Issue requests for the same user
+// data and nonce from a cache, and lets concurrent identical requests share a
+// single call to the wrapped issuer. Only successful responses are cached,
+// and the cache is emptied when an RTMR is extended. Metadata requests are
+// passed through.
+type Cache struct {
+	issuer     Issuer
+	ttl        time.Duration
+	maxEntries int
+	timeout    time.Duration
+	logger     logger.Logger
+	now        func() time.Time
+
+	mu       sync.Mutex
+	entries  map[cacheKey]*list.Element
+	lru      *list.List
+	inflight map[cacheKey]*cacheCall
+	stats    api.CacheStats
+}
+
+type cacheKey struct {
+	userData string
+	nonce    string
+}
+
+type cacheEntry struct {
+	key      cacheKey
+	response *api.IssueResponse
+	expires  time.Time
+}
+
+// cacheCall is an Issue call in progress, shared by every request for the
+// same key that arrives before it completes.
+type cacheCall struct {
+	done     chan struct{}
+	response *api.IssueResponse
+}
+
+func NewCache(cfg *CacheConfig, issuer Issuer, logger logger.Logger) *Cache {
+	c := &Cache{
+		issuer:     issuer,
+		ttl:        cfg.TTL,
+		maxEntries: cfg.MaxEntries,
+		timeout:    cfg.Timeout,
+		logger:     logger,
+		now:        time.Now,
+		entries:    make(map[cacheKey]*list.Element),
+		lru:        list.New(),
+		inflight:   make(map[cacheKey]*cacheCall),
+	}
+	if c.ttl == 0 {
+		c.ttl = DefaultCacheTTL
+	}
+	if c.maxEntries == 0 {
+		c.maxEntries = DefaultCacheMaxEntries
+	}
+	if c.timeout == 0 {
+		c.timeout = DefaultCacheTimeout
+	}
+	return c
+}
+
+// Unwrap returns the cached issuer.
+func (c *Cache) Unwrap() Issuer {
+	return c.issuer
+}
+
+// RTMRExtended empties the cache, since the cached documents quote the RTMR
+// before the extension, and passes the notification on to the cached issuer.
+// Calls in progress are not shared with later requests or cached.
+func (c *Cache) RTMRExtended(index int, value []byte) {
+	c.mu.Lock()
+	c.entries = make(map[cacheKey]*list.Element)
+	c.lru.Init()
+	c.inflight = make(map[cacheKey]*cacheCall)
+	c.mu.Unlock()
+
+	if observer, ok := c.issuer.(RTMRObserver); ok {
+		observer.RTMRExtended(index, value)
+	}
+}
+
+func (c *Cache) Start(ctx context.Context) error {
+	return c.issuer.Start(ctx)
+}
+
+func (c *Cache) Metadata(ctx context.Context, req *api.MetadataRequest) *api.MetadataResponse {
+	return c.issuer.Metadata(ctx, req)
+}
+
+func (c *Cache) Issue(ctx context.Context, req *api.IssueRequest) *api.IssueResponse {
+	key := cacheKey{userData: string(req.UserData), nonce: string(req.Nonce)}
+
+	c.mu.Lock()
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*cacheEntry)
+		if c.now().Before(entry.expires) {
+			c.lru.MoveToFront(elem)
+			c.stats.Hits++
+			c.mu.Unlock()
+			c.logger.Debug("Issue cache hit")
+			return entry.response
+		}
+		c.remove(elem)
+	}
+	call, shared := c.inflight[key]
+	if shared {
+		c.stats.Shared++
+	} else {
+		c.stats.Misses++
+		call = &cacheCall{done: make(chan struct{})}
+		c.inflight[key] = call
+	}
+	c.mu.Unlock()
+
+	if !shared {
+		// The call is shared, so one caller going away must not cancel it for
+		// the others. issue bounds it with its own timeout instead.
+		go c.issue(context.WithoutCancel(ctx), key, req, call)
+	} else {
+		c.logger.Debug("Issue request joined an identical request in progress")
+	}
+
+	select {
+	case <-call.done:
+		return call.response
+	case <-ctx.Done():
+		return &api.IssueResponse{Error: api.NewError(api.ErrorCodeTimeout, ctx.Err())}
+	}
+}
+
+func (c *Cache) issue(ctx context.Context, key cacheKey, req *api.IssueRequest, call *cacheCall) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	// The waiting requests are answered when the timeout expires, even if
+	// the wrapped issuer does not return.
+	result := make(chan *api.IssueResponse, 1)
+	go func() { result <- c.issuer.Issue(ctx, req) }()
+	select {
+	case call.response = <-result:
+	case <-ctx.Done():
+		call.response = &api.IssueResponse{Error: api.NewError(api.ErrorCodeTimeout, fmt.Errorf("issuer did not respond in time: %w", ctx.Err()))}
+	}
+
+	c.mu.Lock()
+	// An RTMR extension during the call replaced the in-flight calls, and the
+	// response may predate it.
+	current := c.inflight[key] == call
+	if current {
+		delete(c.inflight, key)
+	}
+	if current && call.response.Error == nil {
+		c.entries[key] = c.lru.PushFront(&cacheEntry{
+			key:      key,
+			response: call.response,
+			expires:  c.now().Add(c.ttl),
+		})
+		for c.lru.Len() > c.maxEntries {
+			c.remove(c.lru.Back())
+			c.stats.Evictions++
+		}
+	}
+	c.mu.Unlock()
+	close(call.done)
+}
+
+func (c *Cache) remove(elem *list.Element) {
+	delete(c.entries, elem.Value.(*cacheEntry).key)
+	c.lru.Remove(elem)
+}
+
+// Stats returns the cache counters and the number of cached documents,
+// including expired ones not yet evicted.
+func (c *Cache) Stats() api.CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Entries = c.lru.Len()
+	return stats
+}
@@ -0,0 +1,209 @@
+package issuer
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Hyodar/tdxs/pkg/api"
+)
+
+type countingIssuer struct {
+	calls   atomic.Int32
+	release chan struct{}
+	fail    bool
+}
+
+func (i *countingIssuer) Start(context.Context) error { return nil }
+
+func (i *countingIssuer) Issue(_ context.Context, req *api.IssueRequest) *api.IssueResponse {
+	n := i.calls.Add(1)
+	if i.release != nil {
+		<-i.release
+	}
+	if i.fail {
+		return &api.IssueResponse{Error: api.Errorf(api.ErrorCodeBackendUnavailable, "unavailable")}
+	}
+	return &api.IssueResponse{Document: fmt.Appendf(nil, "%s/%s/%d", req.UserData, req.Nonce, n)}
+}
+
+func (i *countingIssuer) Metadata(context.Context, *api.MetadataRequest) *api.MetadataResponse {
+	return &api.MetadataResponse{}
+}
+
+func TestCache(t *testing.T) {
+	inner := &countingIssuer{}
+	cache := NewCache(&CacheConfig{TTL: time.Minute, MaxEntries: 2}, inner, slog.New(slog.DiscardHandler))
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+	issue := func(userData, nonce string) string {
+		t.Helper()
+		resp := cache.Issue(context.Background(), &api.IssueRequest{UserData: []byte(userData), Nonce: []byte(nonce)})
+		if resp.Error != nil {
+			t.Fatalf("issue failed: %v", resp.Error)
+		}
+		return string(resp.Document)
+	}
+
+	first := issue("a", "1")
+	if again := issue("a", "1"); again != first {
+		t.Errorf("cached document = %s, want %s", again, first)
+	}
+	if other := issue("a", "2"); other == first {
+		t.Error("different nonce served from the cache")
+	}
+	if got := inner.calls.Load(); got != 2 {
+		t.Errorf("issuer called %d times, want 2", got)
+	}
+
+	// A third key evicts the least recently used one.
+	issue("b", "1")
+	if stats := cache.Stats(); stats.Evictions != 1 || stats.Entries != 2 {
+		t.Errorf("stats = %+v, want 1 eviction and 2 entries", stats)
+	}
+	if issue("a", "1") == first {
+		t.Error("evicted document served from the cache")
+	}
+
+	now = now.Add(2 * time.Minute)
+	issue("b", "1")
+	if stats := cache.Stats(); stats.Hits != 1 || stats.Misses != 5 {
+		t.Errorf("stats = %+v, want 1 hit and 5 misses", stats)
+	}
+}
+
+func TestCacheSharesConcurrentRequests(t *testing.T) {
+	inner := &countingIssuer{release: make(chan struct{})}
+	cache := NewCache(&CacheConfig{}, inner, slog.New(slog.DiscardHandler))
+	req := &api.IssueRequest{UserData: []byte("a"), Nonce: []byte("1")}
+
+	var wg sync.WaitGroup
+	documents := make([]string, 4)
+	for n := range documents {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			documents[n] = string(cache.Issue(context.Background(), req).Document)
+		}()
+	}
+	for cache.Stats().Misses+cache.Stats().Shared < uint64(len(documents)) {
+		time.Sleep(time.Millisecond)
+	}
+	close(inner.release)
+	wg.Wait()
+
+	if got := inner.calls.Load(); got != 1 {
+		t.Errorf("issuer called %d times, want 1", got)
+	}
+	for _, document := range documents {
+		if document != documents[0] || document == "" {
+			t.Errorf("documents = %q, want one shared document", documents)
+			break
+		}
+	}
+
+	// A caller that gives up does not wait for the call to complete.
+	inner.release = make(chan struct{})
+	defer close(inner.release)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if resp := cache.Issue(ctx, &api.IssueRequest{UserData: []byte("b")}); api.CodeOf(resp.Error) != api.ErrorCodeTimeout {
+		t.Errorf("error = %v, want timeout", resp.Error)
+	}
+}
+
+func TestCacheDoesNotCacheErrors(t *testing.T) {
+	inner := &countingIssuer{fail: true}
+	cache := NewCache(&CacheConfig{}, inner, slog.New(slog.DiscardHandler))
+	for range 2 {
+		if resp := cache.Issue(context.Background(), &api.IssueRequest{}); resp.Error == nil {
+			t.Fatal("issue succeeded")
+		}
+	}
+	if got := inner.calls.Load(); got != 2 {
+		t.Errorf("issuer called %d times, want 2", got)
+	}
+}
+
+type observingIssuer struct {
+	countingIssuer
+	extended []int
+}
+
+func (i *observingIssuer) RTMRExtended(index int, _ []byte) {
+	i.extended = append(i.extended, index)
+}
+
+func TestCacheRTMRExtended(t *testing.T) {
+	inner := &observingIssuer{}
+	batcher := NewBatcher(&BatchConfig{Window: time.Millisecond}, inner, slog.New(slog.DiscardHandler))
+	cache := NewCache(&CacheConfig{}, batcher, slog.New(slog.DiscardHandler))
+	req := &api.IssueRequest{UserData: []byte("a"), Nonce: []byte("1")}
+
+	first := cache.Issue(context.Background(), req)
+	if first.Error != nil {
+		t.Fatalf("issue failed: %v", first.Error)
+	}
+	cache.RTMRExtended(3, make([]byte, 48))
+	if stats := cache.Stats(); stats.Entries != 0 {
+		t.Errorf("stats = %+v, want the cache emptied", stats)
+	}
+	if len(inner.extended) != 1 || inner.extended[0] != 3 {
+		t.Errorf("wrapped issuer notified of %v, want RTMR 3 through the batcher", inner.extended)
+	}
+	if again := cache.Issue(context.Background(), req); string(again.Document) == string(first.Document) {
+		t.Error("document from before the extension served from the cache")
+	}
+
+	// A call in progress during the extension is answered but neither
+	// shared with later requests nor cached.
+	inner.release = make(chan struct{})
+	done := make(chan *api.IssueResponse)
+	go func() { done <- cache.Issue(context.Background(), &api.IssueRequest{UserData: []byte("b")}) }()
+	for inner.calls.Load() < 3 {
+		time.Sleep(time.Millisecond)
+	}
+	cache.RTMRExtended(3, make([]byte, 48))
+	close(inner.release)
+	if resp := <-done; resp.Error != nil {
+		t.Fatalf("issue failed: %v", resp.Error)
+	}
+	if stats := cache.Stats(); stats.Entries != 0 {
+		t.Errorf("stats = %+v, want the response from before the extension not cached", stats)
+	}
+}
+
+func TestCacheTimeout(t *testing.T) {
+	inner := &countingIssuer{release: make(chan struct{})}
+	defer close(inner.release)
+	cache := NewCache(&CacheConfig{Timeout: 20 * time.Millisecond}, inner, slog.New(slog.DiscardHandler))
+	req := &api.IssueRequest{UserData: []byte("a")}
+
+	// The wrapped issuer never returns, and the shared call gives up on its
+	// own although the caller waits indefinitely.
+	if resp := cache.Issue(context.Background(), req); api.CodeOf(resp.Error) != api.ErrorCodeTimeout {
+		t.Fatalf("error = %v, want timeout", resp.Error)
+	}
+	// The key is not held by the abandoned call.
+	cache.Issue(context.Background(), req)
+	if stats := cache.Stats(); stats.Misses != 2 || stats.Shared != 0 {
+		t.Errorf("stats = %+v, want a new call after the timeout", stats)
+	}
+}
+
+func TestBatcherTimeout(t *testing.T) {
+	inner := &countingIssuer{release: make(chan struct{})}
+	defer close(inner.release)
+	batcher := NewBatcher(&BatchConfig{Window: time.Millisecond, Timeout: 20 * time.Millisecond}, inner, slog.New(slog.DiscardHandler))
+
+	// The wrapped issuer never returns, and the batch gives up on its own
+	// although the caller waits indefinitely.
+	resp := batcher.Issue(context.Background(), &api.IssueRequest{UserData: []byte("a")})
+	if api.CodeOf(resp.Error) != api.ErrorCodeTimeout {
+		t.Errorf("error = %v, want timeout", resp.Error)
+	}
+}
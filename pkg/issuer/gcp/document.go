@@ -0,0 +1,197 @@
+package gcp
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/go-tdx-guest/abi"
+	"github.com/google/go-tdx-guest/proto/tdx"
+	"github.com/google/go-tpm-tools/client"
+	"github.com/google/go-tpm-tools/proto/attest"
+	tpmproto "github.com/google/go-tpm-tools/proto/tpm"
+	"github.com/google/go-tpm-tools/server"
+
+	"github.com/Hyodar/tdxs/pkg/eventlog"
+)
+
+// Document is a GCP Confidential VM attestation document: a vTPM attestation
+// with the AK certificate Google issued for the instance, and a TDX quote
+// whose REPORTDATA binds the same user data and nonce to the AK.
+type Document struct {
+	Attestation *attest.Attestation
+	Quote       []byte // raw TDX v4 quote
+	UserData    []byte
+	// EventLog holds the TD's CCEL and runtime event log, replayed against
+	// the quoted RTMRs.
+	EventLog *eventlog.Attachment `json:",omitempty"`
+}
+
+// QuoteFunc returns a raw TDX quote reporting reportData.
+type QuoteFunc func(reportData [abi.ReportDataSize]byte) ([]byte, error)
+
+// ExtraData is the qualifying data of the vTPM quote: sha256(userData ||
+// nonce), as in Constellation's vTPM documents.
+func ExtraData(userData, nonce []byte) []byte {
+	sum := sha256.Sum256(append(append([]byte{}, userData...), nonce...))
+	return sum[:]
+}
+
+// ReportData is the REPORTDATA of the TDX quote: the vTPM quote's extra data
+// followed by sha256 of the AK's PKIX encoding, so both quotes cover the same
+// request and the TD vouches for the vTPM.
+func ReportData(extraData []byte, ak crypto.PublicKey) ([abi.ReportDataSize]byte, error) {
+	var reportData [abi.ReportDataSize]byte
+	der, err := x509.MarshalPKIXPublicKey(ak)
+	if err != nil {
+		return reportData, fmt.Errorf("marshal attestation key: %w", err)
+	}
+	akHash := sha256.Sum256(der)
+	copy(reportData[:sha256.Size], extraData)
+	copy(reportData[sha256.Size:], akHash[:])
+	return reportData, nil
+}
+
+// NewDocument attests with ak and takes a TDX quote bound to it. opts sets
+// the event log and certificate chain fetcher; the nonces are set here.
+func NewDocument(ak *client.Key, opts client.AttestOpts, quote QuoteFunc, userData, nonce []byte) (*Document, error) {
+	extraData := ExtraData(userData, nonce)
+	reportData, err := ReportData(extraData, ak.PublicKey())
+	if err != nil {
+		return nil, err
+	}
+
+	device := &quoteDevice{quote: quote}
+	opts.Nonce = extraData
+	opts.TEEDevice = device
+	opts.TEENonce = reportData[:]
+	attestation, err := ak.Attest(opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to attest: %w", err)
+	}
+
+	return &Document{
+		Attestation: attestation,
+		Quote:       device.raw,
+		UserData:    userData,
+	}, nil
+}
+
+// quoteDevice takes the TDX quote during attestation and keeps it raw: the
+// attestation's quote field is a proto oneof, which does not round-trip
+// through JSON.
+type quoteDevice struct {
+	quote QuoteFunc
+	raw   []byte
+}
+
+func (d *quoteDevice) AddAttestation(_ *attest.Attestation, opts client.AttestOpts) error {
+	var reportData [abi.ReportDataSize]byte
+	copy(reportData[:], opts.TEENonce)
+	raw, err := d.quote(reportData)
+	if err != nil {
+		return fmt.Errorf("failed to get TDX quote: %w", err)
+	}
+	d.raw = raw
+	return nil
+}
+
+func (d *quoteDevice) Close() error {
+	return nil
+}
+
+// Instance is the GCE instance identity from the AK certificate.
+type Instance struct {
+	ProjectID     string `json:"projectId"`
+	ProjectNumber uint64 `json:"projectNumber"`
+	Zone          string `json:"zone"`
+	InstanceName  string `json:"instanceName"`
+	InstanceID    uint64 `json:"instanceId"`
+}
+
+// ParsedDocument holds the decoded parts of a Document.
+type ParsedDocument struct {
+	Document *Document
+	Quote    *tdx.QuoteV4
+	AKCert   *x509.Certificate
+	Instance *Instance      // nil if the AK certificate has no production instance identity
+	PCRs     *tpmproto.PCRs // SHA-256 PCR bank of the vTPM quote
+	TPMQuote *tpmproto.Quote
+}
+
+// ParseDocument decodes a GCP attestation document without verifying it.
+func ParseDocument(doc []byte) (*ParsedDocument, error) {
+	var attDoc Document
+	if err := json.Unmarshal(doc, &attDoc); err != nil {
+		return nil, fmt.Errorf("unmarshal attestation document: %w", err)
+	}
+	if attDoc.Attestation == nil {
+		return nil, fmt.Errorf("attestation is nil")
+	}
+	if len(attDoc.Quote) == 0 {
+		return nil, fmt.Errorf("document has no TDX quote")
+	}
+
+	var sha256Quote *tpmproto.Quote
+	for _, quote := range attDoc.Attestation.Quotes {
+		if quote.GetPcrs().GetHash() == tpmproto.HashAlgo_SHA256 {
+			sha256Quote = quote
+			break
+		}
+	}
+	if sha256Quote == nil {
+		return nil, fmt.Errorf("no SHA256 quote found")
+	}
+
+	if len(attDoc.Attestation.AkCert) == 0 {
+		return nil, fmt.Errorf("attestation has no AK certificate")
+	}
+	akCert, err := x509.ParseCertificate(attDoc.Attestation.AkCert)
+	if err != nil {
+		return nil, fmt.Errorf("parse AK certificate: %w", err)
+	}
+	info, err := server.GetGCEInstanceInfo(akCert)
+	if err != nil {
+		return nil, err
+	}
+
+	quote, err := ParseQuote(attDoc.Quote)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed := &ParsedDocument{
+		Document: &attDoc,
+		Quote:    quote,
+		AKCert:   akCert,
+		PCRs:     sha256Quote.Pcrs,
+		TPMQuote: sha256Quote,
+	}
+	if info != nil {
+		parsed.Instance = &Instance{
+			ProjectID:     info.GetProjectId(),
+			ProjectNumber: info.GetProjectNumber(),
+			Zone:          info.GetZone(),
+			InstanceName:  info.GetInstanceName(),
+			InstanceID:    info.GetInstanceId(),
+		}
+	}
+	return parsed, nil
+}
+
+// ParseQuote decodes a raw TDX v4 quote.
+func ParseQuote(raw []byte) (*tdx.QuoteV4, error) {
+	quotePb, err := abi.QuoteToProto(raw)
+	if err != nil {
+		return nil, fmt.Errorf("parse TDX quote: %w", err)
+	}
+
+	quote, ok := quotePb.(*tdx.QuoteV4)
+	if !ok {
+		return nil, fmt.Errorf("unexpected quote type: %T", quotePb)
+	}
+
+	return quote, nil
+}
@@ -0,0 +1,127 @@
+package gcp
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	tpmproto "github.com/google/go-tpm-tools/proto/tpm"
+)
+
+// The document recorded for the GCP validator tests.
+var (
+	fixtureUserData = []byte("tdxs gcp fixture user data")
+	fixtureNonce    = []byte("tdxs gcp fixture nonce")
+)
+
+func readFixture(t *testing.T) []byte {
+	t.Helper()
+	doc, err := os.ReadFile(filepath.Join("..", "..", "validator", "gcp", "testdata", "document.json"))
+	if err != nil {
+		t.Fatalf("failed to read fixture: %v", err)
+	}
+	return doc
+}
+
+func TestReportData(t *testing.T) {
+	userData := make([]byte, 2, 8)
+	copy(userData, "ab")
+	extraData := ExtraData(userData, []byte("nonce"))
+	if want := sha256.Sum256([]byte("abnonce")); !bytes.Equal(extraData, want[:]) {
+		t.Errorf("extra data = %x, want sha256(userData || nonce)", extraData)
+	}
+	if string(userData[:cap(userData)][2:7]) == "nonce" {
+		t.Error("ExtraData appended the nonce to the caller's user data")
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reportData, err := ReportData(extraData, key.Public())
+	if err != nil {
+		t.Fatalf("ReportData failed: %v", err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	akHash := sha256.Sum256(der)
+	if !bytes.Equal(reportData[:32], extraData) || !bytes.Equal(reportData[32:], akHash[:]) {
+		t.Errorf("report data = %x, want the extra data followed by the AK hash", reportData)
+	}
+
+	if _, err := ReportData(extraData, struct{}{}); err == nil {
+		t.Error("ReportData accepted an unsupported key")
+	}
+}
+
+func TestParseDocument(t *testing.T) {
+	parsed, err := ParseDocument(readFixture(t))
+	if err != nil {
+		t.Fatalf("ParseDocument failed: %v", err)
+	}
+	if !bytes.Equal(parsed.Document.UserData, fixtureUserData) {
+		t.Errorf("user data = %q, want %q", parsed.Document.UserData, fixtureUserData)
+	}
+	if parsed.PCRs.GetHash() != tpmproto.HashAlgo_SHA256 || parsed.Instance == nil {
+		t.Errorf("parsed = %+v, want the SHA-256 PCRs and the instance identity", parsed)
+	}
+
+	// The quotes bind the request to the AK, as built by NewDocument.
+	extraData := ExtraData(fixtureUserData, fixtureNonce)
+	want, err := ReportData(extraData, parsed.AKCert.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(parsed.Quote.GetTdQuoteBody().GetReportData(), want[:]) {
+		t.Errorf("REPORTDATA = %x, want %x", parsed.Quote.GetTdQuoteBody().GetReportData(), want)
+	}
+}
+
+func TestParseDocumentMalformed(t *testing.T) {
+	fixture := readFixture(t)
+	modified := func(modify func(doc *Document)) []byte {
+		t.Helper()
+		var doc Document
+		if err := json.Unmarshal(fixture, &doc); err != nil {
+			t.Fatal(err)
+		}
+		modify(&doc)
+		data, err := json.Marshal(&doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	for _, tt := range []struct {
+		name string
+		doc  []byte
+	}{
+		{name: "InvalidJSON", doc: []byte(`{"Attestation":`)},
+		{name: "NoAttestation", doc: modified(func(doc *Document) { doc.Attestation = nil })},
+		{name: "NoQuote", doc: modified(func(doc *Document) { doc.Quote = nil })},
+		{name: "TruncatedQuote", doc: modified(func(doc *Document) { doc.Quote = doc.Quote[:len(doc.Quote)/2] })},
+		{name: "NoSHA256Quote", doc: modified(func(doc *Document) {
+			for _, quote := range doc.Attestation.Quotes {
+				quote.Pcrs.Hash = tpmproto.HashAlgo_SHA1
+			}
+		})},
+		{name: "NoAKCert", doc: modified(func(doc *Document) { doc.Attestation.AkCert = nil })},
+		{name: "InvalidAKCert", doc: modified(func(doc *Document) { doc.Attestation.AkCert = []byte("not a certificate") })},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if parsed, err := ParseDocument(tt.doc); err == nil {
+				t.Errorf("ParseDocument = %+v, want an error", parsed)
+			}
+		})
+	}
+}
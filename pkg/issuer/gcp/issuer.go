@@ -0,0 +1,218 @@
+package gcp
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/google/go-tdx-guest/abi"
+	tdxclient "github.com/google/go-tdx-guest/client"
+	"github.com/google/go-tpm-tools/client"
+	"github.com/google/go-tpm/tpmutil"
+
+	"github.com/Hyodar/tdxs/pkg/api"
+	"github.com/Hyodar/tdxs/pkg/eventlog"
+	"github.com/Hyodar/tdxs/pkg/issuer"
+	"github.com/Hyodar/tdxs/pkg/logger"
+	"github.com/Hyodar/tdxs/pkg/registry"
+)
+
+// DefaultTPMPath is the vTPM device behind the kernel's resource manager.
+const DefaultTPMPath = "/dev/tpmrm0"
+
+type GCPIssuer struct {
+	issuer.Issuer
+
+	cfg        *GCPIssuerConfig
+	logger     logger.Logger
+	certClient *http.Client
+}
+
+type GCPIssuerConfig struct {
+	TPMPath string `yaml:"tpm_path"`
+	// TPMEventLogPath is where the vTPM event log is read from. It is always
+	// attached: the boot is only measured into the PCRs it replays to.
+	TPMEventLogPath string `yaml:"tpm_event_log_path"`
+	// EventLog attaches the TD's CCEL and the runtime event log, which
+	// validators replay against the quoted RTMRs.
+	EventLog eventlog.AttachConfig `yaml:"event_log"`
+}
+
+func (c *GCPIssuerConfig) Validate() error {
+	if err := c.EventLog.Validate(); err != nil {
+		return fmt.Errorf("event_log: %w", err)
+	}
+	return nil
+}
+
+type TDXMetadata struct {
+	XFAM         string `json:"xfam"`         // Extended features available mask (hex)
+	MrTd         string `json:"mrtd"`         // Measurement of initial TD contents (hex)
+	MrSeam       string `json:"mrseam"`       // Measurement of TDX Module (hex)
+	TDAttributes string `json:"tdattributes"` // TD attributes (hex)
+	Rtmr0        string `json:"rtmr0"`        // Runtime measurement register 0 (hex)
+	Rtmr1        string `json:"rtmr1"`        // Runtime measurement register 1 (hex)
+	Rtmr2        string `json:"rtmr2"`        // Runtime measurement register 2 (hex)
+	Rtmr3        string `json:"rtmr3"`        // Runtime measurement register 3 (hex)
+
+	PCRs     map[uint32]string `json:"pcrs"`               // Map of PCR index to hex value
+	Instance *Instance         `json:"instance,omitempty"` // Instance identity from the AK certificate
+}
+
+func init() {
+	issuer.Register(issuer.IssuerTypeGCP, registry.WithConfig(func(cfg *GCPIssuerConfig, logger logger.Logger) (issuer.Issuer, error) {
+		return NewGCPIssuer(cfg, logger), nil
+	}))
+}
+
+func NewGCPIssuer(cfg *GCPIssuerConfig, logger logger.Logger) *GCPIssuer {
+	return &GCPIssuer{
+		cfg:        cfg,
+		logger:     logger,
+		certClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (i *GCPIssuer) Start(_ context.Context) error {
+	return nil
+}
+
+func (i *GCPIssuer) Issue(ctx context.Context, req *api.IssueRequest) *api.IssueResponse {
+	doc, err := i.issue(req.UserData, req.Nonce)
+	if err != nil {
+		return &api.IssueResponse{Error: backendError(ctx, err)}
+	}
+	return &api.IssueResponse{Document: doc}
+}
+
+// issue attests with the vTPM's Google-certified AK and takes a TDX quote
+// bound to it.
+func (i *GCPIssuer) issue(userData, nonce []byte) ([]byte, error) {
+	tpmPath := i.cfg.TPMPath
+	if tpmPath == "" {
+		tpmPath = DefaultTPMPath
+	}
+	tpm, err := tpmutil.OpenTPM(tpmPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open vTPM: %w", err)
+	}
+	defer tpm.Close()
+
+	ak, err := client.GceAttestationKeyRSA(tpm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load attestation key: %w", err)
+	}
+	defer ak.Close()
+
+	logPath := i.cfg.TPMEventLogPath
+	if logPath == "" {
+		logPath = eventlog.DefaultTPMLogPath
+	}
+	tpmLog, err := os.ReadFile(logPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read TPM event log: %w", err)
+	}
+
+	opts := client.AttestOpts{TCGEventLog: tpmLog, CertChainFetcher: i.certClient}
+	doc, err := NewDocument(ak, opts, rawQuote, userData, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	if i.cfg.EventLog.Enabled() {
+		quote, err := ParseQuote(doc.Quote)
+		if err != nil {
+			return nil, err
+		}
+		doc.EventLog, err = i.cfg.EventLog.Collect(quote.TdQuoteBody.Rtmrs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return json.Marshal(doc)
+}
+
+// rawQuote gets a quote through configfs-tsm, falling back to the TDX guest
+// device.
+func rawQuote(reportData [abi.ReportDataSize]byte) ([]byte, error) {
+	quoteProvider, err := tdxclient.GetQuoteProvider()
+	if err != nil {
+		return nil, err
+	}
+	return tdxclient.GetRawQuote(quoteProvider, reportData)
+}
+
+// Metadata reads the metadata from a new attestation.
+func (i *GCPIssuer) Metadata(ctx context.Context, req *api.MetadataRequest) *api.MetadataResponse {
+	userData, nonce, _ := issuer.MetadataBinding(req)
+
+	doc, err := i.issue(userData, nonce)
+	if err != nil {
+		return &api.MetadataResponse{Error: backendError(ctx, err)}
+	}
+	metadata, err := extractMetadata(doc)
+	if err != nil {
+		return &api.MetadataResponse{Error: fmt.Errorf("extract metadata: %w", err)}
+	}
+
+	response := &api.MetadataResponse{
+		IssuerType: string(issuer.IssuerTypeGCP),
+		UserData:   userData,
+		Nonce:      nonce,
+		Metadata:   metadata,
+	}
+	if req.IncludeDocument {
+		response.Document = doc
+	}
+	return response
+}
+
+func extractMetadata(doc []byte) (*TDXMetadata, error) {
+	parsed, err := ParseDocument(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := &TDXMetadata{
+		PCRs:     make(map[uint32]string),
+		Instance: parsed.Instance,
+	}
+
+	if body := parsed.Quote.TdQuoteBody; body != nil {
+		metadata.XFAM = prefixedHexEncode(body.Xfam)
+		metadata.MrTd = prefixedHexEncode(body.MrTd)
+		metadata.MrSeam = prefixedHexEncode(body.MrSeam)
+		metadata.TDAttributes = prefixedHexEncode(body.TdAttributes)
+
+		if len(body.Rtmrs) == 4 {
+			metadata.Rtmr0 = prefixedHexEncode(body.Rtmrs[0])
+			metadata.Rtmr1 = prefixedHexEncode(body.Rtmrs[1])
+			metadata.Rtmr2 = prefixedHexEncode(body.Rtmrs[2])
+			metadata.Rtmr3 = prefixedHexEncode(body.Rtmrs[3])
+		}
+	}
+
+	for pcrIndex, pcrValue := range parsed.PCRs.GetPcrs() {
+		metadata.PCRs[pcrIndex] = prefixedHexEncode(pcrValue)
+	}
+
+	return metadata, nil
+}
+
+// backendError categorizes a failure of the attestation backend, which
+// usually means the vTPM or the quote provider could not be reached.
+func backendError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return api.NewError(api.ErrorCodeTimeout, err)
+	}
+	return api.NewError(api.ErrorCodeBackendUnavailable, err)
+}
+
+func prefixedHexEncode(data []byte) string {
+	return "0x" + hex.EncodeToString(data)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"github.com/Hyodar/tdxs/pkg/api"
+	"github.com/Hyodar/tdxs/pkg/registry"
 )
 
 type Issuer interface {
@@ -12,10 +13,18 @@ type Issuer interface {
 	Metadata(ctx context.Context, req *api.MetadataRequest) *api.MetadataResponse
 }
 
+// RTMRObserver is implemented by issuers that cache measurements or
+// documents and need to know when an RTMR is extended through the daemon.
+type RTMRObserver interface {
+	RTMRExtended(index int, value []byte)
+}
+
 type IssuerType string
 
 const (
 	IssuerTypeAzure     IssuerType = "azure"
+	IssuerTypeGCP       IssuerType = "gcp"
+	IssuerTypeSNP       IssuerType = "snp"
 	IssuerTypeSimulator IssuerType = "simulator"
 )
 
@@ -23,3 +32,20 @@ const (
 	MetadataUserData = "userData"
 	MetadataNonce    = "nonce"
 )
+
+// MetadataBinding returns the user data and nonce to read metadata with: the
+// caller's, or MetadataUserData and MetadataNonce if the caller set neither.
+func MetadataBinding(req *api.MetadataRequest) (userData []byte, nonce []byte, custom bool) {
+	if len(req.UserData) == 0 && len(req.Nonce) == 0 {
+		return []byte(MetadataUserData), []byte(MetadataNonce), false
+	}
+	return req.UserData, req.Nonce, true
+}
+
+// Registry holds the issuer types available to the manager. Issuer packages
+// register themselves from init.
+var Registry = registry.New[Issuer]("issuer")
+
+func Register(t IssuerType, entry registry.Entry[Issuer]) {
+	Registry.Register(string(t), entry)
+}
@@ -0,0 +1,60 @@
+package azure
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"encoding/binary"
+	"unicode/utf16"
+
+	"github.com/Hyodar/tdxs/pkg/eventlog"
+)
+
+// efiGlobalVariable is EFI_GLOBAL_VARIABLE (8be4df61-93ca-11d2-aa0d-00e098032b8c)
+// in its in-memory byte order.
+var efiGlobalVariable = []byte{0x61, 0xdf, 0xe4, 0x8b, 0xca, 0x93, 0xd2, 0x11, 0xaa, 0x0d, 0x00, 0xe0, 0x98, 0x03, 0x2b, 0x8c}
+
+// SimulatorKernelCmdline is the kernel command line in the simulated
+// firmware event log.
+const SimulatorKernelCmdline = "console=ttyS0 root=/dev/vda1 ro"
+
+// firmwareEvents returns the simulated firmware event log of a TD booting a
+// kernel with Secure Boot enabled: the SecureBoot variable in RTMR0, the
+// kernel image in RTMR1 and the kernel command line in RTMR2.
+func firmwareEvents() []eventlog.Event {
+	event := func(index uint32, eventType uint32, data []byte) eventlog.Event {
+		sum := sha512.Sum384(data)
+		return eventlog.Event{MRIndex: index, Type: eventType, Digest: sum[:], Data: data}
+	}
+	separator := []byte{0, 0, 0, 0}
+	kernel := event(2, eventlog.EventTypeEFIBootServicesApplication, []byte("tdxs simulator kernel"))
+	kernel.Digest = measurement("kernel image")
+	// GRUB measures the command line without its prefix and terminator.
+	cmdline := event(3, eventlog.EventTypeIPL, []byte("kernel_cmdline: "+SimulatorKernelCmdline+"\x00"))
+	sum := sha512.Sum384([]byte(SimulatorKernelCmdline))
+	cmdline.Digest = sum[:]
+
+	return []eventlog.Event{
+		event(1, eventlog.EventTypeEFIVariableDriverConfig, uefiVariable(efiGlobalVariable, "SecureBoot", []byte{1})),
+		event(1, eventlog.EventTypeSeparator, separator),
+		kernel,
+		event(2, eventlog.EventTypeSeparator, separator),
+		cmdline,
+	}
+}
+
+func measurement(label string) []byte {
+	sum := sha512.Sum384([]byte("tdxs simulator " + label))
+	return sum[:]
+}
+
+// uefiVariable encodes UEFI_VARIABLE_DATA.
+func uefiVariable(guid []byte, name string, data []byte) []byte {
+	var buf bytes.Buffer
+	buf.Write(guid)
+	units := utf16.Encode([]rune(name))
+	binary.Write(&buf, binary.LittleEndian, uint64(len(units)))
+	binary.Write(&buf, binary.LittleEndian, uint64(len(data)))
+	binary.Write(&buf, binary.LittleEndian, units)
+	buf.Write(data)
+	return buf.Bytes()
+}
@@ -4,57 +4,276 @@ import (
 	"context"
 	"encoding/hex"
 	"encoding/json"
+	"fmt"
 
 	"github.com/Hyodar/tdxs/pkg/api"
+	"github.com/Hyodar/tdxs/pkg/eventlog"
 	"github.com/Hyodar/tdxs/pkg/issuer"
 	"github.com/Hyodar/tdxs/pkg/logger"
+	"github.com/Hyodar/tdxs/pkg/registry"
+	"github.com/Hyodar/tdxs/pkg/simulator"
+	"github.com/Hyodar/tdxs/pkg/tdx"
 )
 
 type SimulatorIssuer struct {
 	issuer.Issuer
 
-	logger logger.Logger
+	logger    logger.Logger
+	authority *simulator.Authority
+	td        tdx.TD
+	eventLog  eventlog.AttachConfig
+	faults    *simulator.Injector
 }
 
-func NewSimulatorIssuer(logger logger.Logger) *SimulatorIssuer {
-	return &SimulatorIssuer{
+type SimulatorIssuerConfig struct {
+	// Signed switches from unsigned JSON documents to TDX quotes signed by
+	// the simulator CA.
+	Signed bool   `yaml:"signed"`
+	CAFile string `yaml:"ca_file"`
+
+	// TD values reported in signed quotes. Unset fields take defaults.
+	tdx.TD `yaml:",inline"`
+
+	// EventLog attaches a simulated CCEL (ccel: true) and the runtime event
+	// log to signed documents. The RTMRs are then computed by replaying the
+	// logs instead of being configured.
+	EventLog eventlog.AttachConfig `yaml:"event_log"`
+
+	Faults simulator.Faults `yaml:"faults"`
+}
+
+func (c *SimulatorIssuerConfig) Validate() error {
+	if !c.Signed && (c.CAFile != "" || !c.TD.IsZero() || c.EventLog.Enabled()) {
+		return fmt.Errorf("ca_file, TD values and event_log require signed: true")
+	}
+	if err := c.TD.Validate(); err != nil {
+		return err
+	}
+	if err := checkEventLog(&c.EventLog, &c.TD); err != nil {
+		return fmt.Errorf("event_log: %w", err)
+	}
+	if err := checkFaults(&c.Faults, c.Signed); err != nil {
+		return fmt.Errorf("faults: %w", err)
+	}
+	return nil
+}
+
+// checkFaults rejects faults the issuer cannot inject.
+func checkFaults(faults *simulator.Faults, signed bool) error {
+	if err := faults.Validate(); err != nil {
+		return err
+	}
+	if faults.ErrorRates[simulator.MethodValidate] != 0 || faults.ExpiredCollateral != 0 {
+		return fmt.Errorf("validate error rate and expired_collateral apply to the validator")
+	}
+	if !signed && (faults.CorruptSignature != 0 || faults.StaleTCB != 0) {
+		return fmt.Errorf("corrupt_signature and stale_tcb require signed: true")
+	}
+	return nil
+}
+
+func checkEventLog(cfg *eventlog.AttachConfig, td *tdx.TD) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	if cfg.CCELPath != "" {
+		return fmt.Errorf("ccel_path is not supported, the simulator attaches a simulated CCEL")
+	}
+	if cfg.Enabled() && (td.Rtmr0 != nil || td.Rtmr1 != nil || td.Rtmr2 != nil || td.Rtmr3 != nil) {
+		return fmt.Errorf("rtmr0-rtmr3 must not be set, they are computed from the event log")
+	}
+	return nil
+}
+
+func init() {
+	issuer.Register(issuer.IssuerTypeSimulator, registry.WithConfig(func(cfg *SimulatorIssuerConfig, logger logger.Logger) (issuer.Issuer, error) {
+		return NewSimulatorIssuer(cfg, logger)
+	}))
+}
+
+func NewSimulatorIssuer(cfg *SimulatorIssuerConfig, logger logger.Logger) (*SimulatorIssuer, error) {
+	if cfg == nil {
+		cfg = &SimulatorIssuerConfig{}
+	}
+	if err := checkFaults(&cfg.Faults, cfg.Signed); err != nil {
+		return nil, fmt.Errorf("invalid faults: %w", err)
+	}
+
+	i := &SimulatorIssuer{
 		logger: logger,
+		faults: simulator.NewInjector(cfg.Faults),
+	}
+	if !cfg.Signed {
+		return i, nil
+	}
+
+	authority, err := simulator.OpenAuthority(cfg.CAFile)
+	if err != nil {
+		return nil, err
 	}
+	i.authority = authority
+	i.td = cfg.TD.WithDefaults(simulator.DefaultTD())
+	i.eventLog = cfg.EventLog
+	return i, nil
 }
 
 func (i *SimulatorIssuer) Start(_ context.Context) error {
 	return nil
 }
 
-func (i *SimulatorIssuer) Issue(ctx context.Context, req *api.IssueRequest) *api.IssueResponse {
-	type Document struct {
-		UserData string `json:"userData"`
-		Nonce    string `json:"nonce"`
-	}
+// Document is the unsigned document produced by the simulator issuer.
+type Document struct {
+	UserData string `json:"userData"`
+	Nonce    string `json:"nonce"`
+}
+
+// SignedDocument is produced by the simulator issuer in signed mode. Quote is
+// a hex-encoded TDX v4 quote whose REPORTDATA is simulator.ReportData of the
+// user data and the nonce.
+type SignedDocument struct {
+	Quote    string               `json:"quote"`
+	UserData string               `json:"userData"`
+	EventLog *eventlog.Attachment `json:"eventLog,omitempty"`
+}
 
-	doc := Document{
-		UserData: hex.EncodeToString(req.UserData),
-		Nonce:    hex.EncodeToString(req.Nonce),
+func (i *SimulatorIssuer) Issue(ctx context.Context, req *api.IssueRequest) *api.IssueResponse {
+	if err := i.injectError(ctx, simulator.MethodIssue); err != nil {
+		return &api.IssueResponse{Error: err}
 	}
 
-	jsonDoc, err := json.Marshal(doc)
+	doc, err := i.document(req.UserData, req.Nonce)
 	if err != nil {
 		return &api.IssueResponse{Error: err}
 	}
+	return &api.IssueResponse{Document: doc}
+}
+
+// document creates a document over userData and nonce, with the configured
+// faults applied.
+func (i *SimulatorIssuer) document(userData, nonce []byte) ([]byte, error) {
+	if i.faults.WrongNonce() {
+		nonce = append([]byte("wrong nonce "), nonce...)
+	}
+
+	var doc any = Document{
+		UserData: hex.EncodeToString(userData),
+		Nonce:    hex.EncodeToString(nonce),
+	}
+	if i.authority != nil {
+		td, attachment, err := i.currentTD()
+		if err != nil {
+			return nil, err
+		}
+		if i.faults.StaleTCB() {
+			td.TeeTCBSVN = simulator.OutOfDateTeeTCBSVN()
+		}
+		quote, err := i.authority.Quote(&td, simulator.ReportData(userData, nonce))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create quote: %w", err)
+		}
+		if i.faults.CorruptSignature() {
+			simulator.CorruptSignature(quote)
+		}
+		doc = SignedDocument{
+			Quote:    hex.EncodeToString(quote),
+			UserData: hex.EncodeToString(userData),
+			EventLog: attachment,
+		}
+	}
 
-	return &api.IssueResponse{Document: jsonDoc}
+	return json.Marshal(doc)
 }
 
 func (i *SimulatorIssuer) Metadata(ctx context.Context, req *api.MetadataRequest) *api.MetadataResponse {
-	userData := []byte(issuer.MetadataUserData)
-	nonce := []byte(issuer.MetadataNonce)
+	if err := i.injectError(ctx, simulator.MethodMetadata); err != nil {
+		return &api.MetadataResponse{Error: err}
+	}
 
-	return &api.MetadataResponse{
+	userData, nonce, _ := issuer.MetadataBinding(req)
+
+	metadata := map[string]string{
+		"simulator": "true",
+	}
+	if i.authority != nil {
+		td, _, err := i.currentTD()
+		if err != nil {
+			return &api.MetadataResponse{Error: err}
+		}
+		metadata["signed"] = "true"
+		metadata["mrtd"] = hex.EncodeToString(td.MrTd)
+		for index, rtmr := range td.Rtmrs() {
+			metadata[fmt.Sprintf("rtmr%d", index)] = hex.EncodeToString(rtmr)
+		}
+		metadata["mrseam"] = hex.EncodeToString(td.MrSeam)
+		metadata["xfam"] = hex.EncodeToString(td.XFAM)
+		metadata["tdAttributes"] = hex.EncodeToString(td.TDAttributes)
+		metadata["teeTcbSvn"] = hex.EncodeToString(td.TeeTCBSVN)
+	}
+
+	response := &api.MetadataResponse{
 		IssuerType: string(issuer.IssuerTypeSimulator),
 		UserData:   userData,
 		Nonce:      nonce,
-		Metadata: map[string]string{
-			"simulator": "true",
-		},
+		Metadata:   metadata,
 	}
+	if req.IncludeDocument {
+		doc, err := i.document(userData, nonce)
+		if err != nil {
+			return &api.MetadataResponse{Error: err}
+		}
+		response.Document = doc
+	}
+	return response
+}
+
+// currentTD returns the TD values to quote and, if event logs are attached,
+// the logs the RTMRs were computed from.
+func (i *SimulatorIssuer) currentTD() (tdx.TD, *eventlog.Attachment, error) {
+	td := i.td
+	if !i.eventLog.Enabled() {
+		return td, nil, nil
+	}
+
+	attachment := &eventlog.Attachment{}
+	var events []eventlog.Event
+	if i.eventLog.CCEL {
+		events = firmwareEvents()
+		attachment.CCEL = eventlog.MarshalCCEL(events)
+	}
+	if i.eventLog.RuntimeLog != "" {
+		runtime, err := (&eventlog.AttachConfig{RuntimeLog: i.eventLog.RuntimeLog}).Collect(nil)
+		if err != nil {
+			return td, nil, api.NewError(api.ErrorCodeBackendUnavailable, err)
+		}
+		attachment.Runtime = runtime.Runtime
+	}
+
+	values, _ := eventlog.Replay(events, attachment.Runtime)
+	td.Rtmr0, td.Rtmr1, td.Rtmr2, td.Rtmr3 = values[0], values[1], values[2], values[3]
+	return td, attachment, nil
+}
+
+func (i *SimulatorIssuer) Faults() simulator.Faults {
+	return i.faults.Faults()
+}
+
+func (i *SimulatorIssuer) SetFaults(faults simulator.Faults) error {
+	if err := checkFaults(&faults, i.authority != nil); err != nil {
+		return api.NewError(api.ErrorCodeBadRequest, err)
+	}
+	i.faults.Set(faults)
+	i.logger.Info("Updated simulator issuer faults", "enabled", faults.Enabled)
+	return nil
+}
+
+// injectError applies the injected latency and, at the configured rate,
+// fails method.
+func (i *SimulatorIssuer) injectError(ctx context.Context, method string) error {
+	if err := i.faults.Delay(ctx); err != nil {
+		return err
+	}
+	if i.faults.Fail(method) {
+		return api.Errorf(api.ErrorCodeBackendUnavailable, "injected %s failure", method)
+	}
+	return nil
 }
@@ -0,0 +1,84 @@
+package snp
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/go-sev-guest/abi"
+	spb "github.com/google/go-sev-guest/proto/sevsnp"
+)
+
+// Document is an SEV-SNP attestation document: an attestation report whose
+// REPORT_DATA binds the user data and nonce, and the certificate table the
+// host provisioned alongside it.
+type Document struct {
+	Report []byte // raw attestation report
+	// Certificates is the raw certificate table, usually holding the VCEK or
+	// VLEK with the ASK and ARK. Validators fetch a missing VCEK from AMD KDS
+	// unless they run offline.
+	Certificates []byte `json:",omitempty"`
+	UserData     []byte
+}
+
+// QuoteFunc returns a raw attestation report reporting reportData, followed
+// by the certificate table, as go-sev-guest's quote providers do.
+type QuoteFunc func(reportData [abi.ReportDataSize]byte) ([]byte, error)
+
+// ReportData binds user data and a nonce into REPORT_DATA: SHA-256 of
+// userData || nonce, zero padded, as in TDX documents.
+func ReportData(userData, nonce []byte) [abi.ReportDataSize]byte {
+	var reportData [abi.ReportDataSize]byte
+	sum := sha256.Sum256(append(append([]byte{}, userData...), nonce...))
+	copy(reportData[:], sum[:])
+	return reportData
+}
+
+// NewDocument takes an attestation report bound to userData and nonce.
+func NewDocument(quote QuoteFunc, userData, nonce []byte) (*Document, error) {
+	raw, err := quote(ReportData(userData, nonce))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get attestation report: %w", err)
+	}
+	if len(raw) < abi.ReportSize {
+		return nil, fmt.Errorf("attestation report is %d bytes, expected at least %d", len(raw), abi.ReportSize)
+	}
+
+	doc := &Document{Report: raw[:abi.ReportSize], UserData: userData}
+	if len(raw) > abi.ReportSize {
+		doc.Certificates = raw[abi.ReportSize:]
+	}
+	return doc, nil
+}
+
+// ParsedDocument holds the decoded parts of a Document.
+type ParsedDocument struct {
+	Document    *Document
+	Attestation *spb.Attestation
+}
+
+// ParseDocument decodes an SEV-SNP attestation document without verifying
+// it.
+func ParseDocument(doc []byte) (*ParsedDocument, error) {
+	var attDoc Document
+	if err := json.Unmarshal(doc, &attDoc); err != nil {
+		return nil, fmt.Errorf("unmarshal attestation document: %w", err)
+	}
+	if len(attDoc.Report) != abi.ReportSize {
+		return nil, fmt.Errorf("attestation report is %d bytes, expected %d", len(attDoc.Report), abi.ReportSize)
+	}
+
+	report, err := abi.ReportToProto(attDoc.Report)
+	if err != nil {
+		return nil, fmt.Errorf("parse attestation report: %w", err)
+	}
+	certs := new(abi.CertTable)
+	if err := certs.Unmarshal(attDoc.Certificates); err != nil {
+		return nil, fmt.Errorf("parse certificate table: %w", err)
+	}
+
+	return &ParsedDocument{
+		Document:    &attDoc,
+		Attestation: &spb.Attestation{Report: report, CertificateChain: certs.Proto()},
+	}, nil
+}
@@ -0,0 +1,125 @@
+package snp
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-sev-guest/abi"
+)
+
+// The document recorded for the SNP validator tests.
+var (
+	fixtureUserData = []byte("tdxs snp fixture user data")
+	fixtureNonce    = []byte("tdxs snp fixture nonce")
+)
+
+func readFixture(t *testing.T) *Document {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("..", "..", "validator", "snp", "testdata", "document.json"))
+	if err != nil {
+		t.Fatalf("failed to read fixture: %v", err)
+	}
+	var doc Document
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("failed to decode fixture: %v", err)
+	}
+	return &doc
+}
+
+func TestReportData(t *testing.T) {
+	reportData := ReportData([]byte("ab"), []byte("nonce"))
+	want := sha256.Sum256([]byte("abnonce"))
+	if !bytes.Equal(reportData[:sha256.Size], want[:]) || !bytes.Equal(reportData[sha256.Size:], make([]byte, abi.ReportDataSize-sha256.Size)) {
+		t.Errorf("report data = %x, want sha256(userData || nonce) zero padded", reportData)
+	}
+}
+
+func TestDocumentRoundTrip(t *testing.T) {
+	fixture := readFixture(t)
+	var requested [abi.ReportDataSize]byte
+	quote := func(reportData [abi.ReportDataSize]byte) ([]byte, error) {
+		requested = reportData
+		return append(append([]byte{}, fixture.Report...), fixture.Certificates...), nil
+	}
+
+	doc, err := NewDocument(quote, fixtureUserData, fixtureNonce)
+	if err != nil {
+		t.Fatalf("NewDocument failed: %v", err)
+	}
+	if requested != ReportData(fixtureUserData, fixtureNonce) {
+		t.Errorf("requested report data = %x, want the binding of the user data and nonce", requested)
+	}
+	if !bytes.Equal(doc.Report, fixture.Report) || !bytes.Equal(doc.Certificates, fixture.Certificates) {
+		t.Error("report and certificate table not split at the report size")
+	}
+
+	data, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseDocument(data)
+	if err != nil {
+		t.Fatalf("ParseDocument failed: %v", err)
+	}
+	if !bytes.Equal(parsed.Document.UserData, fixtureUserData) {
+		t.Errorf("user data = %q, want %q", parsed.Document.UserData, fixtureUserData)
+	}
+	if want := ReportData(fixtureUserData, fixtureNonce); !bytes.Equal(parsed.Attestation.GetReport().GetReportData(), want[:]) {
+		t.Errorf("REPORT_DATA = %x, want %x", parsed.Attestation.GetReport().GetReportData(), want)
+	}
+	if len(parsed.Attestation.GetCertificateChain().GetVcekCert()) == 0 {
+		t.Error("certificate table has no VCEK")
+	}
+}
+
+func TestNewDocumentErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		quote QuoteFunc
+	}{
+		{name: "QuoteFailed", quote: func([abi.ReportDataSize]byte) ([]byte, error) { return nil, errors.New("no device") }},
+		{name: "ShortReport", quote: func([abi.ReportDataSize]byte) ([]byte, error) { return make([]byte, abi.ReportSize-1), nil }},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if doc, err := NewDocument(tt.quote, fixtureUserData, fixtureNonce); err == nil {
+				t.Errorf("NewDocument = %+v, want an error", doc)
+			}
+		})
+	}
+}
+
+func TestParseDocumentMalformed(t *testing.T) {
+	modified := func(modify func(doc *Document)) []byte {
+		t.Helper()
+		doc := readFixture(t)
+		modify(doc)
+		data, err := json.Marshal(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	for _, tt := range []struct {
+		name string
+		doc  []byte
+	}{
+		{name: "InvalidJSON", doc: []byte(`{"Report":`)},
+		{name: "NoReport", doc: modified(func(doc *Document) { doc.Report = nil })},
+		{name: "ShortReport", doc: modified(func(doc *Document) { doc.Report = doc.Report[:abi.ReportSize-1] })},
+		{name: "LongReport", doc: modified(func(doc *Document) { doc.Report = append(doc.Report, 0) })},
+		{name: "InvalidReport", doc: modified(func(doc *Document) { doc.Report = bytes.Repeat([]byte{0xff}, abi.ReportSize) })},
+		{name: "InvalidCertificateTable", doc: modified(func(doc *Document) { doc.Certificates = []byte("not a certificate table") })},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if parsed, err := ParseDocument(tt.doc); err == nil {
+				t.Errorf("ParseDocument = %+v, want an error", parsed)
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package snp
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/go-sev-guest/abi"
+	"github.com/google/go-sev-guest/client"
+
+	"github.com/Hyodar/tdxs/pkg/api"
+	"github.com/Hyodar/tdxs/pkg/issuer"
+	"github.com/Hyodar/tdxs/pkg/logger"
+	"github.com/Hyodar/tdxs/pkg/registry"
+)
+
+type SNPIssuer struct {
+	issuer.Issuer
+
+	cfg    *SNPIssuerConfig
+	logger logger.Logger
+}
+
+type SNPIssuerConfig struct {
+	// VMPL requests reports at this privilege level, for guests running
+	// under an SVSM or paravisor. Defaults to the provider's level.
+	VMPL *uint `yaml:"vmpl"`
+}
+
+func (c *SNPIssuerConfig) Validate() error {
+	if c.VMPL != nil && *c.VMPL > 3 {
+		return fmt.Errorf("vmpl: %d out of range 0-3", *c.VMPL)
+	}
+	return nil
+}
+
+type SNPMetadata struct {
+	Measurement string `json:"measurement"` // Launch measurement (hex)
+	HostData    string `json:"hostdata"`    // Data provided by the host at launch (hex)
+	FamilyID    string `json:"familyid"`    // Family ID from the ID block (hex)
+	ImageID     string `json:"imageid"`     // Image ID from the ID block (hex)
+	Policy      string `json:"policy"`      // Guest policy (hex)
+	VMPL        uint32 `json:"vmpl"`        // Privilege level the report was requested at
+	GuestSVN    uint32 `json:"guestsvn"`    // Guest security version number
+	ReportedTCB string `json:"reportedtcb"` // TCB version the VCEK is derived from (hex)
+	SigningKey  string `json:"signingkey"`  // VCEK or VLEK
+}
+
+func init() {
+	issuer.Register(issuer.IssuerTypeSNP, registry.WithConfig(func(cfg *SNPIssuerConfig, logger logger.Logger) (issuer.Issuer, error) {
+		return NewSNPIssuer(cfg, logger), nil
+	}))
+}
+
+func NewSNPIssuer(cfg *SNPIssuerConfig, logger logger.Logger) *SNPIssuer {
+	return &SNPIssuer{
+		cfg:    cfg,
+		logger: logger,
+	}
+}
+
+func (i *SNPIssuer) Start(_ context.Context) error {
+	return nil
+}
+
+func (i *SNPIssuer) Issue(ctx context.Context, req *api.IssueRequest) *api.IssueResponse {
+	doc, err := i.issue(req.UserData, req.Nonce)
+	if err != nil {
+		return &api.IssueResponse{Error: backendError(ctx, err)}
+	}
+	return &api.IssueResponse{Document: doc}
+}
+
+func (i *SNPIssuer) issue(userData, nonce []byte) ([]byte, error) {
+	doc, err := NewDocument(i.rawQuote, userData, nonce)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(doc)
+}
+
+// rawQuote gets a report and certificate table through configfs-tsm,
+// falling back to /dev/sev-guest.
+func (i *SNPIssuer) rawQuote(reportData [abi.ReportDataSize]byte) ([]byte, error) {
+	if i.cfg.VMPL == nil {
+		quoteProvider, err := client.GetQuoteProvider()
+		if err != nil {
+			return nil, err
+		}
+		return quoteProvider.GetRawQuote(reportData)
+	}
+	quoteProvider, err := client.GetLeveledQuoteProvider()
+	if err != nil {
+		return nil, err
+	}
+	return quoteProvider.GetRawQuoteAtLevel(reportData, *i.cfg.VMPL)
+}
+
+// Metadata reads the metadata from a new attestation report.
+func (i *SNPIssuer) Metadata(ctx context.Context, req *api.MetadataRequest) *api.MetadataResponse {
+	userData, nonce, _ := issuer.MetadataBinding(req)
+
+	doc, err := i.issue(userData, nonce)
+	if err != nil {
+		return &api.MetadataResponse{Error: backendError(ctx, err)}
+	}
+	metadata, err := extractMetadata(doc)
+	if err != nil {
+		return &api.MetadataResponse{Error: fmt.Errorf("extract metadata: %w", err)}
+	}
+
+	response := &api.MetadataResponse{
+		IssuerType: string(issuer.IssuerTypeSNP),
+		UserData:   userData,
+		Nonce:      nonce,
+		Metadata:   metadata,
+	}
+	if req.IncludeDocument {
+		response.Document = doc
+	}
+	return response
+}
+
+func extractMetadata(doc []byte) (*SNPMetadata, error) {
+	parsed, err := ParseDocument(doc)
+	if err != nil {
+		return nil, err
+	}
+	report := parsed.Attestation.GetReport()
+	signerInfo, err := abi.ParseSignerInfo(report.GetSignerInfo())
+	if err != nil {
+		return nil, err
+	}
+
+	return &SNPMetadata{
+		Measurement: prefixedHexEncode(report.GetMeasurement()),
+		HostData:    prefixedHexEncode(report.GetHostData()),
+		FamilyID:    prefixedHexEncode(report.GetFamilyId()),
+		ImageID:     prefixedHexEncode(report.GetImageId()),
+		Policy:      fmt.Sprintf("0x%x", report.GetPolicy()),
+		VMPL:        report.GetVmpl(),
+		GuestSVN:    report.GetGuestSvn(),
+		ReportedTCB: fmt.Sprintf("0x%016x", report.GetReportedTcb()),
+		SigningKey:  signerInfo.SigningKey.String(),
+	}, nil
+}
+
+// backendError categorizes a failure of the attestation backend, which
+// usually means neither configfs-tsm nor the SEV guest device could be
+// reached.
+func backendError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return api.NewError(api.ErrorCodeTimeout, err)
+	}
+	return api.NewError(api.ErrorCodeBackendUnavailable, err)
+}
+
+func prefixedHexEncode(data []byte) string {
+	return "0x" + hex.EncodeToString(data)
+}
@@ -0,0 +1,16 @@
+package manager
+
+// Built-in backends register themselves with the issuer, validator and
+// transport registries when imported. Additional backends can be added by
+// importing their packages from a custom main.
+import (
+	_ "github.com/Hyodar/tdxs/pkg/issuer/azure"
+	_ "github.com/Hyodar/tdxs/pkg/issuer/gcp"
+	_ "github.com/Hyodar/tdxs/pkg/issuer/simulator"
+	_ "github.com/Hyodar/tdxs/pkg/issuer/snp"
+	_ "github.com/Hyodar/tdxs/pkg/transport/socket"
+	_ "github.com/Hyodar/tdxs/pkg/validator/azure"
+	_ "github.com/Hyodar/tdxs/pkg/validator/gcp"
+	_ "github.com/Hyodar/tdxs/pkg/validator/simulator"
+	_ "github.com/Hyodar/tdxs/pkg/validator/snp"
+)
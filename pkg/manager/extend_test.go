@@ -0,0 +1,54 @@
+package manager
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha512"
+	"log/slog"
+	"path/filepath"
+	"testing"
+
+	"github.com/Hyodar/tdxs/pkg/api"
+	"github.com/Hyodar/tdxs/pkg/audit"
+	"github.com/Hyodar/tdxs/pkg/rtmr"
+)
+
+// Extension results are returned even if they cannot be audited: successes
+// because the caller would otherwise extend again, failures with their own
+// error.
+func TestExtendAuditFailure(t *testing.T) {
+	logger := slog.New(slog.DiscardHandler)
+	device := rtmr.NewFakeDevice()
+	extender, err := rtmr.NewExtenderWithDevice(&rtmr.RTMRConfig{Device: rtmr.DeviceTypeFake}, device, logger)
+	if err != nil {
+		t.Fatalf("failed to create extender: %v", err)
+	}
+	defer extender.Close()
+	auditLog, err := audit.NewAuditLog(&audit.AuditConfig{FilePath: filepath.Join(t.TempDir(), "audit.jsonl")}, logger)
+	if err != nil {
+		t.Fatalf("failed to open audit log: %v", err)
+	}
+	auditLog.Close()
+
+	m := &Manager{logger: logger, extender: extender, audit: auditLog}
+	m.backends.Store(&backends{})
+
+	extend := func(req *api.ExtendRequest) *api.ExtendResponse {
+		wrapper := &api.ExtendRequestWrapper{Caller: &api.Caller{}, Request: req, Response: make(chan *api.ExtendResponse, 1)}
+		m.handleExtendRequest(context.Background(), wrapper)
+		return <-wrapper.Response
+	}
+
+	digest := sha512.Sum384([]byte("image"))
+	resp := extend(&api.ExtendRequest{RTMR: 3, Digest: digest[:]})
+	want := rtmr.Extended(make([]byte, 48), digest[:])
+	if resp.Error != nil || !bytes.Equal(resp.Value, want) || resp.Sequence != 1 {
+		t.Errorf("extend = %+v, want the new value and sequence 1", resp)
+	}
+
+	// Failed extensions keep their own error rather than the audit failure.
+	resp = extend(&api.ExtendRequest{RTMR: 3, Digest: []byte("short")})
+	if api.CodeOf(resp.Error) != api.ErrorCodeBadRequest {
+		t.Errorf("failed extend error = %v, want %s", resp.Error, api.ErrorCodeBadRequest)
+	}
+}
@@ -0,0 +1,112 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"maps"
+
+	"github.com/Hyodar/tdxs/pkg/api"
+	"github.com/Hyodar/tdxs/pkg/simulator"
+)
+
+func (m *Manager) handleFaultsRequest(ctx context.Context, wrapper *api.FaultsRequestWrapper) {
+	response := m.setFaults(wrapper)
+	select {
+	case wrapper.Response <- response:
+	case <-ctx.Done():
+	}
+}
+
+// setFaults applies a faults request to the simulator backends of the
+// requested profile. Only root and the daemon's own user may change faults.
+// Changes last until the next reload, which restores the configured faults.
+func (m *Manager) setFaults(wrapper *api.FaultsRequestWrapper) *api.FaultsResponse {
+	if err := authorizeAdmin(wrapper.Caller, "change faults"); err != nil {
+		return &api.FaultsResponse{Error: err}
+	}
+
+	b := m.backends.Load()
+	profile := b.resolve(wrapper.Profile)
+	if !b.hasProfile(profile) {
+		return &api.FaultsResponse{Error: api.Errorf(api.ErrorCodeBadRequest, "unknown profile: %s", profile)}
+	}
+	issuer, _ := unwrapIssuer(b.issuers[profile]).(simulator.FaultTarget)
+	validator, _ := unwrapValidator(b.validators[profile]).(simulator.FaultTarget)
+	if issuer == nil && validator == nil {
+		return &api.FaultsResponse{Error: api.Errorf(api.ErrorCodeNotEnabled, "profile %s has no backend that supports fault injection", profile)}
+	}
+
+	req := wrapper.Request
+	if req.Issuer != nil && issuer == nil {
+		return &api.FaultsResponse{Error: api.Errorf(api.ErrorCodeNotEnabled, "issuer of profile %s does not support fault injection", profile)}
+	}
+	if req.Validator != nil && validator == nil {
+		return &api.FaultsResponse{Error: api.Errorf(api.ErrorCodeNotEnabled, "validator of profile %s does not support fault injection", profile)}
+	}
+
+	for _, change := range []struct {
+		target simulator.FaultTarget
+		faults *api.Faults
+	}{{issuer, req.Issuer}, {validator, req.Validator}} {
+		if change.target == nil || (change.faults == nil && req.Enabled == nil) {
+			continue
+		}
+		faults := change.target.Faults()
+		if change.faults != nil {
+			faults = simulatorFaults(change.faults)
+		}
+		if req.Enabled != nil {
+			faults.Enabled = *req.Enabled
+		}
+		if err := change.target.SetFaults(faults); err != nil {
+			return &api.FaultsResponse{Error: fmt.Errorf("profile %s: %w", profile, err)}
+		}
+	}
+
+	response := &api.FaultsResponse{}
+	if issuer != nil {
+		response.Issuer = apiFaults(issuer.Faults())
+	}
+	if validator != nil {
+		response.Validator = apiFaults(validator.Faults())
+	}
+	return response
+}
+
+func simulatorFaults(faults *api.Faults) simulator.Faults {
+	return simulator.Faults{
+		Enabled: faults.Enabled,
+		Seed:    faults.Seed,
+		Latency: simulator.Latency{
+			Distribution: simulator.LatencyDistribution(faults.Latency.Distribution),
+			Min:          faults.Latency.Min,
+			Max:          faults.Latency.Max,
+			Mean:         faults.Latency.Mean,
+			StdDev:       faults.Latency.StdDev,
+		},
+		ErrorRates:        maps.Clone(faults.ErrorRates),
+		CorruptSignature:  faults.CorruptSignature,
+		WrongNonce:        faults.WrongNonce,
+		StaleTCB:          faults.StaleTCB,
+		ExpiredCollateral: faults.ExpiredCollateral,
+	}
+}
+
+func apiFaults(faults simulator.Faults) *api.Faults {
+	return &api.Faults{
+		Enabled: faults.Enabled,
+		Seed:    faults.Seed,
+		Latency: api.Latency{
+			Distribution: string(faults.Latency.Distribution),
+			Min:          faults.Latency.Min,
+			Max:          faults.Latency.Max,
+			Mean:         faults.Latency.Mean,
+			StdDev:       faults.Latency.StdDev,
+		},
+		ErrorRates:        maps.Clone(faults.ErrorRates),
+		CorruptSignature:  faults.CorruptSignature,
+		WrongNonce:        faults.WrongNonce,
+		StaleTCB:          faults.StaleTCB,
+		ExpiredCollateral: faults.ExpiredCollateral,
+	}
+}
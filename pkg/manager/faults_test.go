@@ -0,0 +1,27 @@
+package manager
+
+import (
+	"log/slog"
+	"os"
+	"testing"
+
+	"github.com/Hyodar/tdxs/pkg/api"
+)
+
+func TestFaultsUnauthorized(t *testing.T) {
+	// The ACL is checked before the backends are looked at, so the manager
+	// needs none.
+	m := &Manager{logger: slog.New(slog.DiscardHandler)}
+	enabled := true
+	uid := uint32(os.Getuid() + 1)
+	if uid == 0 {
+		uid++
+	}
+
+	for _, caller := range []*api.Caller{{UID: uid}, nil} {
+		resp := m.setFaults(&api.FaultsRequestWrapper{Caller: caller, Request: &api.FaultsRequest{Enabled: &enabled}})
+		if api.CodeOf(resp.Error) != api.ErrorCodeUnauthorized {
+			t.Errorf("faults by caller %+v: error = %v, want %s", caller, resp.Error, api.ErrorCodeUnauthorized)
+		}
+	}
+}
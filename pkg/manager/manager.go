@@ -1,41 +1,58 @@
 package manager
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"sync"
+	"sync/atomic"
 
 	"github.com/Hyodar/tdxs/pkg/api"
+	"github.com/Hyodar/tdxs/pkg/audit"
+	"github.com/Hyodar/tdxs/pkg/health"
 	"github.com/Hyodar/tdxs/pkg/issuer"
-	azureissuer "github.com/Hyodar/tdxs/pkg/issuer/azure"
-	simulatorissuer "github.com/Hyodar/tdxs/pkg/issuer/simulator"
 	"github.com/Hyodar/tdxs/pkg/logger"
+	"github.com/Hyodar/tdxs/pkg/rtmr"
 	"github.com/Hyodar/tdxs/pkg/transport"
-	sockettransport "github.com/Hyodar/tdxs/pkg/transport/socket"
 	"github.com/Hyodar/tdxs/pkg/validator"
-	azurevalidator "github.com/Hyodar/tdxs/pkg/validator/azure"
-	simulatorvalidator "github.com/Hyodar/tdxs/pkg/validator/simulator"
 	"gopkg.in/yaml.v3"
 )
 
 type Manager struct {
 	transport transport.Transport
-	issuer    issuer.Issuer
-	validator validator.Validator
+	backends  atomic.Pointer[backends]
+	audit     *audit.AuditLog
+	health    *health.Monitor
+	extender  *rtmr.Extender
 	logger    logger.Logger
+
+	cfg          *ManagerConfig
+	configLoader ConfigLoader
+	reloadMu     sync.Mutex
+	// runCtx is the context backends are started with, set once Start has
+	// started them.
+	runCtx context.Context
 }
 
 type ManagerConfig struct {
-	Transport *TransportConfig `json:"transport" yaml:"transport"`
-	Issuer    *IssuerConfig    `json:"issuer" yaml:"issuer"`
-	Validator *ValidatorConfig `json:"validator" yaml:"validator"`
+	Transport      *TransportConfig            `json:"transport" yaml:"transport"`
+	Issuer         *IssuerConfig               `json:"issuer" yaml:"issuer"`
+	Validator      *ValidatorConfig            `json:"validator" yaml:"validator"`
+	Issuers        map[string]*IssuerConfig    `json:"issuers" yaml:"issuers"`
+	Validators     map[string]*ValidatorConfig `json:"validators" yaml:"validators"`
+	DefaultProfile string                      `json:"default_profile" yaml:"default_profile"`
+	Audit          *audit.AuditConfig          `json:"audit" yaml:"audit"`
+	Health         *health.HealthConfig        `json:"health" yaml:"health"`
+	RTMR           *rtmr.RTMRConfig            `json:"rtmr" yaml:"rtmr"`
 }
 
 func NewManager(cfg *ManagerConfig, logger logger.Logger) (*Manager, error) {
-	if cfg.Transport == nil {
-		return nil, fmt.Errorf("transport config is required")
-	}
-	if cfg.Issuer == nil && cfg.Validator == nil {
-		return nil, fmt.Errorf("issuer or validator config is required")
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
 	}
 
 	transport, err := createTransport(cfg.Transport, logger)
@@ -43,28 +60,61 @@ func NewManager(cfg *ManagerConfig, logger logger.Logger) (*Manager, error) {
 		return nil, fmt.Errorf("failed to create transport: %w", err)
 	}
 
-	var issuer issuer.Issuer
-	if cfg.Issuer != nil {
-		issuer, err = createIssuer(cfg.Issuer, logger)
+	b, err := createBackends(cfg, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	var auditLog *audit.AuditLog
+	if cfg.Audit != nil {
+		auditLog, err = audit.NewAuditLog(cfg.Audit, logger)
 		if err != nil {
-			return nil, fmt.Errorf("failed to create issuer: %w", err)
+			return nil, fmt.Errorf("failed to create audit log: %w", err)
 		}
 	}
 
-	var validator validator.Validator
-	if cfg.Validator != nil {
-		validator, err = createValidator(cfg.Validator, logger)
+	var extender *rtmr.Extender
+	if cfg.RTMR != nil {
+		extender, err = rtmr.NewExtender(cfg.RTMR, logger)
 		if err != nil {
-			return nil, fmt.Errorf("failed to create validator: %w", err)
+			return nil, fmt.Errorf("failed to create RTMR extender: %w", err)
 		}
 	}
 
-	return &Manager{
+	m := &Manager{
 		logger:    logger,
 		transport: transport,
-		issuer:    issuer,
-		validator: validator,
-	}, nil
+		audit:     auditLog,
+		extender:  extender,
+		cfg:       cfg,
+	}
+	m.backends.Store(b)
+
+	var selfTest health.SelfTestFunc
+	if cfg.Health != nil {
+		selfTest = m.selfTest
+	}
+	m.health, err = health.NewMonitor(cfg.Health, selfTest, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create health monitor: %w", err)
+	}
+	m.health.SetCacheStats(m.cacheStats)
+
+	return m, nil
+}
+
+// referenceValuesDigest identifies the reference values a validator checks
+// against, so audit entries can be tied to the policy that produced them.
+func referenceValuesDigest(cfg *ValidatorConfig) (string, error) {
+	data, err := json.Marshal(struct {
+		Type   validator.ValidatorType `json:"type"`
+		Config interface{}             `json:"config"`
+	}{cfg.Type, cfg.Config})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
 }
 
 type TransportConfig struct {
@@ -84,29 +134,31 @@ func (t *TransportConfig) UnmarshalYAML(unmarshal func(interface{}) error) error
 
 	t.Type = tc.Type
 
-	switch t.Type {
-	case transport.TransportTypeSocket:
-		var cfg sockettransport.SocketTransportConfig
-		if err := tc.Config.Decode(&cfg); err != nil {
-			return err
-		}
-		t.Config = cfg
-	default:
-		return fmt.Errorf("invalid transport type: %s", t.Type)
+	entry, err := transport.Registry.Lookup(string(t.Type))
+	if err != nil {
+		return err
+	}
+	t.Config, err = entry.DecodeConfig(tc.Config)
+	if err != nil {
+		return fmt.Errorf("invalid transport config for type %s: %w", t.Type, err)
 	}
 
 	return nil
 }
 
 type IssuerConfig struct {
-	Type   issuer.IssuerType `yaml:"-"`
-	Config interface{}       `yaml:"-"`
+	Type   issuer.IssuerType   `yaml:"-"`
+	Config interface{}         `yaml:"-"`
+	Cache  *issuer.CacheConfig `yaml:"-"`
+	Batch  *issuer.BatchConfig `yaml:"-"`
 }
 
 func (i *IssuerConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	type issuerConfigHelper struct {
-		Type   issuer.IssuerType `yaml:"type"`
-		Config yaml.Node         `yaml:"config"`
+		Type   issuer.IssuerType   `yaml:"type"`
+		Config yaml.Node           `yaml:"config"`
+		Cache  *issuer.CacheConfig `yaml:"cache"`
+		Batch  *issuer.BatchConfig `yaml:"batch"`
 	}
 	var ic issuerConfigHelper
 	if err := unmarshal(&ic); err != nil {
@@ -114,14 +166,16 @@ func (i *IssuerConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	}
 
 	i.Type = ic.Type
+	i.Cache = ic.Cache
+	i.Batch = ic.Batch
 
-	switch i.Type {
-	case issuer.IssuerTypeAzure, issuer.IssuerTypeSimulator:
-		if !isNilOrEmptyYAMLNode(ic.Config) {
-			return fmt.Errorf("issuer config is not supported for type: %s", i.Type)
-		}
-	default:
-		return fmt.Errorf("invalid issuer type: %s", i.Type)
+	entry, err := issuer.Registry.Lookup(string(i.Type))
+	if err != nil {
+		return err
+	}
+	i.Config, err = entry.DecodeConfig(ic.Config)
+	if err != nil {
+		return fmt.Errorf("invalid issuer config for type %s: %w", i.Type, err)
 	}
 
 	return nil
@@ -144,78 +198,60 @@ func (v *ValidatorConfig) UnmarshalYAML(unmarshal func(interface{}) error) error
 
 	v.Type = vc.Type
 
-	switch v.Type {
-	case validator.ValidatorTypeAzure:
-		var cfg azurevalidator.AzureValidatorConfig
-		if err := vc.Config.Decode(&cfg); err != nil {
-			return err
-		}
-		v.Config = cfg
-	case validator.ValidatorTypeSimulator:
-		if !isNilOrEmptyYAMLNode(vc.Config) {
-			return fmt.Errorf("validator config is not supported for type: %s", v.Type)
-		}
-	default:
-		return fmt.Errorf("invalid validator type: %s", v.Type)
+	entry, err := validator.Registry.Lookup(string(v.Type))
+	if err != nil {
+		return err
+	}
+	v.Config, err = entry.DecodeConfig(vc.Config)
+	if err != nil {
+		return fmt.Errorf("invalid validator config for type %s: %w", v.Type, err)
 	}
 
 	return nil
 }
 
-func isNilOrEmptyYAMLNode(node yaml.Node) bool {
-	if node.Kind == 0 {
-		return true
-	}
-	if node.Kind == yaml.ScalarNode && node.Tag == "!!null" {
-		return true
-	}
-	if node.Kind == yaml.MappingNode && len(node.Content) == 0 {
-		return true
-	}
-	return false
-}
-
 func createTransport(cfg *TransportConfig, logger logger.Logger) (transport.Transport, error) {
-	switch cfg.Type {
-	case transport.TransportTypeSocket:
-		innerCfg, ok := cfg.Config.(sockettransport.SocketTransportConfig)
-		if !ok {
-			return nil, fmt.Errorf("invalid transport config type: %T", cfg.Config)
-		}
-		transport, err := sockettransport.NewSocketTransport(&innerCfg, logger)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create socket transport: %w", err)
-		}
-		return transport, nil
-	default:
-		return nil, fmt.Errorf("invalid transport type: %s", cfg.Type)
+	entry, err := transport.Registry.Lookup(string(cfg.Type))
+	if err != nil {
+		return nil, err
 	}
+	transport, err := entry.New(cfg.Config, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s transport: %w", cfg.Type, err)
+	}
+	return transport, nil
 }
 
 func createIssuer(cfg *IssuerConfig, logger logger.Logger) (issuer.Issuer, error) {
-	switch cfg.Type {
-	case issuer.IssuerTypeAzure:
-		return azureissuer.NewAzureIssuer(logger), nil
-	case issuer.IssuerTypeSimulator:
-		return simulatorissuer.NewSimulatorIssuer(logger), nil
-	default:
-		return nil, fmt.Errorf("invalid issuer type: %s", cfg.Type)
+	entry, err := issuer.Registry.Lookup(string(cfg.Type))
+	if err != nil {
+		return nil, err
+	}
+	created, err := entry.New(cfg.Config, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s issuer: %w", cfg.Type, err)
 	}
+	// Cache hits skip the batch window, so the cache goes outside.
+	if cfg.Batch != nil {
+		created = issuer.NewBatcher(cfg.Batch, created, logger)
+	}
+	if cfg.Cache != nil {
+		created = issuer.NewCache(cfg.Cache, created, logger)
+	}
+	return created, nil
 }
 
 func createValidator(cfg *ValidatorConfig, logger logger.Logger) (validator.Validator, error) {
-	switch cfg.Type {
-	case validator.ValidatorTypeAzure:
-		innerCfg, ok := cfg.Config.(azurevalidator.AzureValidatorConfig)
-		if !ok {
-			return nil, fmt.Errorf("invalid validator config type: %T", cfg.Config)
-		}
-		return azurevalidator.NewAzureValidator(&innerCfg, logger), nil
-	case validator.ValidatorTypeSimulator:
-		return simulatorvalidator.NewSimulatorValidator(logger), nil
-	default:
-		return nil, fmt.Errorf("invalid validator type: %s", cfg.Type)
+	entry, err := validator.Registry.Lookup(string(cfg.Type))
+	if err != nil {
+		return nil, err
+	}
+	created, err := entry.New(cfg.Config, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s validator: %w", cfg.Type, err)
 	}
+	// Any issuer may be batched, so every validator accepts batched documents.
+	return validator.NewBatch(created), nil
 }
 
 func (m *Manager) Start(ctx context.Context) error {
@@ -223,16 +259,43 @@ func (m *Manager) Start(ctx context.Context) error {
 		IssueQueue:    make(chan *api.IssueRequestWrapper, 100),
 		MetadataQueue: make(chan *api.MetadataRequestWrapper, 100),
 		ValidateQueue: make(chan *api.ValidateRequestWrapper, 100),
+		HealthQueue:   make(chan *api.HealthRequestWrapper, 100),
+		ReloadQueue:   make(chan *api.ReloadRequestWrapper, 100),
+		FaultsQueue:   make(chan *api.FaultsRequestWrapper, 100),
+		ExtendQueue:   make(chan *api.ExtendRequestWrapper, 100),
 	}
 
 	transportCtx, transportCancel := context.WithCancel(ctx)
 	defer transportCancel()
 
+	if m.audit != nil {
+		defer m.audit.Close()
+	}
+	if m.extender != nil {
+		defer m.extender.Close()
+	}
+
+	m.reloadMu.Lock()
+	err := m.backends.Load().start(ctx)
+	if err == nil {
+		m.runCtx = ctx
+	}
+	m.reloadMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := m.health.Start(ctx); err != nil {
+		return fmt.Errorf("failed to start health monitor: %w", err)
+	}
+
 	errChan := make(chan error, 1)
 	go func() {
 		if err := m.transport.Start(transportCtx, queues); err != nil {
 			errChan <- fmt.Errorf("transport error: %w", err)
+			return
 		}
+		m.health.SetReady(true)
 	}()
 
 	for {
@@ -248,12 +311,31 @@ func (m *Manager) Start(ctx context.Context) error {
 			go m.handleMetadataRequest(ctx, req)
 		case req := <-queues.ValidateQueue:
 			go m.handleValidateRequest(ctx, req)
+		case req := <-queues.HealthQueue:
+			go m.handleHealthRequest(ctx, req)
+		case req := <-queues.ReloadQueue:
+			go m.handleReloadRequest(ctx, req)
+		case req := <-queues.FaultsQueue:
+			go m.handleFaultsRequest(ctx, req)
+		case req := <-queues.ExtendQueue:
+			go m.handleExtendRequest(ctx, req)
 		}
 	}
 }
 
 func (m *Manager) handleIssueRequest(ctx context.Context, wrapper *api.IssueRequestWrapper) {
-	response := m.issuer.Issue(ctx, wrapper.Request)
+	var response *api.IssueResponse
+	b := m.backends.Load()
+	if issuer, err := b.issuer(wrapper.Profile); err == nil {
+		response = issuer.Issue(ctx, wrapper.Request)
+	} else {
+		response = &api.IssueResponse{Error: err}
+	}
+	entry := audit.NewIssueEntry(wrapper.Caller, wrapper.Request, response)
+	entry.Profile = b.resolve(wrapper.Profile)
+	if err := m.recordAudit(entry); err != nil {
+		response = &api.IssueResponse{Error: err}
+	}
 	select {
 	case wrapper.Response <- response:
 	case <-ctx.Done():
@@ -261,7 +343,18 @@ func (m *Manager) handleIssueRequest(ctx context.Context, wrapper *api.IssueRequ
 }
 
 func (m *Manager) handleMetadataRequest(ctx context.Context, wrapper *api.MetadataRequestWrapper) {
-	response := m.issuer.Metadata(ctx, wrapper.Request)
+	var response *api.MetadataResponse
+	b := m.backends.Load()
+	if issuer, err := b.issuer(wrapper.Profile); err == nil {
+		response = issuer.Metadata(ctx, wrapper.Request)
+	} else {
+		response = &api.MetadataResponse{Error: err}
+	}
+	entry := audit.NewMetadataEntry(wrapper.Caller, wrapper.Request, response)
+	entry.Profile = b.resolve(wrapper.Profile)
+	if err := m.recordAudit(entry); err != nil {
+		response = &api.MetadataResponse{Error: err}
+	}
 	select {
 	case wrapper.Response <- response:
 	case <-ctx.Done():
@@ -269,9 +362,127 @@ func (m *Manager) handleMetadataRequest(ctx context.Context, wrapper *api.Metada
 }
 
 func (m *Manager) handleValidateRequest(ctx context.Context, wrapper *api.ValidateRequestWrapper) {
-	response := m.validator.Validate(ctx, wrapper.Request)
+	var response *api.ValidateResponse
+	b := m.backends.Load()
+	if validator, err := b.validator(wrapper.Profile); err == nil {
+		response = validator.Validate(ctx, wrapper.Request)
+	} else {
+		response = &api.ValidateResponse{Error: err}
+	}
+	entry := audit.NewValidateEntry(wrapper.Caller, wrapper.Request, response, b.referenceValues[b.resolve(wrapper.Profile)])
+	entry.Profile = b.resolve(wrapper.Profile)
+	if err := m.recordAudit(entry); err != nil {
+		response = &api.ValidateResponse{Error: err}
+	}
 	select {
 	case wrapper.Response <- response:
 	case <-ctx.Done():
 	}
 }
+
+func (m *Manager) handleExtendRequest(ctx context.Context, wrapper *api.ExtendRequestWrapper) {
+	var response *api.ExtendResponse
+	if m.extender != nil {
+		response = m.extender.Extend(wrapper.Caller, wrapper.Request)
+		if response.Error == nil {
+			m.notifyRTMRExtended(response.RTMR, response.Value)
+		}
+	} else {
+		response = &api.ExtendResponse{Error: api.Errorf(api.ErrorCodeNotEnabled, "RTMR extension is not configured")}
+	}
+	// The RTMR cannot be rolled back, so the result is returned even if it
+	// could not be audited: withholding a successful extension would make the
+	// caller retry and extend a second time, and a failed one is better
+	// reported with its own error. recordAudit logs the failure.
+	m.recordAudit(audit.NewExtendEntry(wrapper.Caller, wrapper.Request, response))
+	select {
+	case wrapper.Response <- response:
+	case <-ctx.Done():
+	}
+}
+
+// notifyRTMRExtended tells the issuers about an RTMR extension. Issue caches
+// and batchers pass it on to the issuer they wrap.
+func (m *Manager) notifyRTMRExtended(index int, value []byte) {
+	for _, i := range m.backends.Load().issuers {
+		if observer, ok := i.(issuer.RTMRObserver); ok {
+			observer.RTMRExtended(index, value)
+		}
+	}
+}
+
+func (m *Manager) handleHealthRequest(ctx context.Context, wrapper *api.HealthRequestWrapper) {
+	response := m.health.Status()
+	select {
+	case wrapper.Response <- response:
+	case <-ctx.Done():
+	}
+}
+
+// selfTest runs a self-test for every profile with an issuer: it issues a
+// document over random data and, if the profile also has a validator, checks
+// that the document validates back to the same user data.
+func (m *Manager) selfTest(ctx context.Context) error {
+	b := m.backends.Load()
+	for _, profile := range b.profiles() {
+		i, ok := b.issuers[profile]
+		if !ok {
+			continue
+		}
+		// Self-test documents are never requested again, so they bypass the
+		// issue cache.
+		if cache, ok := i.(*issuer.Cache); ok {
+			i = cache.Unwrap()
+		}
+		if err := selfTestProfile(ctx, i, b.validators[profile]); err != nil {
+			return fmt.Errorf("profile %s: %w", profile, err)
+		}
+	}
+	return nil
+}
+
+func selfTestProfile(ctx context.Context, issuer issuer.Issuer, validator validator.Validator) error {
+	userData := make([]byte, 32)
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(userData); err != nil {
+		return fmt.Errorf("failed to generate user data: %w", err)
+	}
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	issueResp := issuer.Issue(ctx, &api.IssueRequest{UserData: userData, Nonce: nonce})
+	if issueResp.Error != nil {
+		return fmt.Errorf("issue failed: %w", issueResp.Error)
+	}
+
+	if validator == nil {
+		return nil
+	}
+
+	validateResp := validator.Validate(ctx, &api.ValidateRequest{Document: issueResp.Document, Nonce: nonce})
+	if validateResp.Error != nil {
+		return fmt.Errorf("validate failed: %w", validateResp.Error)
+	}
+	if !validateResp.Valid {
+		return fmt.Errorf("issued document did not validate: %v", validateResp.FailedChecks)
+	}
+	if !bytes.Equal(validateResp.UserData, userData) {
+		return fmt.Errorf("validated user data does not match issued user data")
+	}
+	return nil
+}
+
+// recordAudit appends entry to the audit log, if one is configured. Results
+// that cannot be recorded are withheld from the caller, except for RTMR
+// extensions.
+func (m *Manager) recordAudit(entry *audit.Entry) error {
+	if m.audit == nil {
+		return nil
+	}
+	if err := m.audit.Record(entry); err != nil {
+		m.logger.Error("Failed to record audit entry", "method", entry.Method, "error", err)
+		return api.NewError(api.ErrorCodeInternal, fmt.Errorf("failed to record audit entry: %w", err))
+	}
+	return nil
+}
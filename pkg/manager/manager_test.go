@@ -0,0 +1,425 @@
+package manager_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Hyodar/tdxs/pkg/api"
+	"github.com/Hyodar/tdxs/pkg/client"
+	"github.com/Hyodar/tdxs/pkg/issuer"
+	"github.com/Hyodar/tdxs/pkg/manager"
+	"gopkg.in/yaml.v3"
+)
+
+// harness runs a manager with the socket transport and simulator backends.
+type harness struct {
+	mgr    *manager.Manager
+	cfg    *manager.ManagerConfig
+	socket string
+	cancel context.CancelFunc
+	done   chan error
+}
+
+func startManager(t *testing.T) *harness {
+	t.Helper()
+
+	dir := t.TempDir()
+	socket := filepath.Join(dir, "tdxs.sock")
+	config := fmt.Sprintf(`
+transport:
+  type: socket
+  config:
+    file_path: %s
+issuer:
+  type: simulator
+  config:
+    signed: true
+    ca_file: %s
+validator:
+  type: simulator
+  config:
+    signed: true
+    ca_file: %s
+`, socket, filepath.Join(dir, "ca.pem"), filepath.Join(dir, "ca.pem"))
+
+	var cfg manager.ManagerConfig
+	if err := yaml.Unmarshal([]byte(config), &cfg); err != nil {
+		t.Fatalf("failed to parse config: %v", err)
+	}
+	mgr, err := manager.NewManager(&cfg, slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatalf("failed to create manager: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	h := &harness{mgr: mgr, cfg: &cfg, socket: socket, cancel: cancel, done: make(chan error, 1)}
+	go func() {
+		h.done <- mgr.Start(ctx)
+	}()
+	t.Cleanup(h.stop)
+
+	// The transport starts in the background.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn, err := net.Dial("unix", socket)
+		if err == nil {
+			conn.Close()
+			return h
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("socket did not come up: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// stop shuts the manager down. Start's result is sent to done.
+func (h *harness) stop() {
+	h.cancel()
+}
+
+func (h *harness) client(t *testing.T) *client.Client {
+	t.Helper()
+
+	c, err := client.NewClient(&client.ClientConfig{SocketPath: h.socket})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// rawConn speaks the line-delimited JSON protocol directly, for requests the
+// client library would never send.
+type rawConn struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func (h *harness) dial(t *testing.T) *rawConn {
+	t.Helper()
+
+	conn, err := net.Dial("unix", h.socket)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	return &rawConn{t: t, conn: conn, reader: bufio.NewReader(conn)}
+}
+
+func (c *rawConn) send(line string) {
+	c.t.Helper()
+	if _, err := c.conn.Write([]byte(line + "\n")); err != nil {
+		c.t.Fatalf("failed to send request: %v", err)
+	}
+}
+
+type envelope struct {
+	Data  json.RawMessage `json:"data"`
+	Error *string         `json:"error"`
+	Code  api.ErrorCode   `json:"code"`
+}
+
+func (c *rawConn) receive() envelope {
+	c.t.Helper()
+	line, err := c.reader.ReadBytes('\n')
+	if err != nil {
+		c.t.Fatalf("failed to read response: %v", err)
+	}
+	var resp envelope
+	if err := json.Unmarshal(line, &resp); err != nil {
+		c.t.Fatalf("failed to decode response %q: %v", line, err)
+	}
+	return resp
+}
+
+// expectClosed checks that the daemon closed the connection.
+func (c *rawConn) expectClosed() {
+	c.t.Helper()
+	if line, err := c.reader.ReadBytes('\n'); err == nil {
+		c.t.Fatalf("connection still open, received %q", line)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	h := startManager(t)
+	c := h.client(t)
+	ctx := context.Background()
+	userData := []byte("user data")
+	nonce := []byte("nonce")
+
+	document, err := c.Issue(ctx, userData, nonce)
+	if err != nil {
+		t.Fatalf("issue failed: %v", err)
+	}
+
+	result, err := c.Validate(ctx, document, nonce)
+	if err != nil {
+		t.Fatalf("validate failed: %v", err)
+	}
+	if !result.Valid || !bytes.Equal(result.UserData, userData) {
+		t.Errorf("validate = %+v, want valid with user data %q", result, userData)
+	}
+
+	result, err = c.Validate(ctx, document, []byte("other nonce"))
+	if err != nil {
+		t.Fatalf("validate failed: %v", err)
+	}
+	if result.Verdict != api.VerdictInvalid || len(result.FailedChecks) != 1 || result.FailedChecks[0].Check != api.CheckNonce {
+		t.Errorf("validate with another nonce = %+v, want a failed nonce check", result)
+	}
+
+	metadata, err := c.Metadata(ctx)
+	if err != nil {
+		t.Fatalf("metadata failed: %v", err)
+	}
+	if metadata.IssuerType != "simulator" {
+		t.Errorf("issuer type = %q, want simulator", metadata.IssuerType)
+	}
+	if string(metadata.Nonce) != issuer.MetadataNonce || len(metadata.Document) != 0 {
+		t.Errorf("metadata = %+v, want the default nonce and no document", metadata)
+	}
+
+	// Metadata read with the caller's nonce comes with a document that
+	// validates against it.
+	metadata, err = c.MetadataWithOptions(ctx, &client.MetadataOptions{UserData: userData, Nonce: nonce, IncludeDocument: true})
+	if err != nil {
+		t.Fatalf("metadata with options failed: %v", err)
+	}
+	result, err = c.Validate(ctx, metadata.Document, nonce)
+	if err != nil {
+		t.Fatalf("validate failed: %v", err)
+	}
+	if !result.Valid || !bytes.Equal(result.UserData, userData) {
+		t.Errorf("validate metadata document = %+v, want valid with user data %q", result, userData)
+	}
+
+	_, err = c.WithProfile("missing").Issue(ctx, userData, nonce)
+	var serverErr *client.ServerError
+	if !errors.As(err, &serverErr) || serverErr.Code != api.ErrorCodeBadRequest {
+		t.Errorf("issue with unknown profile: error = %v, want %s", err, api.ErrorCodeBadRequest)
+	}
+}
+
+func TestPipelinedRequests(t *testing.T) {
+	h := startManager(t)
+	conn := h.dial(t)
+
+	conn.send(`{"method":"metadata"}` + "\n" + `{"method":"health"}` + "\n" + `{"method":"metadata"}`)
+	for i := range 3 {
+		if resp := conn.receive(); resp.Error != nil {
+			t.Errorf("response %d: unexpected error %s", i, *resp.Error)
+		}
+	}
+}
+
+func TestMalformedJSON(t *testing.T) {
+	h := startManager(t)
+	conn := h.dial(t)
+
+	conn.send(`{"method": issue}`)
+	resp := conn.receive()
+	if resp.Error == nil || resp.Code != api.ErrorCodeBadRequest {
+		t.Fatalf("response = %+v, want a %s error", resp, api.ErrorCodeBadRequest)
+	}
+	conn.expectClosed()
+
+	// The daemon keeps serving other connections.
+	if _, err := h.client(t).Metadata(context.Background()); err != nil {
+		t.Errorf("metadata after malformed request failed: %v", err)
+	}
+}
+
+func TestBadRequests(t *testing.T) {
+	h := startManager(t)
+	conn := h.dial(t)
+
+	for _, request := range []string{
+		`{"method":"bogus"}`,
+		`{"method":"issue","data":{"userData":"not hex","nonce":""}}`,
+		`{"method":"validate","data":{"document":"","nonce":""}}`,
+		`{"method":"issue","data":[]}`,
+	} {
+		conn.send(request)
+		if resp := conn.receive(); resp.Error == nil || resp.Code != api.ErrorCodeBadRequest {
+			t.Errorf("%s: response = %+v, want a %s error", request, resp, api.ErrorCodeBadRequest)
+		}
+	}
+
+	// The connection stays usable after requests that were valid JSON.
+	conn.send(`{"method":"issue","data":{"userData":"00","nonce":"01"}}`)
+	if resp := conn.receive(); resp.Error != nil {
+		t.Errorf("issue after bad requests failed: %s", *resp.Error)
+	}
+}
+
+func TestConcurrentClients(t *testing.T) {
+	h := startManager(t)
+	c := h.client(t)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 16*8)
+	for worker := range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for request := range 8 {
+				userData := fmt.Appendf(nil, "worker %d request %d", worker, request)
+				nonce := fmt.Appendf(nil, "nonce %d %d", worker, request)
+
+				document, err := c.Issue(ctx, userData, nonce)
+				if err != nil {
+					errs <- fmt.Errorf("issue: %w", err)
+					continue
+				}
+				result, err := c.Validate(ctx, document, nonce)
+				if err != nil {
+					errs <- fmt.Errorf("validate: %w", err)
+					continue
+				}
+				// Responses must not be crossed between requests.
+				if !result.Valid || !bytes.Equal(result.UserData, userData) {
+					errs <- fmt.Errorf("validate %q: got %+v", userData, result)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	h := startManager(t)
+	idle := h.dial(t)
+	c := h.client(t)
+	if _, err := c.Metadata(context.Background()); err != nil {
+		t.Fatalf("metadata failed: %v", err)
+	}
+
+	h.stop()
+	select {
+	case err := <-h.done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Start returned %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("manager did not stop")
+	}
+
+	// Idle connections are closed and no new ones are accepted.
+	idle.expectClosed()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn, err := net.Dial("unix", h.socket)
+		if err != nil {
+			break
+		}
+		conn.Close()
+		if time.Now().After(deadline) {
+			t.Fatal("socket still accepts connections after shutdown")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReload(t *testing.T) {
+	h := startManager(t)
+	c := h.client(t)
+	conn := h.dial(t)
+	ctx := context.Background()
+
+	conn.send(`{"method":"reload"}`)
+	if resp := conn.receive(); resp.Error == nil {
+		t.Errorf("reload without a config source succeeded")
+	}
+
+	staging := *h.cfg
+	if err := yaml.Unmarshal([]byte("staging:\n  type: simulator\n"), &staging.Issuers); err != nil {
+		t.Fatalf("failed to parse issuers: %v", err)
+	}
+	h.mgr.SetConfigLoader(func() (*manager.ManagerConfig, error) { return &staging, nil })
+	conn.send(`{"method":"reload"}`)
+	if resp := conn.receive(); resp.Error != nil {
+		t.Fatalf("reload failed: %s", *resp.Error)
+	}
+	if _, err := c.WithProfile("staging").Issue(ctx, []byte("user data"), []byte("nonce")); err != nil {
+		t.Errorf("issue with reloaded profile failed: %v", err)
+	}
+
+	// An invalid config is rejected and the running one stays in place.
+	invalid := *h.cfg
+	invalid.Issuer = &manager.IssuerConfig{Type: "bogus"}
+	h.mgr.SetConfigLoader(func() (*manager.ManagerConfig, error) { return &invalid, nil })
+	conn.send(`{"method":"reload"}`)
+	if resp := conn.receive(); resp.Error == nil {
+		t.Errorf("reload with an invalid config succeeded")
+	}
+	if _, err := c.WithProfile("staging").Issue(ctx, []byte("user data"), []byte("nonce")); err != nil {
+		t.Errorf("issue after rejected reload failed: %v", err)
+	}
+}
+
+func TestFaults(t *testing.T) {
+	h := startManager(t)
+	conn := h.dial(t)
+	issue := `{"method":"issue","data":{"userData":"00","nonce":"01"}}`
+
+	conn.send(`{"method":"faults","data":{"issuer":{"enabled":true,"errorRates":{"issue":1}}}}`)
+	resp := conn.receive()
+	if resp.Error != nil {
+		t.Fatalf("faults failed: %s", *resp.Error)
+	}
+	var faults struct {
+		Issuer    *struct{ Enabled bool } `json:"issuer"`
+		Validator *struct{ Enabled bool } `json:"validator"`
+	}
+	if err := json.Unmarshal(resp.Data, &faults); err != nil {
+		t.Fatalf("failed to decode faults: %v", err)
+	}
+	if faults.Issuer == nil || !faults.Issuer.Enabled || faults.Validator == nil || faults.Validator.Enabled {
+		t.Errorf("faults = %s, want only the issuer's enabled", resp.Data)
+	}
+	conn.send(issue)
+	if resp := conn.receive(); resp.Code != api.ErrorCodeBackendUnavailable {
+		t.Errorf("issue with injected failures: code = %q, want %s", resp.Code, api.ErrorCodeBackendUnavailable)
+	}
+
+	// Toggling keeps the configured rates but stops injecting them.
+	conn.send(`{"method":"faults","data":{"enabled":false}}`)
+	if resp := conn.receive(); resp.Error != nil {
+		t.Fatalf("disabling faults failed: %s", *resp.Error)
+	}
+	conn.send(issue)
+	if resp := conn.receive(); resp.Error != nil {
+		t.Errorf("issue with faults disabled failed: %s", *resp.Error)
+	}
+
+	conn.send(`{"method":"faults","data":{"enabled":true}}`)
+	conn.receive()
+	conn.send(issue)
+	if resp := conn.receive(); resp.Code != api.ErrorCodeBackendUnavailable {
+		t.Errorf("issue with faults re-enabled: code = %q, want %s", resp.Code, api.ErrorCodeBackendUnavailable)
+	}
+
+	conn.send(`{"method":"faults","profile":"bogus","data":{"enabled":false}}`)
+	if resp := conn.receive(); resp.Code != api.ErrorCodeBadRequest {
+		t.Errorf("faults for an unknown profile: code = %q, want %s", resp.Code, api.ErrorCodeBadRequest)
+	}
+}
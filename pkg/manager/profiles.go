@@ -0,0 +1,233 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/Hyodar/tdxs/pkg/api"
+	"github.com/Hyodar/tdxs/pkg/issuer"
+	"github.com/Hyodar/tdxs/pkg/logger"
+	"github.com/Hyodar/tdxs/pkg/validator"
+)
+
+// DefaultProfile is the profile name given to the top-level issuer and
+// validator, and the default profile when none is configured.
+const DefaultProfile = "default"
+
+// backends holds the issuers and validators serving requests, keyed by
+// profile. It is replaced as a whole on reload, so a request keeps using the
+// instances it started with.
+type backends struct {
+	issuers         map[string]issuer.Issuer
+	validators      map[string]validator.Validator
+	referenceValues map[string]string
+	defaultProfile  string
+
+	cancel context.CancelFunc
+}
+
+// issuerProfiles merges the top-level issuer into the named issuers under
+// DefaultProfile.
+func (c *ManagerConfig) issuerProfiles() (map[string]*IssuerConfig, error) {
+	profiles := make(map[string]*IssuerConfig, len(c.Issuers)+1)
+	for name, cfg := range c.Issuers {
+		if cfg == nil {
+			return nil, fmt.Errorf("issuer profile %s has no config", name)
+		}
+		profiles[name] = cfg
+	}
+	if c.Issuer != nil {
+		if _, exists := profiles[DefaultProfile]; exists {
+			return nil, fmt.Errorf("issuer and issuers.%s must not both be set", DefaultProfile)
+		}
+		profiles[DefaultProfile] = c.Issuer
+	}
+	return profiles, nil
+}
+
+// validatorProfiles merges the top-level validator into the named validators
+// under DefaultProfile.
+func (c *ManagerConfig) validatorProfiles() (map[string]*ValidatorConfig, error) {
+	profiles := make(map[string]*ValidatorConfig, len(c.Validators)+1)
+	for name, cfg := range c.Validators {
+		if cfg == nil {
+			return nil, fmt.Errorf("validator profile %s has no config", name)
+		}
+		profiles[name] = cfg
+	}
+	if c.Validator != nil {
+		if _, exists := profiles[DefaultProfile]; exists {
+			return nil, fmt.Errorf("validator and validators.%s must not both be set", DefaultProfile)
+		}
+		profiles[DefaultProfile] = c.Validator
+	}
+	return profiles, nil
+}
+
+func createBackends(cfg *ManagerConfig, logger logger.Logger) (*backends, error) {
+	issuerProfiles, err := cfg.issuerProfiles()
+	if err != nil {
+		return nil, err
+	}
+	validatorProfiles, err := cfg.validatorProfiles()
+	if err != nil {
+		return nil, err
+	}
+	if len(issuerProfiles) == 0 && len(validatorProfiles) == 0 {
+		return nil, fmt.Errorf("issuer or validator config is required")
+	}
+
+	b := &backends{
+		issuers:         make(map[string]issuer.Issuer, len(issuerProfiles)),
+		validators:      make(map[string]validator.Validator, len(validatorProfiles)),
+		referenceValues: make(map[string]string, len(validatorProfiles)),
+		defaultProfile:  cfg.DefaultProfile,
+	}
+	if b.defaultProfile == "" {
+		b.defaultProfile = DefaultProfile
+	}
+
+	for name, issuerCfg := range issuerProfiles {
+		b.issuers[name], err = createIssuer(issuerCfg, logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create issuer for profile %s: %w", name, err)
+		}
+	}
+
+	for name, validatorCfg := range validatorProfiles {
+		b.validators[name], err = createValidator(validatorCfg, logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create validator for profile %s: %w", name, err)
+		}
+		b.referenceValues[name], err = referenceValuesDigest(validatorCfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compute reference values digest for profile %s: %w", name, err)
+		}
+	}
+
+	if cfg.DefaultProfile != "" {
+		_, hasIssuer := b.issuers[cfg.DefaultProfile]
+		_, hasValidator := b.validators[cfg.DefaultProfile]
+		if !hasIssuer && !hasValidator {
+			return nil, fmt.Errorf("default profile %s has no issuer or validator", cfg.DefaultProfile)
+		}
+	}
+
+	return b, nil
+}
+
+// start starts every issuer and validator with a context that stop cancels.
+func (b *backends) start(ctx context.Context) error {
+	ctx, b.cancel = context.WithCancel(ctx)
+	for _, name := range sortedKeys(b.issuers) {
+		if err := b.issuers[name].Start(ctx); err != nil {
+			b.cancel()
+			return fmt.Errorf("failed to start issuer for profile %s: %w", name, err)
+		}
+	}
+	for _, name := range sortedKeys(b.validators) {
+		if err := b.validators[name].Start(ctx); err != nil {
+			b.cancel()
+			return fmt.Errorf("failed to start validator for profile %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// stop ends the background work of backends that were started. Requests
+// still using them are not affected.
+func (b *backends) stop() {
+	if b.cancel != nil {
+		b.cancel()
+	}
+}
+
+// resolve maps an empty profile name to the default profile.
+func (b *backends) resolve(profile string) string {
+	if profile == "" {
+		return b.defaultProfile
+	}
+	return profile
+}
+
+func (b *backends) issuer(profile string) (issuer.Issuer, error) {
+	i, ok := b.issuers[b.resolve(profile)]
+	if !ok {
+		if b.hasProfile(profile) {
+			return nil, api.Errorf(api.ErrorCodeNotEnabled, "issuer is not configured for profile %s", b.resolve(profile))
+		}
+		return nil, api.Errorf(api.ErrorCodeBadRequest, "unknown profile: %s", b.resolve(profile))
+	}
+	return i, nil
+}
+
+func (b *backends) validator(profile string) (validator.Validator, error) {
+	v, ok := b.validators[b.resolve(profile)]
+	if !ok {
+		if b.hasProfile(profile) {
+			return nil, api.Errorf(api.ErrorCodeNotEnabled, "validator is not configured for profile %s", b.resolve(profile))
+		}
+		return nil, api.Errorf(api.ErrorCodeBadRequest, "unknown profile: %s", b.resolve(profile))
+	}
+	return v, nil
+}
+
+func (b *backends) hasProfile(profile string) bool {
+	name := b.resolve(profile)
+	_, hasIssuer := b.issuers[name]
+	_, hasValidator := b.validators[name]
+	return hasIssuer || hasValidator
+}
+
+// profiles returns every profile name in sorted order.
+func (b *backends) profiles() []string {
+	seen := make(map[string]struct{}, len(b.issuers)+len(b.validators))
+	for name := range b.issuers {
+		seen[name] = struct{}{}
+	}
+	for name := range b.validators {
+		seen[name] = struct{}{}
+	}
+
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// unwrapIssuer returns the issuer behind an issue cache or batcher.
+func unwrapIssuer(i issuer.Issuer) issuer.Issuer {
+	for {
+		wrapper, ok := i.(interface{ Unwrap() issuer.Issuer })
+		if !ok {
+			return i
+		}
+		i = wrapper.Unwrap()
+	}
+}
+
+// unwrapValidator returns the validator behind the batch validator.
+func unwrapValidator(v validator.Validator) validator.Validator {
+	if wrapper, ok := v.(*validator.Batch); ok {
+		return wrapper.Unwrap()
+	}
+	return v
+}
+
+// cacheStats returns the issue cache counters of every profile with a cache.
+func (m *Manager) cacheStats() map[string]api.CacheStats {
+	b := m.backends.Load()
+	var stats map[string]api.CacheStats
+	for profile, i := range b.issuers {
+		if cache, ok := i.(*issuer.Cache); ok {
+			if stats == nil {
+				stats = make(map[string]api.CacheStats)
+			}
+			stats[profile] = cache.Stats()
+		}
+	}
+	return stats
+}
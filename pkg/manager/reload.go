@@ -0,0 +1,138 @@
+package manager
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+
+	"github.com/Hyodar/tdxs/pkg/api"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigLoader returns the current configuration for a reload.
+type ConfigLoader func() (*ManagerConfig, error)
+
+// LoadManagerConfig reads and parses a YAML configuration file.
+func LoadManagerConfig(path string) (*ManagerConfig, error) {
+	configData, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var config ManagerConfig
+	decoder := yaml.NewDecoder(bytes.NewReader(configData))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+
+	return &config, nil
+}
+
+// SetConfigLoader sets the source of configuration used by ReloadConfig.
+func (m *Manager) SetConfigLoader(loader ConfigLoader) {
+	m.reloadMu.Lock()
+	defer m.reloadMu.Unlock()
+	m.configLoader = loader
+}
+
+// ReloadConfig loads the configuration from the config loader and applies it
+// with Reload.
+func (m *Manager) ReloadConfig() error {
+	m.reloadMu.Lock()
+	loader := m.configLoader
+	m.reloadMu.Unlock()
+
+	if loader == nil {
+		return fmt.Errorf("reload is not supported: no config source")
+	}
+
+	cfg, err := loader()
+	if err != nil {
+		return err
+	}
+	return m.Reload(cfg)
+}
+
+// Reload rebuilds the issuer and validator from cfg and swaps them in.
+// Requests already being handled finish against the previous instances. If
+// the new configuration is invalid, the current one stays in place.
+func (m *Manager) Reload(cfg *ManagerConfig) error {
+	m.reloadMu.Lock()
+	defer m.reloadMu.Unlock()
+
+	if err := cfg.Validate(); err != nil {
+		m.logger.Error("Rejected config reload", "error", err)
+		return fmt.Errorf("invalid config: %w", err)
+	}
+
+	b, err := createBackends(cfg, m.logger)
+	if err != nil {
+		m.logger.Error("Rejected config reload", "error", err)
+		return fmt.Errorf("invalid config: %w", err)
+	}
+
+	if !reflect.DeepEqual(cfg.Transport, m.cfg.Transport) ||
+		!reflect.DeepEqual(cfg.Audit, m.cfg.Audit) ||
+		!reflect.DeepEqual(cfg.Health, m.cfg.Health) ||
+		!reflect.DeepEqual(cfg.RTMR, m.cfg.RTMR) {
+		m.logger.Warn("Transport, audit, health and RTMR config changes take effect only after a restart")
+	}
+
+	if m.runCtx != nil {
+		if err := b.start(m.runCtx); err != nil {
+			m.logger.Error("Rejected config reload", "error", err)
+			return fmt.Errorf("invalid config: %w", err)
+		}
+	}
+
+	m.backends.Swap(b).stop()
+	m.cfg = &ManagerConfig{
+		Transport:      m.cfg.Transport,
+		Issuer:         cfg.Issuer,
+		Validator:      cfg.Validator,
+		Issuers:        cfg.Issuers,
+		Validators:     cfg.Validators,
+		DefaultProfile: cfg.DefaultProfile,
+		Audit:          m.cfg.Audit,
+		Health:         m.cfg.Health,
+		RTMR:           m.cfg.RTMR,
+	}
+
+	m.logger.Info("Reloaded config", "profiles", b.profiles(), "defaultProfile", b.defaultProfile)
+	return nil
+}
+
+func (m *Manager) handleReloadRequest(ctx context.Context, wrapper *api.ReloadRequestWrapper) {
+	response := m.reload(wrapper)
+	select {
+	case wrapper.Response <- response:
+	case <-ctx.Done():
+	}
+}
+
+// reload applies a reload request. Only root and the daemon's own user may
+// reload, since a reload drops caches and pending batches.
+func (m *Manager) reload(wrapper *api.ReloadRequestWrapper) *api.ReloadResponse {
+	if err := authorizeAdmin(wrapper.Caller, "reload the config"); err != nil {
+		return &api.ReloadResponse{Error: err}
+	}
+	return &api.ReloadResponse{Error: m.ReloadConfig()}
+}
+
+// authorizeAdmin allows root and the daemon's own user to make an
+// administrative request. Callers whose credentials are unknown, e.g. on
+// platforms without peer credentials, are refused.
+func authorizeAdmin(caller *api.Caller, action string) error {
+	if caller == nil {
+		return api.Errorf(api.ErrorCodeUnauthorized, "caller credentials are unknown; refusing to %s", action)
+	}
+	if caller.UID != 0 && int(caller.UID) != os.Getuid() {
+		return api.Errorf(api.ErrorCodeUnauthorized, "uid %d may not %s", caller.UID, action)
+	}
+	return nil
+}
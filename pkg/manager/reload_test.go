@@ -0,0 +1,69 @@
+package manager
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Hyodar/tdxs/pkg/api"
+)
+
+func TestReloadUnauthorized(t *testing.T) {
+	loads := 0
+	m := &Manager{logger: slog.New(slog.DiscardHandler)}
+	m.SetConfigLoader(func() (*ManagerConfig, error) {
+		loads++
+		return nil, errors.New("no config")
+	})
+
+	uid := uint32(os.Getuid() + 1)
+	if uid == 0 {
+		uid++
+	}
+	resp := m.reload(&api.ReloadRequestWrapper{Caller: &api.Caller{UID: uid}, Request: &api.ReloadRequest{}})
+	if api.CodeOf(resp.Error) != api.ErrorCodeUnauthorized {
+		t.Errorf("reload by uid %d: error = %v, want %s", uid, resp.Error, api.ErrorCodeUnauthorized)
+	}
+	if loads != 0 {
+		t.Errorf("config loaded %d times by an unauthorized reload", loads)
+	}
+
+	// Callers whose credentials could not be determined are refused too.
+	resp = m.reload(&api.ReloadRequestWrapper{Request: &api.ReloadRequest{}})
+	if api.CodeOf(resp.Error) != api.ErrorCodeUnauthorized {
+		t.Errorf("reload by an unknown caller: error = %v, want %s", resp.Error, api.ErrorCodeUnauthorized)
+	}
+	if loads != 0 {
+		t.Errorf("config loaded %d times by an unauthorized reload", loads)
+	}
+
+	resp = m.reload(&api.ReloadRequestWrapper{Caller: &api.Caller{UID: uint32(os.Getuid())}, Request: &api.ReloadRequest{}})
+	if resp.Error == nil || loads != 1 {
+		t.Errorf("reload by the daemon's uid: error = %v after %d loads, want the loader's error", resp.Error, loads)
+	}
+}
+
+func TestLoadManagerConfigUnknownKeys(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		config string
+	}{
+		{name: "TopLevel", config: "issuer:\n  type: simulator\nbogus: true\n"},
+		{name: "Backend", config: "issuer:\n  type: simulator\n  config:\n    bogus: true\n"},
+		// Kernels and initrds are allowed through files, since the event log
+		// does not tell which PCR9 file was booted.
+		{name: "BootPolicyKernels", config: "validator:\n  type: azure\n  config:\n    boot_policy:\n      kernels: [\"00\"]\n"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(path, []byte(tt.config), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := LoadManagerConfig(path); err == nil {
+				t.Error("config with an unknown key loaded")
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package manager
+
+import (
+	"reflect"
+
+	"github.com/Hyodar/tdxs/pkg/audit"
+	"github.com/Hyodar/tdxs/pkg/health"
+	"github.com/Hyodar/tdxs/pkg/issuer"
+	"github.com/Hyodar/tdxs/pkg/registry"
+	"github.com/Hyodar/tdxs/pkg/rtmr"
+	"github.com/Hyodar/tdxs/pkg/schema"
+	"github.com/Hyodar/tdxs/pkg/transport"
+	"github.com/Hyodar/tdxs/pkg/validator"
+)
+
+// ConfigSchema returns a JSON Schema for the config file, covering every
+// transport, issuer and validator type registered at the time of the call.
+func ConfigSchema() schema.Schema {
+	issuerSchema := typedSchema(issuer.Registry)
+	for _, variant := range issuerSchema["oneOf"].([]schema.Schema) {
+		variant["properties"].(schema.Schema)["cache"] = schema.For(reflect.TypeFor[issuer.CacheConfig]())
+		variant["properties"].(schema.Schema)["batch"] = schema.For(reflect.TypeFor[issuer.BatchConfig]())
+	}
+	validatorSchema := typedSchema(validator.Registry)
+
+	return schema.Schema{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"title":   "tdxs configuration",
+		"type":    "object",
+		"properties": schema.Schema{
+			"transport": typedSchema(transport.Registry),
+			"issuer":    issuerSchema,
+			"validator": validatorSchema,
+			"issuers": schema.Schema{
+				"type":                 "object",
+				"additionalProperties": issuerSchema,
+			},
+			"validators": schema.Schema{
+				"type":                 "object",
+				"additionalProperties": validatorSchema,
+			},
+			"default_profile": schema.Schema{"type": "string"},
+			"audit":           schema.For(reflect.TypeFor[audit.AuditConfig]()),
+			"health":          schema.For(reflect.TypeFor[health.HealthConfig]()),
+			"rtmr":            schema.For(reflect.TypeFor[rtmr.RTMRConfig]()),
+		},
+		"required":             []string{"transport"},
+		"additionalProperties": false,
+	}
+}
+
+// typedSchema describes a `type`/`config` pair, with one alternative per
+// registered type.
+func typedSchema[T any](r *registry.Registry[T]) schema.Schema {
+	var variants []schema.Schema
+	for _, name := range r.Names() {
+		entry, err := r.Lookup(name)
+		if err != nil {
+			continue
+		}
+
+		configSchema := schema.Schema{"type": []string{"null", "object"}, "maxProperties": 0}
+		if entry.ConfigType != nil {
+			configSchema = schema.For(entry.ConfigType)
+		}
+
+		variants = append(variants, schema.Schema{
+			"type": "object",
+			"properties": schema.Schema{
+				"type":   schema.Schema{"const": name},
+				"config": configSchema,
+			},
+			"required":             []string{"type"},
+			"additionalProperties": false,
+		})
+	}
+	return schema.Schema{"oneOf": variants}
+}
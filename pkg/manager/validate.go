@@ -0,0 +1,108 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// configValidator is implemented by backend configs that can check
+// themselves beyond what decoding already enforces.
+type configValidator interface {
+	Validate() error
+}
+
+// Validate checks the whole configuration without creating or starting
+// anything, reporting every problem found rather than only the first.
+func (c *ManagerConfig) Validate() error {
+	var errs []error
+	addErr := func(path string, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", path, err))
+		}
+	}
+
+	if c.Transport == nil {
+		errs = append(errs, fmt.Errorf("transport: config is required"))
+	} else {
+		addErr("transport.config", validateBackendConfig(c.Transport.Config))
+	}
+
+	issuerProfiles, err := c.issuerProfiles()
+	addErr("issuers", err)
+	validatorProfiles, err := c.validatorProfiles()
+	addErr("validators", err)
+
+	if len(issuerProfiles) == 0 && len(validatorProfiles) == 0 {
+		errs = append(errs, fmt.Errorf("issuer or validator config is required"))
+	}
+
+	for _, name := range sortedKeys(issuerProfiles) {
+		path := "issuers." + name
+		if name == DefaultProfile && c.Issuer != nil {
+			path = "issuer"
+		}
+		addErr(path+".config", validateBackendConfig(issuerProfiles[name].Config))
+		if cache := issuerProfiles[name].Cache; cache != nil {
+			addErr(path+".cache", cache.Validate())
+		}
+		if batch := issuerProfiles[name].Batch; batch != nil {
+			addErr(path+".batch", batch.Validate())
+		}
+	}
+	for _, name := range sortedKeys(validatorProfiles) {
+		path := "validators." + name
+		if name == DefaultProfile && c.Validator != nil {
+			path = "validator"
+		}
+		addErr(path+".config", validateBackendConfig(validatorProfiles[name].Config))
+	}
+
+	if c.DefaultProfile != "" {
+		_, hasIssuer := issuerProfiles[c.DefaultProfile]
+		_, hasValidator := validatorProfiles[c.DefaultProfile]
+		if !hasIssuer && !hasValidator {
+			errs = append(errs, fmt.Errorf("default_profile: profile %s has no issuer or validator", c.DefaultProfile))
+		}
+	}
+
+	if c.Audit != nil {
+		addErr("audit", c.Audit.Validate())
+	}
+	if c.Health != nil {
+		addErr("health", c.Health.Validate())
+	}
+	if c.RTMR != nil {
+		addErr("rtmr", c.RTMR.Validate())
+	}
+
+	return errors.Join(errs...)
+}
+
+func validateBackendConfig(cfg any) error {
+	switch v := cfg.(type) {
+	case nil:
+		return nil
+	case configValidator:
+		return v.Validate()
+	}
+
+	// Registries store configs by value, while Validate usually has a
+	// pointer receiver.
+	ptr := reflect.New(reflect.TypeOf(cfg))
+	ptr.Elem().Set(reflect.ValueOf(cfg))
+	if v, ok := ptr.Interface().(configValidator); ok {
+		return v.Validate()
+	}
+	return nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,96 @@
+package measure
+
+import (
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// Authenticode computes the SHA-384 Authenticode digest of a PE image, which
+// is what UEFI firmware measures when it loads an EFI application: the
+// headers without the checksum and the certificate table entry, the sections
+// in file order, then any trailing data except the certificates.
+func Authenticode(image []byte) ([]byte, error) {
+	if len(image) < 0x40 || string(image[:2]) != "MZ" {
+		return nil, fmt.Errorf("not a PE image")
+	}
+	pe := int(binary.LittleEndian.Uint32(image[0x3c:]))
+	if pe < 0 || pe+24 > len(image) || string(image[pe:pe+4]) != "PE\x00\x00" {
+		return nil, fmt.Errorf("not a PE image: no PE signature")
+	}
+	coff := pe + 4
+	numSections := int(binary.LittleEndian.Uint16(image[coff+2:]))
+	optionalSize := int(binary.LittleEndian.Uint16(image[coff+16:]))
+	optional := coff + 20
+	if optional+optionalSize > len(image) || optionalSize < 2 {
+		return nil, fmt.Errorf("truncated PE optional header")
+	}
+
+	var numDirsOffset int
+	switch magic := binary.LittleEndian.Uint16(image[optional:]); magic {
+	case 0x10b: // PE32
+		numDirsOffset = optional + 92
+	case 0x20b: // PE32+
+		numDirsOffset = optional + 108
+	default:
+		return nil, fmt.Errorf("unknown PE optional header magic %#x", magic)
+	}
+	if numDirsOffset+4 > optional+optionalSize {
+		return nil, fmt.Errorf("truncated PE optional header")
+	}
+	checksum := optional + 64
+	sizeOfHeaders := int(binary.LittleEndian.Uint32(image[optional+60:]))
+	numDirs := binary.LittleEndian.Uint32(image[numDirsOffset:])
+	if sizeOfHeaders > len(image) || sizeOfHeaders < optional+optionalSize {
+		return nil, fmt.Errorf("invalid PE header size %#x", sizeOfHeaders)
+	}
+
+	h := sha512.New384()
+	h.Write(image[:checksum])
+	var certSize int
+	if numDirs > 4 {
+		// The certificate table is data directory 4.
+		certDir := numDirsOffset + 4 + 4*8
+		if certDir+8 > optional+optionalSize {
+			return nil, fmt.Errorf("truncated PE data directories")
+		}
+		h.Write(image[checksum+4 : certDir])
+		h.Write(image[certDir+8 : sizeOfHeaders])
+		certSize = int(binary.LittleEndian.Uint32(image[certDir+4:]))
+	} else {
+		h.Write(image[checksum+4 : sizeOfHeaders])
+	}
+
+	type rawData struct{ offset, size int }
+	table := optional + optionalSize
+	if table+40*numSections > len(image) {
+		return nil, fmt.Errorf("truncated PE section table")
+	}
+	sections := make([]rawData, 0, numSections)
+	for i := range numSections {
+		entry := image[table+40*i:]
+		section := rawData{
+			size:   int(binary.LittleEndian.Uint32(entry[16:])),
+			offset: int(binary.LittleEndian.Uint32(entry[20:])),
+		}
+		if section.size == 0 {
+			continue
+		}
+		if section.offset < 0 || section.size < 0 || section.offset+section.size > len(image) {
+			return nil, fmt.Errorf("PE section %d is outside the image", i)
+		}
+		sections = append(sections, section)
+	}
+	sort.SliceStable(sections, func(i, j int) bool { return sections[i].offset < sections[j].offset })
+
+	hashed := sizeOfHeaders
+	for _, section := range sections {
+		h.Write(image[section.offset : section.offset+section.size])
+		hashed += section.size
+	}
+	if end := len(image) - certSize; end > hashed {
+		h.Write(image[hashed:end])
+	}
+	return h.Sum(nil), nil
+}
@@ -0,0 +1,73 @@
+package measure
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// acpiDataSize is the memory QEMU's q35 machine reserves for ACPI tables
+// below 4 GiB.
+const acpiDataSize = 0x20000 + 0x8000
+
+// PatchKernel applies the changes QEMU makes to the setup header of a bzImage
+// before handing it to the firmware for direct kernel boot: the loader type,
+// heap and command line pointers, and the initrd address and size. The
+// firmware measures the patched image, so the RTMR1 digest depends on the
+// initrd size and the guest memory size.
+func PatchKernel(kernel []byte, initrdSize int, memory uint64) ([]byte, error) {
+	if len(kernel) < 0x238 {
+		return nil, fmt.Errorf("kernel image is too small")
+	}
+	patched := append([]byte{}, kernel...)
+	le := binary.LittleEndian
+
+	var protocol uint16
+	if string(patched[0x202:0x206]) == "HdrS" {
+		protocol = le.Uint16(patched[0x206:])
+	}
+
+	// Only bzImages loaded high (LOADED_HIGH) with boot protocol 2.02 or
+	// later are supported; QEMU places their setup code and command line at
+	// fixed addresses.
+	if protocol < 0x202 || patched[0x211]&0x01 == 0 {
+		return nil, fmt.Errorf("kernel boot protocol %#x is too old for direct boot", protocol)
+	}
+	const realAddr, cmdlineAddr = 0x10000, 0x20000
+
+	var initrdMax uint32
+	switch {
+	case protocol >= 0x20c && le.Uint16(patched[0x236:])&0x40 != 0: // XLF_CAN_BE_LOADED_ABOVE_4G
+		initrdMax = math.MaxUint32
+	case protocol >= 0x203:
+		initrdMax = le.Uint32(patched[0x22c:])
+	default:
+		initrdMax = 0x37ffffff
+	}
+	if lowMemory := belowFourGiB(memory); uint64(initrdMax) >= lowMemory-acpiDataSize {
+		initrdMax = uint32(lowMemory - acpiDataSize - 1)
+	}
+
+	le.PutUint32(patched[0x228:], cmdlineAddr)
+	patched[0x210] = 0xb0  // type_of_loader: QEMU
+	patched[0x211] |= 0x80 // CAN_USE_HEAP
+	le.PutUint16(patched[0x224:], cmdlineAddr-realAddr-0x200)
+
+	if initrdSize > 0 {
+		if uint64(initrdSize) >= uint64(initrdMax) {
+			return nil, fmt.Errorf("initrd of %d bytes does not fit below %#x", initrdSize, initrdMax)
+		}
+		le.PutUint32(patched[0x218:], (initrdMax-uint32(initrdSize))&^(pageSize-1))
+		le.PutUint32(patched[0x21c:], uint32(initrdSize))
+	}
+	return patched, nil
+}
+
+// belowFourGiB is the guest memory q35 maps below 4 GiB: all of it up to
+// 2.75 GiB, 2 GiB otherwise.
+func belowFourGiB(memory uint64) uint64 {
+	if memory >= 0xb0000000 {
+		return 0x80000000
+	}
+	return memory
+}
@@ -0,0 +1,156 @@
+// Package measure predicts the measurements of a TD that QEMU boots from
+// TDVF with a kernel, initrd and command line passed directly (-kernel,
+// -initrd, -append), so reference values can be computed from an image build
+// without TDX hardware.
+package measure
+
+import (
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"unicode/utf16"
+
+	"github.com/google/go-tdx-guest/abi"
+
+	"github.com/Hyodar/tdxs/pkg/eventlog"
+	"github.com/Hyodar/tdxs/pkg/rtmr"
+)
+
+// EFI actions TDVF measures into RTMR1 around starting the kernel.
+const (
+	actionCallingEFIApplication = "Calling EFI Application from Boot Option"
+	actionExitBootServices      = "Exit Boot Services Invocation"
+	actionExitBootServicesDone  = "Exit Boot Services Returned with Success"
+)
+
+// minMemory is the smallest guest memory size accepted, well below what a
+// TD needs to boot.
+const minMemory = 64 << 20
+
+// Input describes the TD to measure.
+type Input struct {
+	Firmware []byte
+	Kernel   []byte
+	Initrd   []byte
+	Cmdline  string
+	// Memory is the guest memory size in bytes. QEMU places the initrd
+	// depending on it, which changes the measured kernel image.
+	Memory uint64
+	// FirmwareLog is a CCEL captured from a TD booted with the same firmware
+	// and VM configuration. RTMR0 holds measurements of the TD HOB, the ACPI
+	// tables and UEFI variables, which depend on the VMM, so it is replayed
+	// from this log. Without it, RTMR0 is not computed.
+	FirmwareLog []byte
+}
+
+// Event is one measurement extended into an RTMR.
+type Event struct {
+	RTMR        int    `json:"rtmr"`
+	Description string `json:"description"`
+	Digest      string `json:"digest"`
+}
+
+// Result holds the predicted measurements, hex encoded with a 0x prefix.
+type Result struct {
+	Sources []string `json:"sources,omitempty"`
+
+	MrTd  string `json:"mrtd"`
+	Rtmr0 string `json:"rtmr0,omitempty"`
+	Rtmr1 string `json:"rtmr1"`
+	Rtmr2 string `json:"rtmr2"`
+	Rtmr3 string `json:"rtmr3"`
+
+	Events []Event `json:"events"`
+}
+
+// Measure computes MRTD from the firmware and RTMR0-3 from the firmware log,
+// kernel, initrd and command line. RTMR3 is left to the OS and reported at
+// its initial value.
+func Measure(in *Input) (*Result, error) {
+	if in.Memory < minMemory {
+		return nil, fmt.Errorf("memory must be at least %d MiB", minMemory>>20)
+	}
+	tdvf, err := ParseTDVF(in.Firmware)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse firmware: %w", err)
+	}
+
+	result := &Result{MrTd: hexEncode(tdvf.MRTD())}
+	var values [rtmr.Count][]byte
+	for i := range values {
+		values[i] = make([]byte, abi.RtmrSize)
+	}
+	extend := func(index int, description string, digest []byte) {
+		values[index] = rtmr.Extended(values[index], digest)
+		result.Events = append(result.Events, Event{RTMR: index, Description: description, Digest: hex.EncodeToString(digest)})
+	}
+
+	if in.FirmwareLog != nil {
+		events, err := eventlog.ParseCCEL(in.FirmwareLog)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse firmware log: %w", err)
+		}
+		for _, event := range events {
+			if index, ok := event.RTMR(); ok && index == 0 {
+				extend(0, eventlog.EventTypeName(event.Type), event.Digest)
+			}
+		}
+	}
+
+	// TDVF measures the kernel when loading it, then the boot attempt, the
+	// separator and ExitBootServices.
+	kernel, err := PatchKernel(in.Kernel, len(in.Initrd), in.Memory)
+	if err != nil {
+		return nil, err
+	}
+	kernelDigest, err := Authenticode(kernel)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash kernel: %w", err)
+	}
+	extend(1, "kernel", kernelDigest)
+	extend(1, actionCallingEFIApplication, sha384([]byte(actionCallingEFIApplication)))
+	extend(1, "separator", sha384([]byte{0, 0, 0, 0}))
+	extend(1, actionExitBootServices, sha384([]byte(actionExitBootServices)))
+	extend(1, actionExitBootServicesDone, sha384([]byte(actionExitBootServicesDone)))
+
+	// The kernel's EFI stub measures its load options, which TDVF builds from
+	// the command line and a reference to the initrd, and the initrd.
+	if in.Cmdline != "" || len(in.Initrd) > 0 {
+		extend(2, "kernel command line", sha384(loadOptions(in.Cmdline, len(in.Initrd) > 0)))
+	}
+	if len(in.Initrd) > 0 {
+		extend(2, "initrd", sha384(in.Initrd))
+	}
+
+	if in.FirmwareLog != nil {
+		result.Rtmr0 = hexEncode(values[0])
+	}
+	result.Rtmr1 = hexEncode(values[1])
+	result.Rtmr2 = hexEncode(values[2])
+	result.Rtmr3 = hexEncode(values[3])
+	return result, nil
+}
+
+// loadOptions encodes the load options TDVF passes to the kernel: the command
+// line, followed by " initrd=initrd" if there is an initrd, as a
+// NUL-terminated UTF-16 string.
+func loadOptions(cmdline string, initrd bool) []byte {
+	if initrd {
+		cmdline += " initrd=initrd"
+	}
+	units := utf16.Encode([]rune(cmdline))
+	encoded := make([]byte, 0, 2*len(units)+2)
+	for _, unit := range units {
+		encoded = append(encoded, byte(unit), byte(unit>>8))
+	}
+	return append(encoded, 0, 0)
+}
+
+func sha384(data []byte) []byte {
+	sum := sha512.Sum384(data)
+	return sum[:]
+}
+
+func hexEncode(data []byte) string {
+	return "0x" + hex.EncodeToString(data)
+}
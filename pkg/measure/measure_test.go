@@ -0,0 +1,219 @@
+package measure_test
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+
+	"github.com/Hyodar/tdxs/pkg/eventlog"
+	"github.com/Hyodar/tdxs/pkg/measure"
+	"github.com/Hyodar/tdxs/pkg/rtmr"
+)
+
+// firmware builds a TDVF image: the section data, the TDX metadata and the
+// OVMF GUID table pointing at it.
+func firmware(data []byte, sections []measure.Section) []byte {
+	le := binary.LittleEndian
+	image := append([]byte{}, data...)
+
+	metadata := len(image)
+	image = append(image, "TDVF"...)
+	image = le.AppendUint32(image, uint32(16+32*len(sections)))
+	image = le.AppendUint32(image, 1)
+	image = le.AppendUint32(image, uint32(len(sections)))
+	for _, s := range sections {
+		image = le.AppendUint32(image, s.DataOffset)
+		image = le.AppendUint32(image, s.RawDataSize)
+		image = le.AppendUint64(image, s.MemoryAddress)
+		image = le.AppendUint64(image, s.MemoryDataSize)
+		image = le.AppendUint32(image, s.Type)
+		image = le.AppendUint32(image, s.Attributes)
+	}
+
+	tableSize := 22 + 18 + 0x20
+	image = le.AppendUint32(image, uint32(len(image)+tableSize-metadata))
+	image = le.AppendUint16(image, 22)
+	image = append(image, 0x35, 0x65, 0x7a, 0xe4, 0x4a, 0x98, 0x98, 0x47, 0x86, 0x5e, 0x46, 0x85, 0xa7, 0xbf, 0x8e, 0xc2)
+	image = le.AppendUint16(image, 22+18)
+	image = append(image, 0xde, 0x82, 0xb5, 0x96, 0xb2, 0x1f, 0xf7, 0x45, 0xba, 0xea, 0xa3, 0x66, 0xc5, 0x5a, 0x08, 0x2d)
+	return append(image, make([]byte, 0x20)...)
+}
+
+func mrtdOperation(name string, gpa uint64) []byte {
+	buf := make([]byte, 128)
+	copy(buf, name)
+	binary.LittleEndian.PutUint64(buf[16:], gpa)
+	return buf
+}
+
+func TestMRTD(t *testing.T) {
+	bfv := bytes.Repeat([]byte("firmware"), 512) // one page
+	sections := []measure.Section{
+		{DataOffset: 0, RawDataSize: 4096, MemoryAddress: 0xfffff000, MemoryDataSize: 4096, Type: measure.SectionTypeBFV, Attributes: 1},
+		{MemoryAddress: 0x800000, MemoryDataSize: 8192, Type: measure.SectionTypeTempMem},
+		{MemoryAddress: 0x1000000, MemoryDataSize: 1 << 30, Type: measure.SectionTypePermMem, Attributes: 2},
+	}
+	tdvf, err := measure.ParseTDVF(firmware(bfv, sections))
+	if err != nil {
+		t.Fatalf("failed to parse firmware: %v", err)
+	}
+	if len(tdvf.Sections) != len(sections) || tdvf.Sections[1] != sections[1] {
+		t.Fatalf("sections = %+v, want %+v", tdvf.Sections, sections)
+	}
+
+	// The BFV page is added and extended, the temporary memory pages are
+	// only added and the PAGE_AUG section is left out.
+	h := sha512.New384()
+	h.Write(mrtdOperation("MEM.PAGE.ADD", 0xfffff000))
+	for chunk := 0; chunk < 4096; chunk += 256 {
+		h.Write(mrtdOperation("MR.EXTEND", 0xfffff000+uint64(chunk)))
+		h.Write(bfv[chunk : chunk+256])
+	}
+	h.Write(mrtdOperation("MEM.PAGE.ADD", 0x800000))
+	h.Write(mrtdOperation("MEM.PAGE.ADD", 0x801000))
+	if got, want := tdvf.MRTD(), h.Sum(nil); !bytes.Equal(got, want) {
+		t.Errorf("MRTD = %x, want %x", got, want)
+	}
+
+	if _, err := measure.ParseTDVF(bfv); err == nil {
+		t.Error("image without TDX metadata parsed without error")
+	}
+}
+
+// peImage builds a PE32+ image with one section whose data starts at 0x200,
+// and a bzImage setup header.
+func peImage(section []byte) []byte {
+	le := binary.LittleEndian
+	image := make([]byte, 0x200+len(section))
+	copy(image, "MZ")
+	le.PutUint32(image[0x3c:], 0x40)
+	copy(image[0x40:], "PE\x00\x00")
+	coff := 0x44
+	le.PutUint16(image[coff:], 0x8664)
+	le.PutUint16(image[coff+2:], 1)
+	le.PutUint16(image[coff+16:], 240)
+	optional := coff + 20
+	le.PutUint16(image[optional:], 0x20b)
+	le.PutUint32(image[optional+60:], 0x200)
+	le.PutUint32(image[optional+108:], 16)
+	table := optional + 240
+	copy(image[table:], ".text")
+	le.PutUint32(image[table+16:], uint32(len(section)))
+	le.PutUint32(image[table+20:], 0x200)
+	copy(image[0x200:], section)
+
+	// Setup header: boot protocol 2.15, loaded high, initrd anywhere.
+	copy(image[0x202:], "HdrS")
+	le.PutUint16(image[0x206:], 0x20f)
+	image[0x211] = 0x01
+	le.PutUint16(image[0x236:], 0x40)
+	return image
+}
+
+func TestAuthenticode(t *testing.T) {
+	image := peImage(bytes.Repeat([]byte{0xcc}, 0x200))
+	digest, err := measure.Authenticode(image)
+	if err != nil {
+		t.Fatalf("failed to hash image: %v", err)
+	}
+
+	// The checksum and signatures are not covered.
+	signed := append([]byte{}, image...)
+	binary.LittleEndian.PutUint32(signed[0x44+20+64:], 0x1234)
+	certDir := 0x44 + 20 + 112 + 4*8
+	binary.LittleEndian.PutUint32(signed[certDir:], uint32(len(signed)))
+	binary.LittleEndian.PutUint32(signed[certDir+4:], 16)
+	signed = append(signed, bytes.Repeat([]byte{0xaa}, 16)...)
+	if got, err := measure.Authenticode(signed); err != nil || !bytes.Equal(got, digest) {
+		t.Errorf("digest of signed image = %x, %v; want %x", got, err, digest)
+	}
+
+	image[0x300] ^= 1
+	if got, _ := measure.Authenticode(image); bytes.Equal(got, digest) {
+		t.Error("digest did not change with the section data")
+	}
+	if _, err := measure.Authenticode([]byte("not a PE image")); err == nil {
+		t.Error("garbage hashed without error")
+	}
+}
+
+func TestPatchKernel(t *testing.T) {
+	kernel := peImage(make([]byte, 0x200))
+	patched, err := measure.PatchKernel(kernel, 0x1800, 4<<30)
+	if err != nil {
+		t.Fatalf("failed to patch kernel: %v", err)
+	}
+	le := binary.LittleEndian
+	// With 4 GiB, 2 GiB are below 4 GiB; QEMU reserves 0x28000 bytes for
+	// ACPI at the top and page-aligns the initrd below.
+	if addr := le.Uint32(patched[0x218:]); addr != (0x7ffd7fff-0x1800)&^0xfff {
+		t.Errorf("initrd address = %#x", addr)
+	}
+	if size := le.Uint32(patched[0x21c:]); size != 0x1800 {
+		t.Errorf("initrd size = %#x", size)
+	}
+	if patched[0x210] != 0xb0 || patched[0x211] != 0x81 || le.Uint32(patched[0x228:]) != 0x20000 || le.Uint16(patched[0x224:]) != 0xfe00 {
+		t.Errorf("setup header not patched like QEMU: % x", patched[0x210:0x22c])
+	}
+	if bytes.Equal(kernel, patched) {
+		t.Error("PatchKernel modified its input in place")
+	}
+}
+
+func TestMeasure(t *testing.T) {
+	fw := firmware(make([]byte, 4096), []measure.Section{
+		{RawDataSize: 4096, MemoryAddress: 0xfffff000, MemoryDataSize: 4096, Attributes: 1},
+	})
+	initrd := []byte("initrd")
+	tdvfDigest := sha512.Sum384([]byte("td hob"))
+	firmwareLog := eventlog.MarshalCCEL([]eventlog.Event{
+		{MRIndex: 1, Type: eventlog.EventTypeEFIHandoffTables2, Digest: tdvfDigest[:]},
+		{MRIndex: 2, Type: eventlog.EventTypeEFIBootServicesApplication, Digest: tdvfDigest[:]},
+	})
+	in := &measure.Input{
+		Firmware: fw,
+		Kernel:   peImage(make([]byte, 0x200)),
+		Initrd:   initrd,
+		Cmdline:  "console=hvc0",
+		Memory:   2 << 30,
+	}
+
+	result, err := measure.Measure(in)
+	if err != nil {
+		t.Fatalf("failed to measure: %v", err)
+	}
+	if result.Rtmr0 != "" {
+		t.Errorf("rtmr0 = %s without a firmware log", result.Rtmr0)
+	}
+	cmdline := sha512.Sum384([]byte("c\x00o\x00n\x00s\x00o\x00l\x00e\x00=\x00h\x00v\x00c\x000\x00 \x00i\x00n\x00i\x00t\x00r\x00d\x00=\x00i\x00n\x00i\x00t\x00r\x00d\x00\x00\x00"))
+	initrdDigest := sha512.Sum384(initrd)
+	rtmr2 := rtmr.Extended(rtmr.Extended(make([]byte, 48), cmdline[:]), initrdDigest[:])
+	if result.Rtmr2 != "0x"+hex.EncodeToString(rtmr2) {
+		t.Errorf("rtmr2 = %s, want %x", result.Rtmr2, rtmr2)
+	}
+	if result.Rtmr3 != "0x"+hex.EncodeToString(make([]byte, 48)) {
+		t.Errorf("rtmr3 = %s, want zero", result.Rtmr3)
+	}
+
+	// RTMR0 replays only the RTMR0 events of the firmware log.
+	in.FirmwareLog = firmwareLog
+	withLog, err := measure.Measure(in)
+	if err != nil {
+		t.Fatalf("failed to measure with firmware log: %v", err)
+	}
+	if rtmr0 := rtmr.Extended(make([]byte, 48), tdvfDigest[:]); withLog.Rtmr0 != "0x"+hex.EncodeToString(rtmr0) {
+		t.Errorf("rtmr0 = %s, want %x", withLog.Rtmr0, rtmr0)
+	}
+	if withLog.Rtmr1 != result.Rtmr1 || withLog.MrTd != result.MrTd {
+		t.Error("the firmware log changed measurements other than RTMR0")
+	}
+
+	// QEMU places the initrd below the top of low memory, which changes the
+	// measured kernel.
+	in.Memory = 1 << 30
+	if other, _ := measure.Measure(in); other.Rtmr1 == result.Rtmr1 {
+		t.Error("rtmr1 does not depend on the memory size")
+	}
+}
@@ -0,0 +1,174 @@
+package measure
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+)
+
+// GUIDs of the OVMF footer table and of its entry pointing at the TDX
+// metadata, in their in-memory byte order.
+var (
+	// 96b582de-1fb2-45f7-baea-a366c55a082d
+	ovmfTableFooterGUID = []byte{0xde, 0x82, 0xb5, 0x96, 0xb2, 0x1f, 0xf7, 0x45, 0xba, 0xea, 0xa3, 0x66, 0xc5, 0x5a, 0x08, 0x2d}
+	// e47a6535-984a-4798-865e-4685a7bf8ec2
+	tdxMetadataGUID = []byte{0x35, 0x65, 0x7a, 0xe4, 0x4a, 0x98, 0x98, 0x47, 0x86, 0x5e, 0x46, 0x85, 0xa7, 0xbf, 0x8e, 0xc2}
+)
+
+const (
+	tdvfSignature = "TDVF"
+	pageSize      = 4096
+	extendChunk   = 256
+
+	// Section attributes.
+	attributeMRExtend = 0x1
+	attributePageAug  = 0x2
+)
+
+// Section types of the TDVF metadata.
+const (
+	SectionTypeBFV uint32 = iota
+	SectionTypeCFV
+	SectionTypeTDHOB
+	SectionTypeTempMem
+	SectionTypePermMem
+	SectionTypePayload
+	SectionTypePayloadParam
+)
+
+// Section is a TDVF metadata section: a range of TD memory the VMM
+// initializes from the firmware image before the TD starts.
+type Section struct {
+	DataOffset     uint32
+	RawDataSize    uint32
+	MemoryAddress  uint64
+	MemoryDataSize uint64
+	Type           uint32
+	Attributes     uint32
+}
+
+// TDVF is a TDX firmware image (OVMF built for TDX) with its metadata.
+type TDVF struct {
+	Image    []byte
+	Sections []Section
+}
+
+// ParseTDVF finds the TDX metadata of a firmware image through the OVMF
+// GUID table at the end of the image.
+func ParseTDVF(image []byte) (*TDVF, error) {
+	offset, err := tdxMetadataOffset(image)
+	if err != nil {
+		return nil, err
+	}
+	if offset < 16 || offset > uint32(len(image)) {
+		return nil, fmt.Errorf("TDX metadata offset %#x out of range", offset)
+	}
+	metadata := image[len(image)-int(offset):]
+	if string(metadata[:4]) != tdvfSignature {
+		return nil, fmt.Errorf("TDX metadata has no TDVF signature")
+	}
+	count := binary.LittleEndian.Uint32(metadata[12:16])
+	if uint64(count)*32 > uint64(len(metadata)-16) {
+		return nil, fmt.Errorf("TDX metadata with %d sections is truncated", count)
+	}
+
+	tdvf := &TDVF{Image: image}
+	for i := range int(count) {
+		entry := metadata[16+32*i:]
+		section := Section{
+			DataOffset:     binary.LittleEndian.Uint32(entry[0:4]),
+			RawDataSize:    binary.LittleEndian.Uint32(entry[4:8]),
+			MemoryAddress:  binary.LittleEndian.Uint64(entry[8:16]),
+			MemoryDataSize: binary.LittleEndian.Uint64(entry[16:24]),
+			Type:           binary.LittleEndian.Uint32(entry[24:28]),
+			Attributes:     binary.LittleEndian.Uint32(entry[28:32]),
+		}
+		if uint64(section.DataOffset)+uint64(section.RawDataSize) > uint64(len(image)) {
+			return nil, fmt.Errorf("section %d data is outside the image", i)
+		}
+		if uint64(section.RawDataSize) > section.MemoryDataSize || section.MemoryAddress%pageSize != 0 || section.MemoryDataSize%pageSize != 0 {
+			return nil, fmt.Errorf("section %d is not page aligned", i)
+		}
+		tdvf.Sections = append(tdvf.Sections, section)
+	}
+	return tdvf, nil
+}
+
+// tdxMetadataOffset reads the TDX metadata entry of the OVMF GUID table. The
+// table ends 0x20 bytes before the end of the image with its footer GUID,
+// preceded by the table length; entries are laid out backwards as data,
+// length and GUID.
+func tdxMetadataOffset(image []byte) (uint32, error) {
+	const tableEnd = 0x20
+	if len(image) < tableEnd+18 {
+		return 0, fmt.Errorf("image is too small for an OVMF GUID table")
+	}
+	end := len(image) - tableEnd
+	if !bytes.Equal(image[end-16:end], ovmfTableFooterGUID) {
+		return 0, fmt.Errorf("image has no OVMF GUID table")
+	}
+	tableLength := int(binary.LittleEndian.Uint16(image[end-18:]))
+	start := end - tableLength
+	if tableLength < 18 || start < 0 {
+		return 0, fmt.Errorf("invalid OVMF GUID table length %d", tableLength)
+	}
+
+	for cur := end - 18; cur-18 >= start; {
+		entryLength := int(binary.LittleEndian.Uint16(image[cur-18:]))
+		if entryLength < 18 || cur-entryLength < start {
+			return 0, fmt.Errorf("invalid OVMF GUID table entry")
+		}
+		if bytes.Equal(image[cur-16:cur], tdxMetadataGUID) {
+			if entryLength < 22 {
+				return 0, fmt.Errorf("invalid TDX metadata entry")
+			}
+			return binary.LittleEndian.Uint32(image[cur-entryLength:]), nil
+		}
+		cur -= entryLength
+	}
+	return 0, fmt.Errorf("image has no TDX metadata; not a TDX firmware")
+}
+
+// MRTD computes the MRTD the TDX module reports once the VMM has added the
+// firmware sections: every page is recorded with TDH.MEM.PAGE.ADD and the
+// contents of sections with the MR_EXTEND attribute with TDH.MR.EXTEND, in
+// 256-byte chunks. Sections the TD accepts itself (PAGE_AUG) are not
+// measured.
+func (t *TDVF) MRTD() []byte {
+	h := sha512.New384()
+	for _, section := range t.Sections {
+		if section.Attributes&attributePageAug != 0 {
+			continue
+		}
+		raw := t.Image[section.DataOffset : section.DataOffset+section.RawDataSize]
+		data := make([]byte, pageSize)
+
+		for page := uint64(0); page < section.MemoryDataSize; page += pageSize {
+			gpa := section.MemoryAddress + page
+			h.Write(mrtdOperation("MEM.PAGE.ADD", gpa))
+			if section.Attributes&attributeMRExtend == 0 {
+				continue
+			}
+			// Memory past the raw data is zero.
+			clear(data)
+			if page < uint64(len(raw)) {
+				copy(data, raw[page:])
+			}
+			for chunk := 0; chunk < pageSize; chunk += extendChunk {
+				h.Write(mrtdOperation("MR.EXTEND", gpa+uint64(chunk)))
+				h.Write(data[chunk : chunk+extendChunk])
+			}
+		}
+	}
+	return h.Sum(nil)
+}
+
+// mrtdOperation is the 128-byte buffer the TDX module hashes into MRTD for
+// an operation on a page or chunk.
+func mrtdOperation(name string, gpa uint64) []byte {
+	buf := make([]byte, 128)
+	copy(buf, name)
+	binary.LittleEndian.PutUint64(buf[16:], gpa)
+	return buf
+}
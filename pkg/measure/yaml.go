@@ -0,0 +1,48 @@
+package measure
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ValidatorYAML renders the measurements as the reference values of a
+// validator config section (mr_td, rtmr0-rtmr3), ready to paste under
+// `config:`. RTMR0 is left as a comment if it was not computed.
+func (r *Result) ValidatorYAML() ([]byte, error) {
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	add := func(key string, value string) {
+		root.Content = append(root.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Value: key},
+			&yaml.Node{Kind: yaml.ScalarNode, Value: value, Tag: "!!str", Style: yaml.DoubleQuotedStyle},
+		)
+	}
+	add("mr_td", r.MrTd)
+	if r.Rtmr0 != "" {
+		add("rtmr0", r.Rtmr0)
+	}
+	add("rtmr1", r.Rtmr1)
+	add("rtmr2", r.Rtmr2)
+	add("rtmr3", r.Rtmr3)
+
+	root.HeadComment = "Generated by tdxs measure"
+	if len(r.Sources) > 0 {
+		root.HeadComment += " from: " + strings.Join(r.Sources, ", ")
+	}
+	if r.Rtmr0 == "" {
+		root.HeadComment += "\nrtmr0 depends on the VMM configuration; pass --firmware-log to compute it."
+	}
+
+	var out bytes.Buffer
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{root}}); err != nil {
+		return nil, fmt.Errorf("failed to marshal reference values: %w", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, fmt.Errorf("failed to marshal reference values: %w", err)
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,170 @@
+// Package policy derives validator reference values from issuer metadata.
+package policy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	azureissuer "github.com/Hyodar/tdxs/pkg/issuer/azure"
+)
+
+// Sample is the metadata reported by one issuer instance.
+type Sample struct {
+	Source   string                  `json:"source"`
+	Metadata azureissuer.TDXMetadata `json:"metadata"`
+}
+
+// Difference is a field whose value is not the same in every sample. Values
+// maps each value seen to the sources that reported it; an empty value means
+// the field was missing.
+type Difference struct {
+	Field  string              `json:"field"`
+	Values map[string][]string `json:"values"`
+}
+
+// Policy holds the values common to all samples and the fields that differ.
+type Policy struct {
+	Sources []string `json:"sources"`
+
+	// Fields checked by the Azure validator.
+	PCRs   map[uint32]string `json:"pcrs"`
+	MrSeam string            `json:"mrseam,omitempty"`
+	XFAM   string            `json:"xfam,omitempty"`
+
+	// Fields reported for reference only.
+	MrTd    string `json:"mrtd,omitempty"`
+	MrOwner string `json:"mrowner,omitempty"`
+	Rtmr0   string `json:"rtmr0,omitempty"`
+	Rtmr1   string `json:"rtmr1,omitempty"`
+	Rtmr2   string `json:"rtmr2,omitempty"`
+	Rtmr3   string `json:"rtmr3,omitempty"`
+
+	Differences []Difference `json:"differences,omitempty"`
+}
+
+// Generate intersects the samples. A field (or PCR) is kept only if every
+// sample reports the same value for it; otherwise it is listed in
+// Differences.
+func Generate(samples []Sample) (*Policy, error) {
+	if len(samples) == 0 {
+		return nil, fmt.Errorf("at least one metadata sample is required")
+	}
+
+	p := &Policy{PCRs: make(map[uint32]string)}
+	for _, sample := range samples {
+		p.Sources = append(p.Sources, sample.Source)
+	}
+
+	fields := []struct {
+		name  string
+		get   func(*azureissuer.TDXMetadata) string
+		value *string
+	}{
+		{"mrseam", func(m *azureissuer.TDXMetadata) string { return m.MrSeam }, &p.MrSeam},
+		{"xfam", func(m *azureissuer.TDXMetadata) string { return m.XFAM }, &p.XFAM},
+		{"mrtd", func(m *azureissuer.TDXMetadata) string { return m.MrTd }, &p.MrTd},
+		{"mrowner", func(m *azureissuer.TDXMetadata) string { return m.MrOwner }, &p.MrOwner},
+		{"rtmr0", func(m *azureissuer.TDXMetadata) string { return m.Rtmr0 }, &p.Rtmr0},
+		{"rtmr1", func(m *azureissuer.TDXMetadata) string { return m.Rtmr1 }, &p.Rtmr1},
+		{"rtmr2", func(m *azureissuer.TDXMetadata) string { return m.Rtmr2 }, &p.Rtmr2},
+		{"rtmr3", func(m *azureissuer.TDXMetadata) string { return m.Rtmr3 }, &p.Rtmr3},
+	}
+	for _, field := range fields {
+		value, diff := intersect(field.name, samples, func(s *Sample) string {
+			return field.get(&s.Metadata)
+		})
+		*field.value = value
+		if diff != nil {
+			p.Differences = append(p.Differences, *diff)
+		}
+	}
+
+	indices := make(map[uint32]struct{})
+	for _, sample := range samples {
+		for index := range sample.Metadata.PCRs {
+			indices[index] = struct{}{}
+		}
+	}
+	for _, index := range sortedIndices(indices) {
+		value, diff := intersect(fmt.Sprintf("pcrs.%d", index), samples, func(s *Sample) string {
+			return s.Metadata.PCRs[index]
+		})
+		if diff != nil {
+			p.Differences = append(p.Differences, *diff)
+			continue
+		}
+		p.PCRs[index] = value
+	}
+
+	return p, nil
+}
+
+// intersect returns the value shared by all samples, or a Difference if they
+// disagree.
+func intersect(name string, samples []Sample, get func(*Sample) string) (string, *Difference) {
+	values := make(map[string][]string)
+	for i := range samples {
+		value := get(&samples[i])
+		values[value] = append(values[value], samples[i].Source)
+	}
+	if len(values) == 1 {
+		for value := range values {
+			return value, nil
+		}
+	}
+	return "", &Difference{Field: name, Values: values}
+}
+
+// IsPCR reports whether the difference is about a PCR.
+func (d *Difference) IsPCR() bool {
+	return strings.HasPrefix(d.Field, "pcrs.")
+}
+
+// ParseSample reads saved metadata. It accepts the output of
+// `tdxs metadata -o json`, a full socket response envelope, or a bare
+// metadata object.
+func ParseSample(source string, data []byte) (*Sample, error) {
+	var probe map[string]json.RawMessage
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, fmt.Errorf("failed to parse metadata: %w", err)
+	}
+
+	// Unwrap {"data": ..., "error": ...} and {"issuerType": ..., "metadata": ...}.
+	if raw, ok := probe["data"]; ok {
+		if errRaw, ok := probe["error"]; ok && !bytes.Equal(bytes.TrimSpace(errRaw), []byte("null")) {
+			return nil, fmt.Errorf("metadata response is an error: %s", errRaw)
+		}
+		data = raw
+		probe = nil
+		if err := json.Unmarshal(raw, &probe); err != nil {
+			return nil, fmt.Errorf("failed to parse metadata: %w", err)
+		}
+	}
+	if raw, ok := probe["metadata"]; ok {
+		if issuerType, ok := probe["issuerType"]; ok {
+			var t string
+			if err := json.Unmarshal(issuerType, &t); err == nil && t != "azure" {
+				return nil, fmt.Errorf("unsupported issuer type %q, only azure metadata can be used", t)
+			}
+		}
+		data = raw
+	}
+
+	sample := &Sample{Source: source}
+	if err := json.Unmarshal(data, &sample.Metadata); err != nil {
+		return nil, fmt.Errorf("failed to parse metadata: %w", err)
+	}
+	return sample, nil
+}
+
+func sortedIndices(set map[uint32]struct{}) []uint32 {
+	indices := make([]uint32, 0, len(set))
+	for index := range set {
+		indices = append(indices, index)
+	}
+	sort.Slice(indices, func(i, j int) bool { return indices[i] < indices[j] })
+	return indices
+}
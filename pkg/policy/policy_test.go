@@ -0,0 +1,160 @@
+package policy
+
+import (
+	"bytes"
+	"encoding/hex"
+	"slices"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+
+	azureissuer "github.com/Hyodar/tdxs/pkg/issuer/azure"
+	"github.com/Hyodar/tdxs/pkg/validator/azure"
+)
+
+func digest(b byte) string {
+	return "0x" + strings.Repeat(hex.EncodeToString([]byte{b}), 32)
+}
+
+func metadata(modify func(m *azureissuer.TDXMetadata)) azureissuer.TDXMetadata {
+	m := azureissuer.TDXMetadata{
+		XFAM:    "0xe702060000000000",
+		MrTd:    "0x" + strings.Repeat("01", 48),
+		MrOwner: "0x" + strings.Repeat("00", 48),
+		MrSeam:  "0x" + strings.Repeat("02", 48),
+		Rtmr0:   "0x" + strings.Repeat("10", 48),
+		Rtmr1:   "0x" + strings.Repeat("11", 48),
+		Rtmr2:   "0x" + strings.Repeat("12", 48),
+		Rtmr3:   "0x" + strings.Repeat("00", 48),
+		PCRs:    map[uint32]string{4: digest(4), 9: digest(9), 11: digest(11)},
+	}
+	if modify != nil {
+		modify(&m)
+	}
+	return m
+}
+
+func TestGenerate(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		other func(m *azureissuer.TDXMetadata)
+		pcrs  []uint32 // PCRs kept
+		diffs []string // fields that differ
+	}{
+		{
+			name: "Identical",
+			pcrs: []uint32{4, 9, 11},
+		},
+		{
+			name:  "RTMRs",
+			other: func(m *azureissuer.TDXMetadata) { m.Rtmr1, m.Rtmr2 = m.Rtmr2, m.Rtmr1 },
+			pcrs:  []uint32{4, 9, 11},
+			diffs: []string{"rtmr1", "rtmr2"},
+		},
+		{
+			name:  "PCR",
+			other: func(m *azureissuer.TDXMetadata) { m.PCRs[9] = digest(0x99) },
+			pcrs:  []uint32{4, 11},
+			diffs: []string{"pcrs.9"},
+		},
+		{
+			name:  "MissingPCR",
+			other: func(m *azureissuer.TDXMetadata) { delete(m.PCRs, 11) },
+			pcrs:  []uint32{4, 9},
+			diffs: []string{"pcrs.11"},
+		},
+		{
+			name:  "MrSeam",
+			other: func(m *azureissuer.TDXMetadata) { m.MrSeam = "0x" + strings.Repeat("03", 48) },
+			pcrs:  []uint32{4, 9, 11},
+			diffs: []string{"mrseam"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := Generate([]Sample{
+				{Source: "a", Metadata: metadata(nil)},
+				{Source: "b", Metadata: metadata(nil)},
+				{Source: "c", Metadata: metadata(tt.other)},
+			})
+			if err != nil {
+				t.Fatalf("Generate failed: %v", err)
+			}
+
+			if pcrs := sortedPCRs(p.PCRs); !slices.Equal(pcrs, tt.pcrs) {
+				t.Errorf("PCRs = %v, want %v", pcrs, tt.pcrs)
+			}
+			var diffs []string
+			for _, diff := range p.Differences {
+				diffs = append(diffs, diff.Field)
+				if len(diff.Values) != 2 || !slices.Equal(diff.Values[diffValue(t, diff, "c")], []string{"c"}) {
+					t.Errorf("difference %s = %v, want sample c apart from a and b", diff.Field, diff.Values)
+				}
+			}
+			if !slices.Equal(diffs, tt.diffs) {
+				t.Errorf("differences = %v, want %v", diffs, tt.diffs)
+			}
+		})
+	}
+
+	if _, err := Generate(nil); err == nil {
+		t.Error("Generate without samples succeeded")
+	}
+}
+
+// diffValue returns the value source reported in diff.
+func diffValue(t *testing.T, diff Difference, source string) string {
+	t.Helper()
+	for value, sources := range diff.Values {
+		if slices.Contains(sources, source) {
+			return value
+		}
+	}
+	t.Fatalf("difference %s has no value from %s", diff.Field, source)
+	return ""
+}
+
+func TestValidatorYAML(t *testing.T) {
+	p, err := Generate([]Sample{
+		{Source: "a", Metadata: metadata(nil)},
+		{Source: "b", Metadata: metadata(func(m *azureissuer.TDXMetadata) { m.PCRs[9] = digest(0x99) })},
+	})
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	out, err := p.ValidatorYAML()
+	if err != nil {
+		t.Fatalf("ValidatorYAML failed: %v", err)
+	}
+
+	var section struct {
+		Type   string                     `yaml:"type"`
+		Config azure.AzureValidatorConfig `yaml:"config"`
+	}
+	if err := yaml.Unmarshal(out, &section); err != nil {
+		t.Fatalf("generated YAML does not decode as an Azure validator config: %v\n%s", err, out)
+	}
+	if section.Type != "azure" || section.Config.AzureTDX == nil {
+		t.Fatalf("decoded %+v, want an Azure validator config", section)
+	}
+
+	cfg := section.Config.AzureTDX
+	want := metadata(nil)
+	for _, index := range []uint32{4, 11} {
+		if got := "0x" + hex.EncodeToString(cfg.Measurements[index].Expected); got != want.PCRs[index] {
+			t.Errorf("measurements[%d] = %s, want %s", index, got, want.PCRs[index])
+		}
+	}
+	if _, ok := cfg.Measurements[9]; ok || len(cfg.Measurements) != 2 {
+		t.Errorf("measurements = %v, want PCRs 4 and 11 only", cfg.Measurements)
+	}
+	if got := "0x" + hex.EncodeToString(cfg.MRSeam); got != want.MrSeam {
+		t.Errorf("mrSeam = %s, want %s", got, want.MrSeam)
+	}
+	if got := "0x" + hex.EncodeToString(cfg.XFAM.Value); got != want.XFAM {
+		t.Errorf("xfam = %s, want %s", got, want.XFAM)
+	}
+	if !bytes.Contains(out, []byte("pcrs.9:")) {
+		t.Errorf("generated YAML does not list the differing PCR:\n%s", out)
+	}
+}
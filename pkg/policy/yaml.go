@@ -0,0 +1,117 @@
+package policy
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ValidatorYAML renders the policy as an Azure validator config section,
+// ready to paste under `validator:` or a profile in `validators:`. Fields
+// that cannot be derived from metadata, and fields that differ between
+// samples, are left as comments.
+func (p *Policy) ValidatorYAML() ([]byte, error) {
+	measurements := mapping()
+	for _, index := range sortedPCRs(p.PCRs) {
+		measurements.Content = append(measurements.Content,
+			scalar(strconv.FormatUint(uint64(index), 10), "!!int"),
+			mappingOf(
+				scalar("expected", ""), hexScalar(p.PCRs[index]),
+				scalar("warnOnly", ""), scalar("false", "!!bool"),
+			),
+		)
+	}
+
+	config := mappingOf(scalar("measurements", ""), measurements)
+	if p.MrSeam != "" {
+		config.Content = append(config.Content, scalar("mrSeam", ""), hexScalar(p.MrSeam))
+	}
+	if p.XFAM != "" {
+		config.Content = append(config.Content, scalar("xfam", ""), hexScalar(p.XFAM))
+	}
+	config.Content[len(config.Content)-1].FootComment = strings.Join([]string{
+		"Not available from metadata, set before use:",
+		"qeSVN: ",
+		"pceSVN: ",
+		"teeTCBSVN: ",
+		"qeVendorID: ",
+		"intelRootKey: |",
+		"  -----BEGIN CERTIFICATE-----",
+	}, "\n")
+
+	root := mappingOf(
+		scalar("type", ""), scalar("azure", ""),
+		scalar("config", ""), config,
+	)
+	root.HeadComment = "Generated by tdxs policy generate from: " + strings.Join(p.Sources, ", ")
+
+	if lines := p.differenceLines(); len(lines) > 0 {
+		root.FootComment = "Left out because they differ between samples:\n" + strings.Join(lines, "\n")
+	}
+
+	var out bytes.Buffer
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{root}}); err != nil {
+		return nil, fmt.Errorf("failed to marshal validator config: %w", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, fmt.Errorf("failed to marshal validator config: %w", err)
+	}
+	return out.Bytes(), nil
+}
+
+func (p *Policy) differenceLines() []string {
+	var lines []string
+	for _, diff := range p.Differences {
+		values := make([]string, 0, len(diff.Values))
+		for value := range diff.Values {
+			values = append(values, value)
+		}
+		sort.Strings(values)
+
+		lines = append(lines, diff.Field+":")
+		for _, value := range values {
+			if value == "" {
+				value = "(missing)"
+			}
+			lines = append(lines, fmt.Sprintf("  %s <- %s", value, strings.Join(diff.Values[value], ", ")))
+		}
+	}
+	return lines
+}
+
+func mapping() *yaml.Node {
+	return &yaml.Node{Kind: yaml.MappingNode}
+}
+
+func mappingOf(content ...*yaml.Node) *yaml.Node {
+	return &yaml.Node{Kind: yaml.MappingNode, Content: content}
+}
+
+func scalar(value string, tag string) *yaml.Node {
+	node := &yaml.Node{Kind: yaml.ScalarNode, Value: value, Tag: tag}
+	if tag == "!!str" {
+		// Keep hex values from being read back as integers.
+		node.Style = yaml.DoubleQuotedStyle
+	}
+	return node
+}
+
+// hexScalar renders a metadata value without its 0x prefix, as the validator
+// config expects.
+func hexScalar(value string) *yaml.Node {
+	return scalar(strings.TrimPrefix(value, "0x"), "!!str")
+}
+
+func sortedPCRs(pcrs map[uint32]string) []uint32 {
+	set := make(map[uint32]struct{}, len(pcrs))
+	for index := range pcrs {
+		set[index] = struct{}{}
+	}
+	return sortedIndices(set)
+}
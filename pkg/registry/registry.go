@@ -0,0 +1,144 @@
+package registry
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/Hyodar/tdxs/pkg/logger"
+	"gopkg.in/yaml.v3"
+)
+
+// Entry describes how to build one backend type: how to decode its `config`
+// section and how to construct it from the decoded value.
+type Entry[T any] struct {
+	DecodeConfig func(node yaml.Node) (any, error)
+	New          func(cfg any, logger logger.Logger) (T, error)
+
+	// ConfigType is the type the `config` section decodes into, or nil if the
+	// type takes no config.
+	ConfigType reflect.Type
+}
+
+// Registry maps type names to entries. Backends register themselves from an
+// init function, so a custom main can add types by importing their packages.
+type Registry[T any] struct {
+	kind string
+
+	mu      sync.RWMutex
+	entries map[string]Entry[T]
+}
+
+func New[T any](kind string) *Registry[T] {
+	return &Registry[T]{
+		kind:    kind,
+		entries: make(map[string]Entry[T]),
+	}
+}
+
+// Register adds a type to the registry. It panics if the name is already
+// taken or the entry is incomplete, as registration happens at init time.
+func (r *Registry[T]) Register(name string, entry Entry[T]) {
+	if entry.DecodeConfig == nil || entry.New == nil {
+		panic(fmt.Sprintf("registry: incomplete %s entry for type %q", r.kind, name))
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.entries[name]; exists {
+		panic(fmt.Sprintf("registry: %s type %q registered twice", r.kind, name))
+	}
+	r.entries[name] = entry
+}
+
+func (r *Registry[T]) Lookup(name string) (Entry[T], error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	entry, ok := r.entries[name]
+	if !ok {
+		return Entry[T]{}, fmt.Errorf("invalid %s type: %s", r.kind, name)
+	}
+	return entry, nil
+}
+
+// Names returns the registered type names in sorted order.
+func (r *Registry[T]) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.entries))
+	for name := range r.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// WithConfig builds an entry for a type whose `config` section decodes into C.
+func WithConfig[T any, C any](newFn func(cfg *C, logger logger.Logger) (T, error)) Entry[T] {
+	return Entry[T]{
+		DecodeConfig: func(node yaml.Node) (any, error) {
+			var cfg C
+			if err := DecodeYAMLNode(node, &cfg); err != nil {
+				return nil, err
+			}
+			return cfg, nil
+		},
+		New: func(cfg any, logger logger.Logger) (T, error) {
+			innerCfg, ok := cfg.(C)
+			if !ok {
+				var zero T
+				return zero, fmt.Errorf("invalid config type: expected %T, got %T", *new(C), cfg)
+			}
+			return newFn(&innerCfg, logger)
+		},
+		ConfigType: reflect.TypeFor[C](),
+	}
+}
+
+// WithoutConfig builds an entry for a type that takes no `config` section.
+func WithoutConfig[T any](newFn func(logger logger.Logger) (T, error)) Entry[T] {
+	return Entry[T]{
+		DecodeConfig: func(node yaml.Node) (any, error) {
+			if !IsNilOrEmptyYAMLNode(node) {
+				return nil, fmt.Errorf("config is not supported")
+			}
+			return nil, nil
+		},
+		New: func(_ any, logger logger.Logger) (T, error) {
+			return newFn(logger)
+		},
+	}
+}
+
+// DecodeYAMLNode decodes node into out, rejecting keys that out has no field
+// for.
+func DecodeYAMLNode(node yaml.Node, out any) error {
+	if IsNilOrEmptyYAMLNode(node) {
+		return nil
+	}
+	data, err := yaml.Marshal(&node)
+	if err != nil {
+		return err
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	return decoder.Decode(out)
+}
+
+func IsNilOrEmptyYAMLNode(node yaml.Node) bool {
+	if node.Kind == 0 {
+		return true
+	}
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!null" {
+		return true
+	}
+	if node.Kind == yaml.MappingNode && len(node.Content) == 0 {
+		return true
+	}
+	return false
+}
@@ -0,0 +1,215 @@
+package rtmr
+
+import (
+	"crypto/rand"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Hyodar/tdxs/pkg/schema"
+	"github.com/google/go-tdx-guest/abi"
+)
+
+// Count is the number of RTMRs of a TD.
+const Count = 4
+
+// Device extends and reads RTMRs. Digests and values are SHA-384 sized.
+type Device interface {
+	Extend(index int, digest []byte) error
+	Read(index int) ([]byte, error)
+	// BootID identifies the current boot. RTMRs are reset only when it
+	// changes.
+	BootID() (string, error)
+}
+
+type DeviceType string
+
+const (
+	// DeviceTypeTdxGuest uses the measurement attributes of the tdx_guest
+	// driver, available since Linux 6.16.
+	DeviceTypeTdxGuest DeviceType = "tdx_guest"
+	// DeviceTypeConfigfs uses configfs-tsm RTMR entries.
+	DeviceTypeConfigfs DeviceType = "configfs"
+	// DeviceTypeFake keeps RTMRs in memory, for testing.
+	DeviceTypeFake DeviceType = "fake"
+)
+
+func (DeviceType) JSONSchema() schema.Schema {
+	return schema.Schema{
+		"type": "string",
+		"enum": []string{string(DeviceTypeTdxGuest), string(DeviceTypeConfigfs), string(DeviceTypeFake)},
+	}
+}
+
+const (
+	DefaultTdxGuestPath = "/sys/class/misc/tdx_guest/measurements"
+	DefaultConfigfsPath = "/sys/kernel/config/tsm/rtmrs"
+	bootIDPath          = "/proc/sys/kernel/random/boot_id"
+)
+
+// Extended returns the value of an RTMR holding value after extending it
+// with digest: SHA-384(value || digest).
+func Extended(value []byte, digest []byte) []byte {
+	sum := sha512.Sum384(append(append([]byte{}, value...), digest...))
+	return sum[:]
+}
+
+func checkIndex(index int) error {
+	if index < 0 || index >= Count {
+		return fmt.Errorf("invalid RTMR index %d", index)
+	}
+	return nil
+}
+
+func checkDigest(digest []byte) error {
+	if len(digest) != abi.RtmrSize {
+		return fmt.Errorf("digest must be %d bytes, got %d", abi.RtmrSize, len(digest))
+	}
+	return nil
+}
+
+// tdxGuestDevice writes digests to, and reads values from, the
+// rtmr<N>:sha384 attributes of the tdx_guest driver.
+type tdxGuestDevice struct {
+	path string
+}
+
+func (d *tdxGuestDevice) attribute(index int) string {
+	return filepath.Join(d.path, fmt.Sprintf("rtmr%d:sha384", index))
+}
+
+func (d *tdxGuestDevice) Extend(index int, digest []byte) error {
+	if err := os.WriteFile(d.attribute(index), digest, 0); err != nil {
+		return fmt.Errorf("failed to extend RTMR%d: %w", index, err)
+	}
+	return nil
+}
+
+func (d *tdxGuestDevice) Read(index int) ([]byte, error) {
+	value, err := os.ReadFile(d.attribute(index))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read RTMR%d: %w", index, err)
+	}
+	return value, nil
+}
+
+func (d *tdxGuestDevice) BootID() (string, error) {
+	return readBootID()
+}
+
+// configfsDevice uses one configfs-tsm RTMR entry per register, created on
+// first use. Writing the digest attribute extends the register and reading
+// it returns the current value.
+type configfsDevice struct {
+	path string
+
+	mu      sync.Mutex
+	entries [Count]string
+}
+
+func (d *configfsDevice) entry(index int) (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.entries[index] != "" {
+		return d.entries[index], nil
+	}
+	entry := filepath.Join(d.path, fmt.Sprintf("tdxs-rtmr%d", index))
+	if err := os.Mkdir(entry, 0o700); err != nil && !errors.Is(err, fs.ErrExist) {
+		return "", fmt.Errorf("failed to create configfs-tsm entry: %w", err)
+	}
+	current, err := os.ReadFile(filepath.Join(entry, "index"))
+	if err != nil {
+		return "", fmt.Errorf("failed to read configfs-tsm index: %w", err)
+	}
+	if strings.TrimSpace(string(current)) != strconv.Itoa(index) {
+		if err := os.WriteFile(filepath.Join(entry, "index"), []byte(strconv.Itoa(index)), 0); err != nil {
+			return "", fmt.Errorf("failed to set configfs-tsm index: %w", err)
+		}
+	}
+	d.entries[index] = entry
+	return entry, nil
+}
+
+func (d *configfsDevice) Extend(index int, digest []byte) error {
+	entry, err := d.entry(index)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(entry, "digest"), digest, 0); err != nil {
+		return fmt.Errorf("failed to extend RTMR%d: %w", index, err)
+	}
+	return nil
+}
+
+func (d *configfsDevice) Read(index int) ([]byte, error) {
+	entry, err := d.entry(index)
+	if err != nil {
+		return nil, err
+	}
+	value, err := os.ReadFile(filepath.Join(entry, "digest"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read RTMR%d: %w", index, err)
+	}
+	return value, nil
+}
+
+func (d *configfsDevice) BootID() (string, error) {
+	return readBootID()
+}
+
+func readBootID() (string, error) {
+	id, err := os.ReadFile(bootIDPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read boot ID: %w", err)
+	}
+	return strings.TrimSpace(string(id)), nil
+}
+
+// FakeDevice holds RTMRs in memory. Every instance is a new boot with all
+// registers zero.
+type FakeDevice struct {
+	mu     sync.Mutex
+	rtmrs  [Count][]byte
+	bootID string
+}
+
+func NewFakeDevice() *FakeDevice {
+	d := &FakeDevice{bootID: "fake-" + rand.Text()}
+	for i := range d.rtmrs {
+		d.rtmrs[i] = make([]byte, abi.RtmrSize)
+	}
+	return d
+}
+
+func (d *FakeDevice) Extend(index int, digest []byte) error {
+	if err := checkIndex(index); err != nil {
+		return err
+	}
+	if err := checkDigest(digest); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.rtmrs[index] = Extended(d.rtmrs[index], digest)
+	return nil
+}
+
+func (d *FakeDevice) Read(index int) ([]byte, error) {
+	if err := checkIndex(index); err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]byte{}, d.rtmrs[index]...), nil
+}
+
+func (d *FakeDevice) BootID() (string, error) {
+	return d.bootID, nil
+}
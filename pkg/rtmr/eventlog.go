@@ -0,0 +1,187 @@
+package rtmr
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/Hyodar/tdxs/pkg/api"
+)
+
+// Event records one extension of an RTMR by tdxs. Digest and Value are hex
+// encoded.
+type Event struct {
+	Seq         uint64      `json:"seq"`
+	Time        time.Time   `json:"time"`
+	BootID      string      `json:"bootId"`
+	RTMR        int         `json:"rtmr"`
+	Digest      string      `json:"digest"`
+	Description string      `json:"description,omitempty"`
+	Caller      *api.Caller `json:"caller,omitempty"`
+	// Value is the RTMR value after the extension.
+	Value string `json:"value"`
+}
+
+// eventLog is the runtime event log: the events of the current boot, in the
+// order they were extended, optionally persisted as JSON lines.
+type eventLog struct {
+	path   string
+	file   *os.File
+	events []Event
+}
+
+// openEventLog loads the events of the current boot from path and opens it
+// for appending. Events of earlier boots are dropped from the file. An empty
+// path keeps the log in memory.
+func openEventLog(path string, bootID string) (*eventLog, error) {
+	l := &eventLog{path: path}
+	if path == "" {
+		return l, nil
+	}
+
+	stale, err := l.load(bootID)
+	if err != nil {
+		return nil, err
+	}
+	if stale {
+		if err := l.rewrite(); err != nil {
+			return nil, err
+		}
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open event log: %w", err)
+	}
+	l.file = file
+	return l, nil
+}
+
+// load reads the events of bootID and reports whether the file held events
+// of other boots.
+func (l *eventLog) load(bootID string) (bool, error) {
+	file, err := os.Open(l.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to open event log: %w", err)
+	}
+	defer file.Close()
+
+	stale := false
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return false, fmt.Errorf("event log line %d: %w", line, err)
+		}
+		if event.BootID != bootID {
+			stale = true
+			continue
+		}
+		l.events = append(l.events, event)
+	}
+	if err := scanner.Err(); err != nil {
+		return false, fmt.Errorf("failed to read event log: %w", err)
+	}
+	return stale, nil
+}
+
+// ReadEventLog returns the events of the current boot from a runtime event
+// log written by an Extender, possibly of another process. A line still
+// being written is skipped.
+func ReadEventLog(path string) ([]Event, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read event log: %w", err)
+	}
+
+	var events []Event
+	lines := bytes.Split(data, []byte("\n"))
+	// The last element is empty if the file ends with a complete line and a
+	// partial line otherwise.
+	for i, line := range lines[:len(lines)-1] {
+		var event Event
+		if err := json.Unmarshal(line, &event); err != nil {
+			return nil, fmt.Errorf("event log line %d: %w", i+1, err)
+		}
+		events = append(events, event)
+	}
+
+	// The extender drops events of earlier boots when it opens the log, but
+	// a reader may get to the file first after a reboot. Only the newest
+	// boot's events are kept.
+	if len(events) > 0 {
+		bootID := events[len(events)-1].BootID
+		events = slices.DeleteFunc(events, func(e Event) bool { return e.BootID != bootID })
+	}
+	return events, nil
+}
+
+func (l *eventLog) rewrite() error {
+	tmp := l.path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to rewrite event log: %w", err)
+	}
+	encoder := json.NewEncoder(file)
+	for i := range l.events {
+		if err := encoder.Encode(&l.events[i]); err != nil {
+			file.Close()
+			return fmt.Errorf("failed to rewrite event log: %w", err)
+		}
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to rewrite event log: %w", err)
+	}
+	return os.Rename(tmp, l.path)
+}
+
+func (l *eventLog) nextSeq() uint64 {
+	if len(l.events) == 0 {
+		return 1
+	}
+	return l.events[len(l.events)-1].Seq + 1
+}
+
+func (l *eventLog) append(event Event) error {
+	if l.file != nil {
+		line, err := json.Marshal(&event)
+		if err != nil {
+			return fmt.Errorf("failed to marshal event: %w", err)
+		}
+		if _, err := l.file.Write(append(line, '\n')); err != nil {
+			return fmt.Errorf("failed to write event: %w", err)
+		}
+		if err := l.file.Sync(); err != nil {
+			return fmt.Errorf("failed to sync event log: %w", err)
+		}
+	}
+	l.events = append(l.events, event)
+	return nil
+}
+
+// last returns the newest event of an RTMR, or nil.
+func (l *eventLog) last(index int) *Event {
+	for i := len(l.events) - 1; i >= 0; i-- {
+		if l.events[i].RTMR == index {
+			return &l.events[i]
+		}
+	}
+	return nil
+}
+
+func (l *eventLog) close() error {
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
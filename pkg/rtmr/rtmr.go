@@ -0,0 +1,207 @@
+// Package rtmr extends RTMRs with runtime measurements and keeps the event
+// log needed to replay them.
+package rtmr
+
+import (
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Hyodar/tdxs/pkg/api"
+	"github.com/Hyodar/tdxs/pkg/logger"
+)
+
+// DefaultAllowedRTMRs are the registers left to the OS and applications;
+// RTMR0 and RTMR1 hold firmware and boot loader measurements.
+var DefaultAllowedRTMRs = []int{2, 3}
+
+type RTMRConfig struct {
+	Device DeviceType `yaml:"device"`
+	// Path overrides the default location of the device's files.
+	Path string `yaml:"path"`
+	// EventLog is where the runtime event log is kept. Without it, the log
+	// is lost when the daemon restarts.
+	EventLog string `yaml:"event_log"`
+
+	AllowedRTMRs []int `yaml:"allowed_rtmrs"`
+	// Callers allowed to extend, besides root.
+	AllowedUIDs []uint32 `yaml:"allowed_uids"`
+	AllowedGIDs []uint32 `yaml:"allowed_gids"`
+}
+
+func (c *RTMRConfig) Validate() error {
+	switch c.Device {
+	case DeviceTypeTdxGuest, DeviceTypeConfigfs:
+	case DeviceTypeFake:
+		if c.Path != "" {
+			return fmt.Errorf("path must not be set for the fake device")
+		}
+	case "":
+		return fmt.Errorf("device is required")
+	default:
+		return fmt.Errorf("unknown device %q", c.Device)
+	}
+	for _, index := range c.AllowedRTMRs {
+		if err := checkIndex(index); err != nil {
+			return fmt.Errorf("allowed_rtmrs: %w", err)
+		}
+	}
+	return nil
+}
+
+func (c *RTMRConfig) allowedRTMRs() []int {
+	if c.AllowedRTMRs == nil {
+		return DefaultAllowedRTMRs
+	}
+	return c.AllowedRTMRs
+}
+
+// Extender extends RTMRs on behalf of authorized callers and records every
+// extension in the runtime event log.
+type Extender struct {
+	cfg    *RTMRConfig
+	device Device
+	bootID string
+	logger logger.Logger
+
+	mu  sync.Mutex
+	log *eventLog
+	// broken is set when an extension could not be logged, after which the
+	// log no longer replays to the RTMR values.
+	broken error
+}
+
+func NewExtender(cfg *RTMRConfig, logger logger.Logger) (*Extender, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	var device Device
+	switch cfg.Device {
+	case DeviceTypeTdxGuest:
+		device = &tdxGuestDevice{path: pathOr(cfg.Path, DefaultTdxGuestPath)}
+	case DeviceTypeConfigfs:
+		device = &configfsDevice{path: pathOr(cfg.Path, DefaultConfigfsPath)}
+	case DeviceTypeFake:
+		device = NewFakeDevice()
+	}
+	return NewExtenderWithDevice(cfg, device, logger)
+}
+
+// NewExtenderWithDevice creates an extender for a device the caller provides.
+func NewExtenderWithDevice(cfg *RTMRConfig, device Device, logger logger.Logger) (*Extender, error) {
+	bootID, err := device.BootID()
+	if err != nil {
+		return nil, err
+	}
+	log, err := openEventLog(cfg.EventLog, bootID)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Extender{
+		cfg:    cfg,
+		device: device,
+		bootID: bootID,
+		logger: logger,
+		log:    log,
+	}
+	e.checkLog()
+	return e, nil
+}
+
+func pathOr(path string, fallback string) string {
+	if path == "" {
+		return fallback
+	}
+	return path
+}
+
+// checkLog warns about RTMRs that were extended since their last logged
+// event by someone other than tdxs.
+func (e *Extender) checkLog() {
+	for index := range Count {
+		last := e.log.last(index)
+		if last == nil {
+			continue
+		}
+		value, err := e.device.Read(index)
+		if err != nil {
+			e.logger.Warn("Failed to read RTMR", "rtmr", index, "error", err)
+			continue
+		}
+		if hex.EncodeToString(value) != last.Value {
+			e.logger.Warn("RTMR was extended outside of tdxs, the event log will not replay", "rtmr", index)
+		}
+	}
+}
+
+func (e *Extender) authorized(caller *api.Caller) bool {
+	if caller == nil {
+		return false
+	}
+	return caller.UID == 0 || slices.Contains(e.cfg.AllowedUIDs, caller.UID) || slices.Contains(e.cfg.AllowedGIDs, caller.GID)
+}
+
+// Extend extends the requested RTMR, logs the event and returns the new
+// value of the register.
+func (e *Extender) Extend(caller *api.Caller, req *api.ExtendRequest) *api.ExtendResponse {
+	if !e.authorized(caller) {
+		return &api.ExtendResponse{Error: api.Errorf(api.ErrorCodeUnauthorized, "caller may not extend RTMRs")}
+	}
+	if !slices.Contains(e.cfg.allowedRTMRs(), req.RTMR) {
+		return &api.ExtendResponse{Error: api.Errorf(api.ErrorCodeUnauthorized, "RTMR%d may not be extended", req.RTMR)}
+	}
+	if err := checkDigest(req.Digest); err != nil {
+		return &api.ExtendResponse{Error: api.NewError(api.ErrorCodeBadRequest, err)}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.broken != nil {
+		return &api.ExtendResponse{Error: api.Errorf(api.ErrorCodeInternal, "runtime event log is inconsistent: %w", e.broken)}
+	}
+	if err := e.device.Extend(req.RTMR, req.Digest); err != nil {
+		return &api.ExtendResponse{Error: api.NewError(api.ErrorCodeBackendUnavailable, err)}
+	}
+	value, err := e.device.Read(req.RTMR)
+	if err != nil {
+		e.broken = err
+		return &api.ExtendResponse{Error: api.Errorf(api.ErrorCodeInternal, "extended RTMR%d but failed to read it back: %w", req.RTMR, err)}
+	}
+
+	event := Event{
+		Seq:         e.log.nextSeq(),
+		Time:        time.Now().UTC(),
+		BootID:      e.bootID,
+		RTMR:        req.RTMR,
+		Digest:      hex.EncodeToString(req.Digest),
+		Description: req.Description,
+		Caller:      caller,
+		Value:       hex.EncodeToString(value),
+	}
+	if err := e.log.append(event); err != nil {
+		e.broken = err
+		e.logger.Error("Failed to record RTMR extension, refusing further extensions", "rtmr", req.RTMR, "error", err)
+		return &api.ExtendResponse{Error: api.Errorf(api.ErrorCodeInternal, "extended RTMR%d but failed to record the event: %w", req.RTMR, err)}
+	}
+
+	e.logger.Info("Extended RTMR", "rtmr", req.RTMR, "digest", event.Digest, "description", req.Description, "seq", event.Seq)
+	return &api.ExtendResponse{RTMR: req.RTMR, Value: value, Sequence: event.Seq}
+}
+
+// Events returns the runtime events of the current boot.
+func (e *Extender) Events() []Event {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return slices.Clone(e.log.events)
+}
+
+func (e *Extender) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.log.close()
+}
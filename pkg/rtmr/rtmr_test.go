@@ -0,0 +1,114 @@
+package rtmr_test
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"log/slog"
+	"path/filepath"
+	"testing"
+
+	"github.com/Hyodar/tdxs/pkg/api"
+	"github.com/Hyodar/tdxs/pkg/rtmr"
+)
+
+var root = &api.Caller{PID: 1, UID: 0, GID: 0}
+
+func digest(data string) []byte {
+	sum := sha512.Sum384([]byte(data))
+	return sum[:]
+}
+
+func newExtender(t *testing.T, cfg *rtmr.RTMRConfig, device rtmr.Device) *rtmr.Extender {
+	t.Helper()
+	e, err := rtmr.NewExtenderWithDevice(cfg, device, slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatalf("failed to create extender: %v", err)
+	}
+	t.Cleanup(func() { e.Close() })
+	return e
+}
+
+func TestExtend(t *testing.T) {
+	device := rtmr.NewFakeDevice()
+	e := newExtender(t, &rtmr.RTMRConfig{Device: rtmr.DeviceTypeFake}, device)
+
+	want := make([]byte, 48)
+	for i, data := range []string{"config", "image"} {
+		resp := e.Extend(root, &api.ExtendRequest{RTMR: 3, Digest: digest(data), Description: data})
+		if resp.Error != nil {
+			t.Fatalf("extend failed: %v", resp.Error)
+		}
+		want = rtmr.Extended(want, digest(data))
+		if !bytes.Equal(resp.Value, want) || resp.Sequence != uint64(i+1) {
+			t.Errorf("extend %d = value %x, sequence %d; want %x, %d", i, resp.Value, resp.Sequence, want, i+1)
+		}
+	}
+
+	value, _ := device.Read(3)
+	if !bytes.Equal(value, want) {
+		t.Errorf("device RTMR3 = %x, want %x", value, want)
+	}
+	if events := e.Events(); len(events) != 2 || events[1].Description != "image" {
+		t.Errorf("events = %+v, want 2 events", events)
+	}
+}
+
+func TestExtendRejected(t *testing.T) {
+	cfg := &rtmr.RTMRConfig{Device: rtmr.DeviceTypeFake, AllowedUIDs: []uint32{1000}, AllowedGIDs: []uint32{2000}}
+	e := newExtender(t, cfg, rtmr.NewFakeDevice())
+
+	for _, tc := range []struct {
+		name   string
+		caller *api.Caller
+		req    *api.ExtendRequest
+		code   api.ErrorCode
+	}{
+		{"unknown caller", nil, &api.ExtendRequest{RTMR: 2, Digest: digest("x")}, api.ErrorCodeUnauthorized},
+		{"other uid", &api.Caller{UID: 1001, GID: 1001}, &api.ExtendRequest{RTMR: 2, Digest: digest("x")}, api.ErrorCodeUnauthorized},
+		{"boot rtmr", root, &api.ExtendRequest{RTMR: 1, Digest: digest("x")}, api.ErrorCodeUnauthorized},
+		{"short digest", root, &api.ExtendRequest{RTMR: 2, Digest: []byte{1}}, api.ErrorCodeBadRequest},
+	} {
+		if resp := e.Extend(tc.caller, tc.req); api.CodeOf(resp.Error) != tc.code {
+			t.Errorf("%s: error = %v, want %s", tc.name, resp.Error, tc.code)
+		}
+	}
+
+	for _, caller := range []*api.Caller{{UID: 1000, GID: 1000}, {UID: 1001, GID: 2000}} {
+		if resp := e.Extend(caller, &api.ExtendRequest{RTMR: 2, Digest: digest("x")}); resp.Error != nil {
+			t.Errorf("extend as %+v failed: %v", caller, resp.Error)
+		}
+	}
+	if events := e.Events(); len(events) != 2 {
+		t.Errorf("got %d events, want 2", len(events))
+	}
+}
+
+func TestEventLogPersistence(t *testing.T) {
+	cfg := &rtmr.RTMRConfig{Device: rtmr.DeviceTypeFake, EventLog: filepath.Join(t.TempDir(), "events.jsonl")}
+	device := rtmr.NewFakeDevice()
+
+	first := newExtender(t, cfg, device)
+	first.Extend(root, &api.ExtendRequest{RTMR: 2, Digest: digest("a")})
+	first.Extend(root, &api.ExtendRequest{RTMR: 2, Digest: digest("b")})
+	first.Close()
+
+	// A restart within the same boot continues the log.
+	second := newExtender(t, cfg, device)
+	resp := second.Extend(root, &api.ExtendRequest{RTMR: 2, Digest: digest("c")})
+	if resp.Error != nil || resp.Sequence != 3 {
+		t.Fatalf("extend after restart = %+v, want sequence 3", resp)
+	}
+	if events := second.Events(); len(events) != 3 {
+		t.Errorf("got %d events after restart, want 3", len(events))
+	}
+	second.Close()
+
+	// A new boot starts with empty registers and an empty log.
+	third := newExtender(t, cfg, rtmr.NewFakeDevice())
+	if events := third.Events(); len(events) != 0 {
+		t.Errorf("got %d events after reboot, want 0", len(events))
+	}
+	if resp := third.Extend(root, &api.ExtendRequest{RTMR: 2, Digest: digest("d")}); resp.Sequence != 1 {
+		t.Errorf("sequence after reboot = %d, want 1", resp.Sequence)
+	}
+}
@@ -0,0 +1,137 @@
+// Package schema derives JSON Schemas from the Go types configs decode into.
+package schema
+
+import (
+	"encoding"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Schema is a JSON Schema document or subschema.
+type Schema map[string]any
+
+// Provider is implemented by types that describe their own schema, usually
+// because they decode from YAML in a custom way.
+type Provider interface {
+	JSONSchema() Schema
+}
+
+var (
+	overridesMu sync.RWMutex
+	overrides   = map[reflect.Type]Schema{}
+)
+
+// Override sets the schema for a type the caller does not own, such as a
+// third-party type with a custom YAML decoder.
+func Override(t reflect.Type, s Schema) {
+	overridesMu.Lock()
+	defer overridesMu.Unlock()
+	overrides[t] = s
+}
+
+var (
+	providerType      = reflect.TypeFor[Provider]()
+	yamlUnmarshalType = reflect.TypeFor[yaml.Unmarshaler]()
+	textUnmarshalType = reflect.TypeFor[encoding.TextUnmarshaler]()
+	durationType      = reflect.TypeFor[time.Duration]()
+)
+
+// For returns the schema of the YAML representation of t.
+func For(t reflect.Type) Schema {
+	overridesMu.RLock()
+	s, ok := overrides[t]
+	overridesMu.RUnlock()
+	if ok {
+		return s
+	}
+
+	if t.Implements(providerType) {
+		return reflect.Zero(t).Interface().(Provider).JSONSchema()
+	}
+	if reflect.PointerTo(t).Implements(providerType) {
+		return reflect.New(t).Interface().(Provider).JSONSchema()
+	}
+
+	switch {
+	case t == durationType:
+		return Schema{"type": "string", "description": "duration, e.g. 30s or 5m"}
+	case implements(t, textUnmarshalType):
+		return Schema{"type": "string"}
+	case implements(t, yamlUnmarshalType):
+		// The decoded shape says nothing about what the YAML looks like.
+		return Schema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return For(t.Elem())
+	case reflect.Bool:
+		return Schema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return Schema{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return Schema{"type": "number"}
+	case reflect.String:
+		return Schema{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return Schema{"type": "array", "items": For(t.Elem())}
+	case reflect.Map:
+		return Schema{"type": "object", "additionalProperties": For(t.Elem())}
+	case reflect.Struct:
+		return structSchema(t)
+	default:
+		return Schema{}
+	}
+}
+
+func implements(t reflect.Type, iface reflect.Type) bool {
+	return t.Implements(iface) || reflect.PointerTo(t).Implements(iface)
+}
+
+func structSchema(t reflect.Type) Schema {
+	properties := Schema{}
+	addStructFields(t, properties)
+	return Schema{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
+
+// addStructFields follows the field naming rules of gopkg.in/yaml.v3,
+// including `,inline` for embedded structs.
+func addStructFields(t reflect.Type, properties Schema) {
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		tag := field.Tag.Get("yaml")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+
+		if strings.Contains(","+opts+",", ",inline,") {
+			inner := field.Type
+			if inner.Kind() == reflect.Pointer {
+				inner = inner.Elem()
+			}
+			if inner.Kind() == reflect.Struct {
+				addStructFields(inner, properties)
+			}
+			continue
+		}
+
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		properties[name] = For(field.Type)
+	}
+}
@@ -0,0 +1,349 @@
+package simulator
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/go-tdx-guest/pcs"
+)
+
+// The certificate names the DCAP verifier requires. The organization marks
+// the certificates as simulated.
+const (
+	rootCommonName       = "Intel SGX Root CA"
+	platformCommonName   = "Intel SGX PCK Platform CA"
+	pckCommonName        = "Intel SGX PCK Certificate"
+	tcbSigningCommonName = "Intel SGX TCB Signing"
+	organization         = "tdxs simulator"
+
+	caValidity   = 10 * 365 * 24 * time.Hour
+	leafValidity = 7 * 365 * 24 * time.Hour
+)
+
+// Platform values carried in the PCK certificate and matched by the
+// collateral.
+var (
+	fmspc  = []byte{0x90, 0xc0, 0x6f, 0x00, 0x00, 0x00}
+	pceID  = []byte{0x00, 0x00}
+	pceSvn = uint16(13)
+	cpuSvn = []byte{0x03, 0x03, 0x02, 0x02, 0xff, 0xff, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+)
+
+// Authority is a locally generated stand-in for Intel's provisioning
+// hierarchy: a root CA, a PCK platform CA and PCK certificate that sign the
+// quoting enclave report, a TCB signing certificate for collateral, and the
+// quoting enclave's attestation key. Quotes it signs verify with the DCAP
+// verifier when its root is the trusted root.
+type Authority struct {
+	root           *x509.Certificate
+	rootKey        *ecdsa.PrivateKey
+	platform       *x509.Certificate
+	platformKey    *ecdsa.PrivateKey
+	pck            *x509.Certificate
+	pckKey         *ecdsa.PrivateKey
+	tcbSigning     *x509.Certificate
+	tcbSigningKey  *ecdsa.PrivateKey
+	attestationKey *ecdsa.PrivateKey
+}
+
+// NewAuthority generates a fresh hierarchy.
+func NewAuthority() (*Authority, error) {
+	a := &Authority{}
+	keys := []**ecdsa.PrivateKey{&a.rootKey, &a.platformKey, &a.pckKey, &a.tcbSigningKey, &a.attestationKey}
+	for _, key := range keys {
+		var err error
+		if *key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+			return nil, fmt.Errorf("failed to generate key: %w", err)
+		}
+	}
+
+	now := time.Now().Add(-time.Hour)
+	var err error
+	a.root, err = createCertificate(&x509.Certificate{
+		Subject:               name(rootCommonName),
+		NotBefore:             now,
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}, nil, &a.rootKey.PublicKey, a.rootKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create root certificate: %w", err)
+	}
+
+	a.platform, err = createCertificate(&x509.Certificate{
+		Subject:               name(platformCommonName),
+		NotBefore:             now,
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}, a.root, &a.platformKey.PublicKey, a.rootKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create platform CA certificate: %w", err)
+	}
+
+	a.tcbSigning, err = createCertificate(&x509.Certificate{
+		Subject:               name(tcbSigningCommonName),
+		NotBefore:             now,
+		NotAfter:              now.Add(leafValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageContentCommitment,
+		BasicConstraintsValid: true,
+	}, a.root, &a.tcbSigningKey.PublicKey, a.rootKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create TCB signing certificate: %w", err)
+	}
+
+	sgxExtension, err := pckSGXExtension()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode SGX extension: %w", err)
+	}
+	pckKeyID, err := keyID(&a.pckKey.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	// The verifier expects exactly the six extensions of an Intel PCK
+	// certificate: authority and subject key IDs, key usage, basic
+	// constraints, CRL distribution points and the SGX extension.
+	a.pck, err = createCertificate(&x509.Certificate{
+		Subject:               name(pckCommonName),
+		NotBefore:             now,
+		NotAfter:              now.Add(leafValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageContentCommitment,
+		BasicConstraintsValid: true,
+		SubjectKeyId:          pckKeyID,
+		CRLDistributionPoints: []string{pcs.PckCrlURL("platform")},
+		ExtraExtensions:       []pkix.Extension{sgxExtension},
+	}, a.platform, &a.pckKey.PublicKey, a.platformKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create PCK certificate: %w", err)
+	}
+
+	return a, nil
+}
+
+var (
+	sharedOnce      sync.Once
+	sharedAuthority *Authority
+	sharedErr       error
+)
+
+// OpenAuthority returns the authority stored at path, generating and saving
+// one if the file does not exist. An empty path returns an authority shared
+// by the whole process, so an issuer and a validator in the same daemon
+// trust each other without a file.
+func OpenAuthority(path string) (*Authority, error) {
+	if path == "" {
+		sharedOnce.Do(func() {
+			sharedAuthority, sharedErr = NewAuthority()
+		})
+		return sharedAuthority, sharedErr
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		a, err := NewAuthority()
+		if err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(path, a.marshal(), 0o600); err != nil {
+			return nil, fmt.Errorf("failed to save simulator CA: %w", err)
+		}
+		return a, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read simulator CA: %w", err)
+	}
+
+	a, err := parseAuthority(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse simulator CA %s: %w", path, err)
+	}
+	return a, nil
+}
+
+// Roots returns a pool holding the root certificate, for
+// verify.Options.TrustedRoots.
+func (a *Authority) Roots() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(a.root)
+	return pool
+}
+
+// PCKChain returns the PEM chain embedded in quotes: PCK certificate,
+// platform CA, root CA.
+func (a *Authority) PCKChain() []byte {
+	return append(append(encodeCertificate(a.pck), encodeCertificate(a.platform)...), encodeCertificate(a.root)...)
+}
+
+// The authority is stored as one PEM file. The role header tells the blocks
+// apart.
+const roleHeader = "Role"
+
+func (a *Authority) marshal() []byte {
+	var out []byte
+	for _, entry := range a.entries() {
+		out = append(out, pem.EncodeToMemory(&pem.Block{
+			Type:    "CERTIFICATE",
+			Headers: map[string]string{roleHeader: entry.role},
+			Bytes:   (*entry.cert).Raw,
+		})...)
+	}
+	for _, entry := range a.entries() {
+		out = append(out, encodeKey(entry.role, *entry.key)...)
+	}
+	return append(out, encodeKey("attestation", a.attestationKey)...)
+}
+
+type authorityEntry struct {
+	role string
+	cert **x509.Certificate
+	key  **ecdsa.PrivateKey
+}
+
+func (a *Authority) entries() []authorityEntry {
+	return []authorityEntry{
+		{"root", &a.root, &a.rootKey},
+		{"platform", &a.platform, &a.platformKey},
+		{"pck", &a.pck, &a.pckKey},
+		{"tcb-signing", &a.tcbSigning, &a.tcbSigningKey},
+	}
+}
+
+func parseAuthority(data []byte) (*Authority, error) {
+	certs := make(map[string]*x509.Certificate)
+	keys := make(map[string]*ecdsa.PrivateKey)
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		role := block.Headers[roleHeader]
+		switch block.Type {
+		case "CERTIFICATE":
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("%s certificate: %w", role, err)
+			}
+			certs[role] = cert
+		case "EC PRIVATE KEY":
+			key, err := x509.ParseECPrivateKey(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("%s key: %w", role, err)
+			}
+			keys[role] = key
+		default:
+			return nil, fmt.Errorf("unexpected PEM block %q", block.Type)
+		}
+	}
+
+	a := &Authority{}
+	for _, entry := range a.entries() {
+		if *entry.cert = certs[entry.role]; *entry.cert == nil {
+			return nil, fmt.Errorf("%s certificate is missing", entry.role)
+		}
+		if *entry.key = keys[entry.role]; *entry.key == nil {
+			return nil, fmt.Errorf("%s key is missing", entry.role)
+		}
+	}
+	if a.attestationKey = keys["attestation"]; a.attestationKey == nil {
+		return nil, fmt.Errorf("attestation key is missing")
+	}
+	return a, nil
+}
+
+func createCertificate(template *x509.Certificate, parent *x509.Certificate, pub *ecdsa.PublicKey, signer *ecdsa.PrivateKey) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	template.SerialNumber = serial
+	template.SignatureAlgorithm = x509.ECDSAWithSHA256
+	if parent == nil {
+		parent = template
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, pub, signer)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(der)
+}
+
+func name(commonName string) pkix.Name {
+	return pkix.Name{CommonName: commonName, Organization: []string{organization}}
+}
+
+func keyID(pub *ecdsa.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal public key: %w", err)
+	}
+	sum := sha1.Sum(der)
+	return sum[:], nil
+}
+
+// pckSGXExtension encodes the SGX extension of a PCK certificate: PPID,
+// TCB (16 SGX component SVNs, PCESVN, CPUSVN), PCE ID and FMSPC.
+func pckSGXExtension() (pkix.Extension, error) {
+	ppid := make([]byte, 16)
+	if _, err := rand.Read(ppid); err != nil {
+		return pkix.Extension{}, err
+	}
+
+	components := make([]pkix.AttributeTypeAndValue, 0, 18)
+	for i, svn := range cpuSvn {
+		components = append(components, pkix.AttributeTypeAndValue{Type: tcbOID(i + 1), Value: int(svn)})
+	}
+	components = append(components,
+		pkix.AttributeTypeAndValue{Type: pcs.OidPCESvn, Value: int(pceSvn)},
+		pkix.AttributeTypeAndValue{Type: pcs.OidCPUSvn, Value: cpuSvn},
+	)
+	tcb := struct {
+		Type       asn1.ObjectIdentifier
+		Components []pkix.AttributeTypeAndValue
+	}{pcs.OidTCB, components}
+
+	value, err := asn1.Marshal([]any{
+		pkix.Extension{Id: pcs.OidPPID, Value: ppid},
+		tcb,
+		pkix.Extension{Id: pcs.OidPCEID, Value: pceID},
+		pkix.Extension{Id: pcs.OidFMSPC, Value: fmspc},
+	})
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+	return pkix.Extension{Id: pcs.OidSgxExtension, Value: value}, nil
+}
+
+func tcbOID(component int) asn1.ObjectIdentifier {
+	return append(append(asn1.ObjectIdentifier{}, pcs.OidTCB...), component)
+}
+
+func encodeCertificate(cert *x509.Certificate) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+}
+
+func encodeKey(role string, key *ecdsa.PrivateKey) []byte {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		// P-256 keys always marshal.
+		panic(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Headers: map[string]string{roleHeader: role}, Bytes: der})
+}
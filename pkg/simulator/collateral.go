@@ -0,0 +1,186 @@
+package simulator
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/google/go-tdx-guest/pcs"
+	"github.com/google/go-tdx-guest/verify/trust"
+)
+
+// collateralValidity matches the 30 days between Intel PCS updates.
+const collateralValidity = 30 * 24 * time.Hour
+
+type tcbInfo struct {
+	ID                      string              `json:"id"`
+	Version                 int                 `json:"version"`
+	IssueDate               time.Time           `json:"issueDate"`
+	NextUpdate              time.Time           `json:"nextUpdate"`
+	Fmspc                   string              `json:"fmspc"`
+	PceID                   string              `json:"pceId"`
+	TcbType                 int                 `json:"tcbType"`
+	TcbEvaluationDataNumber int                 `json:"tcbEvaluationDataNumber"`
+	TdxModule               tdxModule           `json:"tdxModule"`
+	TdxModuleIdentities     []tdxModuleIdentity `json:"tdxModuleIdentities"`
+	TcbLevels               []pcs.TcbLevel      `json:"tcbLevels"`
+}
+
+type tdxModule struct {
+	Mrsigner       string `json:"mrsigner"`
+	Attributes     string `json:"attributes"`
+	AttributesMask string `json:"attributesMask"`
+}
+
+type tdxModuleIdentity struct {
+	ID string `json:"id"`
+	tdxModule
+	TcbLevels []pcs.TcbLevel `json:"tcbLevels"`
+}
+
+type enclaveIdentity struct {
+	ID                      string         `json:"id"`
+	Version                 int            `json:"version"`
+	IssueDate               time.Time      `json:"issueDate"`
+	NextUpdate              time.Time      `json:"nextUpdate"`
+	TcbEvaluationDataNumber int            `json:"tcbEvaluationDataNumber"`
+	Miscselect              string         `json:"miscselect"`
+	MiscselectMask          string         `json:"miscselectMask"`
+	Attributes              string         `json:"attributes"`
+	AttributesMask          string         `json:"attributesMask"`
+	Mrsigner                string         `json:"mrsigner"`
+	IsvProdID               uint16         `json:"isvprodid"`
+	TcbLevels               []pcs.TcbLevel `json:"tcbLevels"`
+}
+
+// Collateral returns a verify.Options.Getter that answers the Intel PCS TCB
+// info and QE identity requests for the authority's platform. The collateral
+// is issued at the time of the request. TEE TCB SVNs below DefaultTD's are
+// reported as out of date.
+func (a *Authority) Collateral() trust.HTTPSGetter {
+	return &collateral{authority: a}
+}
+
+// ExpiredCollateral is like Collateral, but the collateral was issued long
+// enough ago that its next update date has passed.
+func (a *Authority) ExpiredCollateral() trust.HTTPSGetter {
+	return &collateral{authority: a, age: 2 * collateralValidity}
+}
+
+type collateral struct {
+	authority *Authority
+	age       time.Duration
+}
+
+func (c *collateral) Get(requestURL string) (map[string][]string, []byte, error) {
+	issued := time.Now().Add(-c.age).UTC().Truncate(time.Second)
+
+	switch requestURL {
+	case pcs.TcbInfoURL(hex.EncodeToString(fmspc)):
+		return c.signed(pcs.TcbInfoIssuerChainPhrase, "tcbInfo", c.tcbInfo(issued))
+	case pcs.QeIdentityURL():
+		return c.signed(pcs.SgxQeIdentityIssuerChainPhrase, "enclaveIdentity", c.qeIdentity(issued))
+	default:
+		return nil, nil, fmt.Errorf("simulated PCS does not serve %s", requestURL)
+	}
+}
+
+// signed wraps body the way Intel PCS does: the signature covers the exact
+// bytes of the named field and the issuer chain is in a URL-escaped header.
+func (c *collateral) signed(chainHeader string, field string, body any) (map[string][]string, []byte, error) {
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return nil, nil, err
+	}
+	signature, err := sign(c.authority.tcbSigningKey, raw)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to sign %s: %w", field, err)
+	}
+	response, err := json.Marshal(map[string]any{
+		field:       json.RawMessage(raw),
+		"signature": hex.EncodeToString(signature),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	chain := append(encodeCertificate(c.authority.tcbSigning), encodeCertificate(c.authority.root)...)
+	header := map[string][]string{chainHeader: {url.QueryEscape(string(chain))}}
+	return header, response, nil
+}
+
+func (c *collateral) tcbInfo(issued time.Time) *tcbInfo {
+	upToDate := pcs.TcbLevel{
+		Tcb: pcs.Tcb{
+			SgxTcbcomponents: components(cpuSvn),
+			Pcesvn:           pceSvn,
+			TdxTcbcomponents: components(upToDateTeeTCBSVN),
+		},
+		TcbDate:   issued.Format(time.RFC3339),
+		TcbStatus: pcs.TcbComponentStatusUpToDate,
+	}
+	outOfDate := pcs.TcbLevel{
+		Tcb: pcs.Tcb{
+			SgxTcbcomponents: components(make([]byte, len(cpuSvn))),
+			TdxTcbcomponents: components(make([]byte, len(upToDateTeeTCBSVN))),
+		},
+		TcbDate:   issued.Format(time.RFC3339),
+		TcbStatus: pcs.TcbComponentStatusOutOfDate,
+	}
+	module := tdxModule{
+		Mrsigner:       hex.EncodeToString(make([]byte, 48)),
+		Attributes:     hex.EncodeToString(make([]byte, 8)),
+		AttributesMask: "ffffffffffffffff",
+	}
+
+	return &tcbInfo{
+		ID:                      "TDX",
+		Version:                 3,
+		IssueDate:               issued,
+		NextUpdate:              issued.Add(collateralValidity),
+		Fmspc:                   hex.EncodeToString(fmspc),
+		PceID:                   hex.EncodeToString(pceID),
+		TcbType:                 0,
+		TcbEvaluationDataNumber: 17,
+		TdxModule:               module,
+		TdxModuleIdentities: []tdxModuleIdentity{{
+			ID:        fmt.Sprintf("TDX_%02x", upToDateTeeTCBSVN[1]),
+			tdxModule: module,
+			TcbLevels: []pcs.TcbLevel{
+				{Tcb: pcs.Tcb{Isvsvn: uint32(upToDateTeeTCBSVN[0])}, TcbDate: upToDate.TcbDate, TcbStatus: pcs.TcbComponentStatusUpToDate},
+				{Tcb: pcs.Tcb{Isvsvn: 0}, TcbDate: outOfDate.TcbDate, TcbStatus: pcs.TcbComponentStatusOutOfDate},
+			},
+		}},
+		TcbLevels: []pcs.TcbLevel{upToDate, outOfDate},
+	}
+}
+
+func (c *collateral) qeIdentity(issued time.Time) *enclaveIdentity {
+	return &enclaveIdentity{
+		ID:                      "TD_QE",
+		Version:                 2,
+		IssueDate:               issued,
+		NextUpdate:              issued.Add(collateralValidity),
+		TcbEvaluationDataNumber: 17,
+		Miscselect:              "00000000",
+		MiscselectMask:          "ffffffff",
+		Attributes:              hex.EncodeToString(qeAttribute),
+		AttributesMask:          "fbffffffffffffff0000000000000000",
+		Mrsigner:                hex.EncodeToString(qeMrSigner),
+		IsvProdID:               uint16(qeIsvProdID),
+		TcbLevels: []pcs.TcbLevel{
+			{Tcb: pcs.Tcb{Isvsvn: qeIsvSvn}, TcbDate: issued.Format(time.RFC3339), TcbStatus: pcs.TcbComponentStatusUpToDate},
+			{Tcb: pcs.Tcb{Isvsvn: 0}, TcbDate: issued.Format(time.RFC3339), TcbStatus: pcs.TcbComponentStatusOutOfDate},
+		},
+	}
+}
+
+func components(svns []byte) []pcs.TcbComponent {
+	out := make([]pcs.TcbComponent, len(svns))
+	for i, svn := range svns {
+		out[i] = pcs.TcbComponent{Svn: svn}
+	}
+	return out
+}
@@ -0,0 +1,256 @@
+package simulator
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/Hyodar/tdxs/pkg/schema"
+	"github.com/Hyodar/tdxs/pkg/tdx"
+)
+
+// Methods that error rates can be set for.
+const (
+	MethodIssue    = "issue"
+	MethodMetadata = "metadata"
+	MethodValidate = "validate"
+)
+
+// Faults configures the faults a simulator backend injects. Rates are
+// probabilities between 0 and 1, rolled independently for every request.
+type Faults struct {
+	Enabled bool `yaml:"enabled"`
+	// Seed makes the injected faults reproducible. Zero picks a random seed.
+	Seed    uint64  `yaml:"seed"`
+	Latency Latency `yaml:"latency"`
+	// ErrorRates maps a method to the rate at which it fails with a
+	// retryable backend error.
+	ErrorRates map[string]float64 `yaml:"error_rates"`
+
+	// Issuer faults: the document is issued but does not validate.
+	CorruptSignature float64 `yaml:"corrupt_signature"`
+	WrongNonce       float64 `yaml:"wrong_nonce"`
+	StaleTCB         float64 `yaml:"stale_tcb"`
+
+	// Validator faults: the collateral used for verification has expired.
+	ExpiredCollateral float64 `yaml:"expired_collateral"`
+}
+
+func (f *Faults) Validate() error {
+	if err := f.Latency.Validate(); err != nil {
+		return fmt.Errorf("latency: %w", err)
+	}
+	for method, rate := range f.ErrorRates {
+		if method != MethodIssue && method != MethodMetadata && method != MethodValidate {
+			return fmt.Errorf("error_rates: unknown method %s", method)
+		}
+		if err := validateRate(rate); err != nil {
+			return fmt.Errorf("error_rates.%s: %w", method, err)
+		}
+	}
+	for name, rate := range map[string]float64{
+		"corrupt_signature":  f.CorruptSignature,
+		"wrong_nonce":        f.WrongNonce,
+		"stale_tcb":          f.StaleTCB,
+		"expired_collateral": f.ExpiredCollateral,
+	} {
+		if err := validateRate(rate); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func validateRate(rate float64) error {
+	if rate < 0 || rate > 1 || math.IsNaN(rate) {
+		return fmt.Errorf("rate %v is not between 0 and 1", rate)
+	}
+	return nil
+}
+
+type LatencyDistribution string
+
+const (
+	// LatencyConstant delays every request by Mean.
+	LatencyConstant LatencyDistribution = "constant"
+	// LatencyUniform delays requests by a duration between Min and Max.
+	LatencyUniform LatencyDistribution = "uniform"
+	// LatencyNormal delays requests around Mean with StdDev, clamped to Min
+	// and, if set, Max.
+	LatencyNormal LatencyDistribution = "normal"
+	// LatencyExponential delays requests by Min plus an exponentially
+	// distributed duration with mean Mean, capped at Max if set.
+	LatencyExponential LatencyDistribution = "exponential"
+)
+
+func (LatencyDistribution) JSONSchema() schema.Schema {
+	return schema.Schema{
+		"type": "string",
+		"enum": []string{"", string(LatencyConstant), string(LatencyUniform), string(LatencyNormal), string(LatencyExponential)},
+	}
+}
+
+// Latency adds a random delay to requests. An empty distribution adds none.
+type Latency struct {
+	Distribution LatencyDistribution `yaml:"distribution"`
+	Min          time.Duration       `yaml:"min"`
+	Max          time.Duration       `yaml:"max"`
+	Mean         time.Duration       `yaml:"mean"`
+	StdDev       time.Duration       `yaml:"stddev"`
+}
+
+func (l *Latency) Validate() error {
+	if l.Min < 0 || l.Max < 0 || l.Mean < 0 || l.StdDev < 0 {
+		return fmt.Errorf("durations must not be negative")
+	}
+	if l.Max != 0 && l.Max < l.Min {
+		return fmt.Errorf("max must not be less than min")
+	}
+	switch l.Distribution {
+	case "", LatencyConstant, LatencyNormal, LatencyExponential:
+		return nil
+	case LatencyUniform:
+		if l.Max == 0 {
+			return fmt.Errorf("uniform distribution requires max")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown distribution %q", l.Distribution)
+	}
+}
+
+func (l *Latency) sample(r *rand.Rand) time.Duration {
+	var d time.Duration
+	switch l.Distribution {
+	case LatencyConstant:
+		return l.Mean
+	case LatencyUniform:
+		return l.Min + time.Duration(r.Int64N(int64(l.Max-l.Min)+1))
+	case LatencyNormal:
+		d = l.Mean + time.Duration(r.NormFloat64()*float64(l.StdDev))
+	case LatencyExponential:
+		d = l.Min + time.Duration(r.ExpFloat64()*float64(l.Mean))
+	default:
+		return 0
+	}
+	d = max(d, l.Min)
+	if l.Max != 0 {
+		d = min(d, l.Max)
+	}
+	return d
+}
+
+// FaultTarget is implemented by backends whose faults can be changed while
+// they run.
+type FaultTarget interface {
+	Faults() Faults
+	SetFaults(faults Faults) error
+}
+
+// Injector rolls the configured faults. It is safe for concurrent use, and
+// its faults can be replaced at any time.
+type Injector struct {
+	mu     sync.Mutex
+	faults Faults
+	rand   *rand.Rand
+}
+
+func NewInjector(faults Faults) *Injector {
+	injector := &Injector{}
+	injector.Set(faults)
+	return injector
+}
+
+// Set replaces the faults. A non-zero seed restarts the random sequence.
+func (i *Injector) Set(faults Faults) {
+	seed := faults.Seed
+	if seed == 0 {
+		seed = rand.Uint64()
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.faults = faults
+	i.rand = rand.New(rand.NewPCG(seed, seed))
+}
+
+func (i *Injector) Faults() Faults {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.faults
+}
+
+// Delay waits for a sampled latency, or until ctx is done.
+func (i *Injector) Delay(ctx context.Context) error {
+	i.mu.Lock()
+	var delay time.Duration
+	if i.faults.Enabled {
+		delay = i.faults.Latency.sample(i.rand)
+	}
+	i.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Fail reports whether method should fail with an injected error.
+func (i *Injector) Fail(method string) bool {
+	return i.roll(func(f *Faults) float64 { return f.ErrorRates[method] })
+}
+
+func (i *Injector) CorruptSignature() bool {
+	return i.roll(func(f *Faults) float64 { return f.CorruptSignature })
+}
+
+func (i *Injector) WrongNonce() bool {
+	return i.roll(func(f *Faults) float64 { return f.WrongNonce })
+}
+
+func (i *Injector) StaleTCB() bool {
+	return i.roll(func(f *Faults) float64 { return f.StaleTCB })
+}
+
+func (i *Injector) ExpiredCollateral() bool {
+	return i.roll(func(f *Faults) float64 { return f.ExpiredCollateral })
+}
+
+func (i *Injector) roll(rate func(f *Faults) float64) bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if !i.faults.Enabled {
+		return false
+	}
+	r := rate(&i.faults)
+	return r > 0 && i.rand.Float64() < r
+}
+
+// quoteSignatureOffset is where the ECDSA signature starts in a v4 quote:
+// after the header, the TD quote body and the signed data size.
+const quoteSignatureOffset = 0x27c
+
+// CorruptSignature flips a bit of the quote signature, in place.
+func CorruptSignature(quote []byte) {
+	if len(quote) > quoteSignatureOffset {
+		quote[quoteSignatureOffset] ^= 0x01
+	}
+}
+
+// OutOfDateTeeTCBSVN returns a TEE TCB SVN one module SVN below what the
+// simulated collateral considers up to date.
+func OutOfDateTeeTCBSVN() tdx.HexBytes {
+	svn := append(tdx.HexBytes{}, upToDateTeeTCBSVN...)
+	svn[0]--
+	return svn
+}
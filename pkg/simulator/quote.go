@@ -0,0 +1,198 @@
+package simulator
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/google/go-tdx-guest/abi"
+	pb "github.com/google/go-tdx-guest/proto/tdx"
+
+	"github.com/Hyodar/tdxs/pkg/tdx"
+)
+
+// DefaultTD returns fixed, made-up measurements, the XFAM and attributes of
+// a typical production TD, and the TEE TCB SVN the simulated collateral
+// considers up to date.
+func DefaultTD() tdx.TD {
+	measurement := func(label string) tdx.HexBytes {
+		sum := sha512.Sum384([]byte("tdxs simulator " + label))
+		return sum[:]
+	}
+	return tdx.TD{
+		MrTd:         measurement("MRTD"),
+		Rtmr0:        measurement("RTMR0"),
+		Rtmr1:        measurement("RTMR1"),
+		Rtmr2:        measurement("RTMR2"),
+		Rtmr3:        measurement("RTMR3"),
+		MrSeam:       measurement("MRSEAM"),
+		XFAM:         tdx.HexBytes{0xe7, 0x18, 0x06, 0x00, 0x00, 0x00, 0x00, 0x00},
+		TDAttributes: tdx.HexBytes{0x00, 0x00, 0x00, 0x10, 0x00, 0x00, 0x00, 0x00}, // SEPT_VE_DISABLE
+		TeeTCBSVN:    append(tdx.HexBytes{}, upToDateTeeTCBSVN...),
+	}
+}
+
+// Values of the simulated quoting enclave, matched by the QE identity
+// collateral.
+var (
+	qeVendorID  = []byte{0x93, 0x9a, 0x72, 0x33, 0xf7, 0x9c, 0x4c, 0xa9, 0x94, 0x0a, 0x0d, 0xb3, 0x95, 0x7f, 0x06, 0x07}
+	qeMrSigner  = []byte{0xdc, 0x9e, 0x2a, 0x7c, 0x6f, 0x94, 0x8f, 0x17, 0x47, 0x4e, 0x34, 0xa7, 0xfc, 0x43, 0xed, 0x03, 0x0f, 0x7c, 0x15, 0x63, 0xf1, 0xba, 0xbd, 0xdf, 0x63, 0x40, 0xc8, 0x2e, 0x0e, 0x54, 0xa8, 0xc5}
+	qeAttribute = []byte{0x11, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+	qeIsvProdID = uint32(2)
+	qeIsvSvn    = uint32(8)
+
+	// Module ISVSVN 4 of TDX module version 1, with SEAM loader SVN 3.
+	upToDateTeeTCBSVN = []byte{0x04, 0x01, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+)
+
+const (
+	certificationDataTypePCKChain = 5
+	certificationDataTypeQEReport = 6
+)
+
+// ReportData binds user data and a nonce into the 64-byte REPORTDATA field:
+// SHA-256 of userData || nonce, zero padded.
+func ReportData(userData []byte, nonce []byte) []byte {
+	sum := sha256.Sum256(append(append([]byte{}, userData...), nonce...))
+	return append(sum[:], make([]byte, abi.ReportDataSize-sha256.Size)...)
+}
+
+// Quote builds a TDX v4 quote reporting td and reportData, signed with the
+// attestation key, and a QE report certifying that key signed with the PCK
+// key. td must have all fields set, see TD.WithDefaults.
+func (a *Authority) Quote(td *tdx.TD, reportData []byte) ([]byte, error) {
+	quote, err := a.quote(td, reportData)
+	if err != nil {
+		return nil, err
+	}
+	return abi.QuoteToAbiBytes(quote)
+}
+
+func (a *Authority) quote(td *tdx.TD, reportData []byte) (*pb.QuoteV4, error) {
+	header := &pb.Header{
+		Version:            abi.QuoteVersion,
+		AttestationKeyType: abi.AttestationKeyType,
+		TeeType:            abi.TeeTDX,
+		PceSvn:             binary.LittleEndian.AppendUint16(nil, pceSvn),
+		QeSvn:              binary.LittleEndian.AppendUint16(nil, uint16(qeIsvSvn)),
+		QeVendorId:         qeVendorID,
+		UserData:           make([]byte, 20),
+	}
+	body := &pb.TDQuoteBody{
+		TeeTcbSvn:      td.TeeTCBSVN,
+		MrSeam:         td.MrSeam,
+		MrSignerSeam:   make([]byte, 48),
+		SeamAttributes: make([]byte, 8),
+		TdAttributes:   td.TDAttributes,
+		Xfam:           td.XFAM,
+		MrTd:           td.MrTd,
+		MrConfigId:     make([]byte, 48),
+		MrOwner:        make([]byte, 48),
+		MrOwnerConfig:  make([]byte, 48),
+		ReportData:     reportData,
+	}
+	for _, rtmr := range td.Rtmrs() {
+		body.Rtmrs = append(body.Rtmrs, rtmr)
+	}
+
+	rawHeader, err := abi.HeaderToAbiBytes(header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode quote header: %w", err)
+	}
+	rawBody, err := abi.TdQuoteBodyToAbiBytes(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode TD quote body: %w", err)
+	}
+	signature, err := sign(a.attestationKey, append(rawHeader, rawBody...))
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign quote: %w", err)
+	}
+
+	// The QE report vouches for the attestation key: its REPORTDATA is the
+	// hash of the key and the QE authentication data.
+	attestationKey := rawPublicKey(&a.attestationKey.PublicKey)
+	authData := make([]byte, 32)
+	for i := range authData {
+		authData[i] = byte(i)
+	}
+	keyHash := sha256.Sum256(append(append([]byte{}, attestationKey...), authData...))
+	qeReport := &pb.EnclaveReport{
+		CpuSvn:     cpuSvn,
+		Reserved1:  make([]byte, 28),
+		Attributes: qeAttribute,
+		MrEnclave:  make([]byte, 32),
+		Reserved2:  make([]byte, 32),
+		MrSigner:   qeMrSigner,
+		Reserved3:  make([]byte, 96),
+		IsvProdId:  qeIsvProdID,
+		IsvSvn:     qeIsvSvn,
+		Reserved4:  make([]byte, 60),
+		ReportData: append(keyHash[:], make([]byte, abi.ReportDataSize-sha256.Size)...),
+	}
+	rawQEReport, err := abi.EnclaveReportToAbiBytes(qeReport)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode QE report: %w", err)
+	}
+	qeReportSignature, err := sign(a.pckKey, rawQEReport)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign QE report: %w", err)
+	}
+
+	chain := a.PCKChain()
+	qeCertificationData := &pb.QEReportCertificationData{
+		QeReport:          qeReport,
+		QeReportSignature: qeReportSignature,
+		QeAuthData: &pb.QeAuthData{
+			ParsedDataSize: uint32(len(authData)),
+			Data:           authData,
+		},
+		PckCertificateChainData: &pb.PCKCertificateChainData{
+			CertificateDataType: certificationDataTypePCKChain,
+			Size:                uint32(len(chain)),
+			PckCertChain:        chain,
+		},
+	}
+	// QE report, its signature, the sized auth data and the typed, sized
+	// certificate chain.
+	certificationSize := len(rawQEReport) + len(qeReportSignature) + 2 + len(authData) + 6 + len(chain)
+
+	return &pb.QuoteV4{
+		Header:      header,
+		TdQuoteBody: body,
+		// Signature, attestation key and the typed, sized certification data.
+		SignedDataSize: uint32(len(signature) + len(attestationKey) + 6 + certificationSize),
+		SignedData: &pb.Ecdsa256BitQuoteV4AuthData{
+			Signature:           signature,
+			EcdsaAttestationKey: attestationKey,
+			CertificationData: &pb.CertificationData{
+				CertificateDataType:       certificationDataTypeQEReport,
+				Size:                      uint32(certificationSize),
+				QeReportCertificationData: qeCertificationData,
+			},
+		},
+	}, nil
+}
+
+// sign returns the raw r || s ECDSA signature over the SHA-256 of message,
+// the encoding quotes and collateral use.
+func sign(key *ecdsa.PrivateKey, message []byte) ([]byte, error) {
+	digest := sha256.Sum256(message)
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+	return signature, nil
+}
+
+func rawPublicKey(pub *ecdsa.PublicKey) []byte {
+	key := make([]byte, 64)
+	pub.X.FillBytes(key[:32])
+	pub.Y.FillBytes(key[32:])
+	return key
+}
@@ -0,0 +1,36 @@
+// Package tdx holds the TD reference values and quote verification helpers
+// shared by the TDX issuers and validators.
+package tdx
+
+import (
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/google/go-tdx-guest/verify"
+	"github.com/google/go-tdx-guest/verify/trust"
+)
+
+// collateralErrors prefix the errors of verify.TdxQuote when TCB info, QE
+// identity or a CRL could not be fetched. The error types of the failed
+// fetches are lost on the way, so only the messages tell.
+var collateralErrors = []string{
+	"unable to receive tcbInfo",
+	"unable to receive QeIdentity",
+	"unable to receive PCK CRL",
+	"unable to receive Root CA CRL",
+}
+
+// CollateralUnavailable reports whether err from verify.TdxQuote means the
+// collateral could not be fetched from Intel PCS, so the quote could not be
+// evaluated, rather than that it failed a check.
+func CollateralUnavailable(err error) bool {
+	var recreationErr *trust.AttestationRecreationErr
+	var crlErr verify.CRLUnavailableErr
+	if errors.As(err, &recreationErr) || errors.As(err, &crlErr) {
+		return true
+	}
+	return slices.ContainsFunc(collateralErrors, func(prefix string) bool {
+		return strings.Contains(err.Error(), prefix)
+	})
+}
@@ -0,0 +1,35 @@
+package tdx
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/Hyodar/tdxs/pkg/schema"
+	"gopkg.in/yaml.v3"
+)
+
+// HexBytes is a byte string written in YAML as hex, with or without a 0x
+// prefix.
+type HexBytes []byte
+
+func (h *HexBytes) UnmarshalYAML(node *yaml.Node) error {
+	var s string
+	if err := node.Decode(&s); err != nil {
+		return err
+	}
+	data, err := hex.DecodeString(strings.TrimPrefix(s, "0x"))
+	if err != nil {
+		return fmt.Errorf("invalid hex value %q: %w", s, err)
+	}
+	*h = data
+	return nil
+}
+
+func (h HexBytes) MarshalYAML() (any, error) {
+	return "0x" + hex.EncodeToString(h), nil
+}
+
+func (HexBytes) JSONSchema() schema.Schema {
+	return schema.Schema{"type": "string", "pattern": "^(0x)?([0-9a-fA-F]{2})*$"}
+}
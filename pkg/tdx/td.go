@@ -0,0 +1,76 @@
+package tdx
+
+import (
+	"fmt"
+
+	"github.com/google/go-tdx-guest/abi"
+)
+
+// TD holds the values a TD quote reports. As validator reference values,
+// unset fields are not checked.
+type TD struct {
+	MrTd         HexBytes `yaml:"mr_td"`
+	Rtmr0        HexBytes `yaml:"rtmr0"`
+	Rtmr1        HexBytes `yaml:"rtmr1"`
+	Rtmr2        HexBytes `yaml:"rtmr2"`
+	Rtmr3        HexBytes `yaml:"rtmr3"`
+	MrSeam       HexBytes `yaml:"mr_seam"`
+	XFAM         HexBytes `yaml:"xfam"`
+	TDAttributes HexBytes `yaml:"td_attributes"`
+	TeeTCBSVN    HexBytes `yaml:"tee_tcb_svn"`
+}
+
+// WithDefaults returns td with unset fields taken from defaults.
+func (td TD) WithDefaults(defaults TD) TD {
+	for i, field := range td.fields() {
+		if len(*field.value) == 0 {
+			*field.value = *defaults.fields()[i].value
+		}
+	}
+	return td
+}
+
+// Validate checks the length of the fields that are set.
+func (td *TD) Validate() error {
+	for _, field := range td.fields() {
+		if len(*field.value) != 0 && len(*field.value) != field.size {
+			return fmt.Errorf("%s: expected %d bytes, got %d", field.name, field.size, len(*field.value))
+		}
+	}
+	return nil
+}
+
+// IsZero reports whether no field is set.
+func (td *TD) IsZero() bool {
+	for _, field := range td.fields() {
+		if len(*field.value) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Rtmrs returns the four RTMRs in order.
+func (td *TD) Rtmrs() []HexBytes {
+	return []HexBytes{td.Rtmr0, td.Rtmr1, td.Rtmr2, td.Rtmr3}
+}
+
+type tdField struct {
+	name  string
+	size  int
+	value *HexBytes
+}
+
+func (td *TD) fields() []tdField {
+	return []tdField{
+		{"mr_td", abi.MrTdSize, &td.MrTd},
+		{"rtmr0", abi.RtmrSize, &td.Rtmr0},
+		{"rtmr1", abi.RtmrSize, &td.Rtmr1},
+		{"rtmr2", abi.RtmrSize, &td.Rtmr2},
+		{"rtmr3", abi.RtmrSize, &td.Rtmr3},
+		{"mr_seam", abi.MrSeamSize, &td.MrSeam},
+		{"xfam", abi.XfamSize, &td.XFAM},
+		{"td_attributes", abi.TdAttributesSize, &td.TDAttributes},
+		{"tee_tcb_svn", abi.TeeTcbSvnSize, &td.TeeTCBSVN},
+	}
+}
@@ -4,8 +4,10 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/Hyodar/tdxs/pkg/api"
+	"github.com/Hyodar/tdxs/pkg/health"
 )
 
 type SocketTransportRequestMethod string
@@ -14,11 +16,16 @@ const (
 	SocketTransportRequestMethodIssue    SocketTransportRequestMethod = "issue"
 	SocketTransportRequestMethodMetadata SocketTransportRequestMethod = "metadata"
 	SocketTransportRequestMethodValidate SocketTransportRequestMethod = "validate"
+	SocketTransportRequestMethodHealth   SocketTransportRequestMethod = "health"
+	SocketTransportRequestMethodReload   SocketTransportRequestMethod = "reload"
+	SocketTransportRequestMethodFaults   SocketTransportRequestMethod = "faults"
+	SocketTransportRequestMethodExtend   SocketTransportRequestMethod = "extend"
 )
 
 type SocketTransportRequest struct {
-	Method SocketTransportRequestMethod `json:"method"`
-	Data   json.RawMessage              `json:"data"`
+	Method  SocketTransportRequestMethod `json:"method"`
+	Profile string                       `json:"profile,omitempty"`
+	Data    json.RawMessage              `json:"data"`
 }
 
 func (r *SocketTransportRequest) UnmarshalData() (any, error) {
@@ -31,8 +38,10 @@ func (r *SocketTransportRequest) UnmarshalData() (any, error) {
 		return issueRequest.ToAPIRequest()
 	case SocketTransportRequestMethodMetadata:
 		var metadataRequest SocketTransportMetadataRequest
-		if err := json.Unmarshal(r.Data, &metadataRequest); err != nil {
-			return nil, fmt.Errorf("failed to unmarshal metadata request: %w", err)
+		if len(r.Data) > 0 {
+			if err := json.Unmarshal(r.Data, &metadataRequest); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal metadata request: %w", err)
+			}
 		}
 		return metadataRequest.ToAPIRequest()
 	case SocketTransportRequestMethodValidate:
@@ -41,6 +50,36 @@ func (r *SocketTransportRequest) UnmarshalData() (any, error) {
 			return nil, fmt.Errorf("failed to unmarshal validate request: %w", err)
 		}
 		return validateRequest.ToAPIRequest()
+	case SocketTransportRequestMethodHealth:
+		var healthRequest SocketTransportHealthRequest
+		if len(r.Data) > 0 {
+			if err := json.Unmarshal(r.Data, &healthRequest); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal health request: %w", err)
+			}
+		}
+		return healthRequest.ToAPIRequest()
+	case SocketTransportRequestMethodReload:
+		var reloadRequest SocketTransportReloadRequest
+		if len(r.Data) > 0 {
+			if err := json.Unmarshal(r.Data, &reloadRequest); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal reload request: %w", err)
+			}
+		}
+		return reloadRequest.ToAPIRequest()
+	case SocketTransportRequestMethodFaults:
+		var faultsRequest SocketTransportFaultsRequest
+		if len(r.Data) > 0 {
+			if err := json.Unmarshal(r.Data, &faultsRequest); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal faults request: %w", err)
+			}
+		}
+		return faultsRequest.ToAPIRequest()
+	case SocketTransportRequestMethodExtend:
+		var extendRequest SocketTransportExtendRequest
+		if err := json.Unmarshal(r.Data, &extendRequest); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal extend request: %w", err)
+		}
+		return extendRequest.ToAPIRequest()
 	}
 	return nil, fmt.Errorf("invalid method: %s", r.Method)
 }
@@ -64,10 +103,26 @@ func (r *SocketTransportIssueRequest) ToAPIRequest() (*api.IssueRequest, error)
 	return &api.IssueRequest{UserData: userData, Nonce: nonce}, nil
 }
 
-type SocketTransportMetadataRequest struct{}
+// SocketTransportMetadataRequest optionally binds the metadata attestation
+// to the caller's user data and nonce, and asks for the document itself.
+type SocketTransportMetadataRequest struct {
+	UserData        string `json:"userData,omitempty"`
+	Nonce           string `json:"nonce,omitempty"`
+	IncludeDocument bool   `json:"includeDocument,omitempty"`
+}
 
 func (r *SocketTransportMetadataRequest) ToAPIRequest() (*api.MetadataRequest, error) {
-	return &api.MetadataRequest{}, nil
+	userData, err := hex.DecodeString(r.UserData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode user data: %w", err)
+	}
+
+	nonce, err := hex.DecodeString(r.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode nonce: %w", err)
+	}
+
+	return &api.MetadataRequest{UserData: userData, Nonce: nonce, IncludeDocument: r.IncludeDocument}, nil
 }
 
 type SocketTransportValidateRequest struct {
@@ -89,6 +144,141 @@ func (r *SocketTransportValidateRequest) ToAPIRequest() (*api.ValidateRequest, e
 	return &api.ValidateRequest{Document: document, Nonce: nonce}, nil
 }
 
+type SocketTransportHealthRequest struct{}
+
+func (r *SocketTransportHealthRequest) ToAPIRequest() (*api.HealthRequest, error) {
+	return &api.HealthRequest{}, nil
+}
+
+type SocketTransportReloadRequest struct{}
+
+func (r *SocketTransportReloadRequest) ToAPIRequest() (*api.ReloadRequest, error) {
+	return &api.ReloadRequest{}, nil
+}
+
+type SocketTransportFaultsRequest struct {
+	Issuer    *SocketTransportFaults `json:"issuer,omitempty"`
+	Validator *SocketTransportFaults `json:"validator,omitempty"`
+	Enabled   *bool                  `json:"enabled,omitempty"`
+}
+
+func (r *SocketTransportFaultsRequest) ToAPIRequest() (*api.FaultsRequest, error) {
+	req := &api.FaultsRequest{Enabled: r.Enabled}
+	if r.Issuer != nil {
+		faults, err := r.Issuer.ToAPI()
+		if err != nil {
+			return nil, fmt.Errorf("invalid issuer faults: %w", err)
+		}
+		req.Issuer = faults
+	}
+	if r.Validator != nil {
+		faults, err := r.Validator.ToAPI()
+		if err != nil {
+			return nil, fmt.Errorf("invalid validator faults: %w", err)
+		}
+		req.Validator = faults
+	}
+	return req, nil
+}
+
+type SocketTransportExtendRequest struct {
+	RTMR        int    `json:"rtmr"`
+	Digest      string `json:"digest"`
+	Description string `json:"description,omitempty"`
+}
+
+func (r *SocketTransportExtendRequest) ToAPIRequest() (*api.ExtendRequest, error) {
+	digest, err := hex.DecodeString(r.Digest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode digest: %w", err)
+	}
+
+	return &api.ExtendRequest{RTMR: r.RTMR, Digest: digest, Description: r.Description}, nil
+}
+
+// SocketTransportFaults mirrors api.Faults, with durations written as
+// strings such as "250ms".
+type SocketTransportFaults struct {
+	Enabled           bool                   `json:"enabled"`
+	Seed              uint64                 `json:"seed,omitempty"`
+	Latency           SocketTransportLatency `json:"latency"`
+	ErrorRates        map[string]float64     `json:"errorRates,omitempty"`
+	CorruptSignature  float64                `json:"corruptSignature,omitempty"`
+	WrongNonce        float64                `json:"wrongNonce,omitempty"`
+	StaleTCB          float64                `json:"staleTcb,omitempty"`
+	ExpiredCollateral float64                `json:"expiredCollateral,omitempty"`
+}
+
+type SocketTransportLatency struct {
+	Distribution string `json:"distribution,omitempty"`
+	Min          string `json:"min,omitempty"`
+	Max          string `json:"max,omitempty"`
+	Mean         string `json:"mean,omitempty"`
+	StdDev       string `json:"stddev,omitempty"`
+}
+
+func (f *SocketTransportFaults) ToAPI() (*api.Faults, error) {
+	latency := api.Latency{Distribution: f.Latency.Distribution}
+	for _, field := range []struct {
+		name  string
+		value string
+		dest  *time.Duration
+	}{
+		{"min", f.Latency.Min, &latency.Min},
+		{"max", f.Latency.Max, &latency.Max},
+		{"mean", f.Latency.Mean, &latency.Mean},
+		{"stddev", f.Latency.StdDev, &latency.StdDev},
+	} {
+		if field.value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(field.value)
+		if err != nil {
+			return nil, fmt.Errorf("latency %s: %w", field.name, err)
+		}
+		*field.dest = d
+	}
+
+	return &api.Faults{
+		Enabled:           f.Enabled,
+		Seed:              f.Seed,
+		Latency:           latency,
+		ErrorRates:        f.ErrorRates,
+		CorruptSignature:  f.CorruptSignature,
+		WrongNonce:        f.WrongNonce,
+		StaleTCB:          f.StaleTCB,
+		ExpiredCollateral: f.ExpiredCollateral,
+	}, nil
+}
+
+func NewSocketTransportFaults(faults *api.Faults) *SocketTransportFaults {
+	if faults == nil {
+		return nil
+	}
+	duration := func(d time.Duration) string {
+		if d == 0 {
+			return ""
+		}
+		return d.String()
+	}
+	return &SocketTransportFaults{
+		Enabled: faults.Enabled,
+		Seed:    faults.Seed,
+		Latency: SocketTransportLatency{
+			Distribution: faults.Latency.Distribution,
+			Min:          duration(faults.Latency.Min),
+			Max:          duration(faults.Latency.Max),
+			Mean:         duration(faults.Latency.Mean),
+			StdDev:       duration(faults.Latency.StdDev),
+		},
+		ErrorRates:        faults.ErrorRates,
+		CorruptSignature:  faults.CorruptSignature,
+		WrongNonce:        faults.WrongNonce,
+		StaleTCB:          faults.StaleTCB,
+		ExpiredCollateral: faults.ExpiredCollateral,
+	}
+}
+
 type SocketTransportIssueResponseData struct {
 	Document string `json:"document"`
 }
@@ -96,20 +286,23 @@ type SocketTransportIssueResponseData struct {
 type SocketTransportIssueResponse struct {
 	Data  *SocketTransportIssueResponseData `json:"data"`
 	Error *string                           `json:"error"`
+	Code  api.ErrorCode                     `json:"code,omitempty"`
 }
 
 func NewIssueResponseFromError(err error) *SocketTransportIssueResponse {
-	errStr := fmt.Sprintf("transport error: %v", err)
+	errStr, code := responseError("transport", err)
 	return &SocketTransportIssueResponse{
-		Error: &errStr,
+		Error: errStr,
+		Code:  code,
 	}
 }
 
 func NewIssueResponseFromAPI(response *api.IssueResponse) *SocketTransportIssueResponse {
 	if response.Error != nil {
-		errStr := fmt.Sprintf("validator error: %v", response.Error)
+		errStr, code := responseError("issuer", response.Error)
 		return &SocketTransportIssueResponse{
-			Error: &errStr,
+			Error: errStr,
+			Code:  code,
 		}
 	}
 
@@ -125,25 +318,29 @@ type SocketTransportMetadataResponseData struct {
 	UserData   string `json:"userData"`
 	Nonce      string `json:"nonce"`
 	Metadata   any    `json:"metadata"`
+	Document   string `json:"document,omitempty"`
 }
 
 type SocketTransportMetadataResponse struct {
 	Data  *SocketTransportMetadataResponseData `json:"data"`
 	Error *string                              `json:"error"`
+	Code  api.ErrorCode                        `json:"code,omitempty"`
 }
 
 func NewMetadataResponseFromError(err error) *SocketTransportMetadataResponse {
-	errStr := fmt.Sprintf("transport error: %v", err)
+	errStr, code := responseError("transport", err)
 	return &SocketTransportMetadataResponse{
-		Error: &errStr,
+		Error: errStr,
+		Code:  code,
 	}
 }
 
 func NewMetadataResponseFromAPI(response *api.MetadataResponse) *SocketTransportMetadataResponse {
 	if response.Error != nil {
-		errStr := fmt.Sprintf("validator error: %v", response.Error)
+		errStr, code := responseError("issuer", response.Error)
 		return &SocketTransportMetadataResponse{
-			Error: &errStr,
+			Error: errStr,
+			Code:  code,
 		}
 	}
 
@@ -153,39 +350,207 @@ func NewMetadataResponseFromAPI(response *api.MetadataResponse) *SocketTransport
 			UserData:   hex.EncodeToString(response.UserData),
 			Nonce:      hex.EncodeToString(response.Nonce),
 			Metadata:   response.Metadata,
+			Document:   hex.EncodeToString(response.Document),
 		},
 	}
 }
 
 type SocketTransportValidateResponseData struct {
-	UserData string `json:"userData"`
-	Valid    bool   `json:"valid"`
+	UserData     string                       `json:"userData"`
+	Valid        bool                         `json:"valid"`
+	Verdict      api.Verdict                  `json:"verdict"`
+	FailedChecks []SocketTransportFailedCheck `json:"failedChecks,omitempty"`
+	Claims       any                          `json:"claims,omitempty"`
+}
+
+type SocketTransportFailedCheck struct {
+	Check  string        `json:"check"`
+	Code   api.ErrorCode `json:"code"`
+	Reason string        `json:"reason"`
 }
 
 type SocketTransportValidateResponse struct {
 	Data  *SocketTransportValidateResponseData `json:"data"`
 	Error *string                              `json:"error"`
+	Code  api.ErrorCode                        `json:"code,omitempty"`
 }
 
 func NewValidateResponseFromError(err error) *SocketTransportValidateResponse {
-	errStr := fmt.Sprintf("transport error: %v", err)
+	errStr, code := responseError("transport", err)
 	return &SocketTransportValidateResponse{
-		Error: &errStr,
+		Error: errStr,
+		Code:  code,
 	}
 }
 
 func NewValidateResponseFromAPI(response *api.ValidateResponse) *SocketTransportValidateResponse {
 	if response.Error != nil {
-		errStr := fmt.Sprintf("validator error: %v", response.Error)
+		errStr, code := responseError("validator", response.Error)
 		return &SocketTransportValidateResponse{
-			Error: &errStr,
+			Error: errStr,
+			Code:  code,
 		}
 	}
 
+	var failedChecks []SocketTransportFailedCheck
+	for _, check := range response.FailedChecks {
+		failedChecks = append(failedChecks, SocketTransportFailedCheck{
+			Check:  check.Check,
+			Code:   check.Code,
+			Reason: check.Reason,
+		})
+	}
+
 	return &SocketTransportValidateResponse{
 		Data: &SocketTransportValidateResponseData{
-			UserData: hex.EncodeToString(response.UserData),
-			Valid:    response.Valid,
+			UserData:     hex.EncodeToString(response.UserData),
+			Valid:        response.Valid,
+			Verdict:      response.Verdict(),
+			FailedChecks: failedChecks,
+			Claims:       response.Claims,
 		},
 	}
 }
+
+type SocketTransportHealthResponse struct {
+	Data  *health.StatusJSON `json:"data"`
+	Error *string            `json:"error"`
+	Code  api.ErrorCode      `json:"code,omitempty"`
+}
+
+func NewHealthResponseFromError(err error) *SocketTransportHealthResponse {
+	errStr, code := responseError("transport", err)
+	return &SocketTransportHealthResponse{
+		Error: errStr,
+		Code:  code,
+	}
+}
+
+func NewHealthResponseFromAPI(response *api.HealthResponse) *SocketTransportHealthResponse {
+	if response.Error != nil {
+		errStr, code := responseError("health", response.Error)
+		return &SocketTransportHealthResponse{
+			Error: errStr,
+			Code:  code,
+		}
+	}
+
+	return &SocketTransportHealthResponse{
+		Data: health.NewStatusJSON(response),
+	}
+}
+
+type SocketTransportReloadResponseData struct {
+	Reloaded bool `json:"reloaded"`
+}
+
+type SocketTransportReloadResponse struct {
+	Data  *SocketTransportReloadResponseData `json:"data"`
+	Error *string                            `json:"error"`
+	Code  api.ErrorCode                      `json:"code,omitempty"`
+}
+
+func NewReloadResponseFromError(err error) *SocketTransportReloadResponse {
+	errStr, code := responseError("transport", err)
+	return &SocketTransportReloadResponse{
+		Error: errStr,
+		Code:  code,
+	}
+}
+
+func NewReloadResponseFromAPI(response *api.ReloadResponse) *SocketTransportReloadResponse {
+	if response.Error != nil {
+		errStr, code := responseError("reload", response.Error)
+		return &SocketTransportReloadResponse{
+			Error: errStr,
+			Code:  code,
+		}
+	}
+
+	return &SocketTransportReloadResponse{
+		Data: &SocketTransportReloadResponseData{
+			Reloaded: true,
+		},
+	}
+}
+
+type SocketTransportFaultsResponseData struct {
+	Issuer    *SocketTransportFaults `json:"issuer"`
+	Validator *SocketTransportFaults `json:"validator"`
+}
+
+type SocketTransportFaultsResponse struct {
+	Data  *SocketTransportFaultsResponseData `json:"data"`
+	Error *string                            `json:"error"`
+	Code  api.ErrorCode                      `json:"code,omitempty"`
+}
+
+func NewFaultsResponseFromError(err error) *SocketTransportFaultsResponse {
+	errStr, code := responseError("transport", err)
+	return &SocketTransportFaultsResponse{
+		Error: errStr,
+		Code:  code,
+	}
+}
+
+func NewFaultsResponseFromAPI(response *api.FaultsResponse) *SocketTransportFaultsResponse {
+	if response.Error != nil {
+		errStr, code := responseError("faults", response.Error)
+		return &SocketTransportFaultsResponse{
+			Error: errStr,
+			Code:  code,
+		}
+	}
+
+	return &SocketTransportFaultsResponse{
+		Data: &SocketTransportFaultsResponseData{
+			Issuer:    NewSocketTransportFaults(response.Issuer),
+			Validator: NewSocketTransportFaults(response.Validator),
+		},
+	}
+}
+
+type SocketTransportExtendResponseData struct {
+	RTMR     int    `json:"rtmr"`
+	Value    string `json:"value"`
+	Sequence uint64 `json:"sequence"`
+}
+
+type SocketTransportExtendResponse struct {
+	Data  *SocketTransportExtendResponseData `json:"data"`
+	Error *string                            `json:"error"`
+	Code  api.ErrorCode                      `json:"code,omitempty"`
+}
+
+func NewExtendResponseFromError(err error) *SocketTransportExtendResponse {
+	errStr, code := responseError("transport", err)
+	return &SocketTransportExtendResponse{
+		Error: errStr,
+		Code:  code,
+	}
+}
+
+func NewExtendResponseFromAPI(response *api.ExtendResponse) *SocketTransportExtendResponse {
+	if response.Error != nil {
+		errStr, code := responseError("rtmr", response.Error)
+		return &SocketTransportExtendResponse{
+			Error: errStr,
+			Code:  code,
+		}
+	}
+
+	return &SocketTransportExtendResponse{
+		Data: &SocketTransportExtendResponseData{
+			RTMR:     response.RTMR,
+			Value:    hex.EncodeToString(response.Value),
+			Sequence: response.Sequence,
+		},
+	}
+}
+
+// responseError formats err for a response envelope, naming the component it
+// came from, and returns its code.
+func responseError(source string, err error) (*string, api.ErrorCode) {
+	errStr := fmt.Sprintf("%s error: %v", source, err)
+	return &errStr, api.CodeOf(err)
+}
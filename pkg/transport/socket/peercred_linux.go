@@ -0,0 +1,31 @@
+package socket
+
+import (
+	"net"
+	"syscall"
+
+	"github.com/Hyodar/tdxs/pkg/api"
+)
+
+func peerCaller(conn net.Conn) *api.Caller {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return nil
+	}
+
+	rawConn, err := unixConn.SyscallConn()
+	if err != nil {
+		return nil
+	}
+
+	var cred *syscall.Ucred
+	var credErr error
+	err = rawConn.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil || credErr != nil {
+		return nil
+	}
+
+	return &api.Caller{PID: cred.Pid, UID: cred.Uid, GID: cred.Gid}
+}
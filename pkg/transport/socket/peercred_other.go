@@ -0,0 +1,13 @@
+//go:build !linux
+
+package socket
+
+import (
+	"net"
+
+	"github.com/Hyodar/tdxs/pkg/api"
+)
+
+func peerCaller(_ net.Conn) *api.Caller {
+	return nil
+}
@@ -9,9 +9,11 @@ import (
 	"os"
 	"os/user"
 	"strconv"
+	"time"
 
 	"github.com/Hyodar/tdxs/pkg/api"
 	"github.com/Hyodar/tdxs/pkg/logger"
+	"github.com/Hyodar/tdxs/pkg/registry"
 	"github.com/Hyodar/tdxs/pkg/transport"
 	"github.com/coreos/go-systemd/v22/activation"
 	"github.com/coreos/go-systemd/v22/daemon"
@@ -43,11 +45,54 @@ func (c *SocketTransportConfig) Validate() error {
 		if c.FilePath == "" {
 			return fmt.Errorf("file_path is required when systemd is false")
 		}
+		if c.Perm&^os.ModePerm != 0 {
+			return fmt.Errorf("perm %#o has bits outside of the permission mask", uint32(c.Perm))
+		}
+		if _, _, err := c.resolveOwnership(); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
+// resolveOwnership looks up the configured owner and group, returning -1 for
+// the ones that are not set.
+func (c *SocketTransportConfig) resolveOwnership() (int, int, error) {
+	uid := -1
+	gid := -1
+
+	// Resolve user ID
+	if c.Owner != "" {
+		u, err := user.Lookup(c.Owner)
+		if err != nil {
+			return -1, -1, fmt.Errorf("failed to lookup user %s: %w", c.Owner, err)
+		}
+		uid, err = strconv.Atoi(u.Uid)
+		if err != nil {
+			return -1, -1, fmt.Errorf("failed to parse UID: %w", err)
+		}
+	}
+
+	// Resolve group ID
+	if c.Group != "" {
+		g, err := user.LookupGroup(c.Group)
+		if err != nil {
+			return -1, -1, fmt.Errorf("failed to lookup group %s: %w", c.Group, err)
+		}
+		gid, err = strconv.Atoi(g.Gid)
+		if err != nil {
+			return -1, -1, fmt.Errorf("failed to parse GID: %w", err)
+		}
+	}
+
+	return uid, gid, nil
+}
+
+func init() {
+	transport.Register(transport.TransportTypeSocket, registry.WithConfig(NewSocketTransport))
+}
+
 func NewSocketTransport(cfg *SocketTransportConfig, logger logger.Logger) (transport.Transport, error) {
 	if err := cfg.Validate(); err != nil {
 		return nil, err
@@ -104,6 +149,10 @@ func (t *SocketTransport) Start(ctx context.Context, queues *transport.Transport
 
 	t.listener = listener
 	go t.acceptConnections(ctx)
+	context.AfterFunc(ctx, func() {
+		// Closing the listener also removes a socket file we created.
+		listener.Close()
+	})
 
 	if t.cfg.Systemd {
 		sent, err := daemon.SdNotify(false, daemon.SdNotifyReady)
@@ -134,11 +183,22 @@ func (t *SocketTransport) acceptConnections(ctx context.Context) {
 	}
 }
 
+// errShuttingDown answers requests that were cut off by the daemon stopping.
+var errShuttingDown = api.Errorf(api.ErrorCodeBackendUnavailable, "daemon is shutting down")
+
 func (t *SocketTransport) handleConnection(ctx context.Context, conn net.Conn) {
 	defer conn.Close()
 
+	// Wake up a connection waiting for its next request when the daemon
+	// stops, while still letting a response in progress be written.
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetReadDeadline(time.Now())
+	})
+	defer stop()
+
 	decoder := json.NewDecoder(conn)
 	encoder := json.NewEncoder(conn)
+	caller := peerCaller(conn)
 
 	for {
 		select {
@@ -149,16 +209,18 @@ func (t *SocketTransport) handleConnection(ctx context.Context, conn net.Conn) {
 
 		var req SocketTransportRequest
 		if err := decoder.Decode(&req); err != nil {
-			if err == io.EOF {
+			if err == io.EOF || ctx.Err() != nil {
 				return
 			}
-			encoder.Encode(NewIssueResponseFromError(fmt.Errorf("failed to decode request: %w", err)))
-			continue
+			// The decoder cannot resynchronize after malformed input, so the
+			// connection is answered once and closed.
+			encoder.Encode(NewIssueResponseFromError(api.NewError(api.ErrorCodeBadRequest, fmt.Errorf("failed to decode request: %w", err))))
+			return
 		}
 
 		apiRequest, err := req.UnmarshalData()
 		if err != nil {
-			encoder.Encode(NewIssueResponseFromError(fmt.Errorf("failed to unmarshal request data: %w", err)))
+			encoder.Encode(NewIssueResponseFromError(api.NewError(api.ErrorCodeBadRequest, fmt.Errorf("failed to unmarshal request data: %w", err))))
 			continue
 		}
 
@@ -166,6 +228,8 @@ func (t *SocketTransport) handleConnection(ctx context.Context, conn net.Conn) {
 		case SocketTransportRequestMethodIssue:
 			issueReq := apiRequest.(*api.IssueRequest)
 			wrapper := &api.IssueRequestWrapper{
+				Caller:   caller,
+				Profile:  req.Profile,
 				Request:  issueReq,
 				Response: make(chan *api.IssueResponse, 1),
 			}
@@ -176,17 +240,19 @@ func (t *SocketTransport) handleConnection(ctx context.Context, conn net.Conn) {
 				case resp := <-wrapper.Response:
 					encoder.Encode(NewIssueResponseFromAPI(resp))
 				case <-ctx.Done():
-					encoder.Encode(NewIssueResponseFromError(fmt.Errorf("context cancelled")))
+					encoder.Encode(NewIssueResponseFromError(errShuttingDown))
 					return
 				}
 			case <-ctx.Done():
-				encoder.Encode(NewIssueResponseFromError(fmt.Errorf("context cancelled")))
+				encoder.Encode(NewIssueResponseFromError(errShuttingDown))
 				return
 			}
 
 		case SocketTransportRequestMethodMetadata:
 			metadataReq := apiRequest.(*api.MetadataRequest)
 			wrapper := &api.MetadataRequestWrapper{
+				Caller:   caller,
+				Profile:  req.Profile,
 				Request:  metadataReq,
 				Response: make(chan *api.MetadataResponse, 1),
 			}
@@ -197,17 +263,19 @@ func (t *SocketTransport) handleConnection(ctx context.Context, conn net.Conn) {
 				case resp := <-wrapper.Response:
 					encoder.Encode(NewMetadataResponseFromAPI(resp))
 				case <-ctx.Done():
-					encoder.Encode(NewMetadataResponseFromError(fmt.Errorf("context cancelled")))
+					encoder.Encode(NewMetadataResponseFromError(errShuttingDown))
 					return
 				}
 			case <-ctx.Done():
-				encoder.Encode(NewMetadataResponseFromError(fmt.Errorf("context cancelled")))
+				encoder.Encode(NewMetadataResponseFromError(errShuttingDown))
 				return
 			}
 
 		case SocketTransportRequestMethodValidate:
 			validateReq := apiRequest.(*api.ValidateRequest)
 			wrapper := &api.ValidateRequestWrapper{
+				Caller:   caller,
+				Profile:  req.Profile,
 				Request:  validateReq,
 				Response: make(chan *api.ValidateResponse, 1),
 			}
@@ -218,46 +286,113 @@ func (t *SocketTransport) handleConnection(ctx context.Context, conn net.Conn) {
 				case resp := <-wrapper.Response:
 					encoder.Encode(NewValidateResponseFromAPI(resp))
 				case <-ctx.Done():
-					encoder.Encode(NewValidateResponseFromError(fmt.Errorf("context cancelled")))
+					encoder.Encode(NewValidateResponseFromError(errShuttingDown))
+					return
+				}
+			case <-ctx.Done():
+				encoder.Encode(NewValidateResponseFromError(errShuttingDown))
+				return
+			}
+
+		case SocketTransportRequestMethodHealth:
+			healthReq := apiRequest.(*api.HealthRequest)
+			wrapper := &api.HealthRequestWrapper{
+				Caller:   caller,
+				Request:  healthReq,
+				Response: make(chan *api.HealthResponse, 1),
+			}
+
+			select {
+			case t.queues.HealthQueue <- wrapper:
+				select {
+				case resp := <-wrapper.Response:
+					encoder.Encode(NewHealthResponseFromAPI(resp))
+				case <-ctx.Done():
+					encoder.Encode(NewHealthResponseFromError(errShuttingDown))
 					return
 				}
 			case <-ctx.Done():
-				encoder.Encode(NewValidateResponseFromError(fmt.Errorf("context cancelled")))
+				encoder.Encode(NewHealthResponseFromError(errShuttingDown))
+				return
+			}
+
+		case SocketTransportRequestMethodReload:
+			reloadReq := apiRequest.(*api.ReloadRequest)
+			wrapper := &api.ReloadRequestWrapper{
+				Caller:   caller,
+				Request:  reloadReq,
+				Response: make(chan *api.ReloadResponse, 1),
+			}
+
+			select {
+			case t.queues.ReloadQueue <- wrapper:
+				select {
+				case resp := <-wrapper.Response:
+					encoder.Encode(NewReloadResponseFromAPI(resp))
+				case <-ctx.Done():
+					encoder.Encode(NewReloadResponseFromError(errShuttingDown))
+					return
+				}
+			case <-ctx.Done():
+				encoder.Encode(NewReloadResponseFromError(errShuttingDown))
+				return
+			}
+
+		case SocketTransportRequestMethodFaults:
+			faultsReq := apiRequest.(*api.FaultsRequest)
+			wrapper := &api.FaultsRequestWrapper{
+				Caller:   caller,
+				Profile:  req.Profile,
+				Request:  faultsReq,
+				Response: make(chan *api.FaultsResponse, 1),
+			}
+
+			select {
+			case t.queues.FaultsQueue <- wrapper:
+				select {
+				case resp := <-wrapper.Response:
+					encoder.Encode(NewFaultsResponseFromAPI(resp))
+				case <-ctx.Done():
+					encoder.Encode(NewFaultsResponseFromError(errShuttingDown))
+					return
+				}
+			case <-ctx.Done():
+				encoder.Encode(NewFaultsResponseFromError(errShuttingDown))
+				return
+			}
+
+		case SocketTransportRequestMethodExtend:
+			extendReq := apiRequest.(*api.ExtendRequest)
+			wrapper := &api.ExtendRequestWrapper{
+				Caller:   caller,
+				Request:  extendReq,
+				Response: make(chan *api.ExtendResponse, 1),
+			}
+
+			select {
+			case t.queues.ExtendQueue <- wrapper:
+				select {
+				case resp := <-wrapper.Response:
+					encoder.Encode(NewExtendResponseFromAPI(resp))
+				case <-ctx.Done():
+					encoder.Encode(NewExtendResponseFromError(errShuttingDown))
+					return
+				}
+			case <-ctx.Done():
+				encoder.Encode(NewExtendResponseFromError(errShuttingDown))
 				return
 			}
 
 		default:
-			encoder.Encode(NewIssueResponseFromError(fmt.Errorf("unknown method: %s", req.Method)))
+			encoder.Encode(NewIssueResponseFromError(api.Errorf(api.ErrorCodeBadRequest, "unknown method: %s", req.Method)))
 		}
 	}
 }
 
 func (t *SocketTransport) setOwnership() error {
-	uid := -1
-	gid := -1
-
-	// Resolve user ID
-	if t.cfg.Owner != "" {
-		u, err := user.Lookup(t.cfg.Owner)
-		if err != nil {
-			return fmt.Errorf("failed to lookup user %s: %w", t.cfg.Owner, err)
-		}
-		uid, err = strconv.Atoi(u.Uid)
-		if err != nil {
-			return fmt.Errorf("failed to parse UID: %w", err)
-		}
-	}
-
-	// Resolve group ID
-	if t.cfg.Group != "" {
-		g, err := user.LookupGroup(t.cfg.Group)
-		if err != nil {
-			return fmt.Errorf("failed to lookup group %s: %w", t.cfg.Group, err)
-		}
-		gid, err = strconv.Atoi(g.Gid)
-		if err != nil {
-			return fmt.Errorf("failed to parse GID: %w", err)
-		}
+	uid, gid, err := t.cfg.resolveOwnership()
+	if err != nil {
+		return err
 	}
 
 	// Apply ownership
@@ -4,12 +4,17 @@ import (
 	"context"
 
 	"github.com/Hyodar/tdxs/pkg/api"
+	"github.com/Hyodar/tdxs/pkg/registry"
 )
 
 type TransportQueues struct {
 	IssueQueue    chan *api.IssueRequestWrapper
 	MetadataQueue chan *api.MetadataRequestWrapper
 	ValidateQueue chan *api.ValidateRequestWrapper
+	HealthQueue   chan *api.HealthRequestWrapper
+	ReloadQueue   chan *api.ReloadRequestWrapper
+	FaultsQueue   chan *api.FaultsRequestWrapper
+	ExtendQueue   chan *api.ExtendRequestWrapper
 }
 
 type Transport interface {
@@ -21,3 +26,11 @@ type TransportType string
 const (
 	TransportTypeSocket TransportType = "socket"
 )
+
+// Registry holds the transport types available to the manager. Transport
+// packages register themselves from init.
+var Registry = registry.New[Transport]("transport")
+
+func Register(t TransportType, entry registry.Entry[Transport]) {
+	Registry.Register(string(t), entry)
+}
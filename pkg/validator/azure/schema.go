@@ -0,0 +1,45 @@
+package azure
+
+import (
+	"reflect"
+
+	"github.com/Hyodar/tdxs/internal/constellation/attestation/measurements"
+	"github.com/Hyodar/tdxs/internal/constellation/config"
+	"github.com/Hyodar/tdxs/internal/constellation/encoding"
+
+	"github.com/Hyodar/tdxs/pkg/schema"
+)
+
+// The Constellation config types decode from YAML in their own way, so their
+// schemas are spelled out here.
+func init() {
+	hexBytes := schema.Schema{"type": "string", "pattern": "^(0x)?([0-9a-fA-F]{2})*$"}
+	latestOr := func(value schema.Schema) schema.Schema {
+		return schema.Schema{"anyOf": []schema.Schema{{"const": "latest"}, value}}
+	}
+
+	measurement := schema.Schema{
+		"anyOf": []schema.Schema{
+			hexBytes,
+			{
+				"type": "object",
+				"properties": schema.Schema{
+					"expected": hexBytes,
+					"warnOnly": schema.Schema{"type": "boolean"},
+				},
+				"required":             []string{"expected"},
+				"additionalProperties": false,
+			},
+		},
+	}
+
+	schema.Override(reflect.TypeFor[encoding.HexBytes](), hexBytes)
+	schema.Override(reflect.TypeFor[measurements.M](), schema.Schema{
+		"type":                 "object",
+		"propertyNames":        schema.Schema{"pattern": "^[0-9]+$"},
+		"additionalProperties": measurement,
+	})
+	schema.Override(reflect.TypeFor[config.AttestationVersion[uint16]](), latestOr(schema.Schema{"type": "integer", "minimum": 0, "maximum": 65535}))
+	schema.Override(reflect.TypeFor[config.AttestationVersion[encoding.HexBytes]](), latestOr(hexBytes))
+	schema.Override(reflect.TypeFor[config.Certificate](), schema.Schema{"type": "string", "description": "PEM-encoded certificate"})
+}
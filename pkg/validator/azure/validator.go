@@ -1,32 +1,110 @@
 package azure
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"errors"
 	"fmt"
+	"maps"
+	"slices"
 
 	azure "github.com/Hyodar/tdxs/internal/constellation/attestation/azure/tdx"
+	"github.com/Hyodar/tdxs/internal/constellation/attestation/measurements"
 	"github.com/Hyodar/tdxs/internal/constellation/config"
 
 	"github.com/Hyodar/tdxs/pkg/api"
+	"github.com/Hyodar/tdxs/pkg/eventlog"
+	azureissuer "github.com/Hyodar/tdxs/pkg/issuer/azure"
 	"github.com/Hyodar/tdxs/pkg/logger"
+	"github.com/Hyodar/tdxs/pkg/registry"
+	"github.com/Hyodar/tdxs/pkg/tdx"
 	"github.com/Hyodar/tdxs/pkg/validator"
 )
 
 type AzureValidator struct {
 	validator.Validator
 
-	logger  logger.Logger
-	backend *azure.Validator
+	logger          logger.Logger
+	cfg             *config.AzureTDX
+	requireEventLog bool
+	bootPolicy      *eventlog.BootPolicy
+	backend         backend
+}
+
+// backend verifies a document and returns its user data; tests replace it.
+type backend interface {
+	Validate(ctx context.Context, attDoc []byte, nonce []byte) ([]byte, error)
 }
 
 type AzureValidatorConfig struct {
 	*config.AzureTDX `yaml:",inline"`
+
+	// RequireEventLog rejects documents without a vTPM event log.
+	RequireEventLog bool `yaml:"require_event_log"`
+	// BootPolicy checks the boot measured in the event log. It implies
+	// require_event_log and allows measurements to be left empty.
+	BootPolicy *eventlog.BootPolicy `yaml:"boot_policy"`
+}
+
+// Validate checks the shape of the reference values. The certificate and hex
+// fields are already decoded by the time it runs.
+func (c *AzureValidatorConfig) Validate() error {
+	if c.AzureTDX == nil {
+		return fmt.Errorf("config is required")
+	}
+
+	var errs []error
+	if len(c.Measurements) == 0 && c.BootPolicy == nil {
+		errs = append(errs, fmt.Errorf("measurements must not be empty without a boot_policy"))
+	}
+	if c.BootPolicy != nil {
+		if err := c.BootPolicy.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("boot_policy: %w", err))
+		}
+	}
+	for _, index := range slices.Sorted(maps.Keys(c.Measurements)) {
+		measurement := c.Measurements[index]
+		if index > 23 {
+			errs = append(errs, fmt.Errorf("measurements: PCR index %d out of range 0-23", index))
+		}
+		if len(measurement.Expected) != sha256.Size {
+			errs = append(errs, fmt.Errorf("measurements[%d]: expected %d bytes, got %d", index, sha256.Size, len(measurement.Expected)))
+		}
+	}
+
+	checkLength := func(name string, value []byte, want int, wantLatest bool) {
+		if !wantLatest && len(value) != want {
+			errs = append(errs, fmt.Errorf("%s: expected %d bytes, got %d", name, want, len(value)))
+		}
+	}
+	checkLength("teeTCBSVN", c.TEETCBSVN.Value, 16, c.TEETCBSVN.WantLatest)
+	checkLength("qeVendorID", c.QEVendorID.Value, 16, c.QEVendorID.WantLatest)
+	checkLength("xfam", c.XFAM.Value, 8, c.XFAM.WantLatest)
+	if len(c.MRSeam) > 0 {
+		checkLength("mrSeam", c.MRSeam, 48, false)
+	}
+
+	if len(c.IntelRootKey.Raw) == 0 {
+		errs = append(errs, fmt.Errorf("intelRootKey is required"))
+	}
+
+	return errors.Join(errs...)
+}
+
+func init() {
+	validator.Register(validator.ValidatorTypeAzure, registry.WithConfig(func(cfg *AzureValidatorConfig, logger logger.Logger) (validator.Validator, error) {
+		return NewAzureValidator(cfg, logger), nil
+	}))
 }
 
 func NewAzureValidator(cfg *AzureValidatorConfig, logger logger.Logger) *AzureValidator {
 	return &AzureValidator{
-		backend: azure.NewValidator(cfg.AzureTDX, logger),
-		logger:  logger,
+		cfg:             cfg.AzureTDX,
+		requireEventLog: cfg.RequireEventLog || cfg.BootPolicy != nil,
+		bootPolicy:      cfg.BootPolicy,
+		backend:         azure.NewValidator(cfg.AzureTDX, logger),
+		logger:          logger,
 	}
 }
 
@@ -36,12 +114,106 @@ func (i *AzureValidator) Start(_ context.Context) error {
 
 func (i *AzureValidator) Validate(ctx context.Context, req *api.ValidateRequest) *api.ValidateResponse {
 	if i.backend == nil {
-		return &api.ValidateResponse{Error: fmt.Errorf("backend not initialized")}
+		return api.NewValidateErrorResponse(api.Errorf(api.ErrorCodeBackendUnavailable, "backend not initialized"))
+	}
+	if len(req.Document) == 0 {
+		return api.NewValidateErrorResponse(api.Errorf(api.ErrorCodeBadRequest, "document is empty"))
+	}
+
+	parsed, err := azureissuer.ParseDocument(req.Document)
+	if err != nil {
+		return api.NewInvalidResponse(api.NewFailedCheck(api.CheckFormat, err.Error()))
 	}
 
 	userData, err := i.backend.Validate(ctx, req.Document, req.Nonce)
 	if err != nil {
-		return &api.ValidateResponse{Error: err}
+		if ctx.Err() != nil {
+			return api.NewValidateErrorResponse(api.NewError(api.ErrorCodeTimeout, err))
+		}
+		if tdx.CollateralUnavailable(err) {
+			return api.NewValidateErrorResponse(api.NewError(api.ErrorCodeBackendUnavailable, err))
+		}
+
+		// The backend reports a single error. Re-check what can be checked
+		// on the parsed document to say which part failed; if nothing does,
+		// the failure cannot be told apart from a backend problem.
+		if checks := i.explain(parsed, req.Nonce); len(checks) > 0 {
+			return api.NewInvalidResponse(checks...)
+		}
+		return api.NewValidateErrorResponse(api.NewError(api.ErrorCodeAttestationInvalid, fmt.Errorf("document did not verify: %w", err)))
+	}
+
+	claims, checks := i.checkEventLog(parsed)
+	if len(checks) > 0 {
+		return api.NewInvalidResponse(checks...)
+	}
+	resp := api.NewValidResponse(userData)
+	if claims != nil {
+		resp.Claims = claims
+	}
+	return resp
+}
+
+// checkEventLog replays the vTPM event log of a verified document against its
+// quoted PCRs and applies the boot policy to what it measured.
+func (i *AzureValidator) checkEventLog(parsed *azureissuer.ParsedDocument) (*eventlog.BootClaims, []api.FailedCheck) {
+	raw := parsed.Document.Attestation.GetEventLog()
+	if len(raw) == 0 {
+		if i.requireEventLog {
+			return nil, []api.FailedCheck{api.NewFailedCheck(api.CheckEventLog, "document has no event log")}
+		}
+		return nil, nil
+	}
+
+	var checks []api.FailedCheck
+	claims, errs := eventlog.VerifyTPMLog(raw, parsed.PCRs.GetPcrs())
+	for _, err := range errs {
+		checks = append(checks, api.NewFailedCheck(api.CheckEventLog, err.Error()))
+	}
+	if len(checks) > 0 {
+		return nil, checks
 	}
-	return &api.ValidateResponse{UserData: userData, Valid: true}
+	if i.bootPolicy != nil {
+		for _, err := range i.bootPolicy.Check(claims) {
+			checks = append(checks, api.NewFailedCheck(api.CheckMeasurements, err.Error()))
+		}
+	}
+	return claims, checks
+}
+
+// explain compares the unverified contents of a document that failed
+// validation with the nonce and reference values.
+func (i *AzureValidator) explain(parsed *azureissuer.ParsedDocument, nonce []byte) []api.FailedCheck {
+	var checks []api.FailedCheck
+
+	// The issuer binds user data and nonce into the vTPM quote as
+	// sha256(userData || nonce).
+	binding := sha256.Sum256(append(append([]byte{}, parsed.Document.UserData...), nonce...))
+	if !bytes.Equal(parsed.ExtraData, binding[:]) {
+		checks = append(checks, api.NewFailedCheck(api.CheckNonce, "vTPM quote is not bound to the given user data and nonce"))
+	}
+
+	for _, index := range slices.Sorted(maps.Keys(i.cfg.Measurements)) {
+		measurement := i.cfg.Measurements[index]
+		if measurement.ValidationOpt == measurements.WarnOnly {
+			continue
+		}
+		actual, ok := parsed.PCRs.GetPcrs()[index]
+		if !ok {
+			checks = append(checks, api.NewFailedCheck(api.CheckMeasurements, fmt.Sprintf("PCR %d is missing", index)))
+		} else if !bytes.Equal(actual, measurement.Expected) {
+			checks = append(checks, api.NewFailedCheck(api.CheckMeasurements, fmt.Sprintf("PCR %d is %x, expected %x", index, actual, measurement.Expected)))
+		}
+	}
+
+	if body := parsed.Quote.GetTdQuoteBody(); body != nil {
+		if len(i.cfg.MRSeam) > 0 && !bytes.Equal(body.MrSeam, i.cfg.MRSeam) {
+			checks = append(checks, api.NewFailedCheck(api.CheckMeasurements, fmt.Sprintf("MRSEAM is %x, expected %x", body.MrSeam, []byte(i.cfg.MRSeam))))
+		}
+		if !i.cfg.XFAM.WantLatest && !bytes.Equal(body.Xfam, i.cfg.XFAM.Value) {
+			checks = append(checks, api.NewFailedCheck(api.CheckAttributes, fmt.Sprintf("XFAM is %x, expected %x", body.Xfam, []byte(i.cfg.XFAM.Value))))
+		}
+	}
+
+	return checks
 }
@@ -0,0 +1,188 @@
+package azure
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"testing"
+
+	"github.com/google/go-tdx-guest/verify/trust"
+	"github.com/google/go-tpm-tools/proto/attest"
+	tpmproto "github.com/google/go-tpm-tools/proto/tpm"
+
+	"github.com/Hyodar/tdxs/internal/constellation/attestation/measurements"
+	"github.com/Hyodar/tdxs/internal/constellation/config"
+	"github.com/Hyodar/tdxs/internal/constellation/encoding"
+
+	"github.com/Hyodar/tdxs/pkg/api"
+	azureissuer "github.com/Hyodar/tdxs/pkg/issuer/azure"
+	"github.com/Hyodar/tdxs/pkg/simulator"
+	"github.com/Hyodar/tdxs/pkg/validator/validatortest"
+)
+
+// Azure documents cannot be produced outside an Azure TDX VM, so only the
+// cases that need no valid document run here.
+func TestConformance(t *testing.T) {
+	v := NewAzureValidator(&AzureValidatorConfig{AzureTDX: &config.AzureTDX{}}, slog.New(slog.DiscardHandler))
+	validatortest.Run(t, v, validatortest.Fixtures{})
+}
+
+// fakeBackend stands in for Constellation's validator, which needs a
+// document from an Azure TDX VM.
+type fakeBackend struct {
+	err error
+}
+
+func (b *fakeBackend) Validate(ctx context.Context, attDoc []byte, _ []byte) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if b.err != nil {
+		return nil, b.err
+	}
+	parsed, err := azureissuer.ParseDocument(attDoc)
+	if err != nil {
+		return nil, err
+	}
+	return parsed.Document.UserData, nil
+}
+
+var (
+	testUserData = []byte("user data")
+	testNonce    = []byte("nonce")
+	testPCR      = bytes.Repeat([]byte{0x04}, sha256.Size)
+)
+
+// testDocument builds an unsigned Azure document quoting pcr4 as PCR 4,
+// with a TDX quote from the simulator authority.
+func testDocument(t *testing.T, pcr4 []byte) []byte {
+	t.Helper()
+
+	authority, err := simulator.NewAuthority()
+	if err != nil {
+		t.Fatal(err)
+	}
+	td := simulator.DefaultTD()
+	quote, err := authority.Quote(&td, make([]byte, 64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	instanceInfo, err := json.Marshal(&azureissuer.InstanceInfo{AttestationReport: quote})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// TPMS_ATTEST up to its extraData: magic, type, qualifiedSigner and
+	// extraData, the latter two as TPM2B.
+	binding := sha256.Sum256(append(append([]byte{}, testUserData...), testNonce...))
+	var attested bytes.Buffer
+	binary.Write(&attested, binary.BigEndian, uint32(0xff544347))
+	binary.Write(&attested, binary.BigEndian, uint16(0x8018))
+	binary.Write(&attested, binary.BigEndian, uint16(0))
+	binary.Write(&attested, binary.BigEndian, uint16(len(binding)))
+	attested.Write(binding[:])
+
+	doc, err := json.Marshal(&azureissuer.Document{
+		Attestation: &attest.Attestation{Quotes: []*tpmproto.Quote{{
+			Quote: attested.Bytes(),
+			Pcrs:  &tpmproto.PCRs{Hash: tpmproto.HashAlgo_SHA256, Pcrs: map[uint32][]byte{4: pcr4}},
+		}}},
+		InstanceInfo: instanceInfo,
+		UserData:     testUserData,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestVerdicts(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for _, tt := range []struct {
+		name    string
+		ctx     context.Context
+		pcr4    []byte
+		nonce   []byte
+		err     error // returned by the backend
+		verdict api.Verdict
+		code    api.ErrorCode // of the error verdict
+		check   string        // failed check of the invalid verdict
+	}{
+		{
+			name:    "Valid",
+			verdict: api.VerdictValid,
+		},
+		{
+			name: "CollateralUnavailable",
+			// As verify.TdxQuote reports it, without the error type.
+			err:     fmt.Errorf("verifying TDX quote: unable to receive tcbInfo: %v", &trust.AttestationRecreationErr{Msg: "could not receive tcbInfo response"}),
+			verdict: api.VerdictError,
+			code:    api.ErrorCodeBackendUnavailable,
+		},
+		{
+			name:    "Timeout",
+			ctx:     cancelled,
+			verdict: api.VerdictError,
+			code:    api.ErrorCodeTimeout,
+		},
+		{
+			name:    "WrongPCR",
+			pcr4:    bytes.Repeat([]byte{0x05}, sha256.Size),
+			err:     errors.New("PCR 4 mismatch"),
+			verdict: api.VerdictInvalid,
+			check:   api.CheckMeasurements,
+		},
+		{
+			name:    "WrongNonce",
+			nonce:   []byte("other nonce"),
+			err:     errors.New("nonce mismatch"),
+			verdict: api.VerdictInvalid,
+			check:   api.CheckNonce,
+		},
+		{
+			// Nothing the validator can re-check differs, so the failure is
+			// not attributed to a check.
+			name:    "Unexplained",
+			err:     errors.New("verifying quote signature"),
+			verdict: api.VerdictError,
+			code:    api.ErrorCodeAttestationInvalid,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			v := NewAzureValidator(&AzureValidatorConfig{AzureTDX: &config.AzureTDX{
+				Measurements: measurements.M{4: {Expected: testPCR, ValidationOpt: measurements.Enforce}},
+				XFAM:         config.AttestationVersion[encoding.HexBytes]{WantLatest: true},
+			}}, slog.New(slog.DiscardHandler))
+			v.backend = &fakeBackend{err: tt.err}
+
+			ctx, pcr4, nonce := tt.ctx, tt.pcr4, tt.nonce
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			if pcr4 == nil {
+				pcr4 = testPCR
+			}
+			if nonce == nil {
+				nonce = testNonce
+			}
+			resp := v.Validate(ctx, &api.ValidateRequest{Document: testDocument(t, pcr4), Nonce: nonce})
+
+			if verdict := resp.Verdict(); verdict != tt.verdict {
+				t.Fatalf("verdict = %s, want %s (%+v)", verdict, tt.verdict, resp)
+			}
+			if code := api.CodeOf(resp.Error); tt.code != "" && code != tt.code {
+				t.Errorf("error code = %s, want %s", code, tt.code)
+			}
+			if tt.check != "" && (len(resp.FailedChecks) != 1 || resp.FailedChecks[0].Check != tt.check) {
+				t.Errorf("failed checks = %+v, want %s", resp.FailedChecks, tt.check)
+			}
+		})
+	}
+}
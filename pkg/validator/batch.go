@@ -0,0 +1,51 @@
+package validator
+
+import (
+	"context"
+
+	"github.com/Hyodar/tdxs/pkg/api"
+	"github.com/Hyodar/tdxs/pkg/batch"
+)
+
+// Batch is a Validator that accepts batched documents (see batch.Document)
+// in addition to the documents of the wrapped validator. The inner document
+// is validated as issued over the Merkle root with no nonce, then the
+// inclusion proof must bind the returned user data and the request nonce to
+// that root. Other documents are passed through.
+type Batch struct {
+	validator Validator
+}
+
+func NewBatch(validator Validator) *Batch {
+	return &Batch{validator: validator}
+}
+
+// Unwrap returns the wrapped validator.
+func (b *Batch) Unwrap() Validator {
+	return b.validator
+}
+
+func (b *Batch) Start(ctx context.Context) error {
+	return b.validator.Start(ctx)
+}
+
+func (b *Batch) Validate(ctx context.Context, req *api.ValidateRequest) *api.ValidateResponse {
+	if !batch.IsDocument(req.Document) {
+		return b.validator.Validate(ctx, req)
+	}
+	doc, err := batch.ParseDocument(req.Document)
+	if err != nil {
+		return api.NewInvalidResponse(api.NewFailedCheck(api.CheckFormat, err.Error()))
+	}
+
+	resp := b.validator.Validate(ctx, &api.ValidateRequest{Document: doc.Document, Options: req.Options})
+	if resp.Verdict() != api.VerdictValid {
+		return resp
+	}
+	userData, err := doc.Batch.Verify(req.Nonce, resp.UserData)
+	if err != nil {
+		return api.NewInvalidResponse(api.NewFailedCheck(api.CheckNonce, err.Error()))
+	}
+	resp.UserData = userData
+	return resp
+}
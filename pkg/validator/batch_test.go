@@ -0,0 +1,91 @@
+package validator_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Hyodar/tdxs/pkg/api"
+	"github.com/Hyodar/tdxs/pkg/batch"
+	"github.com/Hyodar/tdxs/pkg/issuer"
+	simulatorissuer "github.com/Hyodar/tdxs/pkg/issuer/simulator"
+	"github.com/Hyodar/tdxs/pkg/validator"
+	simulatorvalidator "github.com/Hyodar/tdxs/pkg/validator/simulator"
+	"github.com/Hyodar/tdxs/pkg/validator/validatortest"
+)
+
+func TestBatch(t *testing.T) {
+	logger := slog.New(slog.DiscardHandler)
+	caFile := t.TempDir() + "/ca.pem"
+
+	iss, err := simulatorissuer.NewSimulatorIssuer(&simulatorissuer.SimulatorIssuerConfig{Signed: true, CAFile: caFile}, logger)
+	if err != nil {
+		t.Fatalf("failed to create issuer: %v", err)
+	}
+	batcher := issuer.NewBatcher(&issuer.BatchConfig{Window: time.Minute, MaxSize: 5}, iss, logger)
+
+	// The batch is issued once MaxSize requests are pending.
+	documents := make([][]byte, 5)
+	var wg sync.WaitGroup
+	for i := range documents {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp := batcher.Issue(context.Background(), &api.IssueRequest{
+				UserData: fmt.Appendf(nil, "user data %d", i),
+				Nonce:    fmt.Appendf(nil, "nonce %d", i),
+			})
+			if resp.Error != nil {
+				t.Errorf("request %d: failed to issue document: %v", i, resp.Error)
+				return
+			}
+			documents[i] = resp.Document
+		}()
+	}
+	wg.Wait()
+	if t.Failed() {
+		return
+	}
+
+	shared, err := batch.ParseDocument(documents[0])
+	if err != nil {
+		t.Fatalf("failed to parse batched document: %v", err)
+	}
+	for i, document := range documents {
+		doc, err := batch.ParseDocument(document)
+		if err != nil {
+			t.Fatalf("request %d: failed to parse batched document: %v", i, err)
+		}
+		if string(doc.Document) != string(shared.Document) || doc.Batch.Size != 5 {
+			t.Errorf("request %d: not issued in the same batch of 5", i)
+		}
+	}
+
+	v, err := simulatorvalidator.NewSimulatorValidator(&simulatorvalidator.SimulatorValidatorConfig{Signed: true, CAFile: caFile}, logger)
+	if err != nil {
+		t.Fatalf("failed to create validator: %v", err)
+	}
+
+	// Swapping in another caller's proof must not verify.
+	tampered, _ := batch.ParseDocument(documents[3])
+	tampered.Batch.Index = 2
+	validatortest.Run(t, validator.NewBatch(v), validatortest.Fixtures{
+		Document: documents[3],
+		Nonce:    []byte("nonce 3"),
+		UserData: []byte("user data 3"),
+		Tampered: marshal(t, tampered),
+	})
+}
+
+func marshal(t *testing.T, doc *batch.Document) []byte {
+	t.Helper()
+	data, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatalf("failed to marshal document: %v", err)
+	}
+	return data
+}
@@ -0,0 +1,206 @@
+//go:build fixtures
+
+package gcp
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"log/slog"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-tdx-guest/abi"
+	"github.com/google/go-tdx-guest/verify/trust"
+	"github.com/google/go-tpm-tools/client"
+	tpmsimulator "github.com/google/go-tpm-tools/simulator"
+	"github.com/google/go-tpm/legacy/tpm2"
+	"github.com/google/go-tpm/tpmutil"
+
+	"github.com/Hyodar/tdxs/pkg/api"
+	"github.com/Hyodar/tdxs/pkg/eventlog"
+	gcpissuer "github.com/Hyodar/tdxs/pkg/issuer/gcp"
+	"github.com/Hyodar/tdxs/pkg/simulator"
+	"github.com/Hyodar/tdxs/pkg/validator/validatortest"
+)
+
+// gceInstanceInfo is the GCE instance identity extension of AK certificates,
+// as go-tpm-tools' server package parses it.
+type gceInstanceInfo struct {
+	Zone               string `asn1:"utf8"`
+	ProjectNumber      int64
+	ProjectID          string `asn1:"utf8"`
+	InstanceID         int64
+	InstanceName       string                `asn1:"utf8"`
+	SecurityProperties gceSecurityProperties `asn1:"explicit,optional"`
+}
+
+type gceSecurityProperties struct {
+	SecurityVersion int64 `asn1:"explicit,tag:0,optional"`
+	IsProduction    bool  `asn1:"explicit,tag:1,optional"`
+}
+
+var oidGCEInstanceInfo = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 1, 21}
+
+// recordingGetter records the collateral served by the simulator authority.
+type recordingGetter struct {
+	getter     trust.HTTPSGetter
+	collateral *validatortest.Collateral
+}
+
+func (g *recordingGetter) Get(url string) (map[string][]string, []byte, error) {
+	header, body, err := g.getter.Get(url)
+	if err == nil {
+		g.collateral.Record(url, header, body)
+	}
+	return header, body, err
+}
+
+func TestGenerateFixtures(t *testing.T) {
+	tpm, err := tpmsimulator.Get()
+	if err != nil {
+		t.Fatalf("failed to start TPM simulator: %v", err)
+	}
+	defer tpm.Close()
+
+	events := fixtureEvents()
+	for _, event := range events {
+		if err := tpm2.PCRExtend(tpm, tpmutil.Handle(event.MRIndex), tpm2.AlgSHA256, event.Digest, ""); err != nil {
+			t.Fatalf("failed to extend PCR %d: %v", event.MRIndex, err)
+		}
+	}
+
+	ak, err := client.AttestationKeyRSA(tpm)
+	if err != nil {
+		t.Fatalf("failed to create AK: %v", err)
+	}
+	defer ak.Close()
+	akRoot := certifyAK(t, ak)
+
+	authority, err := simulator.NewAuthority()
+	if err != nil {
+		t.Fatalf("failed to create authority: %v", err)
+	}
+	td := simulator.DefaultTD()
+	quote := func(reportData [abi.ReportDataSize]byte) ([]byte, error) {
+		return authority.Quote(&td, reportData[:])
+	}
+
+	opts := client.AttestOpts{TCGEventLog: eventlog.MarshalTPMLog(events)}
+	doc, err := gcpissuer.NewDocument(ak, opts, quote, fixtureUserData, fixtureNonce)
+	if err != nil {
+		t.Fatalf("failed to create document: %v", err)
+	}
+	docJSON, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Validating once records the collateral the validator asks for.
+	validatortest.WriteFixture(t, "document.json", docJSON)
+	validatortest.WriteFixture(t, "ak_root.pem", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: akRoot.Raw}))
+	validatortest.WriteFixture(t, "intel_root.pem", intelRoot(t, authority))
+
+	v, err := NewGCPValidator(&GCPValidatorConfig{
+		AKRootsFile:   filepath.Join("testdata", "ak_root.pem"),
+		IntelRootFile: filepath.Join("testdata", "intel_root.pem"),
+	}, slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatal(err)
+	}
+	collateral := &validatortest.Collateral{Time: time.Now().UTC().Truncate(time.Second)}
+	v.getter = &recordingGetter{getter: authority.Collateral(), collateral: collateral}
+	v.now = collateral.Now
+	if resp := v.Validate(t.Context(), &api.ValidateRequest{Document: docJSON, Nonce: fixtureNonce}); !resp.Valid {
+		t.Fatalf("generated document does not validate: %+v", resp)
+	}
+
+	collateralJSON, err := json.MarshalIndent(collateral, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	validatortest.WriteFixture(t, "collateral.json", collateralJSON)
+}
+
+// certifyAK issues the AK a certificate with the fixture instance identity
+// from a new root, and returns the root.
+func certifyAK(t *testing.T, ak *client.Key) *x509.Certificate {
+	t.Helper()
+
+	rootKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notBefore := time.Now().Add(-time.Hour)
+	notAfter := notBefore.AddDate(100, 0, 0)
+	rootTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "tdxs fixture EK/AK CA Root"},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	rootDER, err := x509.CreateCertificate(rand.Reader, rootTemplate, rootTemplate, &rootKey.PublicKey, rootKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := x509.ParseCertificate(rootDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	instance, err := asn1.Marshal(gceInstanceInfo{
+		Zone:               fixtureZone,
+		ProjectNumber:      123456789012,
+		ProjectID:          fixtureProject,
+		InstanceID:         1234567890123456789,
+		InstanceName:       "tdxs-fixture",
+		SecurityProperties: gceSecurityProperties{IsProduction: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	akDER, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber:    big.NewInt(2),
+		Subject:         pkix.Name{CommonName: "tdxs fixture AK"},
+		NotBefore:       notBefore,
+		NotAfter:        notAfter,
+		KeyUsage:        x509.KeyUsageDigitalSignature,
+		ExtraExtensions: []pkix.Extension{{Id: oidGCEInstanceInfo, Value: instance}},
+	}, root, ak.PublicKey(), rootKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	akCert, err := x509.ParseCertificate(akDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ak.SetCert(akCert); err != nil {
+		t.Fatalf("failed to set AK certificate: %v", err)
+	}
+	return root
+}
+
+// intelRoot returns the root CA of the authority's PCK chain.
+func intelRoot(t *testing.T, authority *simulator.Authority) []byte {
+	t.Helper()
+	var last *pem.Block
+	for rest := authority.PCKChain(); ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		last = block
+	}
+	return pem.EncodeToMemory(last)
+}
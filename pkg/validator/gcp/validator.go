@@ -0,0 +1,386 @@
+package gcp
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/google/go-tdx-guest/verify"
+	"github.com/google/go-tdx-guest/verify/trust"
+	"github.com/google/go-tpm-tools/server"
+
+	"github.com/Hyodar/tdxs/internal/constellation/attestation/measurements"
+
+	"github.com/Hyodar/tdxs/pkg/api"
+	"github.com/Hyodar/tdxs/pkg/eventlog"
+	gcpissuer "github.com/Hyodar/tdxs/pkg/issuer/gcp"
+	"github.com/Hyodar/tdxs/pkg/logger"
+	"github.com/Hyodar/tdxs/pkg/registry"
+	"github.com/Hyodar/tdxs/pkg/tdx"
+	"github.com/Hyodar/tdxs/pkg/validator"
+)
+
+type GCPValidator struct {
+	validator.Validator
+
+	logger          logger.Logger
+	cfg             *GCPValidatorConfig
+	requireEventLog bool
+
+	akRoots         *x509.CertPool
+	akIntermediates []*x509.Certificate
+	intelRoots      *x509.CertPool // nil for the root embedded in go-tdx-guest
+
+	// getter fetches TCB collateral and now is the time certificates and
+	// collateral are checked at; tests replace both to replay recorded
+	// collateral.
+	getter trust.HTTPSGetter
+	now    func() time.Time
+}
+
+type GCPValidatorConfig struct {
+	// Measurements are the expected values of the vTPM's SHA-256 PCRs.
+	Measurements measurements.M `yaml:"measurements"`
+	// TD holds reference values for the TDX quote. Unset fields are not
+	// checked.
+	TD tdx.TD `yaml:"td"`
+
+	// AKRootsFile holds the PEM certificates the AK certificate must chain
+	// to; self-signed ones are roots, others intermediates. Defaults to
+	// Google's EK/AK CA.
+	AKRootsFile string `yaml:"ak_roots_file"`
+	// IntelRootFile holds the PEM Intel SGX root CA. Defaults to the root
+	// embedded in go-tdx-guest.
+	IntelRootFile string `yaml:"intel_root_file"`
+	// Offline skips fetching TCB info and QE identity from Intel PCS: the
+	// quote signature and PCK chain are checked, the TCB status is not.
+	Offline bool `yaml:"offline"`
+
+	// Instance restricts the instances accepted by the identity in their AK
+	// certificate.
+	Instance InstancePolicy `yaml:"instance"`
+
+	// RequireEventLog rejects documents without a vTPM event log.
+	RequireEventLog bool `yaml:"require_event_log"`
+	// BootPolicy checks the boot measured in the vTPM event log. It implies
+	// require_event_log.
+	BootPolicy *eventlog.BootPolicy `yaml:"boot_policy"`
+}
+
+// InstancePolicy lists the accepted GCE projects and zones. Empty lists
+// accept any.
+type InstancePolicy struct {
+	ProjectIDs []string `yaml:"project_ids"`
+	Zones      []string `yaml:"zones"`
+}
+
+func (p *InstancePolicy) isZero() bool {
+	return len(p.ProjectIDs) == 0 && len(p.Zones) == 0
+}
+
+func (c *GCPValidatorConfig) Validate() error {
+	var errs []error
+	if len(c.Measurements) == 0 && c.TD.IsZero() && c.BootPolicy == nil {
+		errs = append(errs, fmt.Errorf("at least one of measurements, td and boot_policy is required"))
+	}
+	for _, index := range slices.Sorted(maps.Keys(c.Measurements)) {
+		measurement := c.Measurements[index]
+		if index > 23 {
+			errs = append(errs, fmt.Errorf("measurements: PCR index %d out of range 0-23", index))
+		}
+		if len(measurement.Expected) != sha256.Size {
+			errs = append(errs, fmt.Errorf("measurements[%d]: expected %d bytes, got %d", index, sha256.Size, len(measurement.Expected)))
+		}
+	}
+	if err := c.TD.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("td: %w", err))
+	}
+	if c.BootPolicy != nil {
+		if err := c.BootPolicy.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("boot_policy: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Claims are returned for valid documents.
+type Claims struct {
+	Instance *gcpissuer.Instance  `json:"instance,omitempty"`
+	Boot     *eventlog.BootClaims `json:"boot,omitempty"` // from the vTPM event log
+	TD       *eventlog.Claims     `json:"td,omitempty"`   // from the attached TD event logs
+}
+
+func init() {
+	validator.Register(validator.ValidatorTypeGCP, registry.WithConfig(func(cfg *GCPValidatorConfig, logger logger.Logger) (validator.Validator, error) {
+		return NewGCPValidator(cfg, logger)
+	}))
+}
+
+func NewGCPValidator(cfg *GCPValidatorConfig, logger logger.Logger) (*GCPValidator, error) {
+	v := &GCPValidator{
+		logger:          logger,
+		cfg:             cfg,
+		requireEventLog: cfg.RequireEventLog || cfg.BootPolicy != nil,
+		akRoots:         x509.NewCertPool(),
+		getter:          trust.DefaultHTTPSGetter(),
+		now:             time.Now,
+	}
+
+	if cfg.AKRootsFile == "" {
+		for _, root := range server.GceEKRoots {
+			v.akRoots.AddCert(root)
+		}
+		v.akIntermediates = server.GceEKIntermediates
+	} else {
+		certs, err := readCertificates(cfg.AKRootsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read AK roots: %w", err)
+		}
+		for _, cert := range certs {
+			if bytes.Equal(cert.RawIssuer, cert.RawSubject) {
+				v.akRoots.AddCert(cert)
+			} else {
+				v.akIntermediates = append(v.akIntermediates, cert)
+			}
+		}
+	}
+
+	if cfg.IntelRootFile != "" {
+		certs, err := readCertificates(cfg.IntelRootFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read Intel root: %w", err)
+		}
+		v.intelRoots = x509.NewCertPool()
+		for _, cert := range certs {
+			v.intelRoots.AddCert(cert)
+		}
+	}
+	return v, nil
+}
+
+func readCertificates(path string) ([]*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificates in %s", path)
+	}
+	return certs, nil
+}
+
+func (i *GCPValidator) Start(_ context.Context) error {
+	return nil
+}
+
+// Validate checks the AK certificate and vTPM quote, then the TDX quote and
+// its TCB, then that both quotes are bound to the request and to each other,
+// then the instance identity, reference values and event logs.
+func (i *GCPValidator) Validate(ctx context.Context, req *api.ValidateRequest) *api.ValidateResponse {
+	if len(req.Document) == 0 {
+		return api.NewValidateErrorResponse(api.Errorf(api.ErrorCodeBadRequest, "document is empty"))
+	}
+
+	parsed, err := gcpissuer.ParseDocument(req.Document)
+	if err != nil {
+		return api.NewInvalidResponse(api.NewFailedCheck(api.CheckFormat, err.Error()))
+	}
+	now := i.now()
+
+	if err := i.checkAKCert(parsed, now); err != nil {
+		return api.NewInvalidResponse(api.NewFailedCheck(api.CheckSignature, err.Error()))
+	}
+
+	var checks []api.FailedCheck
+	extraData := gcpissuer.ExtraData(parsed.Document.UserData, req.Nonce)
+	if err := verifyTPMQuote(parsed.TPMQuote, parsed.AKCert.PublicKey, extraData); errors.Is(err, errExtraData) {
+		checks = append(checks, api.NewFailedCheck(api.CheckNonce, err.Error()))
+	} else if err != nil {
+		return api.NewInvalidResponse(api.NewFailedCheck(api.CheckSignature, err.Error()))
+	}
+
+	// Verifying without collateral first tells signature failures apart
+	// from TCB failures.
+	timeSet := &verify.TimeSet{PckCertChain: now, TcbInfo: now, QeIdentity: now, PckCrl: now, RootCaCrl: now}
+	if err := verify.TdxQuote(parsed.Quote, &verify.Options{TrustedRoots: i.intelRoots, Now: timeSet}); err != nil {
+		return api.NewInvalidResponse(api.NewFailedCheck(api.CheckSignature, err.Error()))
+	}
+	if !i.cfg.Offline {
+		err := verify.TdxQuote(parsed.Quote, &verify.Options{
+			TrustedRoots:  i.intelRoots,
+			GetCollateral: true,
+			Getter:        i.getter,
+			Now:           timeSet,
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return api.NewValidateErrorResponse(api.NewError(api.ErrorCodeTimeout, err))
+			}
+			if tdx.CollateralUnavailable(err) {
+				return api.NewValidateErrorResponse(api.NewError(api.ErrorCodeBackendUnavailable, err))
+			}
+			return api.NewInvalidResponse(api.NewFailedCheck(api.CheckTCB, err.Error()))
+		}
+	}
+
+	body := parsed.Quote.TdQuoteBody
+	reportData, err := gcpissuer.ReportData(extraData, parsed.AKCert.PublicKey)
+	if err != nil {
+		return api.NewInvalidResponse(api.NewFailedCheck(api.CheckSignature, err.Error()))
+	}
+	if !bytes.Equal(body.ReportData[:sha256.Size], reportData[:sha256.Size]) {
+		checks = append(checks, api.NewFailedCheck(api.CheckNonce, "REPORTDATA does not match the user data and nonce"))
+	}
+	if !bytes.Equal(body.ReportData[sha256.Size:], reportData[sha256.Size:]) {
+		checks = append(checks, api.NewFailedCheck(api.CheckSignature, "REPORTDATA is not bound to the vTPM attestation key"))
+	}
+
+	checks = append(checks, i.checkInstance(parsed.Instance)...)
+	checks = append(checks, i.checkPCRs(parsed.PCRs.GetPcrs())...)
+	checks = append(checks, validator.CompareTD(&i.cfg.TD, body)...)
+
+	claims := &Claims{Instance: parsed.Instance}
+	var logChecks []api.FailedCheck
+	claims.Boot, logChecks = i.checkTPMLog(parsed)
+	checks = append(checks, logChecks...)
+	if attachment := parsed.Document.EventLog; attachment != nil {
+		var errs []error
+		claims.TD, errs = attachment.Verify(body.Rtmrs)
+		for _, err := range errs {
+			checks = append(checks, api.NewFailedCheck(api.CheckEventLog, err.Error()))
+		}
+	}
+
+	if len(checks) > 0 {
+		return api.NewInvalidResponse(checks...)
+	}
+	resp := api.NewValidResponse(parsed.Document.UserData)
+	resp.Claims = claims
+	return resp
+}
+
+// oidSubjectAltName is handled here because x509 marks SAN extensions
+// unhandled when they hold only TPM manufacturer names, as GCE AK
+// certificates do.
+var oidSubjectAltName = asn1.ObjectIdentifier{2, 5, 29, 17}
+
+// checkAKCert verifies the AK certificate against the AK roots, with the
+// intermediates from the configuration and the attestation.
+func (i *GCPValidator) checkAKCert(parsed *gcpissuer.ParsedDocument, now time.Time) error {
+	intermediates := x509.NewCertPool()
+	for _, cert := range i.akIntermediates {
+		intermediates.AddCert(cert)
+	}
+	for _, der := range parsed.Document.Attestation.GetIntermediateCerts() {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return fmt.Errorf("failed to parse AK intermediate certificate: %w", err)
+		}
+		intermediates.AddCert(cert)
+	}
+
+	cert := parsed.AKCert
+	cert.UnhandledCriticalExtensions = slices.DeleteFunc(cert.UnhandledCriticalExtensions, func(oid asn1.ObjectIdentifier) bool {
+		return oid.Equal(oidSubjectAltName)
+	})
+	_, err := cert.Verify(x509.VerifyOptions{
+		Roots:         i.akRoots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		// AK certificates carry TCG key usages, not server authentication.
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return fmt.Errorf("AK certificate does not chain to a trusted root: %w", err)
+	}
+	return nil
+}
+
+func (i *GCPValidator) checkInstance(instance *gcpissuer.Instance) []api.FailedCheck {
+	policy := &i.cfg.Instance
+	if policy.isZero() {
+		return nil
+	}
+	if instance == nil {
+		return []api.FailedCheck{api.NewFailedCheck(api.CheckIdentity, "AK certificate has no production instance identity")}
+	}
+
+	var checks []api.FailedCheck
+	if len(policy.ProjectIDs) > 0 && !slices.Contains(policy.ProjectIDs, instance.ProjectID) {
+		checks = append(checks, api.NewFailedCheck(api.CheckIdentity, fmt.Sprintf("project %q is not allowed", instance.ProjectID)))
+	}
+	if len(policy.Zones) > 0 && !slices.Contains(policy.Zones, instance.Zone) {
+		checks = append(checks, api.NewFailedCheck(api.CheckIdentity, fmt.Sprintf("zone %q is not allowed", instance.Zone)))
+	}
+	return checks
+}
+
+func (i *GCPValidator) checkPCRs(pcrs map[uint32][]byte) []api.FailedCheck {
+	var checks []api.FailedCheck
+	for _, index := range slices.Sorted(maps.Keys(i.cfg.Measurements)) {
+		measurement := i.cfg.Measurements[index]
+		actual, ok := pcrs[index]
+		switch {
+		case ok && bytes.Equal(actual, measurement.Expected):
+		case measurement.ValidationOpt == measurements.WarnOnly:
+			i.logger.Warn("PCR does not match its warn-only reference value", "pcr", index, "actual", fmt.Sprintf("%x", actual), "expected", fmt.Sprintf("%x", measurement.Expected))
+		case !ok:
+			checks = append(checks, api.NewFailedCheck(api.CheckMeasurements, fmt.Sprintf("PCR %d is missing", index)))
+		default:
+			checks = append(checks, api.NewFailedCheck(api.CheckMeasurements, fmt.Sprintf("PCR %d is %x, expected %x", index, actual, measurement.Expected)))
+		}
+	}
+	return checks
+}
+
+// checkTPMLog replays the vTPM event log against the quoted PCRs and applies
+// the boot policy to what it measured.
+func (i *GCPValidator) checkTPMLog(parsed *gcpissuer.ParsedDocument) (*eventlog.BootClaims, []api.FailedCheck) {
+	raw := parsed.Document.Attestation.GetEventLog()
+	if len(raw) == 0 {
+		if i.requireEventLog {
+			return nil, []api.FailedCheck{api.NewFailedCheck(api.CheckEventLog, "document has no vTPM event log")}
+		}
+		return nil, nil
+	}
+
+	var checks []api.FailedCheck
+	claims, errs := eventlog.VerifyTPMLog(raw, parsed.PCRs.GetPcrs())
+	for _, err := range errs {
+		checks = append(checks, api.NewFailedCheck(api.CheckEventLog, err.Error()))
+	}
+	if len(checks) > 0 {
+		return nil, checks
+	}
+	if i.cfg.BootPolicy != nil {
+		for _, err := range i.cfg.BootPolicy.Check(claims) {
+			checks = append(checks, api.NewFailedCheck(api.CheckMeasurements, err.Error()))
+		}
+	}
+	return claims, checks
+}
@@ -0,0 +1,251 @@
+package gcp
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"time"
+	"unicode/utf16"
+
+	"github.com/Hyodar/tdxs/internal/constellation/attestation/measurements"
+
+	"github.com/Hyodar/tdxs/pkg/api"
+	"github.com/Hyodar/tdxs/pkg/eventlog"
+	gcpissuer "github.com/Hyodar/tdxs/pkg/issuer/gcp"
+	"github.com/Hyodar/tdxs/pkg/simulator"
+	"github.com/Hyodar/tdxs/pkg/tdx"
+	"github.com/Hyodar/tdxs/pkg/validator/validatortest"
+)
+
+// The fixtures in testdata are a document from a TPM simulator with a fake
+// Google AK certificate and a TDX quote from the simulator authority, along
+// with the collateral it served. Regenerate them with
+//
+//	go test -tags fixtures -run TestGenerateFixtures ./pkg/validator/gcp
+var (
+	fixtureUserData = []byte("tdxs gcp fixture user data")
+	fixtureNonce    = []byte("tdxs gcp fixture nonce")
+)
+
+const (
+	fixtureProject = "tdxs-fixtures"
+	fixtureZone    = "us-central1-a"
+)
+
+// fixtureEvents is the vTPM event log of the fixture: Secure Boot enabled in
+// PCR7 and a boot application in PCR4.
+func fixtureEvents() []eventlog.Event {
+	event := func(pcr uint32, eventType uint32, data []byte) eventlog.Event {
+		sum := sha256.Sum256(data)
+		return eventlog.Event{MRIndex: pcr, Type: eventType, Digest: sum[:], Data: data}
+	}
+	efiGlobalVariable := []byte{0x61, 0xdf, 0xe4, 0x8b, 0xca, 0x93, 0xd2, 0x11, 0xaa, 0x0d, 0x00, 0xe0, 0x98, 0x03, 0x2b, 0x8c}
+	var secureBoot bytes.Buffer
+	name := utf16.Encode([]rune("SecureBoot"))
+	secureBoot.Write(efiGlobalVariable)
+	binary.Write(&secureBoot, binary.LittleEndian, uint64(len(name)))
+	binary.Write(&secureBoot, binary.LittleEndian, uint64(1))
+	binary.Write(&secureBoot, binary.LittleEndian, name)
+	secureBoot.WriteByte(1)
+
+	separator := []byte{0, 0, 0, 0}
+	bootApp := event(4, eventlog.EventTypeEFIBootServicesApplication, []byte("tdxs fixture boot application"))
+	bootApp.Digest = fixtureDigest("boot application")
+	return []eventlog.Event{
+		event(7, eventlog.EventTypeEFIVariableDriverConfig, secureBoot.Bytes()),
+		event(7, eventlog.EventTypeSeparator, separator),
+		event(4, eventlog.EventTypeSeparator, separator),
+		bootApp,
+	}
+}
+
+func fixtureDigest(label string) []byte {
+	sum := sha256.Sum256([]byte("tdxs fixture " + label))
+	return sum[:]
+}
+
+// fixturePCR replays the fixture events extended into pcr.
+func fixturePCR(pcr uint32) []byte {
+	value := make([]byte, sha256.Size)
+	for _, event := range fixtureEvents() {
+		if event.MRIndex == pcr {
+			sum := sha256.Sum256(append(value, event.Digest...))
+			value = sum[:]
+		}
+	}
+	return value
+}
+
+func newFixtureValidator(t *testing.T, modify func(cfg *GCPValidatorConfig)) *GCPValidator {
+	t.Helper()
+
+	cfg := &GCPValidatorConfig{
+		Measurements: measurements.M{
+			4: {Expected: fixturePCR(4), ValidationOpt: measurements.Enforce},
+			7: {Expected: fixturePCR(7), ValidationOpt: measurements.Enforce},
+		},
+		TD:              simulator.DefaultTD(),
+		AKRootsFile:     filepath.Join("testdata", "ak_root.pem"),
+		IntelRootFile:   filepath.Join("testdata", "intel_root.pem"),
+		Instance:        InstancePolicy{ProjectIDs: []string{fixtureProject}},
+		RequireEventLog: true,
+	}
+	if modify != nil {
+		modify(cfg)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("invalid config: %v", err)
+	}
+	v, err := NewGCPValidator(cfg, slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatalf("failed to create validator: %v", err)
+	}
+
+	collateral := validatortest.ReadCollateral(t)
+	v.getter = validatortest.TDXGetter{Collateral: collateral}
+	v.now = collateral.Now
+	return v
+}
+
+// tamper returns the fixture document with PCR 7 changed in its vTPM quote.
+func tamper(t *testing.T, doc []byte) []byte {
+	t.Helper()
+	var attDoc gcpissuer.Document
+	if err := json.Unmarshal(doc, &attDoc); err != nil {
+		t.Fatalf("failed to decode document: %v", err)
+	}
+	for _, quote := range attDoc.Attestation.Quotes {
+		if pcrs := quote.GetPcrs().GetPcrs(); pcrs[7] != nil {
+			pcrs[7] = fixtureDigest("tampered")
+		}
+	}
+	tampered, err := json.Marshal(&attDoc)
+	if err != nil {
+		t.Fatalf("failed to encode document: %v", err)
+	}
+	return tampered
+}
+
+func TestConformance(t *testing.T) {
+	doc := validatortest.ReadFixture(t, "document.json")
+	validatortest.Run(t, newFixtureValidator(t, nil), validatortest.Fixtures{
+		Document: doc,
+		Nonce:    fixtureNonce,
+		UserData: fixtureUserData,
+		Tampered: tamper(t, doc),
+	})
+}
+
+func TestValidate(t *testing.T) {
+	doc := validatortest.ReadFixture(t, "document.json")
+
+	for _, tt := range []struct {
+		name   string
+		modify func(cfg *GCPValidatorConfig)
+		now    time.Duration // added to the recording time
+		check  string        // the failed check expected, or "" for valid
+	}{
+		{name: "Valid"},
+		{
+			name:   "Offline",
+			modify: func(cfg *GCPValidatorConfig) { cfg.Offline = true },
+		},
+		{
+			name: "WarnOnlyPCR",
+			modify: func(cfg *GCPValidatorConfig) {
+				cfg.Measurements[7] = measurements.Measurement{Expected: fixtureDigest("other"), ValidationOpt: measurements.WarnOnly}
+			},
+		},
+		{
+			name: "BootPolicy",
+			modify: func(cfg *GCPValidatorConfig) {
+				cfg.BootPolicy = &eventlog.BootPolicy{SecureBoot: true, BootApplications: []eventlog.Hex{fixtureDigest("boot application")}}
+			},
+		},
+		{
+			name:   "UntrustedAK",
+			modify: func(cfg *GCPValidatorConfig) { cfg.AKRootsFile = filepath.Join("testdata", "intel_root.pem") },
+			check:  api.CheckSignature,
+		},
+		{
+			name:   "UntrustedQuote",
+			modify: func(cfg *GCPValidatorConfig) { cfg.IntelRootFile = filepath.Join("testdata", "ak_root.pem") },
+			check:  api.CheckSignature,
+		},
+		{
+			name:  "StaleCollateral",
+			now:   60 * 24 * time.Hour,
+			check: api.CheckTCB,
+		},
+		{
+			name:   "OtherProject",
+			modify: func(cfg *GCPValidatorConfig) { cfg.Instance.ProjectIDs = []string{"other-project"} },
+			check:  api.CheckIdentity,
+		},
+		{
+			name:   "OtherZone",
+			modify: func(cfg *GCPValidatorConfig) { cfg.Instance.Zones = []string{"europe-west4-a"} },
+			check:  api.CheckIdentity,
+		},
+		{
+			name: "OtherPCR",
+			modify: func(cfg *GCPValidatorConfig) {
+				cfg.Measurements[4] = measurements.Measurement{Expected: fixtureDigest("other"), ValidationOpt: measurements.Enforce}
+			},
+			check: api.CheckMeasurements,
+		},
+		{
+			name:   "OtherMRTD",
+			modify: func(cfg *GCPValidatorConfig) { cfg.TD.MrTd = bytes.Repeat([]byte{1}, 48) },
+			check:  api.CheckMeasurements,
+		},
+		{
+			name:   "OtherXFAM",
+			modify: func(cfg *GCPValidatorConfig) { cfg.TD.XFAM = tdx.HexBytes{0xe7, 0x02, 0x06, 0, 0, 0, 0, 0} },
+			check:  api.CheckAttributes,
+		},
+		{
+			name: "OtherBootApplication",
+			modify: func(cfg *GCPValidatorConfig) {
+				cfg.BootPolicy = &eventlog.BootPolicy{BootApplications: []eventlog.Hex{fixtureDigest("other")}}
+			},
+			check: api.CheckMeasurements,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			v := newFixtureValidator(t, tt.modify)
+			recorded := v.now()
+			v.now = func() time.Time { return recorded.Add(tt.now) }
+
+			resp := v.Validate(context.Background(), &api.ValidateRequest{Document: doc, Nonce: fixtureNonce})
+			if tt.check == "" {
+				if !resp.Valid {
+					t.Fatalf("response = %+v, want valid", resp)
+				}
+				claims, ok := resp.Claims.(*Claims)
+				if !ok || claims.Instance == nil || claims.Instance.ProjectID != fixtureProject || claims.Boot == nil || !claims.Boot.SecureBoot {
+					t.Errorf("claims = %+v, want the fixture instance and boot", resp.Claims)
+				}
+				return
+			}
+			if resp.Verdict() != api.VerdictInvalid || len(resp.FailedChecks) != 1 || resp.FailedChecks[0].Check != tt.check {
+				t.Errorf("response = %+v, want a failed %s check", resp, tt.check)
+			}
+		})
+	}
+}
+
+func TestCollateralUnavailable(t *testing.T) {
+	v := newFixtureValidator(t, nil)
+	v.getter = validatortest.TDXGetter{Collateral: &validatortest.Collateral{}}
+
+	resp := v.Validate(context.Background(), &api.ValidateRequest{Document: validatortest.ReadFixture(t, "document.json"), Nonce: fixtureNonce})
+	if resp.Verdict() != api.VerdictError || api.CodeOf(resp.Error) != api.ErrorCodeBackendUnavailable {
+		t.Errorf("response = %+v, want a backend_unavailable error", resp)
+	}
+}
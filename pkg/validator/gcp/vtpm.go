@@ -0,0 +1,107 @@
+package gcp
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+
+	tpmproto "github.com/google/go-tpm-tools/proto/tpm"
+	"github.com/google/go-tpm/legacy/tpm2"
+)
+
+// errExtraData is returned by verifyTPMQuote for a genuine quote over other
+// qualifying data.
+var errExtraData = errors.New("vTPM quote is not bound to the given user data and nonce")
+
+// verifyTPMQuote checks the signature of a vTPM quote with the AK, that it
+// covers pcrs, and that its qualifying data is extraData. It follows
+// go-tpm-tools' server.VerifyAttestation, which also wants the platform's
+// TEE report checked its own way.
+func verifyTPMQuote(quote *tpmproto.Quote, ak crypto.PublicKey, extraData []byte) error {
+	sig, err := tpm2.DecodeSignature(bytes.NewBuffer(quote.GetRawSig()))
+	if err != nil {
+		return fmt.Errorf("failed to decode vTPM quote signature: %w", err)
+	}
+
+	var hashAlg tpm2.Algorithm
+	switch {
+	case sig.RSA != nil:
+		hashAlg = sig.RSA.HashAlg
+	case sig.ECC != nil:
+		hashAlg = sig.ECC.HashAlg
+	default:
+		return fmt.Errorf("vTPM quote signature has no hash algorithm")
+	}
+	if hashAlg != tpm2.AlgSHA256 && hashAlg != tpm2.AlgSHA384 && hashAlg != tpm2.AlgSHA512 {
+		return fmt.Errorf("unsupported vTPM quote signature hash algorithm 0x%x", hashAlg)
+	}
+	hash, err := hashAlg.Hash()
+	if err != nil {
+		return err
+	}
+	h := hash.New()
+	h.Write(quote.GetQuote())
+	digest := h.Sum(nil)
+
+	switch pub := ak.(type) {
+	case *rsa.PublicKey:
+		if sig.Alg != tpm2.AlgRSASSA {
+			return fmt.Errorf("unsupported vTPM quote signature scheme 0x%x for an RSA key", sig.Alg)
+		}
+		if err := rsa.VerifyPKCS1v15(pub, hash, digest, sig.RSA.Signature); err != nil {
+			return fmt.Errorf("vTPM quote signature does not verify: %w", err)
+		}
+	case *ecdsa.PublicKey:
+		if sig.Alg != tpm2.AlgECDSA {
+			return fmt.Errorf("unsupported vTPM quote signature scheme 0x%x for an ECC key", sig.Alg)
+		}
+		if !ecdsa.Verify(pub, digest, sig.ECC.R, sig.ECC.S) {
+			return fmt.Errorf("vTPM quote signature does not verify")
+		}
+	default:
+		return fmt.Errorf("unsupported attestation key type %T", ak)
+	}
+
+	attestation, err := tpm2.DecodeAttestationData(quote.GetQuote())
+	if err != nil {
+		return fmt.Errorf("failed to decode vTPM quote: %w", err)
+	}
+	if attestation.Type != tpm2.TagAttestQuote || attestation.AttestedQuoteInfo == nil {
+		return fmt.Errorf("vTPM attestation is not a quote")
+	}
+	if err := checkPCRDigest(attestation.AttestedQuoteInfo, quote.GetPcrs(), hash); err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(attestation.ExtraData, extraData) == 0 {
+		return errExtraData
+	}
+	return nil
+}
+
+// checkPCRDigest checks that the quote selected exactly the PCRs given, and
+// that their digest is the quoted one.
+func checkPCRDigest(info *tpm2.QuoteInfo, pcrs *tpmproto.PCRs, hash crypto.Hash) error {
+	if tpm2.Algorithm(pcrs.GetHash()) != info.PCRSelection.Hash || len(pcrs.GetPcrs()) != len(info.PCRSelection.PCRs) {
+		return fmt.Errorf("PCRs do not match the vTPM quote's selection")
+	}
+	for _, index := range info.PCRSelection.PCRs {
+		if _, ok := pcrs.GetPcrs()[uint32(index)]; !ok {
+			return fmt.Errorf("PCRs do not match the vTPM quote's selection")
+		}
+	}
+
+	h := hash.New()
+	for index := uint32(0); index < 24; index++ {
+		if value, ok := pcrs.GetPcrs()[index]; ok {
+			h.Write(value)
+		}
+	}
+	if subtle.ConstantTimeCompare(h.Sum(nil), info.PCRDigest) == 0 {
+		return fmt.Errorf("PCR values do not match the vTPM quote's digest")
+	}
+	return nil
+}
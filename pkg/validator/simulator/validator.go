@@ -7,51 +7,230 @@ import (
 	"encoding/json"
 	"fmt"
 
+	"github.com/google/go-tdx-guest/abi"
+	pb "github.com/google/go-tdx-guest/proto/tdx"
+	"github.com/google/go-tdx-guest/verify"
+
 	"github.com/Hyodar/tdxs/pkg/api"
+	"github.com/Hyodar/tdxs/pkg/eventlog"
+	simulatorissuer "github.com/Hyodar/tdxs/pkg/issuer/simulator"
 	"github.com/Hyodar/tdxs/pkg/logger"
+	"github.com/Hyodar/tdxs/pkg/registry"
+	"github.com/Hyodar/tdxs/pkg/simulator"
+	"github.com/Hyodar/tdxs/pkg/tdx"
 	"github.com/Hyodar/tdxs/pkg/validator"
 )
 
 type SimulatorValidator struct {
 	validator.Validator
 
-	logger logger.Logger
+	logger    logger.Logger
+	authority *simulator.Authority
+	td        tdx.TD
+	faults    *simulator.Injector
+
+	requireEventLog bool
+}
+
+type SimulatorValidatorConfig struct {
+	// Signed makes the validator expect TDX quotes signed by the simulator CA
+	// instead of unsigned JSON documents.
+	Signed bool   `yaml:"signed"`
+	CAFile string `yaml:"ca_file"`
+
+	// Reference values for signed quotes. Unset fields are not checked.
+	tdx.TD `yaml:",inline"`
+
+	// RequireEventLog rejects signed documents without an attached event
+	// log. Attached logs are always replayed against the quoted RTMRs.
+	RequireEventLog bool `yaml:"require_event_log"`
+
+	Faults simulator.Faults `yaml:"faults"`
+}
+
+func (c *SimulatorValidatorConfig) Validate() error {
+	if !c.Signed && (c.CAFile != "" || !c.TD.IsZero() || c.RequireEventLog) {
+		return fmt.Errorf("ca_file, reference values and require_event_log require signed: true")
+	}
+	if err := c.TD.Validate(); err != nil {
+		return err
+	}
+	if err := checkFaults(&c.Faults, c.Signed); err != nil {
+		return fmt.Errorf("faults: %w", err)
+	}
+	return nil
+}
+
+// checkFaults rejects faults the validator cannot inject.
+func checkFaults(faults *simulator.Faults, signed bool) error {
+	if err := faults.Validate(); err != nil {
+		return err
+	}
+	if faults.ErrorRates[simulator.MethodIssue] != 0 || faults.ErrorRates[simulator.MethodMetadata] != 0 ||
+		faults.CorruptSignature != 0 || faults.WrongNonce != 0 || faults.StaleTCB != 0 {
+		return fmt.Errorf("issue and metadata error rates, corrupt_signature, wrong_nonce and stale_tcb apply to the issuer")
+	}
+	if !signed && faults.ExpiredCollateral != 0 {
+		return fmt.Errorf("expired_collateral requires signed: true")
+	}
+	return nil
+}
+
+func init() {
+	validator.Register(validator.ValidatorTypeSimulator, registry.WithConfig(func(cfg *SimulatorValidatorConfig, logger logger.Logger) (validator.Validator, error) {
+		return NewSimulatorValidator(cfg, logger)
+	}))
 }
 
-func NewSimulatorValidator(logger logger.Logger) *SimulatorValidator {
-	return &SimulatorValidator{
+func NewSimulatorValidator(cfg *SimulatorValidatorConfig, logger logger.Logger) (*SimulatorValidator, error) {
+	if cfg == nil {
+		cfg = &SimulatorValidatorConfig{}
+	}
+	if err := checkFaults(&cfg.Faults, cfg.Signed); err != nil {
+		return nil, fmt.Errorf("invalid faults: %w", err)
+	}
+
+	v := &SimulatorValidator{
 		logger: logger,
+		faults: simulator.NewInjector(cfg.Faults),
 	}
+	if !cfg.Signed {
+		return v, nil
+	}
+
+	authority, err := simulator.OpenAuthority(cfg.CAFile)
+	if err != nil {
+		return nil, err
+	}
+	v.authority = authority
+	v.td = cfg.TD
+	v.requireEventLog = cfg.RequireEventLog
+	return v, nil
 }
 
 func (i *SimulatorValidator) Start(_ context.Context) error {
 	return nil
 }
 
-func (i *SimulatorValidator) Validate(_ context.Context, req *api.ValidateRequest) *api.ValidateResponse {
-	if req.Document == nil {
-		return &api.ValidateResponse{Error: fmt.Errorf("document is nil")}
+func (i *SimulatorValidator) Validate(ctx context.Context, req *api.ValidateRequest) *api.ValidateResponse {
+	if err := i.faults.Delay(ctx); err != nil {
+		return api.NewValidateErrorResponse(err)
 	}
-
-	type Document struct {
-		UserData string `json:"userData"`
-		Nonce    string `json:"nonce"`
+	if i.faults.Fail(simulator.MethodValidate) {
+		return api.NewValidateErrorResponse(api.Errorf(api.ErrorCodeBackendUnavailable, "injected validate failure"))
+	}
+	if len(req.Document) == 0 {
+		return api.NewValidateErrorResponse(api.Errorf(api.ErrorCodeBadRequest, "document is empty"))
+	}
+	if i.authority != nil {
+		return i.validateSigned(req)
 	}
 
-	var doc Document
+	var doc simulatorissuer.Document
 	if err := json.Unmarshal(req.Document, &doc); err != nil {
-		return &api.ValidateResponse{Error: err}
+		return api.NewInvalidResponse(api.NewFailedCheck(api.CheckFormat, err.Error()))
 	}
 
 	userData, err := hex.DecodeString(doc.UserData)
 	if err != nil {
-		return &api.ValidateResponse{Error: err}
+		return api.NewInvalidResponse(api.NewFailedCheck(api.CheckFormat, fmt.Sprintf("user data: %v", err)))
 	}
 
 	nonce, err := hex.DecodeString(doc.Nonce)
 	if err != nil {
-		return &api.ValidateResponse{Error: err}
+		return api.NewInvalidResponse(api.NewFailedCheck(api.CheckFormat, fmt.Sprintf("nonce: %v", err)))
 	}
 
-	return &api.ValidateResponse{UserData: userData, Valid: bytes.Equal(req.Nonce, nonce)}
+	if !bytes.Equal(req.Nonce, nonce) {
+		return api.NewInvalidResponse(api.NewFailedCheck(api.CheckNonce, "document nonce does not match"))
+	}
+	return api.NewValidResponse(userData)
+}
+
+// validateSigned verifies a signed document the way a DCAP verifier does:
+// the quote signature and PCK chain against the simulator root, then the
+// TCB against the simulated collateral, then REPORTDATA and the reference
+// values.
+func (i *SimulatorValidator) validateSigned(req *api.ValidateRequest) *api.ValidateResponse {
+	var doc simulatorissuer.SignedDocument
+	if err := json.Unmarshal(req.Document, &doc); err != nil {
+		return api.NewInvalidResponse(api.NewFailedCheck(api.CheckFormat, err.Error()))
+	}
+	if doc.Quote == "" {
+		return api.NewInvalidResponse(api.NewFailedCheck(api.CheckFormat, "document has no quote"))
+	}
+
+	rawQuote, err := hex.DecodeString(doc.Quote)
+	if err != nil {
+		return api.NewInvalidResponse(api.NewFailedCheck(api.CheckFormat, fmt.Sprintf("quote: %v", err)))
+	}
+	userData, err := hex.DecodeString(doc.UserData)
+	if err != nil {
+		return api.NewInvalidResponse(api.NewFailedCheck(api.CheckFormat, fmt.Sprintf("user data: %v", err)))
+	}
+	parsed, err := abi.QuoteToProto(rawQuote)
+	if err != nil {
+		return api.NewInvalidResponse(api.NewFailedCheck(api.CheckFormat, fmt.Sprintf("quote: %v", err)))
+	}
+	quote, ok := parsed.(*pb.QuoteV4)
+	if !ok {
+		return api.NewInvalidResponse(api.NewFailedCheck(api.CheckFormat, fmt.Sprintf("unsupported quote type %T", parsed)))
+	}
+
+	// Verifying without collateral first tells signature failures apart
+	// from TCB failures.
+	if err := verify.TdxQuote(quote, &verify.Options{TrustedRoots: i.authority.Roots()}); err != nil {
+		return api.NewInvalidResponse(api.NewFailedCheck(api.CheckSignature, err.Error()))
+	}
+	collateral := i.authority.Collateral()
+	if i.faults.ExpiredCollateral() {
+		collateral = i.authority.ExpiredCollateral()
+	}
+	if err := verify.TdxQuote(quote, &verify.Options{
+		TrustedRoots:  i.authority.Roots(),
+		GetCollateral: true,
+		Getter:        collateral,
+	}); err != nil {
+		return api.NewInvalidResponse(api.NewFailedCheck(api.CheckTCB, err.Error()))
+	}
+
+	var checks []api.FailedCheck
+	if !bytes.Equal(quote.TdQuoteBody.ReportData, simulator.ReportData(userData, req.Nonce)) {
+		checks = append(checks, api.NewFailedCheck(api.CheckNonce, "REPORTDATA does not match the user data and nonce"))
+	}
+	checks = append(checks, validator.CompareTD(&i.td, quote.TdQuoteBody)...)
+
+	var claims *eventlog.Claims
+	switch {
+	case doc.EventLog != nil:
+		var errs []error
+		claims, errs = doc.EventLog.Verify(quote.TdQuoteBody.Rtmrs)
+		for _, err := range errs {
+			checks = append(checks, api.NewFailedCheck(api.CheckEventLog, err.Error()))
+		}
+	case i.requireEventLog:
+		checks = append(checks, api.NewFailedCheck(api.CheckEventLog, "document has no event log"))
+	}
+
+	if len(checks) > 0 {
+		return api.NewInvalidResponse(checks...)
+	}
+	resp := api.NewValidResponse(userData)
+	if claims != nil {
+		resp.Claims = claims
+	}
+	return resp
+}
+
+func (i *SimulatorValidator) Faults() simulator.Faults {
+	return i.faults.Faults()
+}
+
+func (i *SimulatorValidator) SetFaults(faults simulator.Faults) error {
+	if err := checkFaults(&faults, i.authority != nil); err != nil {
+		return api.NewError(api.ErrorCodeBadRequest, err)
+	}
+	i.faults.Set(faults)
+	i.logger.Info("Updated simulator validator faults", "enabled", faults.Enabled)
+	return nil
 }
@@ -0,0 +1,328 @@
+package azure_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/Hyodar/tdxs/pkg/api"
+	"github.com/Hyodar/tdxs/pkg/eventlog"
+	simulatorissuer "github.com/Hyodar/tdxs/pkg/issuer/simulator"
+	"github.com/Hyodar/tdxs/pkg/rtmr"
+	"github.com/Hyodar/tdxs/pkg/simulator"
+	"github.com/Hyodar/tdxs/pkg/tdx"
+	simulatorvalidator "github.com/Hyodar/tdxs/pkg/validator/simulator"
+	"github.com/Hyodar/tdxs/pkg/validator/validatortest"
+)
+
+func TestConformance(t *testing.T) {
+	logger := slog.New(slog.DiscardHandler)
+	userData := []byte("user data")
+	nonce := []byte("nonce")
+
+	iss, err := simulatorissuer.NewSimulatorIssuer(&simulatorissuer.SimulatorIssuerConfig{}, logger)
+	if err != nil {
+		t.Fatalf("failed to create issuer: %v", err)
+	}
+	resp := iss.Issue(context.Background(), &api.IssueRequest{UserData: userData, Nonce: nonce})
+	if resp.Error != nil {
+		t.Fatalf("failed to issue document: %v", resp.Error)
+	}
+
+	v, err := simulatorvalidator.NewSimulatorValidator(&simulatorvalidator.SimulatorValidatorConfig{}, logger)
+	if err != nil {
+		t.Fatalf("failed to create validator: %v", err)
+	}
+	validatortest.Run(t, v, validatortest.Fixtures{
+		Document: resp.Document,
+		Nonce:    nonce,
+		UserData: userData,
+	})
+}
+
+func TestConformanceSigned(t *testing.T) {
+	logger := slog.New(slog.DiscardHandler)
+	userData := []byte("user data")
+	nonce := []byte("nonce")
+	caFile := t.TempDir() + "/ca.pem"
+
+	iss, err := simulatorissuer.NewSimulatorIssuer(&simulatorissuer.SimulatorIssuerConfig{Signed: true, CAFile: caFile}, logger)
+	if err != nil {
+		t.Fatalf("failed to create issuer: %v", err)
+	}
+	resp := iss.Issue(context.Background(), &api.IssueRequest{UserData: userData, Nonce: nonce})
+	if resp.Error != nil {
+		t.Fatalf("failed to issue document: %v", resp.Error)
+	}
+
+	v, err := simulatorvalidator.NewSimulatorValidator(&simulatorvalidator.SimulatorValidatorConfig{
+		Signed: true,
+		CAFile: caFile,
+		TD:     simulator.DefaultTD(),
+	}, logger)
+	if err != nil {
+		t.Fatalf("failed to create validator: %v", err)
+	}
+	validatortest.Run(t, v, validatortest.Fixtures{
+		Document: resp.Document,
+		Nonce:    nonce,
+		UserData: userData,
+		Tampered: tamperMRTD(t, resp.Document),
+	})
+
+	t.Run("UntrustedCA", func(t *testing.T) {
+		other, err := simulatorvalidator.NewSimulatorValidator(&simulatorvalidator.SimulatorValidatorConfig{
+			Signed: true,
+			CAFile: t.TempDir() + "/other.pem",
+		}, logger)
+		if err != nil {
+			t.Fatalf("failed to create validator: %v", err)
+		}
+		checkFailed(t, other.Validate(context.Background(), &api.ValidateRequest{Document: resp.Document, Nonce: nonce}), api.CheckSignature)
+	})
+
+	t.Run("PolicyMismatch", func(t *testing.T) {
+		td := simulator.DefaultTD()
+		td.MrTd = make([]byte, len(td.MrTd))
+		td.XFAM = make([]byte, len(td.XFAM))
+		strict, err := simulatorvalidator.NewSimulatorValidator(&simulatorvalidator.SimulatorValidatorConfig{Signed: true, CAFile: caFile, TD: td}, logger)
+		if err != nil {
+			t.Fatalf("failed to create validator: %v", err)
+		}
+		resp := strict.Validate(context.Background(), &api.ValidateRequest{Document: resp.Document, Nonce: nonce})
+		checkFailed(t, resp, api.CheckMeasurements)
+		checkFailed(t, resp, api.CheckAttributes)
+	})
+
+	t.Run("OutOfDateTCB", func(t *testing.T) {
+		td := tdx.TD{TeeTCBSVN: simulator.DefaultTD().TeeTCBSVN}
+		td.TeeTCBSVN[0]--
+		stale, err := simulatorissuer.NewSimulatorIssuer(&simulatorissuer.SimulatorIssuerConfig{Signed: true, CAFile: caFile, TD: td}, logger)
+		if err != nil {
+			t.Fatalf("failed to create issuer: %v", err)
+		}
+		issued := stale.Issue(context.Background(), &api.IssueRequest{UserData: userData, Nonce: nonce})
+		if issued.Error != nil {
+			t.Fatalf("failed to issue document: %v", issued.Error)
+		}
+		checkFailed(t, v.Validate(context.Background(), &api.ValidateRequest{Document: issued.Document, Nonce: nonce}), api.CheckTCB)
+	})
+}
+
+// tamperMRTD flips a bit of the MRTD in a signed document's quote.
+func tamperMRTD(t *testing.T, document []byte) []byte {
+	t.Helper()
+
+	var doc simulatorissuer.SignedDocument
+	if err := json.Unmarshal(document, &doc); err != nil {
+		t.Fatalf("failed to parse document: %v", err)
+	}
+	quote, err := hex.DecodeString(doc.Quote)
+	if err != nil {
+		t.Fatalf("failed to decode quote: %v", err)
+	}
+	// Header (0x30 bytes), then MRTD at offset 0x88 of the TD quote body.
+	quote[0x30+0x88] ^= 0x01
+	doc.Quote = hex.EncodeToString(quote)
+
+	tampered, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatalf("failed to encode document: %v", err)
+	}
+	return tampered
+}
+
+func checkFailed(t *testing.T, resp *api.ValidateResponse, check string) {
+	t.Helper()
+
+	if resp.Verdict() != api.VerdictInvalid {
+		t.Fatalf("verdict = %s, want %s (error: %v)", resp.Verdict(), api.VerdictInvalid, resp.Error)
+	}
+	for _, failed := range resp.FailedChecks {
+		if failed.Check == check {
+			return
+		}
+	}
+	t.Errorf("failed checks %v do not include %s", resp.FailedChecks, check)
+}
+
+func TestFaults(t *testing.T) {
+	logger := slog.New(slog.DiscardHandler)
+	nonce := []byte("nonce")
+	caFile := t.TempDir() + "/ca.pem"
+
+	iss, err := simulatorissuer.NewSimulatorIssuer(&simulatorissuer.SimulatorIssuerConfig{Signed: true, CAFile: caFile}, logger)
+	if err != nil {
+		t.Fatalf("failed to create issuer: %v", err)
+	}
+	v, err := simulatorvalidator.NewSimulatorValidator(&simulatorvalidator.SimulatorValidatorConfig{Signed: true, CAFile: caFile}, logger)
+	if err != nil {
+		t.Fatalf("failed to create validator: %v", err)
+	}
+
+	issue := func(t *testing.T, faults simulator.Faults) []byte {
+		t.Helper()
+		if err := iss.SetFaults(faults); err != nil {
+			t.Fatalf("failed to set faults: %v", err)
+		}
+		resp := iss.Issue(context.Background(), &api.IssueRequest{Nonce: nonce})
+		if resp.Error != nil {
+			t.Fatalf("failed to issue document: %v", resp.Error)
+		}
+		return resp.Document
+	}
+
+	for _, test := range []struct {
+		name   string
+		faults simulator.Faults
+		check  string
+	}{
+		{"CorruptSignature", simulator.Faults{Enabled: true, CorruptSignature: 1}, api.CheckSignature},
+		{"WrongNonce", simulator.Faults{Enabled: true, WrongNonce: 1}, api.CheckNonce},
+		{"StaleTCB", simulator.Faults{Enabled: true, StaleTCB: 1}, api.CheckTCB},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			document := issue(t, test.faults)
+			checkFailed(t, v.Validate(context.Background(), &api.ValidateRequest{Document: document, Nonce: nonce}), test.check)
+		})
+	}
+
+	t.Run("ExpiredCollateral", func(t *testing.T) {
+		document := issue(t, simulator.Faults{})
+		if err := v.SetFaults(simulator.Faults{Enabled: true, ExpiredCollateral: 1}); err != nil {
+			t.Fatalf("failed to set faults: %v", err)
+		}
+		defer v.SetFaults(simulator.Faults{})
+		checkFailed(t, v.Validate(context.Background(), &api.ValidateRequest{Document: document, Nonce: nonce}), api.CheckTCB)
+	})
+
+	t.Run("ErrorRate", func(t *testing.T) {
+		if err := iss.SetFaults(simulator.Faults{Enabled: true, ErrorRates: map[string]float64{simulator.MethodIssue: 1}}); err != nil {
+			t.Fatalf("failed to set faults: %v", err)
+		}
+		resp := iss.Issue(context.Background(), &api.IssueRequest{Nonce: nonce})
+		if code := api.CodeOf(resp.Error); code != api.ErrorCodeBackendUnavailable {
+			t.Errorf("error code = %q, want %q", code, api.ErrorCodeBackendUnavailable)
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		document := issue(t, simulator.Faults{CorruptSignature: 1, WrongNonce: 1, StaleTCB: 1})
+		if resp := v.Validate(context.Background(), &api.ValidateRequest{Document: document, Nonce: nonce}); !resp.Valid {
+			t.Errorf("verdict = %s, want valid (failed checks: %v)", resp.Verdict(), resp.FailedChecks)
+		}
+	})
+
+	t.Run("Unsupported", func(t *testing.T) {
+		if err := v.SetFaults(simulator.Faults{WrongNonce: 1}); err == nil {
+			t.Error("validator accepted an issuer fault")
+		}
+		if err := iss.SetFaults(simulator.Faults{ExpiredCollateral: 1}); err == nil {
+			t.Error("issuer accepted a validator fault")
+		}
+	})
+}
+
+func TestEventLog(t *testing.T) {
+	logger := slog.New(slog.DiscardHandler)
+	nonce := []byte("nonce")
+	dir := t.TempDir()
+	caFile := dir + "/ca.pem"
+	runtimeLog := dir + "/events.jsonl"
+
+	extender, err := rtmr.NewExtender(&rtmr.RTMRConfig{Device: rtmr.DeviceTypeFake, EventLog: runtimeLog}, logger)
+	if err != nil {
+		t.Fatalf("failed to create extender: %v", err)
+	}
+	defer extender.Close()
+	root := &api.Caller{UID: 0}
+	for _, description := range []string{"app config", "app image"} {
+		digest := sha512.Sum384([]byte(description))
+		if resp := extender.Extend(root, &api.ExtendRequest{RTMR: 3, Digest: digest[:], Description: description}); resp.Error != nil {
+			t.Fatalf("failed to extend: %v", resp.Error)
+		}
+	}
+
+	iss, err := simulatorissuer.NewSimulatorIssuer(&simulatorissuer.SimulatorIssuerConfig{
+		Signed:   true,
+		CAFile:   caFile,
+		EventLog: eventlog.AttachConfig{CCEL: true, RuntimeLog: runtimeLog},
+	}, logger)
+	if err != nil {
+		t.Fatalf("failed to create issuer: %v", err)
+	}
+	issued := iss.Issue(context.Background(), &api.IssueRequest{Nonce: nonce})
+	if issued.Error != nil {
+		t.Fatalf("failed to issue document: %v", issued.Error)
+	}
+
+	v, err := simulatorvalidator.NewSimulatorValidator(&simulatorvalidator.SimulatorValidatorConfig{Signed: true, CAFile: caFile, RequireEventLog: true}, logger)
+	if err != nil {
+		t.Fatalf("failed to create validator: %v", err)
+	}
+	validate := func(document []byte) *api.ValidateResponse {
+		return v.Validate(context.Background(), &api.ValidateRequest{Document: document, Nonce: nonce})
+	}
+
+	resp := validate(issued.Document)
+	if !resp.Valid {
+		t.Fatalf("verdict = %s, want valid (failed checks: %v)", resp.Verdict(), resp.FailedChecks)
+	}
+	claims, ok := resp.Claims.(*eventlog.Claims)
+	if !ok {
+		t.Fatalf("claims = %T, want *eventlog.Claims", resp.Claims)
+	}
+	if claims.KernelCmdline != simulatorissuer.SimulatorKernelCmdline {
+		t.Errorf("kernel cmdline = %q, want %q", claims.KernelCmdline, simulatorissuer.SimulatorKernelCmdline)
+	}
+	if len(claims.UEFIVariables) != 1 || claims.UEFIVariables[0].Name != "SecureBoot" || claims.UEFIVariables[0].Value != "01" {
+		t.Errorf("UEFI variables = %+v, want SecureBoot = 01", claims.UEFIVariables)
+	}
+	if len(claims.Images) != 1 || len(claims.Runtime) != 2 || claims.Runtime[1].Description != "app image" {
+		t.Errorf("images = %+v, runtime = %+v, want 1 image and 2 runtime events", claims.Images, claims.Runtime)
+	}
+
+	modify := func(change func(doc *simulatorissuer.SignedDocument)) []byte {
+		var doc simulatorissuer.SignedDocument
+		if err := json.Unmarshal(issued.Document, &doc); err != nil {
+			t.Fatalf("failed to parse document: %v", err)
+		}
+		change(&doc)
+		document, err := json.Marshal(doc)
+		if err != nil {
+			t.Fatalf("failed to encode document: %v", err)
+		}
+		return document
+	}
+
+	t.Run("DroppedEvent", func(t *testing.T) {
+		checkFailed(t, validate(modify(func(doc *simulatorissuer.SignedDocument) {
+			doc.EventLog.Runtime = doc.EventLog.Runtime[:1]
+		})), api.CheckEventLog)
+	})
+
+	t.Run("ForgedCmdline", func(t *testing.T) {
+		// Changing event data alone keeps the replay intact but must not
+		// change the claims.
+		document := modify(func(doc *simulatorissuer.SignedDocument) {
+			doc.EventLog.CCEL = bytes.Replace(doc.EventLog.CCEL, []byte("ro"), []byte("rw"), 1)
+		})
+		resp := validate(document)
+		if !resp.Valid {
+			t.Fatalf("verdict = %s, want valid (failed checks: %v)", resp.Verdict(), resp.FailedChecks)
+		}
+		if cmdline := resp.Claims.(*eventlog.Claims).KernelCmdline; cmdline != "" {
+			t.Errorf("kernel cmdline = %q from unverified event data", cmdline)
+		}
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		checkFailed(t, validate(modify(func(doc *simulatorissuer.SignedDocument) {
+			doc.EventLog = nil
+		})), api.CheckEventLog)
+	})
+}
@@ -0,0 +1,154 @@
+//go:build fixtures
+
+package snp
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"log/slog"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-sev-guest/abi"
+	"github.com/google/go-sev-guest/kds"
+	spb "github.com/google/go-sev-guest/proto/sevsnp"
+	test "github.com/google/go-sev-guest/testing"
+
+	"github.com/Hyodar/tdxs/pkg/api"
+	snpissuer "github.com/Hyodar/tdxs/pkg/issuer/snp"
+	"github.com/Hyodar/tdxs/pkg/validator/validatortest"
+)
+
+const fixtureProductName = "Milan-B1"
+
+func TestGenerateFixtures(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	created := now.Add(-time.Hour)
+	chipID := fixtureBytes("chip id", abi.ChipIDSize)
+	signer, err := (&test.AmdSignerBuilder{
+		ProductName:      fixtureProductName,
+		ArkCreationTime:  created,
+		AskCreationTime:  created,
+		AsvkCreationTime: created,
+		VcekCreationTime: created,
+		VlekCreationTime: created,
+		VcekCustom:       test.CertOverride{Extensions: test.CustomExtensions(fixtureTCB, chipID, "", fixtureProductName)},
+	}).TestOnlyCertChain()
+	if err != nil {
+		t.Fatalf("failed to create AMD certificate chain: %v", err)
+	}
+
+	product, err := kds.ParseProductName(fixtureProductName, abi.VcekReportSigner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs, err := signer.CertTableBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs, err = abi.ExtendPlatformCertTable(certs, &abi.ExtraPlatformInfo{
+		Size:      abi.ExtraPlatformInfoV0Size,
+		Cpuid1Eax: abi.MaskedCpuid1EaxFromSevProduct(product),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tcb, err := kds.ComposeTCBParts(fixtureTCB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	quote := func(reportData [abi.ReportDataSize]byte) ([]byte, error) {
+		report, err := abi.ReportToAbiBytes(&spb.Report{
+			Version:         abi.ReportVersion3,
+			GuestSvn:        fixtureGuestSVN,
+			Policy:          abi.SnpPolicyToBytes(abi.SnpPolicy{ABIMajor: 1, ABIMinor: 55, SMT: true}),
+			FamilyId:        make([]byte, abi.FamilyIDSize),
+			ImageId:         make([]byte, abi.ImageIDSize),
+			SignatureAlgo:   abi.SignEcdsaP384Sha384,
+			CurrentTcb:      uint64(tcb),
+			ReportData:      reportData[:],
+			Measurement:     fixtureMeasurement,
+			HostData:        fixtureHostData,
+			IdKeyDigest:     make([]byte, abi.IDKeyDigestSize),
+			AuthorKeyDigest: make([]byte, abi.AuthorKeyDigestSize),
+			ReportId:        fixtureBytes("report id", abi.ReportIDSize),
+			ReportIdMa:      make([]byte, abi.ReportIDMASize),
+			ReportedTcb:     uint64(tcb),
+			Cpuid1EaxFms:    abi.MaskedCpuid1EaxFromSevProduct(product),
+			ChipId:          chipID,
+			CommittedTcb:    uint64(tcb),
+			CurrentBuild:    21,
+			CurrentMinor:    55,
+			CurrentMajor:    1,
+			CommittedBuild:  21,
+			CommittedMinor:  55,
+			CommittedMajor:  1,
+			LaunchTcb:       uint64(tcb),
+			Signature:       make([]byte, abi.SignatureSize),
+		})
+		if err != nil {
+			return nil, err
+		}
+		r, s, err := signer.Sign(abi.SignedComponent(report))
+		if err != nil {
+			return nil, err
+		}
+		if err := abi.SetSignature(r, s, report); err != nil {
+			return nil, err
+		}
+		return append(report, certs...), nil
+	}
+
+	doc, err := snpissuer.NewDocument(quote, fixtureUserData, fixtureNonce)
+	if err != nil {
+		t.Fatalf("failed to create document: %v", err)
+	}
+	docJSON, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The ARK signs the CRL the ASK points at, as AMD KDS serves it.
+	crl, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: created,
+		NextUpdate: created.AddDate(0, 0, 7),
+	}, signer.Ark, signer.Keys.Ark)
+	if err != nil {
+		t.Fatalf("failed to create CRL: %v", err)
+	}
+	collateral := &validatortest.Collateral{Time: now}
+	collateral.Record(signer.Ask.CRLDistributionPoints[0], nil, crl)
+	collateralJSON, err := json.MarshalIndent(collateral, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var roots []byte
+	for _, cert := range []*x509.Certificate{signer.Ask, signer.Asvk, signer.Ark} {
+		roots = append(roots, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+
+	validatortest.WriteFixture(t, "document.json", docJSON)
+	validatortest.WriteFixture(t, "amd_roots.pem", roots)
+	validatortest.WriteFixture(t, "collateral.json", collateralJSON)
+
+	v, err := NewSNPValidator(&SNPValidatorConfig{
+		Measurement:  fixtureMeasurement,
+		Policy:       GuestPolicy{AllowSMT: true},
+		AMDRootsFile: filepath.Join("testdata", "amd_roots.pem"),
+	}, slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.getter = validatortest.SNPGetter{Collateral: collateral}
+	v.now = collateral.Now
+	if resp := v.Validate(t.Context(), &api.ValidateRequest{Document: docJSON, Nonce: fixtureNonce}); !resp.Valid {
+		t.Fatalf("generated document does not validate: %+v", resp)
+	}
+}
@@ -0,0 +1,360 @@
+package snp
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/go-sev-guest/abi"
+	"github.com/google/go-sev-guest/kds"
+	spb "github.com/google/go-sev-guest/proto/sevsnp"
+	"github.com/google/go-sev-guest/validate"
+	"github.com/google/go-sev-guest/verify"
+	"github.com/google/go-sev-guest/verify/trust"
+
+	"github.com/Hyodar/tdxs/pkg/api"
+	"github.com/Hyodar/tdxs/pkg/eventlog"
+	snpissuer "github.com/Hyodar/tdxs/pkg/issuer/snp"
+	"github.com/Hyodar/tdxs/pkg/logger"
+	"github.com/Hyodar/tdxs/pkg/registry"
+	"github.com/Hyodar/tdxs/pkg/validator"
+)
+
+type SNPValidator struct {
+	validator.Validator
+
+	logger logger.Logger
+	cfg    *SNPValidatorConfig
+
+	// roots are kept for the validator's lifetime, so the CRLs go-sev-guest
+	// caches on them are fetched once per CRL update.
+	roots map[string][]*trust.AMDRootCerts
+
+	// getter fetches certificates and CRLs from AMD KDS and now is the time
+	// they are checked at; tests replace both to replay recorded collateral.
+	getter trust.HTTPSGetter
+	now    func() time.Time
+}
+
+type SNPValidatorConfig struct {
+	// Measurement is the expected launch measurement.
+	Measurement eventlog.Hex `yaml:"measurement"`
+	// HostData is the expected HOST_DATA the host set at launch. Not checked
+	// if unset.
+	HostData eventlog.Hex `yaml:"host_data"`
+
+	// Policy is the most permissive guest policy accepted.
+	Policy GuestPolicy `yaml:"policy"`
+	// VMPL is the privilege level reports must be requested at. Not checked
+	// if unset.
+	VMPL            *uint32 `yaml:"vmpl"`
+	MinimumGuestSVN uint32  `yaml:"minimum_guest_svn"`
+	// MinimumTCB is the component-wise minimum of the reported TCB.
+	MinimumTCB TCB `yaml:"minimum_tcb"`
+
+	// AMDRootsFile holds the PEM ARKs with their ASKs and ASVKs, as served
+	// by AMD KDS's cert_chain endpoints. Defaults to the roots embedded in
+	// go-sev-guest.
+	AMDRootsFile string `yaml:"amd_roots_file"`
+	// Offline only uses the certificates in the document: a missing VCEK is
+	// not fetched from AMD KDS and revocations are not checked.
+	Offline bool `yaml:"offline"`
+}
+
+// GuestPolicy lists the guest policy capabilities accepted.
+type GuestPolicy struct {
+	AllowDebug          bool `yaml:"allow_debug"`
+	AllowSMT            bool `yaml:"allow_smt"`
+	AllowMigrationAgent bool `yaml:"allow_migration_agent"`
+	RequireSingleSocket bool `yaml:"require_single_socket"`
+}
+
+// TCB holds security patch levels of the SEV-SNP firmware components.
+type TCB struct {
+	Bootloader uint8 `yaml:"bootloader"`
+	TEE        uint8 `yaml:"tee"`
+	SNP        uint8 `yaml:"snp"`
+	Microcode  uint8 `yaml:"microcode"`
+}
+
+func (c *SNPValidatorConfig) Validate() error {
+	var errs []error
+	if len(c.Measurement) != abi.MeasurementSize {
+		errs = append(errs, fmt.Errorf("measurement: expected %d bytes, got %d", abi.MeasurementSize, len(c.Measurement)))
+	}
+	if len(c.HostData) != 0 && len(c.HostData) != abi.HostDataSize {
+		errs = append(errs, fmt.Errorf("host_data: expected %d bytes, got %d", abi.HostDataSize, len(c.HostData)))
+	}
+	if c.VMPL != nil && *c.VMPL > 3 {
+		errs = append(errs, fmt.Errorf("vmpl: %d out of range 0-3", *c.VMPL))
+	}
+	return errors.Join(errs...)
+}
+
+// Claims are returned for valid documents.
+type Claims struct {
+	Product     string `json:"product,omitempty"` // product line, e.g. Milan
+	SigningKey  string `json:"signingKey"`        // VCEK or VLEK
+	Measurement string `json:"measurement"`
+	HostData    string `json:"hostData"`
+	Policy      uint64 `json:"policy"`
+	VMPL        uint32 `json:"vmpl"`
+	GuestSVN    uint32 `json:"guestSvn"`
+	ReportedTCB uint64 `json:"reportedTcb"`
+}
+
+func init() {
+	validator.Register(validator.ValidatorTypeSNP, registry.WithConfig(func(cfg *SNPValidatorConfig, logger logger.Logger) (validator.Validator, error) {
+		return NewSNPValidator(cfg, logger)
+	}))
+}
+
+func NewSNPValidator(cfg *SNPValidatorConfig, logger logger.Logger) (*SNPValidator, error) {
+	v := &SNPValidator{
+		logger: logger,
+		cfg:    cfg,
+		getter: trust.DefaultHTTPSGetter(),
+		now:    time.Now,
+	}
+
+	var rootsPEM []byte
+	if cfg.AMDRootsFile == "" {
+		rootsPEM = bytes.Join([][]byte{
+			trust.AskArkMilanVcekBytes, trust.AskArkMilanVlekBytes,
+			trust.AskArkGenoaVcekBytes, trust.AskArkGenoaVlekBytes,
+			trust.AskArkTurinVcekBytes, trust.AskArkTurinVlekBytes,
+		}, []byte("\n"))
+	} else {
+		var err error
+		rootsPEM, err = os.ReadFile(cfg.AMDRootsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read AMD roots: %w", err)
+		}
+	}
+	roots, err := parseRoots(rootsPEM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse AMD roots: %w", err)
+	}
+	v.roots = roots
+	return v, nil
+}
+
+// parseRoots groups the ARKs in data by the product line in their common
+// name, with the ASKs and ASVKs they signed.
+func parseRoots(data []byte) (map[string][]*trust.AMDRootCerts, error) {
+	var arks, intermediates []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(cert.RawIssuer, cert.RawSubject) {
+			intermediates = append(intermediates, cert)
+		} else if !containsCert(arks, cert) {
+			arks = append(arks, cert)
+		}
+	}
+
+	roots := make(map[string][]*trust.AMDRootCerts)
+	for _, ark := range arks {
+		productLine, ok := strings.CutPrefix(ark.Subject.CommonName, "ARK-")
+		if !ok {
+			return nil, fmt.Errorf("root %q is not an AMD root key", ark.Subject.CommonName)
+		}
+		root := trust.AMDRootCertsProduct(productLine)
+		root.ProductCerts = &trust.ProductCerts{Ark: ark}
+		for _, cert := range intermediates {
+			if cert.CheckSignatureFrom(ark) != nil {
+				continue
+			}
+			if strings.HasPrefix(cert.Subject.CommonName, "SEV-VLEK") {
+				root.ProductCerts.Asvk = cert
+			} else {
+				root.ProductCerts.Ask = cert
+			}
+		}
+		roots[productLine] = append(roots[productLine], root)
+	}
+	if len(roots) == 0 {
+		return nil, fmt.Errorf("no AMD root keys found")
+	}
+	return roots, nil
+}
+
+func containsCert(certs []*x509.Certificate, cert *x509.Certificate) bool {
+	for _, c := range certs {
+		if c.Equal(cert) {
+			return true
+		}
+	}
+	return false
+}
+
+func (i *SNPValidator) Start(_ context.Context) error {
+	return nil
+}
+
+// Validate checks the report signature and the VCEK or VLEK chain, then
+// revocations and the TCB, then that the report is bound to the request,
+// and finally the reference values.
+func (i *SNPValidator) Validate(ctx context.Context, req *api.ValidateRequest) *api.ValidateResponse {
+	if len(req.Document) == 0 {
+		return api.NewValidateErrorResponse(api.Errorf(api.ErrorCodeBadRequest, "document is empty"))
+	}
+
+	parsed, err := snpissuer.ParseDocument(req.Document)
+	if err != nil {
+		return api.NewInvalidResponse(api.NewFailedCheck(api.CheckFormat, err.Error()))
+	}
+	attestation := parsed.Attestation
+	report := attestation.GetReport()
+	now := i.now()
+
+	// Verifying without revocations first tells signature failures apart
+	// from TCB failures.
+	err = verify.SnpAttestation(attestation, &verify.Options{
+		DisableCertFetching: i.cfg.Offline,
+		Getter:              i.getter,
+		Now:                 now,
+		TrustedRoots:        i.roots,
+	})
+	if err != nil {
+		if resp := i.unavailable(ctx, err); resp != nil {
+			return resp
+		}
+		return api.NewInvalidResponse(api.NewFailedCheck(api.CheckSignature, err.Error()))
+	}
+	if !i.cfg.Offline {
+		err := verify.SnpAttestation(attestation, &verify.Options{
+			CheckRevocations:    true,
+			DisableCertFetching: true,
+			Getter:              i.getter,
+			Now:                 now,
+			TrustedRoots:        i.roots,
+		})
+		if err != nil {
+			if resp := i.unavailable(ctx, err); resp != nil {
+				return resp
+			}
+			return api.NewInvalidResponse(api.NewFailedCheck(api.CheckTCB, err.Error()))
+		}
+	}
+
+	var checks []api.FailedCheck
+	if err := i.checkTCB(attestation); err != nil {
+		checks = append(checks, api.NewFailedCheck(api.CheckTCB, err.Error()))
+	}
+
+	reportData := snpissuer.ReportData(parsed.Document.UserData, req.Nonce)
+	if !bytes.Equal(report.GetReportData(), reportData[:]) {
+		checks = append(checks, api.NewFailedCheck(api.CheckNonce, "REPORT_DATA does not match the user data and nonce"))
+	}
+
+	checks = append(checks, i.compare(report)...)
+
+	if len(checks) > 0 {
+		return api.NewInvalidResponse(checks...)
+	}
+	resp := api.NewValidResponse(parsed.Document.UserData)
+	resp.Claims = claims(attestation)
+	return resp
+}
+
+// unavailable returns an error response if err is not the document's fault:
+// the request timed out, or the VCEK or CRL could not be fetched from AMD KDS.
+func (i *SNPValidator) unavailable(ctx context.Context, err error) *api.ValidateResponse {
+	if ctx.Err() != nil {
+		return api.NewValidateErrorResponse(api.NewError(api.ErrorCodeTimeout, err))
+	}
+	var recreationErr *trust.AttestationRecreationErr
+	var crlErr verify.CRLUnavailableErr
+	if errors.As(err, &recreationErr) || errors.As(err, &crlErr) {
+		return api.NewValidateErrorResponse(api.NewError(api.ErrorCodeBackendUnavailable, err))
+	}
+	return nil
+}
+
+// checkTCB checks that the reported TCB is the one the VCEK was issued for,
+// that the firmware is committed, and the configured minimum.
+func (i *SNPValidator) checkTCB(attestation *spb.Attestation) error {
+	tcb := i.cfg.MinimumTCB
+	return validate.SnpAttestation(attestation, &validate.Options{
+		// The guest policy is checked by compare; this one accepts any.
+		GuestPolicy: abi.SnpPolicy{Debug: true, SMT: true, MigrateMA: true},
+		MinimumTCB: kds.TCBParts{
+			BlSpl:    tcb.Bootloader,
+			TeeSpl:   tcb.TEE,
+			SnpSpl:   tcb.SNP,
+			UcodeSpl: tcb.Microcode,
+		},
+	})
+}
+
+// compare checks the report against the configured reference values.
+func (i *SNPValidator) compare(report *spb.Report) []api.FailedCheck {
+	cfg := i.cfg
+	var checks []api.FailedCheck
+	if !bytes.Equal(report.GetMeasurement(), cfg.Measurement) {
+		checks = append(checks, api.NewFailedCheck(api.CheckMeasurements, fmt.Sprintf("MEASUREMENT is %x, expected %x", report.GetMeasurement(), []byte(cfg.Measurement))))
+	}
+	if len(cfg.HostData) > 0 && !bytes.Equal(report.GetHostData(), cfg.HostData) {
+		checks = append(checks, api.NewFailedCheck(api.CheckMeasurements, fmt.Sprintf("HOST_DATA is %x, expected %x", report.GetHostData(), []byte(cfg.HostData))))
+	}
+
+	policy, err := abi.ParseSnpPolicy(report.GetPolicy())
+	if err != nil {
+		return append(checks, api.NewFailedCheck(api.CheckAttributes, err.Error()))
+	}
+	if policy.Debug && !cfg.Policy.AllowDebug {
+		checks = append(checks, api.NewFailedCheck(api.CheckAttributes, "guest policy allows debugging"))
+	}
+	if policy.SMT && !cfg.Policy.AllowSMT {
+		checks = append(checks, api.NewFailedCheck(api.CheckAttributes, "guest policy allows SMT"))
+	}
+	if policy.MigrateMA && !cfg.Policy.AllowMigrationAgent {
+		checks = append(checks, api.NewFailedCheck(api.CheckAttributes, "guest policy allows a migration agent"))
+	}
+	if !policy.SingleSocket && cfg.Policy.RequireSingleSocket {
+		checks = append(checks, api.NewFailedCheck(api.CheckAttributes, "guest policy does not restrict the guest to a single socket"))
+	}
+	if cfg.VMPL != nil && report.GetVmpl() != *cfg.VMPL {
+		checks = append(checks, api.NewFailedCheck(api.CheckAttributes, fmt.Sprintf("VMPL is %d, expected %d", report.GetVmpl(), *cfg.VMPL)))
+	}
+	if report.GetGuestSvn() < cfg.MinimumGuestSVN {
+		checks = append(checks, api.NewFailedCheck(api.CheckAttributes, fmt.Sprintf("GUEST_SVN %d is below the minimum %d", report.GetGuestSvn(), cfg.MinimumGuestSVN)))
+	}
+	return checks
+}
+
+func claims(attestation *spb.Attestation) *Claims {
+	report := attestation.GetReport()
+	claims := &Claims{
+		Measurement: fmt.Sprintf("%x", report.GetMeasurement()),
+		HostData:    fmt.Sprintf("%x", report.GetHostData()),
+		Policy:      report.GetPolicy(),
+		VMPL:        report.GetVmpl(),
+		GuestSVN:    report.GetGuestSvn(),
+		ReportedTCB: report.GetReportedTcb(),
+	}
+	if info, err := abi.ParseSignerInfo(report.GetSignerInfo()); err == nil {
+		claims.SigningKey = info.SigningKey.String()
+	}
+	if fms := report.GetCpuid1EaxFms(); fms != 0 {
+		claims.Product = kds.ProductLineFromFms(fms)
+	}
+	return claims
+}
@@ -0,0 +1,207 @@
+package snp
+
+import (
+	"context"
+	"crypto/sha512"
+	"encoding/json"
+	"log/slog"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-sev-guest/abi"
+	"github.com/google/go-sev-guest/kds"
+
+	"github.com/Hyodar/tdxs/pkg/api"
+	snpissuer "github.com/Hyodar/tdxs/pkg/issuer/snp"
+	"github.com/Hyodar/tdxs/pkg/validator/validatortest"
+)
+
+// The fixtures in testdata are a document with a report signed by a fake AMD
+// certificate chain from go-sev-guest, along with the CRL of that chain.
+// Regenerate them with
+//
+//	go test -tags fixtures -run TestGenerateFixtures ./pkg/validator/snp
+var (
+	fixtureUserData    = []byte("tdxs snp fixture user data")
+	fixtureNonce       = []byte("tdxs snp fixture nonce")
+	fixtureMeasurement = fixtureBytes("measurement", abi.MeasurementSize)
+	fixtureHostData    = fixtureBytes("host data", abi.HostDataSize)
+
+	// fixtureTCB is the TCB of the fixture report and its VCEK.
+	fixtureTCB = kds.TCBParts{BlSpl: 3, TeeSpl: 0, SnpSpl: 8, UcodeSpl: 115}
+)
+
+const fixtureGuestSVN = 2
+
+func fixtureBytes(label string, size int) []byte {
+	sum := sha512.Sum512([]byte("tdxs fixture " + label))
+	return sum[:size]
+}
+
+func newFixtureValidator(t *testing.T, modify func(cfg *SNPValidatorConfig)) *SNPValidator {
+	t.Helper()
+
+	vmpl := uint32(0)
+	cfg := &SNPValidatorConfig{
+		Measurement:     fixtureMeasurement,
+		HostData:        fixtureHostData,
+		Policy:          GuestPolicy{AllowSMT: true},
+		VMPL:            &vmpl,
+		MinimumGuestSVN: fixtureGuestSVN,
+		MinimumTCB:      TCB{Bootloader: 3, SNP: 8, Microcode: 115},
+		AMDRootsFile:    filepath.Join("testdata", "amd_roots.pem"),
+	}
+	if modify != nil {
+		modify(cfg)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("invalid config: %v", err)
+	}
+	v, err := NewSNPValidator(cfg, slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatalf("failed to create validator: %v", err)
+	}
+
+	collateral := validatortest.ReadCollateral(t)
+	v.getter = validatortest.SNPGetter{Collateral: collateral}
+	v.now = collateral.Now
+	return v
+}
+
+// tamper returns the fixture document with its measurement changed.
+func tamper(t *testing.T, doc []byte) []byte {
+	t.Helper()
+	var attDoc snpissuer.Document
+	if err := json.Unmarshal(doc, &attDoc); err != nil {
+		t.Fatalf("failed to decode document: %v", err)
+	}
+	attDoc.Report[0x90] ^= 0xff
+	tampered, err := json.Marshal(&attDoc)
+	if err != nil {
+		t.Fatalf("failed to encode document: %v", err)
+	}
+	return tampered
+}
+
+func TestConformance(t *testing.T) {
+	doc := validatortest.ReadFixture(t, "document.json")
+	validatortest.Run(t, newFixtureValidator(t, nil), validatortest.Fixtures{
+		Document: doc,
+		Nonce:    fixtureNonce,
+		UserData: fixtureUserData,
+		Tampered: tamper(t, doc),
+	})
+}
+
+func TestValidate(t *testing.T) {
+	doc := validatortest.ReadFixture(t, "document.json")
+
+	for _, tt := range []struct {
+		name   string
+		modify func(cfg *SNPValidatorConfig)
+		check  string // the failed check expected, or "" for valid
+	}{
+		{name: "Valid"},
+		{
+			name:   "Offline",
+			modify: func(cfg *SNPValidatorConfig) { cfg.Offline = true },
+		},
+		{
+			name:   "UntrustedRoot",
+			modify: func(cfg *SNPValidatorConfig) { cfg.AMDRootsFile = "" },
+			check:  api.CheckSignature,
+		},
+		{
+			name:   "MinimumTCB",
+			modify: func(cfg *SNPValidatorConfig) { cfg.MinimumTCB.SNP = 9 },
+			check:  api.CheckTCB,
+		},
+		{
+			name:   "OtherMeasurement",
+			modify: func(cfg *SNPValidatorConfig) { cfg.Measurement = fixtureBytes("other", abi.MeasurementSize) },
+			check:  api.CheckMeasurements,
+		},
+		{
+			name:   "OtherHostData",
+			modify: func(cfg *SNPValidatorConfig) { cfg.HostData = fixtureBytes("other", abi.HostDataSize) },
+			check:  api.CheckMeasurements,
+		},
+		{
+			name:   "SMTNotAllowed",
+			modify: func(cfg *SNPValidatorConfig) { cfg.Policy.AllowSMT = false },
+			check:  api.CheckAttributes,
+		},
+		{
+			name:   "SingleSocketRequired",
+			modify: func(cfg *SNPValidatorConfig) { cfg.Policy.RequireSingleSocket = true },
+			check:  api.CheckAttributes,
+		},
+		{
+			name: "OtherVMPL",
+			modify: func(cfg *SNPValidatorConfig) {
+				vmpl := uint32(1)
+				cfg.VMPL = &vmpl
+			},
+			check: api.CheckAttributes,
+		},
+		{
+			name:   "MinimumGuestSVN",
+			modify: func(cfg *SNPValidatorConfig) { cfg.MinimumGuestSVN = fixtureGuestSVN + 1 },
+			check:  api.CheckAttributes,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			v := newFixtureValidator(t, tt.modify)
+			if v.cfg.Offline {
+				// Offline validators must not need AMD KDS.
+				v.getter = validatortest.SNPGetter{Collateral: &validatortest.Collateral{}}
+			}
+
+			resp := v.Validate(context.Background(), &api.ValidateRequest{Document: doc, Nonce: fixtureNonce})
+			if tt.check == "" {
+				if !resp.Valid {
+					t.Fatalf("response = %+v, want valid", resp)
+				}
+				claims, ok := resp.Claims.(*Claims)
+				if !ok || claims.Product != "Milan" || claims.SigningKey != "VCEK" || claims.GuestSVN != fixtureGuestSVN {
+					t.Errorf("claims = %+v, want the fixture report", resp.Claims)
+				}
+				return
+			}
+			if resp.Verdict() != api.VerdictInvalid || len(resp.FailedChecks) != 1 || resp.FailedChecks[0].Check != tt.check {
+				t.Errorf("response = %+v, want a failed %s check", resp, tt.check)
+			}
+		})
+	}
+}
+
+func TestCollateralUnavailable(t *testing.T) {
+	doc := validatortest.ReadFixture(t, "document.json")
+	var attDoc snpissuer.Document
+	if err := json.Unmarshal(doc, &attDoc); err != nil {
+		t.Fatalf("failed to decode document: %v", err)
+	}
+	attDoc.Certificates = nil
+	withoutVCEK, err := json.Marshal(&attDoc)
+	if err != nil {
+		t.Fatalf("failed to encode document: %v", err)
+	}
+
+	for _, tt := range []struct {
+		name     string
+		document []byte
+	}{
+		{name: "CRL", document: doc},
+		{name: "VCEK", document: withoutVCEK},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			v := newFixtureValidator(t, nil)
+			v.getter = validatortest.SNPGetter{Collateral: &validatortest.Collateral{}}
+
+			resp := v.Validate(context.Background(), &api.ValidateRequest{Document: tt.document, Nonce: fixtureNonce})
+			if resp.Verdict() != api.VerdictError || api.CodeOf(resp.Error) != api.ErrorCodeBackendUnavailable {
+				t.Errorf("response = %+v, want a backend_unavailable error", resp)
+			}
+		})
+	}
+}
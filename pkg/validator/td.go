@@ -0,0 +1,40 @@
+package validator
+
+import (
+	"bytes"
+	"fmt"
+
+	pb "github.com/google/go-tdx-guest/proto/tdx"
+
+	"github.com/Hyodar/tdxs/pkg/api"
+	"github.com/Hyodar/tdxs/pkg/tdx"
+)
+
+// CompareTD checks a TD quote body against the reference values in td.
+// Unset fields are not checked.
+func CompareTD(td *tdx.TD, body *pb.TDQuoteBody) []api.FailedCheck {
+	fields := []struct {
+		check    string
+		name     string
+		expected []byte
+		actual   []byte
+	}{
+		{api.CheckMeasurements, "MRTD", td.MrTd, body.MrTd},
+		{api.CheckMeasurements, "RTMR0", td.Rtmr0, body.Rtmrs[0]},
+		{api.CheckMeasurements, "RTMR1", td.Rtmr1, body.Rtmrs[1]},
+		{api.CheckMeasurements, "RTMR2", td.Rtmr2, body.Rtmrs[2]},
+		{api.CheckMeasurements, "RTMR3", td.Rtmr3, body.Rtmrs[3]},
+		{api.CheckMeasurements, "MRSEAM", td.MrSeam, body.MrSeam},
+		{api.CheckAttributes, "XFAM", td.XFAM, body.Xfam},
+		{api.CheckAttributes, "TD attributes", td.TDAttributes, body.TdAttributes},
+		{api.CheckAttributes, "TEE TCB SVN", td.TeeTCBSVN, body.TeeTcbSvn},
+	}
+
+	var checks []api.FailedCheck
+	for _, field := range fields {
+		if len(field.expected) > 0 && !bytes.Equal(field.expected, field.actual) {
+			checks = append(checks, api.NewFailedCheck(field.check, fmt.Sprintf("%s is %x, expected %x", field.name, field.actual, field.expected)))
+		}
+	}
+	return checks
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"github.com/Hyodar/tdxs/pkg/api"
+	"github.com/Hyodar/tdxs/pkg/registry"
 )
 
 type Validator interface {
@@ -15,5 +16,15 @@ type ValidatorType string
 
 const (
 	ValidatorTypeAzure     ValidatorType = "azure"
+	ValidatorTypeGCP       ValidatorType = "gcp"
+	ValidatorTypeSNP       ValidatorType = "snp"
 	ValidatorTypeSimulator ValidatorType = "simulator"
 )
+
+// Registry holds the validator types available to the manager. Validator
+// packages register themselves from init.
+var Registry = registry.New[Validator]("validator")
+
+func Register(t ValidatorType, entry registry.Entry[Validator]) {
+	Registry.Register(string(t), entry)
+}
@@ -0,0 +1,95 @@
+package validatortest
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Collateral is what a collateral service (Intel PCS, AMD KDS) served at
+// Time, recorded when fixtures are generated and replayed by the tests.
+type Collateral struct {
+	Time      time.Time           `json:"time"`
+	Responses map[string]Response `json:"responses"`
+}
+
+type Response struct {
+	Header map[string][]string `json:"header,omitempty"`
+	Body   []byte              `json:"body"`
+}
+
+// Now returns the recording time, for validators to check certificates and
+// collateral at.
+func (c *Collateral) Now() time.Time {
+	return c.Time
+}
+
+// Record adds a response.
+func (c *Collateral) Record(url string, header map[string][]string, body []byte) {
+	if c.Responses == nil {
+		c.Responses = make(map[string]Response)
+	}
+	c.Responses[url] = Response{Header: header, Body: body}
+}
+
+func (c *Collateral) response(url string) (Response, error) {
+	response, ok := c.Responses[url]
+	if !ok {
+		return Response{}, fmt.Errorf("no recorded response for %s", url)
+	}
+	return response, nil
+}
+
+// TDXGetter replays collateral as a go-tdx-guest trust.HTTPSGetter.
+type TDXGetter struct {
+	*Collateral
+}
+
+func (g TDXGetter) Get(url string) (map[string][]string, []byte, error) {
+	response, err := g.response(url)
+	return response.Header, response.Body, err
+}
+
+// SNPGetter replays collateral as a go-sev-guest trust.HTTPSGetter.
+type SNPGetter struct {
+	*Collateral
+}
+
+func (g SNPGetter) Get(url string) ([]byte, error) {
+	response, err := g.response(url)
+	return response.Body, err
+}
+
+// ReadFixture reads testdata/name.
+func ReadFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("failed to read fixture: %v", err)
+	}
+	return data
+}
+
+// WriteFixture writes testdata/name, for fixture generators.
+func WriteFixture(t *testing.T, name string, data []byte) {
+	t.Helper()
+	if err := os.MkdirAll("testdata", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join("testdata", name), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// ReadCollateral reads the collateral recorded in testdata/collateral.json.
+func ReadCollateral(t *testing.T) *Collateral {
+	t.Helper()
+	var collateral Collateral
+	if err := json.Unmarshal(ReadFixture(t, "collateral.json"), &collateral); err != nil {
+		t.Fatalf("failed to decode collateral: %v", err)
+	}
+	return &collateral
+}
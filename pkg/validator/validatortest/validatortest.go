@@ -0,0 +1,168 @@
+// Package validatortest is a conformance suite for validator.Validator
+// implementations. Every validator must pass it.
+package validatortest
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/Hyodar/tdxs/pkg/api"
+	"github.com/Hyodar/tdxs/pkg/validator"
+)
+
+// Fixtures are the documents a validator is exercised with. Optional fields
+// left empty skip the cases that need them, for backends whose documents
+// cannot be produced offline.
+type Fixtures struct {
+	// Document validates with Nonce and yields UserData.
+	Document []byte
+	Nonce    []byte
+	UserData []byte
+
+	// Tampered is Document with a modification its validator must detect.
+	Tampered []byte
+}
+
+// Run checks the verdict contract of v:
+//
+//   - a valid verdict has Valid set, UserData, no failed checks and no error;
+//   - an invalid verdict has failed checks with a name, a reason and the
+//     attestation_invalid or policy_rejected code, and no user data or error;
+//   - a could-not-evaluate verdict has an error with a code and nothing else.
+func Run(t *testing.T, v validator.Validator, fixtures Fixtures) {
+	t.Helper()
+
+	validate := func(t *testing.T, document []byte, nonce []byte) *api.ValidateResponse {
+		t.Helper()
+		resp := v.Validate(context.Background(), &api.ValidateRequest{Document: document, Nonce: nonce})
+		if resp == nil {
+			t.Fatal("Validate returned nil")
+		}
+		checkConsistent(t, resp)
+		return resp
+	}
+
+	t.Run("EmptyDocument", func(t *testing.T) {
+		resp := validate(t, nil, []byte("nonce"))
+		if resp.Verdict() != api.VerdictError {
+			t.Fatalf("verdict = %s, want %s", resp.Verdict(), api.VerdictError)
+		}
+		if code := api.CodeOf(resp.Error); code != api.ErrorCodeBadRequest {
+			t.Errorf("error code = %s, want %s", code, api.ErrorCodeBadRequest)
+		}
+	})
+
+	t.Run("Garbage", func(t *testing.T) {
+		for _, document := range [][]byte{
+			[]byte("not an attestation document"),
+			[]byte("{}"),
+			{0x00, 0x01, 0x02, 0x03},
+		} {
+			resp := validate(t, document, []byte("nonce"))
+			if resp.Verdict() != api.VerdictInvalid {
+				t.Errorf("document %q: verdict = %s, want %s", document, resp.Verdict(), api.VerdictInvalid)
+			}
+		}
+	})
+
+	t.Run("Valid", func(t *testing.T) {
+		if fixtures.Document == nil {
+			t.Skip("no valid document fixture")
+		}
+		resp := validate(t, fixtures.Document, fixtures.Nonce)
+		if resp.Verdict() != api.VerdictValid {
+			t.Fatalf("verdict = %s, want %s (failed checks: %v, error: %v)", resp.Verdict(), api.VerdictValid, resp.FailedChecks, resp.Error)
+		}
+		if !bytes.Equal(resp.UserData, fixtures.UserData) {
+			t.Errorf("user data = %x, want %x", resp.UserData, fixtures.UserData)
+		}
+	})
+
+	t.Run("WrongNonce", func(t *testing.T) {
+		if fixtures.Document == nil {
+			t.Skip("no valid document fixture")
+		}
+		nonce := append([]byte("wrong-"), fixtures.Nonce...)
+		resp := validate(t, fixtures.Document, nonce)
+		if resp.Verdict() != api.VerdictInvalid {
+			t.Fatalf("verdict = %s, want %s", resp.Verdict(), api.VerdictInvalid)
+		}
+		if !hasCheck(resp, api.CheckNonce) {
+			t.Errorf("failed checks %v do not include %s", resp.FailedChecks, api.CheckNonce)
+		}
+	})
+
+	t.Run("Tampered", func(t *testing.T) {
+		if fixtures.Tampered == nil {
+			t.Skip("no tampered document fixture")
+		}
+		resp := validate(t, fixtures.Tampered, fixtures.Nonce)
+		if resp.Verdict() != api.VerdictInvalid {
+			t.Fatalf("verdict = %s, want %s", resp.Verdict(), api.VerdictInvalid)
+		}
+	})
+
+	t.Run("CancelledContext", func(t *testing.T) {
+		if fixtures.Document == nil {
+			t.Skip("no valid document fixture")
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		resp := v.Validate(ctx, &api.ValidateRequest{Document: fixtures.Document, Nonce: fixtures.Nonce})
+		if resp == nil {
+			t.Fatal("Validate returned nil")
+		}
+		checkConsistent(t, resp)
+		// Validators may finish without looking at the context, but must not
+		// turn a cancellation into an invalid verdict.
+		if resp.Verdict() == api.VerdictInvalid {
+			t.Errorf("verdict = %s after cancellation (failed checks: %v)", resp.Verdict(), resp.FailedChecks)
+		}
+	})
+}
+
+func checkConsistent(t *testing.T, resp *api.ValidateResponse) {
+	t.Helper()
+
+	switch resp.Verdict() {
+	case api.VerdictValid:
+		if len(resp.FailedChecks) > 0 {
+			t.Errorf("valid verdict with failed checks: %v", resp.FailedChecks)
+		}
+	case api.VerdictInvalid:
+		if len(resp.FailedChecks) == 0 {
+			t.Error("invalid verdict without failed checks")
+		}
+		if len(resp.UserData) > 0 {
+			t.Errorf("invalid verdict with user data %x", resp.UserData)
+		}
+		for _, check := range resp.FailedChecks {
+			if check.Check == "" || check.Reason == "" {
+				t.Errorf("failed check without name or reason: %+v", check)
+			}
+			if check.Code != api.ErrorCodeAttestationInvalid && check.Code != api.ErrorCodePolicyRejected {
+				t.Errorf("failed check %s has code %q", check.Check, check.Code)
+			}
+		}
+	case api.VerdictError:
+		if resp.Valid {
+			t.Error("error verdict with Valid set")
+		}
+		if len(resp.FailedChecks) > 0 || len(resp.UserData) > 0 {
+			t.Errorf("error verdict with failed checks %v or user data %x", resp.FailedChecks, resp.UserData)
+		}
+		if api.CodeOf(resp.Error) == api.ErrorCodeInternal {
+			t.Errorf("error without a code: %v", resp.Error)
+		}
+	}
+}
+
+func hasCheck(resp *api.ValidateResponse, name string) bool {
+	for _, check := range resp.FailedChecks {
+		if check.Check == name {
+			return true
+		}
+	}
+	return false
+}